@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"owlet/server/domain"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var articleCmd = &cobra.Command{
+	Use:   "article",
+	Short: "Manage articles",
+}
+
+var articleBackfillAbstractsCmd = &cobra.Command{
+	Use:   "backfill-abstracts",
+	Short: "Generate abstracts of the articles without abstracts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		regenerate, _ := cmd.Flags().GetBool("regenerate")
+		return withDatabase(func(db *gorm.DB) error {
+			updated, err := domain.BackfillArticleAbstractsFunc(regenerate, commandSession(cmd))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "abstracts of %d articles generated\n", updated)
+			return nil
+		})
+	},
+}
+
+var articleCheckLinksCmd = &cobra.Command{
+	Use:   "check-links",
+	Short: "List the wiki links to the articles not found, deleted or unpublished",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDatabase(func(db *gorm.DB) error {
+			links, err := domain.QueryBrokenArticleLinksFunc(commandSession(cmd))
+			if err != nil {
+				return err
+			}
+			for _, l := range links {
+				fmt.Fprintf(cmd.OutOrStdout(), "article %s: broken link [[%s]]\n", l.SourceID, l.Target)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%d broken links found\n", len(links))
+			return nil
+		})
+	},
+}
+
+func init() {
+	articleBackfillAbstractsCmd.Flags().Bool("regenerate", false,
+		"generate the generated abstracts again, the abstracts written by authors are always kept")
+
+	articleCmd.AddCommand(articleBackfillAbstractsCmd, articleCheckLinksCmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"owlet/init/db"
+	"owlet/server/infra/app"
+
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the database",
+}
+
+var dbPrepareCmd = &cobra.Command{
+	Use:   "prepare",
+	Short: "Create the database if not exists",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dsn, err := app.LoadDatabaseConfig()
+		if err != nil {
+			return err
+		}
+		if err := db.PrepareMysqlDatabase(dsn); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "database prepared")
+		return nil
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbPrepareCmd)
+}
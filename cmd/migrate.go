@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"owlet/server/infra/assemble"
+	"owlet/server/infra/persistence"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage database schema migrations",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDatabase(func(db *gorm.DB) error {
+			versions, err := persistence.MigrateUp(db, assemble.AutoMigrations, assemble.Migrations)
+			for _, v := range versions {
+				fmt.Fprintf(cmd.OutOrStdout(), "applied %d\n", v)
+			}
+			return err
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the latest applied migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		steps, err := cmd.Flags().GetInt("steps")
+		if err != nil {
+			return err
+		}
+		return withDatabase(func(db *gorm.DB) error {
+			versions, err := persistence.MigrateDown(db, assemble.Migrations, steps)
+			for _, v := range versions {
+				fmt.Fprintf(cmd.OutOrStdout(), "reverted %d\n", v)
+			}
+			return err
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDatabase(func(db *gorm.DB) error {
+			statuses, err := persistence.MigrationStatuses(db, assemble.Migrations)
+			if err != nil {
+				return err
+			}
+			printMigrationStatuses(cmd, statuses)
+			return nil
+		})
+	},
+}
+
+func init() {
+	migrateDownCmd.Flags().Int("steps", 1, "number of migrations to revert")
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+}
+
+func printMigrationStatuses(cmd *cobra.Command, statuses []persistence.MigrationStatus) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED\tAPPLIED TIME\tDESCRIPTION")
+	for _, s := range statuses {
+		appliedTime := "-"
+		if s.AppliedTime != nil {
+			appliedTime = s.AppliedTime.Time().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%t\t%s\t%s\n", s.Version, s.Applied, appliedTime, s.Description)
+	}
+	w.Flush()
+}
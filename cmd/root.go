@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"owlet/server/infra/app"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "owlet",
+	Short: "A wiki service",
+	// serve by default, keep compatible with the image entrypoint
+	RunE:          runServe,
+	SilenceUsage:  true,
+	SilenceErrors: false,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd, migrateCmd, dbCmd, userCmd, articleCmd, exportCmd, importCmd)
+}
+
+// Execute run the command line interface
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+// withDatabase open the database with the same configuration as the server, and close it after fn returned.
+func withDatabase(fn func(db *gorm.DB) error) error {
+	gormDB, err := app.OpenDatabase()
+	if err != nil {
+		return err
+	}
+	defer persistence.StopGormDB(gormDB)
+	return fn(gormDB)
+}
+
+func commandSession(cmd *cobra.Command) *sessions.Session {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &sessions.Session{Context: ctx}
+}
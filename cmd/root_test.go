@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRootCommand(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("sub commands should be registered as expected", func(t *testing.T) {
+		names := []string{}
+		for _, c := range rootCmd.Commands() {
+			names = append(names, c.Name())
+		}
+		Expect(names).To(ContainElements("serve", "migrate", "db", "user", "article", "export", "import"))
+
+		for _, path := range [][]string{
+			{"migrate", "up"}, {"migrate", "down"}, {"migrate", "status"},
+			{"db", "prepare"},
+			{"user", "create"}, {"user", "lock"}, {"user", "reset-password"},
+			{"article", "backfill-abstracts"}, {"article", "check-links"},
+		} {
+			c, _, err := rootCmd.Find(path)
+			Expect(err).To(BeNil())
+			Expect(c.Name()).To(Equal(path[len(path)-1]))
+		}
+	})
+
+	t.Run("should reject missing arguments", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		rootCmd.SetOut(out)
+		rootCmd.SetErr(out)
+		rootCmd.SetArgs([]string{"user", "lock"})
+		Expect(rootCmd.Execute()).To(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("accepts 1 arg(s), received 0"))
+	})
+
+	t.Run("should reject invalid user before connecting to database", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		rootCmd.SetOut(out)
+		rootCmd.SetErr(out)
+		rootCmd.SetArgs([]string{"user", "create", "tom", "--email", "not-an-email", "--password", "123"})
+		err := rootCmd.Execute()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("'UserCreate.email' Error:Field validation for 'email' failed on the 'email' tag"))
+		Expect(err.Error()).To(ContainSubstring("'UserCreate.password' Error:Field validation for 'password' failed on the 'gte' tag"))
+	})
+
+	t.Run("should reject short password before connecting to database", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		rootCmd.SetOut(out)
+		rootCmd.SetErr(out)
+		rootCmd.SetArgs([]string{"user", "reset-password", "tom", "--password", "1"})
+		err := rootCmd.Execute()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("'passwordReset.password' Error:Field validation for 'password' failed on the 'gte' tag"))
+	})
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"owlet/server/infra/app"
+
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the http server",
+	Args:  cobra.NoArgs,
+	RunE:  runServe,
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	app.Bootstrap()
+	return nil
+}
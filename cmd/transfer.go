@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"owlet/server/domain"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export articles and tags as json",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		return withDatabase(func(db *gorm.DB) error {
+			ds, err := domain.ExportDatasetFunc(commandSession(cmd))
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(ds)
+		})
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import articles and tags from json exported before",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input, _ := cmd.Flags().GetString("input")
+
+		var r io.Reader = cmd.InOrStdin()
+		if input != "" && input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		ds := domain.Dataset{}
+		if err := json.NewDecoder(r).Decode(&ds); err != nil {
+			return err
+		}
+
+		return withDatabase(func(db *gorm.DB) error {
+			if err := domain.ImportDatasetFunc(&ds, commandSession(cmd)); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "imported %d articles, %d tags, %d tag assignments\n",
+				len(ds.Articles), len(ds.Tags), len(ds.TagAssignments))
+			return nil
+		})
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringP("output", "o", "-", "output file, '-' for stdout")
+	importCmd.Flags().StringP("input", "i", "-", "input file, '-' for stdin")
+}
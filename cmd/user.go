@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"owlet/server/domain"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage users",
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create <username>",
+	Short: "Create a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := domain.UserCreate{Username: args[0]}
+		c.Email, _ = cmd.Flags().GetString("email")
+		c.Password, _ = cmd.Flags().GetString("password")
+		c.RealName, _ = cmd.Flags().GetString("real-name")
+		// validated as the requests of REST API
+		if err := binding.Validator.ValidateStruct(&c); err != nil {
+			return err
+		}
+		return withDatabase(func(db *gorm.DB) error {
+			u, err := domain.CreateUserFunc(&c, commandSession(cmd))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "user %s created, id: %s\n", u.Username, u.ID)
+			return nil
+		})
+	},
+}
+
+var userLockCmd = &cobra.Command{
+	Use:   "lock <username>",
+	Short: "Lock (or unlock) a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unlock, _ := cmd.Flags().GetBool("unlock")
+		return withDatabase(func(db *gorm.DB) error {
+			if err := domain.LockUserFunc(args[0], !unlock, commandSession(cmd)); err != nil {
+				return err
+			}
+			if unlock {
+				fmt.Fprintf(cmd.OutOrStdout(), "user %s unlocked\n", args[0])
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "user %s locked\n", args[0])
+			}
+			return nil
+		})
+	},
+}
+
+// passwordReset the password is validated as domain.PasswordReset of REST API
+type passwordReset struct {
+	Password string `json:"password" binding:"required,gte=6,lte=64"`
+}
+
+var userResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password <username>",
+	Short: "Reset the password of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r := passwordReset{}
+		r.Password, _ = cmd.Flags().GetString("password")
+		if err := binding.Validator.ValidateStruct(&r); err != nil {
+			return err
+		}
+		return withDatabase(func(db *gorm.DB) error {
+			if err := domain.ResetPasswordFunc(args[0], r.Password, commandSession(cmd)); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "password of user %s reset\n", args[0])
+			return nil
+		})
+	},
+}
+
+func init() {
+	userCreateCmd.Flags().String("email", "", "email of the user")
+	userCreateCmd.Flags().String("password", "", "password of the user")
+	userCreateCmd.Flags().String("real-name", "", "real name of the user")
+	_ = userCreateCmd.MarkFlagRequired("email")
+	_ = userCreateCmd.MarkFlagRequired("password")
+
+	userLockCmd.Flags().Bool("unlock", false, "unlock the user instead")
+
+	userResetPasswordCmd.Flags().String("password", "", "new password of the user")
+	_ = userResetPasswordCmd.MarkFlagRequired("password")
+
+	userCmd.AddCommand(userCreateCmd, userLockCmd, userResetPasswordCmd)
+}
@@ -35,6 +35,241 @@ const docTemplate = `{
                 }
             }
         },
+        "/healthz": {
+            "get": {
+                "operationId": "health-liveness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "operationId": "health-readiness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/files/gc": {
+            "post": {
+                "operationId": "file-gc",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FileGCReport"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/i18n/missing-translations": {
+            "get": {
+                "operationId": "i18n-missing-translations",
+                "responses": {
+                    "200": {
+                        "description": "message ids absent in each language",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/log-level": {
+            "get": {
+                "operationId": "log-level-get",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/logging.LogLevel"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "log-level-set",
+                "parameters": [
+                    {
+                        "description": "log level",
+                        "name": "level",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/logging.LogLevel"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/logging.LogLevel"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/users": {
+            "get": {
+                "operationId": "admin-user-list",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "keyword of username, email or real name",
+                        "name": "kw",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page number based 1",
+                        "name": "page",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/domain.UserSummary"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/users/{id}/lock": {
+            "put": {
+                "operationId": "admin-user-lock",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "operationId": "admin-user-unlock",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/users/{id}/mfa": {
+            "delete": {
+                "operationId": "admin-user-mfa-reset",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
         "/v1/articles": {
             "get": {
                 "operationId": "article-meta-list",
@@ -71,212 +306,1547 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/articles/{id}": {
-            "get": {
-                "operationId": "article-detail",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "response body",
-                        "schema": {
-                            "$ref": "#/definitions/domain.ArticleDetail"
-                        }
-                    },
-                    "default": {
-                        "description": "error",
-                        "schema": {
-                            "$ref": "#/definitions/fail.ErrorBody"
-                        }
-                    }
+        "/v1/articles/by-slug/{slug}": {
+            "get": {
+                "operationId": "article-detail-by-slug",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "current or retired slug, the retired slug is redirected to the current one",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html, the content is rendered as content_html if html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response body",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ArticleDetail"
+                        }
+                    },
+                    "301": {
+                        "description": "redirect to the current slug"
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/articles/{id}": {
+            "get": {
+                "operationId": "article-detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "markdown (default) or html, the content is rendered as content_html if html",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "response body",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ArticleDetail"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/articles/{id}/backlinks": {
+            "get": {
+                "operationId": "article-backlink-list",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/domain.ArticleMetaExt"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/articles/{id}/links": {
+            "get": {
+                "operationId": "article-link-list",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/domain.ArticleLinkDetail"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/articles/{id}/slug": {
+            "put": {
+                "operationId": "article-slug-update",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "the slug, which is normalized",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.ArticleSlugUpdate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "the slug in effect",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ArticleSlugUpdate"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/ldap/login": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "operationId": "auth-ldap-login",
+                "parameters": [
+                    {
+                        "description": "username and password in directory",
+                        "name": "login",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/sessions.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserProfile"
+                        }
+                    },
+                    "202": {
+                        "description": "the second factor is required, see /v1/auth/mfa/verify",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFAChallenge"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/mfa/verify": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "operationId": "auth-mfa-verify",
+                "parameters": [
+                    {
+                        "description": "the challenge token and a TOTP code or a recovery code",
+                        "name": "verification",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFAVerification"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserProfile"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/oidc": {
+            "get": {
+                "operationId": "auth-oidc-provider-list",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/oidc/{provider}/callback": {
+            "get": {
+                "operationId": "auth-oidc-callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "state",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/oidc/{provider}/link": {
+            "get": {
+                "operationId": "auth-oidc-link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "local path to go after linked",
+                        "name": "return_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/auth/oidc/{provider}/login": {
+            "get": {
+                "operationId": "auth-oidc-login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "local path to go after login",
+                        "name": "return_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/files": {
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "operationId": "file-create",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "the file to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/domain.File"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/files/{id}": {
+            "get": {
+                "operationId": "file-detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.File"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "operationId": "file-delete",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/files/{id}/content": {
+            "get": {
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "operationId": "file-content",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "the max width of image",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "the max height of image",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "the token of signed link, it is required by private files",
+                        "name": "token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tags": {
+            "get": {
+                "operationId": "tag-with-stat-list",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/domain.TagWithStat"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/email-verification/confirm": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-email-verification-confirm",
+                "parameters": [
+                    {
+                        "description": "token in mail",
+                        "name": "verification",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.EmailVerification"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me": {
+            "get": {
+                "operationId": "user-me-detail",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserProfile"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-me-patch",
+                "parameters": [
+                    {
+                        "description": "fields to update",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserPatch"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserProfile"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/email-verification": {
+            "post": {
+                "operationId": "user-me-email-verification-request",
+                "responses": {
+                    "202": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/identities": {
+            "get": {
+                "operationId": "user-me-identity-list",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/domain.UserIdentity"
+                            }
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/identities/{id}": {
+            "delete": {
+                "operationId": "user-me-identity-unlink",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/mfa": {
+            "post": {
+                "operationId": "user-me-mfa-enroll",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFAEnrollment"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-me-mfa-disable",
+                "parameters": [
+                    {
+                        "description": "a TOTP code or a recovery code",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFACode"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/mfa/confirm": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-me-mfa-confirm",
+                "parameters": [
+                    {
+                        "description": "the TOTP code of the new secret",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFACode"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFARecoveryCodes"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/mfa/recovery-codes": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-me-mfa-recovery-codes",
+                "parameters": [
+                    {
+                        "description": "a TOTP code",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFACode"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MFARecoveryCodes"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/me/usage": {
+            "get": {
+                "operationId": "user-me-usage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FileUsageReport"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/password-reset": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-password-reset-request",
+                "parameters": [
+                    {
+                        "description": "the email of account",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.PasswordResetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/password-reset/confirm": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "operationId": "user-password-reset-confirm",
+                "parameters": [
+                    {
+                        "description": "token in mail and the new password",
+                        "name": "reset",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.PasswordReset"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/{id}": {
+            "get": {
+                "operationId": "user-author-detail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page number of articles based 1",
+                        "name": "page",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AuthorProfile"
+                        }
+                    },
+                    "default": {
+                        "description": "error",
+                        "schema": {
+                            "$ref": "#/definitions/fail.ErrorBody"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "domain.ArticleDetail": {
+            "type": "object",
+            "properties": {
+                "abstracts": {
+                    "type": "string"
+                },
+                "abstracts_generated": {
+                    "description": "AbstractsGenerated the abstracts is generated from the content rather than written by the author,\nsee deriveArticleAbstracts",
+                    "type": "boolean"
+                },
+                "char_count": {
+                    "type": "integer"
+                },
+                "code_block_count": {
+                    "type": "integer"
+                },
+                "comment_num": {
+                    "type": "integer"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "content_html": {
+                    "description": "ContentHTML the sanitized HTML rendered from Content, it is present only if requested, see ArticleDetailQuery",
+                    "type": "string"
+                },
+                "create_time": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "image_count": {
+                    "type": "integer"
+                },
+                "is_elite": {
+                    "type": "boolean"
+                },
+                "is_invalid": {
+                    "type": "boolean"
+                },
+                "is_top": {
+                    "type": "boolean"
+                },
+                "modify_time": {
+                    "type": "string"
+                },
+                "reading_minutes": {
+                    "type": "integer"
+                },
+                "slug": {
+                    "description": "Slug the unique name in permalinks, see assignArticleSlugs",
+                    "type": "string"
+                },
+                "source": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.Tag"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "toc": {
+                    "description": "derived from the content when the article is saved, see deriveArticleStats",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/markdown.Heading"
+                    }
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uid": {
+                    "type": "integer"
+                },
+                "view_num": {
+                    "type": "integer"
+                },
+                "word_count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.ArticleLinkDetail": {
+            "type": "object",
+            "properties": {
+                "broken": {
+                    "type": "boolean"
+                },
+                "source_id": {
+                    "type": "integer"
+                },
+                "target": {
+                    "type": "string"
+                },
+                "target_id": {
+                    "type": "integer"
+                },
+                "target_title": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.ArticleMetaExt": {
+            "type": "object",
+            "properties": {
+                "abstracts": {
+                    "type": "string"
+                },
+                "abstracts_generated": {
+                    "description": "AbstractsGenerated the abstracts is generated from the content rather than written by the author,\nsee deriveArticleAbstracts",
+                    "type": "boolean"
+                },
+                "char_count": {
+                    "type": "integer"
+                },
+                "code_block_count": {
+                    "type": "integer"
+                },
+                "comment_num": {
+                    "type": "integer"
+                },
+                "create_time": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "image_count": {
+                    "type": "integer"
+                },
+                "is_elite": {
+                    "type": "boolean"
+                },
+                "is_invalid": {
+                    "type": "boolean"
+                },
+                "is_top": {
+                    "type": "boolean"
+                },
+                "modify_time": {
+                    "type": "string"
+                },
+                "reading_minutes": {
+                    "type": "integer"
+                },
+                "slug": {
+                    "description": "Slug the unique name in permalinks, see assignArticleSlugs",
+                    "type": "string"
+                },
+                "source": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.Tag"
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "toc": {
+                    "description": "derived from the content when the article is saved, see deriveArticleStats",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/markdown.Heading"
+                    }
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uid": {
+                    "type": "integer"
+                },
+                "view_num": {
+                    "type": "integer"
+                },
+                "word_count": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.ArticleSlugUpdate": {
+            "type": "object",
+            "required": [
+                "slug"
+            ],
+            "properties": {
+                "slug": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "domain.AuthorProfile": {
+            "type": "object",
+            "properties": {
+                "articles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ArticleMetaExt"
+                    }
+                },
+                "avatar": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "real_name": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.EmailVerification": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "type": "string",
+                    "maxLength": 1024
+                }
+            }
+        },
+        "domain.File": {
+            "type": "object",
+            "properties": {
+                "create_time": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "mime_type": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "integer"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.FileGCReport": {
+            "type": "object",
+            "properties": {
+                "blobs": {
+                    "type": "integer"
+                },
+                "reclaimed_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.FileUsageReport": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "quota": {
+                    "type": "integer"
+                },
+                "space_bytes": {
+                    "type": "integer"
+                },
+                "space_quota": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.MFAChallenge": {
+            "type": "object",
+            "properties": {
+                "mfa_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.MFACode": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "maxLength": 32
+                }
+            }
+        },
+        "domain.MFAEnrollment": {
+            "type": "object",
+            "properties": {
+                "secret": {
+                    "type": "string"
+                },
+                "uri": {
+                    "type": "string"
                 }
             }
         },
-        "/v1/tags": {
-            "get": {
-                "operationId": "tag-with-stat-list",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/domain.TagWithStat"
-                            }
-                        }
-                    },
-                    "default": {
-                        "description": "error",
-                        "schema": {
-                            "$ref": "#/definitions/fail.ErrorBody"
-                        }
+        "domain.MFARecoveryCodes": {
+            "type": "object",
+            "properties": {
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "domain.ArticleDetail": {
+        },
+        "domain.MFAVerification": {
             "type": "object",
+            "required": [
+                "code",
+                "mfa_token"
+            ],
             "properties": {
-                "abstracts": {
-                    "type": "string"
+                "code": {
+                    "type": "string",
+                    "maxLength": 32
                 },
-                "comment_num": {
+                "mfa_token": {
+                    "type": "string",
+                    "maxLength": 128
+                }
+            }
+        },
+        "domain.PasswordReset": {
+            "type": "object",
+            "required": [
+                "password",
+                "token"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string",
+                    "maxLength": 64,
+                    "minLength": 6
+                },
+                "token": {
+                    "type": "string",
+                    "maxLength": 1024
+                }
+            }
+        },
+        "domain.PasswordResetRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "domain.Tag": {
+            "type": "object",
+            "properties": {
+                "id": {
                     "type": "integer"
                 },
-                "content": {
+                "image": {
                     "type": "string"
                 },
-                "create_time": {
+                "name": {
                     "type": "string"
                 },
+                "note": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.TagWithStat": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
                 "id": {
                     "type": "integer"
                 },
-                "is_elite": {
-                    "type": "boolean"
+                "image": {
+                    "type": "string"
                 },
-                "is_invalid": {
-                    "type": "boolean"
+                "name": {
+                    "type": "string"
                 },
-                "is_top": {
-                    "type": "boolean"
+                "note": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.UserIdentity": {
+            "type": "object",
+            "properties": {
+                "auth_channel": {
+                    "type": "integer"
                 },
-                "modify_time": {
+                "channel_key": {
                     "type": "string"
                 },
-                "source": {
+                "id": {
                     "type": "integer"
                 },
-                "status": {
+                "user": {
                     "type": "integer"
+                }
+            }
+        },
+        "domain.UserPatch": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string",
+                    "maxLength": 255
                 },
-                "tags": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/domain.Tag"
-                    }
+                "email": {
+                    "type": "string",
+                    "maxLength": 255
                 },
-                "title": {
-                    "type": "string"
+                "locale": {
+                    "type": "string",
+                    "maxLength": 35
                 },
-                "type": {
-                    "type": "integer"
+                "phone_no": {
+                    "type": "string",
+                    "maxLength": 32
                 },
-                "uid": {
-                    "type": "integer"
+                "real_name": {
+                    "type": "string",
+                    "maxLength": 255
                 },
-                "view_num": {
-                    "type": "integer"
+                "theme": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "theme_editor": {
+                    "type": "string",
+                    "maxLength": 255
                 }
             }
         },
-        "domain.ArticleMetaExt": {
+        "domain.UserProfile": {
             "type": "object",
             "properties": {
-                "abstracts": {
+                "avatar": {
                     "type": "string"
                 },
-                "comment_num": {
-                    "type": "integer"
-                },
                 "create_time": {
                     "type": "string"
                 },
-                "id": {
-                    "type": "integer"
+                "email": {
+                    "type": "string"
                 },
-                "is_elite": {
+                "email_verified": {
                     "type": "boolean"
                 },
-                "is_invalid": {
-                    "type": "boolean"
+                "id": {
+                    "type": "integer"
                 },
-                "is_top": {
-                    "type": "boolean"
+                "locale": {
+                    "type": "string"
                 },
                 "modify_time": {
                     "type": "string"
                 },
-                "source": {
-                    "type": "integer"
+                "phone_no": {
+                    "type": "string"
                 },
-                "status": {
-                    "type": "integer"
+                "real_name": {
+                    "type": "string"
                 },
-                "tags": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/domain.Tag"
-                    }
+                "theme": {
+                    "type": "string"
                 },
-                "title": {
+                "theme_editor": {
                     "type": "string"
                 },
-                "type": {
-                    "type": "integer"
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.UserSummary": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string"
                 },
-                "uid": {
-                    "type": "integer"
+                "create_time": {
+                    "type": "string"
                 },
-                "view_num": {
+                "email": {
+                    "type": "string"
+                },
+                "email_verified": {
+                    "type": "boolean"
+                },
+                "id": {
                     "type": "integer"
+                },
+                "is_locked": {
+                    "type": "boolean"
+                },
+                "locale": {
+                    "type": "string"
+                },
+                "modify_time": {
+                    "type": "string"
+                },
+                "phone_no": {
+                    "type": "string"
+                },
+                "real_name": {
+                    "type": "string"
+                },
+                "theme": {
+                    "type": "string"
+                },
+                "theme_editor": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
                 }
             }
         },
-        "domain.Tag": {
+        "fail.ErrorBody": {
             "type": "object",
             "properties": {
-                "id": {
-                    "type": "integer"
+                "code": {
+                    "type": "string"
                 },
-                "image": {
+                "data": {},
+                "message": {
                     "type": "string"
                 },
-                "name": {
+                "trace_id": {
                     "type": "string"
+                }
+            }
+        },
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/health.Result"
+                    }
                 },
-                "note": {
+                "status": {
                     "type": "string"
                 }
             }
         },
-        "domain.TagWithStat": {
+        "health.Result": {
             "type": "object",
             "properties": {
-                "count": {
-                    "type": "integer"
+                "check_time": {
+                    "type": "string"
                 },
-                "id": {
+                "duration_ms": {
                     "type": "integer"
                 },
-                "image": {
+                "error": {
                     "type": "string"
                 },
                 "name": {
                     "type": "string"
                 },
-                "note": {
+                "status": {
                     "type": "string"
                 }
             }
         },
-        "fail.ErrorBody": {
+        "logging.LogLevel": {
             "type": "object",
+            "required": [
+                "level"
+            ],
             "properties": {
-                "code": {
+                "level": {
+                    "type": "string",
+                    "enum": [
+                        "panic",
+                        "fatal",
+                        "error",
+                        "warn",
+                        "warning",
+                        "info",
+                        "debug",
+                        "trace"
+                    ]
+                }
+            }
+        },
+        "markdown.Heading": {
+            "type": "object",
+            "properties": {
+                "anchor": {
                     "type": "string"
                 },
-                "data": {},
-                "message": {
+                "children": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/markdown.Heading"
+                    }
+                },
+                "level": {
+                    "type": "integer"
+                },
+                "title": {
                     "type": "string"
                 }
             }
@@ -306,6 +1876,23 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "sessions.LoginRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "password"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "password": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
         }
     }
 }`
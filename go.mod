@@ -1,77 +1,85 @@
 module owlet
 
-go 1.16
+go 1.19
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/DATA-DOG/go-sqlmock v1.5.0
-	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
 	github.com/aliyun/aliyun-oss-go-sdk v2.2.0+incompatible
-	github.com/baiyubin/aliyun-sts-go-sdk v0.0.0-20180326062324-cfa1a18b161f // indirect
 	github.com/fundwit/go-commons v0.3.2
 	github.com/gin-gonic/gin v1.7.7
-	github.com/go-openapi/spec v0.20.4 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.4.1
 	github.com/go-playground/validator/v10 v10.4.1
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/golang/mock v1.6.0
 	github.com/google/uuid v1.3.0
-	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/mozillazg/go-pinyin v0.21.0
 	github.com/nicksnyder/go-i18n/v2 v2.1.2
 	github.com/onsi/gomega v1.18.1
 	github.com/opentracing/opentracing-go v1.2.0
-	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/satori/go.uuid v1.2.0 // indirect
+	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/prometheus/client_golang v1.12.2
 	github.com/sirupsen/logrus v1.7.0
 	github.com/smartystreets/goconvey v1.7.2
 	github.com/sony/sonyflake v1.0.0
-	github.com/spf13/cobra v1.4.0 // indirect
-	github.com/swaggo/files v0.0.0-20210815190702-a29dd2bc99b2 // indirect
-	github.com/swaggo/gin-swagger v1.4.1 // indirect
-	github.com/swaggo/swag v1.8.0 // indirect
+	github.com/spf13/cobra v1.4.0
+	github.com/swaggo/files v0.0.0-20210815190702-a29dd2bc99b2
+	github.com/swaggo/gin-swagger v1.4.1
+	github.com/swaggo/swag v1.8.0
 	github.com/uber/jaeger-client-go v2.29.1+incompatible
 	github.com/uber/jaeger-lib v2.4.1+incompatible
-	go.uber.org/atomic v1.9.0 // indirect
-	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
-	golang.org/x/text v0.3.7
-	golang.org/x/time v0.0.0-20211116232009-f0f3c7e86c11 // indirect
-	golang.org/x/tools v0.1.8 // indirect
+	github.com/yuin/goldmark v1.4.13
+	golang.org/x/crypto v0.24.0
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	golang.org/x/text v0.16.0
+	golang.org/x/time v0.0.0-20211116232009-f0f3c7e86c11
 	gopkg.in/yaml.v2 v2.4.0
 	gorm.io/driver/mysql v1.2.1
 	gorm.io/gorm v1.22.4
 	gorm.io/plugin/opentracing v0.0.0-20211008090106-7b0d17ed1816
 )
 
-// require (
-// 	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
-// 	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
-// 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
-// 	github.com/cpuguy83/go-md2man/v2 v2.0.1 // indirect
-// 	github.com/fundwit/go-commons v0.3.2
-// 	github.com/gin-gonic/gin v1.7.4
-// 	github.com/go-openapi/spec v0.20.4 // indirect
-// 	github.com/go-playground/validator/v10 v10.4.1
-// 	github.com/go-sql-driver/mysql v1.6.0
-// 	github.com/google/uuid v1.3.0
-// 	github.com/mailru/easyjson v0.7.7 // indirect
-// 	github.com/nicksnyder/go-i18n/v2 v2.1.2
-// 	github.com/onsi/gomega v1.13.0
-// 	github.com/opentracing/opentracing-go v1.2.0
-// 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
-// 	github.com/pkg/errors v0.9.1 // indirect
-// 	github.com/sirupsen/logrus v1.7.0
-// 	github.com/sony/sonyflake v1.0.0 // indirect
-// 	github.com/swaggo/files v0.0.0-20210815190702-a29dd2bc99b2
-// 	github.com/swaggo/gin-swagger v1.3.3
-// 	github.com/swaggo/swag v1.7.6 // indirect
-// 	github.com/uber/jaeger-client-go v2.29.1+incompatible
-// 	github.com/uber/jaeger-lib v2.4.1+incompatible
-// 	go.uber.org/atomic v1.9.0 // indirect
-// 	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f // indirect
-// 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
-// 	golang.org/x/text v0.3.7
-// 	golang.org/x/tools v0.1.8 // indirect
-// 	gopkg.in/yaml.v2 v2.4.0
-// 	gorm.io/driver/mysql v1.2.1
-// 	gorm.io/gorm v1.22.4
-// 	gorm.io/plugin/opentracing v0.0.0-20211008090106-7b0d17ed1816
-// )
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20200615164410-66371956d46c // indirect
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
+	github.com/KyleBanks/depth v1.2.1 // indirect
+	github.com/PuerkitoBio/purell v1.1.1 // indirect
+	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/baiyubin/aliyun-sts-go-sdk v0.0.0-20180326062324-cfa1a18b161f // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/gin-contrib/sse v0.1.0 // indirect
+	github.com/go-openapi/jsonpointer v0.19.5 // indirect
+	github.com/go-openapi/jsonreference v0.19.6 // indirect
+	github.com/go-openapi/spec v0.20.4 // indirect
+	github.com/go-openapi/swag v0.19.15 // indirect
+	github.com/go-playground/locales v0.13.0 // indirect
+	github.com/go-playground/universal-translator v0.17.0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.3 // indirect
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/leodido/go-urn v1.2.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.32.1 // indirect
+	github.com/prometheus/procfs v0.7.3 // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/ugorji/go/codec v1.1.7 // indirect
+	go.uber.org/atomic v1.9.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	google.golang.org/protobuf v1.26.0 // indirect
+)
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"owlet/server/infra/app"
+	"os"
+	"owlet/cmd"
 )
 
 // @Title owlet
@@ -10,5 +11,7 @@ import (
 // @Accept  json
 // @Produce  json
 func main() {
-	app.Bootstrap()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
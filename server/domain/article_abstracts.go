@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"owlet/server/infra/markdown"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+
+	"github.com/fundwit/go-commons/types"
+)
+
+// ArticleAbstractsMaxLength the length of the abstracts column in characters
+const ArticleAbstractsMaxLength = 1000
+
+var BackfillArticleAbstractsFunc = BackfillArticleAbstracts
+
+// deriveArticleAbstracts generate the abstracts from the content if the author did not write one.
+// The generated abstracts is refreshed with the content, while the one written by the author is always kept.
+func deriveArticleAbstracts(a *ArticleRecord) {
+	if a.Abstracts != "" && !a.AbstractsGenerated {
+		return
+	}
+	a.Abstracts = markdown.Summarize(a.Content, ArticleAbstractsMaxLength)
+	a.AbstractsGenerated = a.Abstracts != ""
+}
+
+// BackfillArticleAbstracts generate the abstracts of saved articles which have no abstracts,
+// the generated abstracts are generated again if regenerate is true. The articles summarized to nothing are kept
+// as they are. The number of updated articles is returned.
+func BackfillArticleAbstracts(regenerate bool, s *sessions.Session) (int, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	updated := 0
+	var lastID types.ID
+	for {
+		var articles []ArticleRecord
+		q := db.Model(&ArticleRecord{}).Select("id, abstracts, abstracts_generated, content").Where("id > ?", lastID)
+		if regenerate {
+			q = q.Where("abstracts IS NULL OR abstracts = '' OR abstracts_generated = ?", true)
+		} else {
+			q = q.Where("abstracts IS NULL OR abstracts = ''")
+		}
+		if err := q.Order("id").Limit(articleStatsBatchSize).Find(&articles).Error; err != nil {
+			return updated, err
+		}
+		for _, a := range articles {
+			lastID = a.ID
+			deriveArticleAbstracts(&a)
+			if a.Abstracts == "" {
+				continue // nothing to summarize, e.g. the content has code blocks only
+			}
+			if err := db.Model(&ArticleRecord{}).Where("id = ?", a.ID).Updates(map[string]interface{}{
+				"abstracts": a.Abstracts, "abstracts_generated": a.AbstractsGenerated,
+			}).Error; err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if len(articles) < articleStatsBatchSize {
+			return updated, nil
+		}
+	}
+}
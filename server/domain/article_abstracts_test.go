@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+func TestDeriveArticleAbstracts(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should generate abstracts if it is empty or generated", func(t *testing.T) {
+		a := ArticleRecord{Content: "# Title\n\nFirst sentence.\n\n```\ncode\n```"}
+		deriveArticleAbstracts(&a)
+		Expect(a.Abstracts).To(Equal("First sentence."))
+		Expect(a.AbstractsGenerated).To(BeTrue())
+
+		a.Content = "Modified."
+		deriveArticleAbstracts(&a)
+		Expect(a.Abstracts).To(Equal("Modified."))
+		Expect(a.AbstractsGenerated).To(BeTrue())
+
+		a.Content = "```\ncode only\n```"
+		deriveArticleAbstracts(&a)
+		Expect(a.Abstracts).To(BeEmpty())
+		Expect(a.AbstractsGenerated).To(BeFalse())
+	})
+
+	t.Run("should keep abstracts written by author", func(t *testing.T) {
+		a := ArticleRecord{ArticleMeta: ArticleMeta{Abstracts: "manual"}, Content: "content"}
+		deriveArticleAbstracts(&a)
+		Expect(a.Abstracts).To(Equal("manual"))
+		Expect(a.AbstractsGenerated).To(BeFalse())
+	})
+}
+
+func TestBackfillArticleAbstracts(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+
+	t.Run("should generate abstracts of articles without abstracts", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, abstracts, abstracts_generated, content FROM `article` " +
+			"WHERE id > ? AND (abstracts IS NULL OR abstracts = '') ORDER BY id LIMIT 100")).
+			WithArgs(0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "abstracts", "abstracts_generated", "content"}).
+				AddRow(1, nil, false, "Hello *world*.").AddRow(2, "", false, "```\ncode\n```"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `abstracts`=?,`abstracts_generated`=? WHERE id = ?")).
+			WithArgs("Hello world.", true, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// article 2 is summarized to nothing, it is neither updated nor counted
+		updated, err := BackfillArticleAbstracts(false, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated).To(Equal(1))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should regenerate the generated abstracts", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, abstracts, abstracts_generated, content FROM `article` "+
+			"WHERE id > ? AND (abstracts IS NULL OR abstracts = '' OR abstracts_generated = ?) ORDER BY id LIMIT 100")).
+			WithArgs(0, true).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "abstracts", "abstracts_generated", "content"}).
+				AddRow(1, "stale", true, "Fresh."))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `abstracts`=?,`abstracts_generated`=? WHERE id = ?")).
+			WithArgs("Fresh.", true, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		updated, err := BackfillArticleAbstracts(true, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated).To(Equal(1))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, abstracts, abstracts_generated, content FROM `article`")).
+			WillReturnError(errors.New("some error"))
+
+		updated, err := BackfillArticleAbstracts(false, s)
+		Expect(err).To(MatchError("some error"))
+		Expect(updated).To(BeZero())
+	})
+}
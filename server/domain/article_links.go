@@ -0,0 +1,305 @@
+package domain
+
+import (
+	"owlet/server/infra/idgen"
+	"owlet/server/infra/markdown"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+)
+
+// maxLinkTargetLength the targets longer than titles never match an article
+const maxLinkTargetLength = 255
+
+// ArticleLink a wiki link '[[target]]' in the content of the source article. The target is the title or the id
+// of the linked article, TargetID is 0 until an article matching the target is saved.
+type ArticleLink struct {
+	ID       types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	SourceID types.ID `json:"source_id" gorm:"type:BIGINT UNSIGNED NOT NULL;uniqueIndex:uk_article_link,priority:1"`
+	Target   string   `json:"target" gorm:"type:NVARCHAR(255) NOT NULL;uniqueIndex:uk_article_link,priority:2"`
+	TargetID types.ID `json:"target_id" gorm:"type:BIGINT UNSIGNED NOT NULL DEFAULT '0';index:idx_article_link_target"`
+}
+
+func (l *ArticleLink) TableName() string {
+	return "article_link"
+}
+
+// ArticleLinkDetail a link is broken if the target is not found, deleted or unpublished
+type ArticleLinkDetail struct {
+	SourceID    types.ID `json:"source_id"`
+	Target      string   `json:"target"`
+	TargetID    types.ID `json:"target_id"`
+	TargetTitle string   `json:"target_title"`
+	Broken      bool     `json:"broken"`
+}
+
+var (
+	QueryArticleLinksFunc       = QueryArticleLinks
+	QueryArticleBacklinksFunc   = QueryArticleBacklinks
+	QueryBrokenArticleLinksFunc = QueryBrokenArticleLinks
+)
+
+// QueryArticleLinks the links in the content of the article
+func QueryArticleLinks(id types.ID, s *sessions.Session) ([]ArticleLinkDetail, error) {
+	links := []ArticleLinkDetail{}
+	err := articleLinkDetails(persistence.ActiveGormDB.WithContext(s.Context)).
+		Where("article_link.source_id = ?", id).Order("article_link.target").Scan(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// QueryBrokenArticleLinks the broken links of all articles
+func QueryBrokenArticleLinks(s *sessions.Session) ([]ArticleLinkDetail, error) {
+	links := []ArticleLinkDetail{}
+	err := articleLinkDetails(persistence.ActiveGormDB.WithContext(s.Context)).
+		Where("article.id IS NULL OR article.is_invalid = 1 OR article.status <> 1").
+		Order("article_link.source_id, article_link.target").Scan(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func articleLinkDetails(db *gorm.DB) *gorm.DB {
+	return db.Table("article_link").
+		Select("article_link.source_id, article_link.target, article_link.target_id, " +
+			"COALESCE(article.title, '') AS target_title, " +
+			"(article.id IS NULL OR article.is_invalid = 1 OR article.status <> 1) AS broken").
+		Joins("LEFT JOIN article ON article.id = article_link.target_id")
+}
+
+// QueryArticleBacklinks the articles linking to the article, which are visible to the session
+func QueryArticleBacklinks(id types.ID, s *sessions.Session) ([]ArticleMetaExt, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	var sourceIDs []types.ID
+	if err := db.Model(&ArticleLink{}).Where("target_id = ?", id).Pluck("source_id", &sourceIDs).Error; err != nil {
+		return nil, err
+	}
+	articles := []ArticleMetaExt{}
+	if len(sourceIDs) == 0 {
+		return articles, nil
+	}
+
+	err := db.Model(&ArticleRecord{}).
+		Select("id, type, title, slug, uid, create_time, modify_time, status, is_invalid, "+
+			"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, "+
+			"toc, word_count, char_count, reading_minutes, code_block_count, image_count").
+		Where("id IN ? AND is_invalid = 0 AND (status = 1 || uid = ?)", sourceIDs, s.Identity.ID).
+		Order("create_time DESC").
+		Scan(&articles).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := appendTags(articles, s); err != nil {
+		return nil, err
+	}
+	return articles, nil
+}
+
+// syncArticleLinks replace the links of articles with the wiki links in their contents, the targets are resolved
+// by id first and then by title. It should be called in the transaction saving the articles.
+func syncArticleLinks(tx *gorm.DB, articles []ArticleRecord) error {
+	if len(articles) == 0 {
+		return nil
+	}
+
+	sourceIDs := []types.ID{}
+	linksOfSource := map[types.ID][]markdown.WikiLink{}
+	targetIDs, titles := []types.ID{}, []string{}
+	for _, a := range articles {
+		sourceIDs = append(sourceIDs, a.ID)
+		for _, link := range markdown.WikiLinks(a.Content) {
+			if utf8.RuneCountInString(link.Target) > maxLinkTargetLength {
+				continue
+			}
+			linksOfSource[a.ID] = append(linksOfSource[a.ID], link)
+			if id, err := types.ParseID(link.Target); err == nil {
+				targetIDs = append(targetIDs, id)
+			}
+			titles = append(titles, link.Target)
+		}
+	}
+
+	resolved := map[string]types.ID{}
+	if len(titles) > 0 {
+		var targets []ArticleRecord
+		q := tx.Model(&ArticleRecord{}).Select("id, title")
+		if len(targetIDs) > 0 {
+			q = q.Where("id IN ? OR title IN ?", targetIDs, titles)
+		} else {
+			q = q.Where("title IN ?", titles)
+		}
+		if err := q.Order("id").Find(&targets).Error; err != nil {
+			return err
+		}
+		for _, t := range targets {
+			if _, found := resolved[t.Title]; !found {
+				resolved[t.Title] = t.ID
+			}
+		}
+		// the ids take precedence over the titles
+		for _, t := range targets {
+			resolved[t.ID.String()] = t.ID
+		}
+	}
+
+	newLinks := []ArticleLink{}
+	for _, sourceID := range sourceIDs {
+		for _, link := range linksOfSource[sourceID] {
+			newLinks = append(newLinks, ArticleLink{ID: idgen.NextID(idWorker), SourceID: sourceID,
+				Target: link.Target, TargetID: resolved[link.Target]})
+		}
+	}
+
+	if err := tx.Where("source_id IN ?", sourceIDs).Delete(&ArticleLink{}).Error; err != nil {
+		return err
+	}
+	if len(newLinks) > 0 {
+		if err := tx.Create(&newLinks).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveDanglingArticleLinks the links to the targets not found before are resolved to the saved articles
+func resolveDanglingArticleLinks(tx *gorm.DB, articles []ArticleRecord) error {
+	for _, a := range articles {
+		err := tx.Model(&ArticleLink{}).Where("target_id = 0 AND target IN ?", []string{a.ID.String(), a.Title}).
+			Update("target_id", a.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteRenamedArticleLinks the links to the renamed articles by the old titles are rewritten to the new titles.
+// The contents of articles being saved are rewritten in place, the others are updated together with the fields
+// derived from the contents. It should be called before the articles are saved.
+func rewriteRenamedArticleLinks(tx *gorm.DB, articles []ArticleRecord) error {
+	ids := []types.ID{}
+	indexOfID := map[types.ID]int{}
+	for idx, a := range articles {
+		ids = append(ids, a.ID)
+		indexOfID[a.ID] = idx
+	}
+	sortIDs(ids)
+
+	var saved []ArticleRecord
+	if err := tx.Model(&ArticleRecord{}).Select("id, title").Where("id IN ?", ids).Order("id").
+		Find(&saved).Error; err != nil {
+		return err
+	}
+	for _, old := range saved {
+		title := articles[indexOfID[old.ID]].Title
+		if old.Title == "" || old.Title == title {
+			continue
+		}
+		rename := func(link markdown.WikiLink) string {
+			if link.Target == old.Title {
+				link.Target = title
+			}
+			return link.String()
+		}
+
+		var sourceIDs []types.ID
+		if err := tx.Model(&ArticleLink{}).Where("target_id = ? AND target = ?", old.ID, old.Title).
+			Order("source_id").Pluck("source_id", &sourceIDs).Error; err != nil {
+			return err
+		}
+		others := []types.ID{}
+		for _, sourceID := range sourceIDs {
+			if idx, found := indexOfID[sourceID]; found {
+				articles[idx].Content = markdown.ReplaceWikiLinks(articles[idx].Content, rename)
+			} else {
+				others = append(others, sourceID)
+			}
+		}
+		if err := rewriteArticleContents(tx, others, rename); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&ArticleLink{}).Where("target_id = ? AND target = ?", old.ID, old.Title).
+			Update("target", title).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rewriteArticleContents(tx *gorm.DB, ids []types.ID, rewrite func(link markdown.WikiLink) string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var sources []ArticleRecord
+	if err := tx.Model(&ArticleRecord{}).Select("id, abstracts, abstracts_generated, content").
+		Where("id IN ?", ids).Order("id").Find(&sources).Error; err != nil {
+		return err
+	}
+	for _, a := range sources {
+		a.Content = markdown.ReplaceWikiLinks(a.Content, rewrite)
+		deriveArticleStats(&a)
+		deriveArticleAbstracts(&a)
+		if err := tx.Model(&ArticleRecord{}).Where("id = ?", a.ID).Updates(map[string]interface{}{
+			"content": a.Content, "abstracts": a.Abstracts, "abstracts_generated": a.AbstractsGenerated,
+			"toc": a.TOC, "word_count": a.WordCount, "char_count": a.CharCount, "reading_minutes": a.ReadingMinutes,
+			"code_block_count": a.CodeBlockCount, "image_count": a.ImageCount,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkWikiLinks convert the wiki links of the content to Markdown links of the linked articles before rendered,
+// the broken links are converted to plain texts
+func linkWikiLinks(content string, links []ArticleLinkDetail) string {
+	linkOfTarget := map[string]ArticleLinkDetail{}
+	for _, l := range links {
+		linkOfTarget[l.Target] = l
+	}
+	return markdown.ReplaceWikiLinks(content, func(link markdown.WikiLink) string {
+		detail, found := linkOfTarget[link.Target]
+		if !found || detail.Broken {
+			return escapeMarkdown(link.Text())
+		}
+		text := link.Label
+		if text == "" {
+			text = detail.TargetTitle
+		}
+		return "[" + escapeMarkdown(text) + "](" + PathArticles + "/" + detail.TargetID.String() + ")"
+	})
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`",
+	"<", `\<`, ">", `\>`)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// backfillArticleLinks record the links of the articles saved before the links are introduced
+func backfillArticleLinks(db *gorm.DB) error {
+	var lastID types.ID
+	for {
+		var articles []ArticleRecord
+		if err := db.Model(&ArticleRecord{}).Select("id, title, content").Where("id > ?", lastID).Order("id").
+			Limit(articleStatsBatchSize).Find(&articles).Error; err != nil {
+			return err
+		}
+		if err := syncArticleLinks(db, articles); err != nil {
+			return err
+		}
+		if len(articles) < articleStatsBatchSize {
+			return nil
+		}
+		lastID = articles[len(articles)-1].ID
+	}
+}
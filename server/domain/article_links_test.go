@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+)
+
+var articleLinkDetailColumns = []string{"source_id", "target", "target_id", "target_title", "broken"}
+
+func TestQueryArticleLinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+	const selectExpr = "SELECT article_link.source_id, article_link.target, article_link.target_id, " +
+		"COALESCE(article.title, '') AS target_title, " +
+		"(article.id IS NULL OR article.is_invalid = 1 OR article.status <> 1) AS broken FROM `article_link` " +
+		"LEFT JOIN article ON article.id = article_link.target_id "
+
+	t.Run("should query links of article with broken flags", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta(selectExpr + "WHERE article_link.source_id = ? ORDER BY article_link.target")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(articleLinkDetailColumns).
+				AddRow(100, "Intro", 200, "Intro", 0).AddRow(100, "Missing", 0, "", 1))
+
+		links, err := QueryArticleLinks(100, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(links).To(Equal([]ArticleLinkDetail{
+			{SourceID: 100, Target: "Intro", TargetID: 200, TargetTitle: "Intro"},
+			{SourceID: 100, Target: "Missing", Broken: true},
+		}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should query broken links of all articles", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta(selectExpr +
+			"WHERE article.id IS NULL OR article.is_invalid = 1 OR article.status <> 1 " +
+			"ORDER BY article_link.source_id, article_link.target")).
+			WillReturnRows(sqlmock.NewRows(articleLinkDetailColumns).AddRow(100, "Draft", 300, "Draft", 1))
+
+		links, err := QueryBrokenArticleLinks(s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(links).To(Equal([]ArticleLinkDetail{
+			{SourceID: 100, Target: "Draft", TargetID: 300, TargetTitle: "Draft", Broken: true},
+		}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta(selectExpr)).WillReturnError(errors.New("some error"))
+		mock.ExpectQuery(regexp.QuoteMeta(selectExpr)).WillReturnError(errors.New("some error"))
+
+		links, err := QueryArticleLinks(100, s)
+		Expect(err).To(MatchError("some error"))
+		Expect(links).To(BeNil())
+		links, err = QueryBrokenArticleLinks(s)
+		Expect(err).To(MatchError("some error"))
+		Expect(links).To(BeNil())
+	})
+}
+
+func TestQueryArticleBacklinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 10}}
+
+	t.Run("should query visible articles linking to the article", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `source_id` FROM `article_link` WHERE target_id = ?")).
+			WithArgs(200).WillReturnRows(sqlmock.NewRows([]string{"source_id"}).AddRow(100).AddRow(101))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, "+
+			"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, "+
+			"toc, word_count, char_count, reading_minutes, code_block_count, image_count FROM `article` "+
+			"WHERE id IN (?,?) AND is_invalid = 0 AND (status = 1 || uid = ?) ORDER BY create_time DESC")).
+			WithArgs(100, 101, 10).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(100, "linking"))
+		QueryTagAssignmentsFunc = func(resIds []types.ID, s *sessions.Session) ([]TagAssignment, error) {
+			Expect(resIds).To(Equal([]types.ID{100}))
+			return nil, nil
+		}
+
+		articles, err := QueryArticleBacklinks(200, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(articles).To(Equal([]ArticleMetaExt{{ArticleMeta: ArticleMeta{ID: 100, Title: "linking"}}}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return empty list if no article links to the article", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `source_id` FROM `article_link` WHERE target_id = ?")).
+			WithArgs(200).WillReturnRows(sqlmock.NewRows([]string{"source_id"}))
+
+		articles, err := QueryArticleBacklinks(200, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(articles).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `source_id` FROM `article_link`")).WillReturnError(errors.New("some error"))
+
+		articles, err := QueryArticleBacklinks(200, s)
+		Expect(err).To(MatchError("some error"))
+		Expect(articles).To(BeNil())
+	})
+}
+
+func TestLinkWikiLinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	links := []ArticleLinkDetail{
+		{Target: "Intro", TargetID: 200, TargetTitle: "Intro"},
+		{Target: "300", TargetID: 300, TargetTitle: "The [best] guide"},
+		{Target: "Draft", TargetID: 400, TargetTitle: "Draft", Broken: true},
+	}
+	content := "[[Intro]], [[Intro|the intro]], [[300]], [[Draft|a draft]], [[Unknown]] and `[[Intro]]`"
+	Expect(linkWikiLinks(content, links)).To(Equal("[Intro](/v1/articles/200), [the intro](/v1/articles/200), " +
+		`[The \[best\] guide](/v1/articles/300), a draft, Unknown and ` + "`[[Intro]]`"))
+}
+
+func TestBackfillArticleLinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should record links of each article", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content FROM `article` WHERE id > ? ORDER BY id LIMIT 100")).
+			WithArgs(0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content"}).
+				AddRow(1, "A", "see [[B]]").AddRow(2, "B", "no links"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article` WHERE title IN (?) ORDER BY id")).
+			WithArgs("B").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(2, "B"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_link` WHERE source_id IN (?,?)")).
+			WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article_link` (`source_id`,`target`,`target_id`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(1, "B", 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(backfillArticleLinks(db)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content FROM `article`")).WillReturnError(errors.New("some error"))
+
+		Expect(backfillArticleLinks(db)).To(MatchError("some error"))
+	})
+}
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"owlet/server/infra/markdown"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	ArticleFormatMarkdown = "markdown"
+	ArticleFormatHTML     = "html"
+
+	// renderedArticleTTL the time a rendered revision is kept since it is rendered
+	renderedArticleTTL = time.Hour
+)
+
+// ArticleDetailQuery the content is always returned as Markdown, the sanitized HTML is returned additionally
+// as content_html if the format is html
+type ArticleDetailQuery struct {
+	Format string `form:"format" binding:"omitempty,oneof=markdown html"`
+}
+
+// renderedArticles the HTML of articles keyed by the id and the digest of content, so each revision is
+// rendered once and a stale HTML is never served after the content is modified
+var renderedArticles = cache.New(renderedArticleTTL, 10*time.Minute)
+
+// renderArticle render the content of article to sanitized HTML (see markdown.Render).
+// The content with signed links is rendered each time, the links differ in each request.
+func renderArticle(id types.ID, content string, signed bool) (string, error) {
+	if signed {
+		return markdown.Render(content)
+	}
+	digest := sha256.Sum256([]byte(content))
+	key := id.String() + ":" + hex.EncodeToString(digest[:])
+	if rendered, found := renderedArticles.Get(key); found {
+		return rendered.(string), nil
+	}
+	rendered, err := markdown.Render(content)
+	if err != nil {
+		return "", err
+	}
+	renderedArticles.SetDefault(key, rendered)
+	return rendered, nil
+}
@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"errors"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/slugs"
+	"strconv"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ArticleSlug a retired slug of article, it is kept so that the permalinks shared before are redirected
+// to the current slug of the article
+type ArticleSlug struct {
+	Slug       string          `json:"slug" gorm:"primary_key;type:VARCHAR(100) NOT NULL"`
+	ArticleID  types.ID        `json:"article_id" gorm:"type:BIGINT UNSIGNED NOT NULL;index:idx_article_slug_article"`
+	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
+}
+
+func (s *ArticleSlug) TableName() string {
+	return "article_slug"
+}
+
+// ArticleSlugUpdate the requested slug is normalized, e.g. 'My Article' => 'my-article'
+type ArticleSlugUpdate struct {
+	Slug string `json:"slug" binding:"required,lte=255"`
+}
+
+var (
+	ResolveArticleSlugFunc = ResolveArticleSlug
+	UpdateArticleSlugFunc  = UpdateArticleSlug
+)
+
+// ResolveArticleSlug the id and the current slug of the article addressed by the slug, which is either the current
+// slug or a retired one of the article
+func ResolveArticleSlug(slug string, s *sessions.Session) (types.ID, string, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	var a ArticleRecord
+	err := db.Model(&ArticleRecord{}).Select("id, slug").Where("slug = ?", slug).First(&a).Error
+	if err == nil {
+		return a.ID, a.Slug, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, "", err
+	}
+
+	var retired ArticleSlug
+	if err := db.Where("slug = ?", slug).First(&retired).Error; err != nil {
+		return 0, "", err
+	}
+	if err := db.Model(&ArticleRecord{}).Select("id, slug").Where("id = ?", retired.ArticleID).
+		First(&a).Error; err != nil {
+		return 0, "", err
+	}
+	return a.ID, a.Slug, nil
+}
+
+// UpdateArticleSlug change the slug of article owned by the user in session, administrators are able to change
+// the slug of any article. The slug is suffixed with a sequence if it is used by another article, and the
+// previous slug is retired. The slug in effect is returned.
+func UpdateArticleSlug(id types.ID, slug string, s *sessions.Session) (string, error) {
+	base := slugs.Make(slug)
+	if base == "" {
+		return "", &fail.ErrBadParam{Param: "slug", InvalidValue: slug}
+	}
+
+	result := ""
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var a ArticleRecord
+		if err := tx.Model(&ArticleRecord{}).Select("id, uid, slug").Where("id = ?", id).First(&a).Error; err != nil {
+			return err
+		}
+		if a.UID != s.Identity.ID && !s.Perms.HasRole(authority.RoleAdmin) {
+			return fail.ErrForbidden
+		}
+		unique, err := uniqueArticleSlug(tx, base, id, nil)
+		if err != nil {
+			return err
+		}
+		result = unique
+		if unique == a.Slug {
+			return nil
+		}
+		if err := tx.Model(&ArticleRecord{}).Where("id = ?", id).Update("slug", unique).Error; err != nil {
+			return err
+		}
+		return retireArticleSlug(tx, id, a.Slug, unique)
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// assignArticleSlugs decide the slugs of the articles before they are saved. The slug given is normalized,
+// otherwise the saved slug is kept or a new slug is generated from the title. The slugs replaced are retired.
+func assignArticleSlugs(tx *gorm.DB, articles []ArticleRecord) error {
+	ids := []types.ID{}
+	for _, a := range articles {
+		ids = append(ids, a.ID)
+	}
+	sortIDs(ids)
+	var saved []ArticleRecord
+	if err := tx.Model(&ArticleRecord{}).Select("id, slug").Where("id IN ?", ids).Order("id").
+		Find(&saved).Error; err != nil {
+		return err
+	}
+	savedSlugs := map[types.ID]string{}
+	for _, a := range saved {
+		savedSlugs[a.ID] = a.Slug
+	}
+
+	taken := map[string]bool{}
+	for idx := range articles {
+		a := &articles[idx]
+		base := slugs.Make(a.Slug)
+		if base == "" {
+			base = savedSlugs[a.ID]
+		}
+		if base == "" {
+			base = articleSlugOfTitle(a.ID, a.Title)
+		}
+		slug, err := uniqueArticleSlug(tx, base, a.ID, taken)
+		if err != nil {
+			return err
+		}
+		a.Slug = slug
+		taken[slug] = true
+
+		if old := savedSlugs[a.ID]; old != slug {
+			if err := retireArticleSlug(tx, a.ID, old, slug); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// articleSlugOfTitle the titles without letters and digits get the slugs like 'article-123'
+func articleSlugOfTitle(id types.ID, title string) string {
+	if slug := slugs.Make(title); slug != "" {
+		return slug
+	}
+	return "article-" + id.String()
+}
+
+// uniqueArticleSlug the slug is suffixed with the smallest sequence from 2 if it is taken, e.g. 'intro-2'.
+// The slugs are taken by other articles currently or formerly, and the ones in taken which are being assigned.
+func uniqueArticleSlug(tx *gorm.DB, base string, id types.ID, taken map[string]bool) (string, error) {
+	var current, retired []string
+	if err := tx.Model(&ArticleRecord{}).Where("(slug = ? OR slug LIKE ?) AND id <> ?", base, base+"-%", id).
+		Pluck("slug", &current).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Model(&ArticleSlug{}).Where("(slug = ? OR slug LIKE ?) AND article_id <> ?", base, base+"-%", id).
+		Pluck("slug", &retired).Error; err != nil {
+		return "", err
+	}
+	used := map[string]bool{}
+	for _, slug := range append(current, retired...) {
+		used[slug] = true
+	}
+
+	slug := base
+	for seq := 2; used[slug] || taken[slug]; seq++ {
+		suffix := "-" + strconv.Itoa(seq)
+		prefix := base
+		if len(prefix)+len(suffix) > slugs.MaxLength {
+			prefix = prefix[:slugs.MaxLength-len(suffix)]
+		}
+		slug = prefix + suffix
+	}
+	return slug, nil
+}
+
+// retireArticleSlug keep the old slug for redirection, the new slug is no longer a retired one of the article
+func retireArticleSlug(tx *gorm.DB, id types.ID, old, slug string) error {
+	if err := tx.Where("slug = ? AND article_id = ?", slug, id).Delete(&ArticleSlug{}).Error; err != nil {
+		return err
+	}
+	if old == "" {
+		return nil
+	}
+	retired := ArticleSlug{Slug: old, ArticleID: id, CreateTime: types.CurrentTimestamp()}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&retired).Error
+}
+
+// backfillArticleSlugs generate the slugs of the articles saved before the slugs are introduced
+func backfillArticleSlugs(db *gorm.DB) error {
+	var lastID types.ID
+	for {
+		var articles []ArticleRecord
+		if err := db.Model(&ArticleRecord{}).Select("id, title").Where("id > ?", lastID).Order("id").
+			Limit(articleStatsBatchSize).Find(&articles).Error; err != nil {
+			return err
+		}
+		for _, a := range articles {
+			slug, err := uniqueArticleSlug(db, articleSlugOfTitle(a.ID, a.Title), a.ID, nil)
+			if err != nil {
+				return err
+			}
+			if err := db.Model(&ArticleRecord{}).Where("id = ?", a.ID).Update("slug", slug).Error; err != nil {
+				return err
+			}
+			lastID = a.ID
+		}
+		if len(articles) < articleStatsBatchSize {
+			return nil
+		}
+	}
+}
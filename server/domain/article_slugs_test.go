@@ -0,0 +1,236 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+// expectUsedArticleSlugs the current and retired slugs like the base used by other articles
+func expectUsedArticleSlugs(mock sqlmock.Sqlmock, base string, id types.ID, used ...string) {
+	rows := sqlmock.NewRows([]string{"slug"})
+	for _, slug := range used {
+		rows.AddRow(slug)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `slug` FROM `article` WHERE (slug = ? OR slug LIKE ?) AND id <> ?")).
+		WithArgs(base, base+"-%", id).WillReturnRows(rows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `slug` FROM `article_slug` WHERE (slug = ? OR slug LIKE ?) AND article_id <> ?")).
+		WithArgs(base, base+"-%", id).WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+}
+
+func TestAssignArticleSlugs(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should generate, keep or normalize slugs and handle collisions", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE id IN (?,?,?,?) ORDER BY id")).
+			WithArgs(1, 2, 3, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(1, "kept").AddRow(2, "before"))
+		// 1: the saved slug is kept even though the title is changed
+		expectUsedArticleSlugs(mock, "kept", 1)
+		// 2: the given slug is normalized, and the saved one is retired
+		expectUsedArticleSlugs(mock, "my-slug", 2)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_slug` WHERE slug = ? AND article_id = ?")).
+			WithArgs("my-slug", 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article_slug` (`slug`,`article_id`,`create_time`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE")).
+			WithArgs("before", 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		// 3: the slug is generated from the title, the slugs used by other articles are suffixed
+		expectUsedArticleSlugs(mock, "ni-hao", 3, "ni-hao", "ni-hao-2")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_slug`")).
+			WithArgs("ni-hao-3", 3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		// 4: the slugs assigned in the same batch are taken too
+		expectUsedArticleSlugs(mock, "ni-hao", 4, "ni-hao", "ni-hao-2")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_slug`")).
+			WithArgs("ni-hao-4", 4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		articles := []ArticleRecord{
+			{ArticleMeta: ArticleMeta{ID: 1, Title: "Renamed"}},
+			{ArticleMeta: ArticleMeta{ID: 2, Title: "Title", Slug: "My Slug"}},
+			{ArticleMeta: ArticleMeta{ID: 3, Title: "你好"}},
+			{ArticleMeta: ArticleMeta{ID: 4, Title: "你好!"}},
+		}
+		Expect(assignArticleSlugs(db, articles)).To(Succeed())
+		Expect(articles[0].Slug).To(Equal("kept"))
+		Expect(articles[1].Slug).To(Equal("my-slug"))
+		Expect(articles[2].Slug).To(Equal("ni-hao-3"))
+		Expect(articles[3].Slug).To(Equal("ni-hao-4"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fallback to id if no letter or digit in title", func(t *testing.T) {
+		Expect(articleSlugOfTitle(100, "???")).To(Equal("article-100"))
+		Expect(articleSlugOfTitle(100, "Go")).To(Equal("go"))
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article`")).WillReturnError(sql.ErrConnDone)
+
+		Expect(assignArticleSlugs(db, []ArticleRecord{{ArticleMeta: ArticleMeta{ID: 1}}})).To(Equal(sql.ErrConnDone))
+	})
+}
+
+func TestResolveArticleSlug(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+
+	t.Run("should resolve current slug", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE slug = ? ORDER BY `article`.`id` LIMIT 1")).
+			WithArgs("intro").WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(100, "intro"))
+
+		id, current, err := ResolveArticleSlug("intro", s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(types.ID(100)))
+		Expect(current).To(Equal("intro"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should resolve retired slug to current slug", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE slug = ?")).
+			WithArgs("old").WillReturnError(gorm.ErrRecordNotFound)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article_slug` WHERE slug = ? ORDER BY `article_slug`.`slug` LIMIT 1")).
+			WithArgs("old").WillReturnRows(sqlmock.NewRows([]string{"slug", "article_id"}).AddRow("old", 100))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE id = ?")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(100, "intro"))
+
+		id, current, err := ResolveArticleSlug("old", s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(types.ID(100)))
+		Expect(current).To(Equal("intro"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error if slug is not found", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE slug = ?")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article_slug` WHERE slug = ?")).
+			WillReturnRows(sqlmock.NewRows([]string{"slug", "article_id"}))
+
+		_, _, err := ResolveArticleSlug("unknown", s)
+		Expect(err).To(Equal(gorm.ErrRecordNotFound))
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE slug = ?")).WillReturnError(sql.ErrConnDone)
+
+		_, _, err := ResolveArticleSlug("intro", s)
+		Expect(err).To(Equal(sql.ErrConnDone))
+	})
+}
+
+func TestUpdateArticleSlug(t *testing.T) {
+	RegisterTestingT(t)
+
+	author := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 10}}
+	expectArticle := func(mock sqlmock.Sqlmock, slug string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, uid, slug FROM `article` WHERE id = ?")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"id", "uid", "slug"}).AddRow(100, 10, slug))
+	}
+
+	t.Run("should update slug and retire the previous one", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectArticle(mock, "intro")
+		expectUsedArticleSlugs(mock, "getting-started", 100, "getting-started")
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `slug`=? WHERE id = ?")).
+			WithArgs("getting-started-2", 100).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_slug` WHERE slug = ? AND article_id = ?")).
+			WithArgs("getting-started-2", 100).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article_slug`")).
+			WithArgs("intro", 100, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		slug, err := UpdateArticleSlug(100, "Getting Started", author)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slug).To(Equal("getting-started-2"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should do nothing if slug is not changed", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectArticle(mock, "intro")
+		expectUsedArticleSlugs(mock, "intro", 100)
+		mock.ExpectCommit()
+
+		slug, err := UpdateArticleSlug(100, "Intro", author)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slug).To(Equal("intro"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should allow administrators only besides the author", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectArticle(mock, "intro")
+		mock.ExpectRollback()
+
+		_, err := UpdateArticleSlug(100, "other", &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 20}})
+		Expect(err).To(Equal(fail.ErrForbidden))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		expectArticle(mock, "intro")
+		expectUsedArticleSlugs(mock, "intro", 100)
+		mock.ExpectCommit()
+		slug, err := UpdateArticleSlug(100, "intro", &sessions.Session{Context: context.TODO(),
+			Identity: sessions.Identity{ID: 20}, Perms: authority.Permissions{authority.RoleAdmin}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slug).To(Equal("intro"))
+	})
+
+	t.Run("should reject slug without letters or digits", func(t *testing.T) {
+		_, err := UpdateArticleSlug(100, "---", author)
+		Expect(err).To(Equal(&fail.ErrBadParam{Param: "slug", InvalidValue: "---"}))
+	})
+}
+
+func TestBackfillArticleSlugs(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should generate slugs of each article", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article` WHERE id > ? ORDER BY id LIMIT 100")).
+			WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "Hello").AddRow(2, "Hello"))
+		expectUsedArticleSlugs(mock, "hello", 1)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `slug`=? WHERE id = ?")).
+			WithArgs("hello", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUsedArticleSlugs(mock, "hello", 2, "hello")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `slug`=? WHERE id = ?")).
+			WithArgs("hello-2", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(backfillArticleSlugs(db)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article`")).WillReturnError(sql.ErrConnDone)
+
+		Expect(backfillArticleSlugs(db)).To(Equal(sql.ErrConnDone))
+	})
+}
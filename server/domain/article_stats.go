@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"owlet/server/infra/markdown"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+)
+
+// articleStatsBatchSize the number of articles updated in each round of backfilling
+const articleStatsBatchSize = 100
+
+// articleStatsFields the fields of ArticleMeta derived from the content
+var articleStatsFields = []string{"TOC", "WordCount", "CharCount", "ReadingMinutes", "CodeBlockCount", "ImageCount"}
+
+// ArticleTOC the table of contents of article, it is stored as JSON
+type ArticleTOC []markdown.Heading
+
+func (t ArticleTOC) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (t *ArticleTOC) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported toc value %T", value)
+	}
+	if len(b) == 0 {
+		*t = nil
+		return nil
+	}
+	return json.Unmarshal(b, t)
+}
+
+// deriveArticleStats refresh the fields derived from the content, it should be called whenever the content is saved
+func deriveArticleStats(a *ArticleRecord) {
+	stats := markdown.Analyze(a.Content)
+	a.TOC = stats.TOC
+	a.WordCount = stats.Words
+	a.CharCount = stats.Chars
+	a.ReadingMinutes = stats.ReadingMinutes
+	a.CodeBlockCount = stats.CodeBlocks
+	a.ImageCount = stats.Images
+}
+
+// backfillArticleStats derive the fields of the articles saved before they are introduced
+func backfillArticleStats(db *gorm.DB) error {
+	var lastID types.ID
+	for {
+		var articles []ArticleRecord
+		if err := db.Model(&ArticleRecord{}).Select("id, content").Where("id > ?", lastID).Order("id").
+			Limit(articleStatsBatchSize).Find(&articles).Error; err != nil {
+			return err
+		}
+		for _, a := range articles {
+			deriveArticleStats(&a)
+			if err := db.Model(&ArticleRecord{}).Where("id = ?", a.ID).Updates(map[string]interface{}{
+				"toc": a.TOC, "word_count": a.WordCount, "char_count": a.CharCount, "reading_minutes": a.ReadingMinutes,
+				"code_block_count": a.CodeBlockCount, "image_count": a.ImageCount,
+			}).Error; err != nil {
+				return err
+			}
+			lastID = a.ID
+		}
+		if len(articles) < articleStatsBatchSize {
+			return nil
+		}
+	}
+}
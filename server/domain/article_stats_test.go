@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"errors"
+	"owlet/server/infra/markdown"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+func TestArticleTOC(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be stored as JSON", func(t *testing.T) {
+		toc := ArticleTOC{{Level: 1, Title: "A", Anchor: "a", Children: []markdown.Heading{{Level: 2, Title: "B", Anchor: "b"}}}}
+		value, err := toc.Value()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(`[{"level":1,"title":"A","anchor":"a","children":[{"level":2,"title":"B","anchor":"b"}]}]`))
+
+		var scanned ArticleTOC
+		Expect(scanned.Scan([]byte(value.(string)))).To(Succeed())
+		Expect(scanned).To(Equal(toc))
+
+		value, err = ArticleTOC(nil).Value()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(BeNil())
+		Expect(scanned.Scan(nil)).To(Succeed())
+		Expect(scanned).To(BeNil())
+		Expect(scanned.Scan("")).To(Succeed())
+		Expect(scanned).To(BeNil())
+		Expect(scanned.Scan(1)).ToNot(Succeed())
+	})
+}
+
+func TestDeriveArticleStats(t *testing.T) {
+	RegisterTestingT(t)
+
+	a := ArticleRecord{Content: "# 标题\n\nhello world\n\n```\ncode\n```\n\n![a](a.png)\n"}
+	deriveArticleStats(&a)
+	Expect(a.TOC).To(Equal(ArticleTOC{{Level: 1, Title: "标题", Anchor: "标题"}}))
+	Expect(a.WordCount).To(Equal(4))
+	Expect(a.CharCount).To(Equal(12))
+	Expect(a.ReadingMinutes).To(Equal(1))
+	Expect(a.CodeBlockCount).To(Equal(1))
+	Expect(a.ImageCount).To(Equal(1))
+}
+
+func TestBackfillArticleStats(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should derive statistics of each article", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, content FROM `article` WHERE id > ? ORDER BY id LIMIT 100")).
+			WithArgs(0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "content"}).AddRow(1, "# A").AddRow(2, "two words"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `char_count`=?,`code_block_count`=?,`image_count`=?,"+
+			"`reading_minutes`=?,`toc`=?,`word_count`=? WHERE id = ?")).
+			WithArgs(1, 0, 0, 1, `[{"level":1,"title":"A","anchor":"a"}]`, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article`")).
+			WithArgs(8, 0, 0, 1, "[]", 2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(backfillArticleStats(db)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, content FROM `article`")).WillReturnError(errors.New("some error"))
+
+		Expect(backfillArticleStats(db)).To(MatchError("some error"))
+	})
+}
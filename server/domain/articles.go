@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"owlet/server/infra/authority"
+	"owlet/server/infra/markdown"
 	"owlet/server/infra/persistence"
 	"owlet/server/infra/sessions"
 
@@ -43,6 +45,8 @@ type ArticleMeta struct {
 	ID    types.ID    `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
 	Type  GenericType `json:"type" gorm:"type:TINYINT NOT NULL"`
 	Title string      `json:"title" gorm:"type:NVARCHAR(255) NOT NULL"`
+	// Slug the unique name in permalinks, see assignArticleSlugs
+	Slug string `json:"slug" gorm:"type:VARCHAR(100) NULL;uniqueIndex:uk_article_slug"`
 
 	UID        types.ID        `json:"uid" gorm:"type:BIGINT NOT NULL"`
 	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
@@ -50,12 +54,23 @@ type ArticleMeta struct {
 	Status     ArticleStatus   `json:"status" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
 	IsInvalid  bool            `json:"is_invalid" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
 
-	Abstracts  string        `json:"abstracts" gorm:"type:NVARCHAR(1000) NULL"`
-	Source     ArticleSource `json:"source" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
-	IsElite    bool          `json:"is_elite" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
-	IsTop      bool          `json:"is_top" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
-	ViewNum    int           `json:"view_num" gorm:"type:INT NOT NULL DEFAULT '0'"`
-	CommentNum int           `json:"comment_num" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	Abstracts string `json:"abstracts" gorm:"type:NVARCHAR(1000) NULL"`
+	// AbstractsGenerated the abstracts is generated from the content rather than written by the author,
+	// see deriveArticleAbstracts
+	AbstractsGenerated bool          `json:"abstracts_generated" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	Source             ArticleSource `json:"source" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	IsElite            bool          `json:"is_elite" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	IsTop              bool          `json:"is_top" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	ViewNum            int           `json:"view_num" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	CommentNum         int           `json:"comment_num" gorm:"type:INT NOT NULL DEFAULT '0'"`
+
+	// derived from the content when the article is saved, see deriveArticleStats
+	TOC            ArticleTOC `json:"toc" gorm:"column:toc;type:TEXT NULL"`
+	WordCount      int        `json:"word_count" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	CharCount      int        `json:"char_count" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	ReadingMinutes int        `json:"reading_minutes" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	CodeBlockCount int        `json:"code_block_count" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	ImageCount     int        `json:"image_count" gorm:"type:INT NOT NULL DEFAULT '0'"`
 }
 
 type ArticleRecord struct {
@@ -72,7 +87,9 @@ type ArticleMetaExt struct {
 type ArticleDetail struct {
 	ArticleRecord
 
-	Tags []Tag `json:"tags"  gorm:"-"`
+	// ContentHTML the sanitized HTML rendered from Content, it is present only if requested, see ArticleDetailQuery
+	ContentHTML string `json:"content_html,omitempty" gorm:"-"`
+	Tags        []Tag  `json:"tags"  gorm:"-"`
 }
 
 func (r *ArticleRecord) TableName() string {
@@ -97,8 +114,9 @@ func QueryArticles(q ArticleQuery, s *sessions.Session) ([]ArticleMetaExt, error
 	}
 
 	db := persistence.ActiveGormDB.Model(&ArticleRecord{}).
-		Select("id, type, title, uid, create_time, modify_time, status, is_invalid, "+
-			"abstracts, source, is_elite, is_top, view_num, comment_num").
+		Select("id, type, title, slug, uid, create_time, modify_time, status, is_invalid, "+
+			"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, "+
+			"toc, word_count, char_count, reading_minutes, code_block_count, image_count").
 		Where("is_invalid = 0 AND (status = 1 || uid = ?)", s.Identity.ID).
 		Order("is_top DESC, create_time DESC").
 		Offset(offset).
@@ -120,12 +138,40 @@ func QueryArticles(q ArticleQuery, s *sessions.Session) ([]ArticleMetaExt, error
 	return articleMetaExtList, nil
 }
 
-func DetailArticle(id types.ID, s *sessions.Session) (*ArticleDetail, error) {
+// DetailArticle the attachments of a draft are private (see isPrivateBlob), the links of them are signed
+// if the session is the author or an administrator. The content is rendered to HTML if q.Format is html,
+// the wiki links are rendered as the links to the linked articles.
+func DetailArticle(id types.ID, q ArticleDetailQuery, s *sessions.Session) (*ArticleDetail, error) {
 	var detail ArticleDetail
 	db := persistence.ActiveGormDB.Model(&ArticleRecord{}).Select("*").Where("id = ?", id)
 	if err := db.First(&detail).Error; err != nil {
 		return nil, err
 	}
+	signed := false
+	if detail.Status == ArticleStatusDraft && s.Identity.ID != 0 &&
+		(detail.UID == s.Identity.ID || s.Perms.HasRole(authority.RoleAdmin)) {
+		content, err := signFileLinks(detail.Content, FileLinks, s)
+		if err != nil {
+			return nil, err
+		}
+		signed = content != detail.Content
+		detail.Content = content
+	}
+	if q.Format == ArticleFormatHTML {
+		content := detail.Content
+		if len(markdown.WikiLinks(content)) > 0 {
+			links, err := QueryArticleLinksFunc(detail.ID, s)
+			if err != nil {
+				return nil, err
+			}
+			content = linkWikiLinks(content, links)
+		}
+		rendered, err := renderArticle(detail.ID, content, signed)
+		if err != nil {
+			return nil, err
+		}
+		detail.ContentHTML = rendered
+	}
 
 	articleMetaExts := []ArticleMetaExt{{ArticleMeta: detail.ArticleMeta}}
 	if err := appendTags(articleMetaExts, s); err != nil {
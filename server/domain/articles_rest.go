@@ -16,7 +16,11 @@ var (
 func RegisterArticlesRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
 	g := r.Group(PathArticles, middleWares...)
 	g.GET("", handleQueryArticles)
-	g.GET(":id", handleDetailArticle)
+	g.GET(":id", sessions.OptionalSessionFilter(), handleDetailArticle)
+	g.GET(":id/links", sessions.OptionalSessionFilter(), handleQueryArticleLinks)
+	g.GET(":id/backlinks", sessions.OptionalSessionFilter(), handleQueryArticleBacklinks)
+	g.PUT(":id/slug", sessions.SessionFilter(), handleUpdateArticleSlug)
+	g.GET("by-slug/:slug", sessions.OptionalSessionFilter(), handleDetailArticleBySlug)
 }
 
 // @ID article-meta-list
@@ -41,6 +45,7 @@ func handleQueryArticles(c *gin.Context) {
 
 // @ID article-detail
 // @Param id path uint64 true "id"
+// @Param format query string false "markdown (default) or html, the content is rendered as content_html if html"
 // @Success 200 {object} domain.ArticleDetail "response body"
 // @Failure default {object} fail.ErrorBody "error"
 // @Router /v1/articles/{id} [get]
@@ -49,10 +54,108 @@ func handleDetailArticle(c *gin.Context) {
 	if err != nil {
 		panic(err)
 	}
+	q := ArticleDetailQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
 
-	detail, err := DetailArticleFunc(id, &sessions.Session{Context: c.Request.Context()})
+	detail, err := DetailArticleFunc(id, q, sessions.ExtractSessionFromGinContext(c))
 	if err != nil {
 		panic(err)
 	}
 	c.JSON(http.StatusOK, detail)
 }
+
+// @ID article-link-list
+// @Param id path uint64 true "id"
+// @Success 200 {array} domain.ArticleLinkDetail
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/articles/{id}/links [get]
+func handleQueryArticleLinks(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+
+	links, err := QueryArticleLinksFunc(id, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// @ID article-backlink-list
+// @Param id path uint64 true "id"
+// @Success 200 {array} domain.ArticleMetaExt
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/articles/{id}/backlinks [get]
+func handleQueryArticleBacklinks(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+
+	articles, err := QueryArticleBacklinksFunc(id, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, articles)
+}
+
+// @ID article-detail-by-slug
+// @Param slug path string true "current or retired slug, the retired slug is redirected to the current one"
+// @Param format query string false "markdown (default) or html, the content is rendered as content_html if html"
+// @Success 200 {object} domain.ArticleDetail "response body"
+// @Success 301 "redirect to the current slug"
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/articles/by-slug/{slug} [get]
+func handleDetailArticleBySlug(c *gin.Context) {
+	q := ArticleDetailQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+
+	s := sessions.ExtractSessionFromGinContext(c)
+	slug := c.Param("slug")
+	id, current, err := ResolveArticleSlugFunc(slug, s)
+	if err != nil {
+		panic(err)
+	}
+	if current != slug {
+		location := PathArticles + "/by-slug/" + current
+		if c.Request.URL.RawQuery != "" {
+			location += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusMovedPermanently, location)
+		return
+	}
+
+	detail, err := DetailArticleFunc(id, q, s)
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+// @ID article-slug-update
+// @Param id path uint64 true "id"
+// @Param body body domain.ArticleSlugUpdate true "the slug, which is normalized"
+// @Success 200 {object} domain.ArticleSlugUpdate "the slug in effect"
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/articles/{id}/slug [put]
+func handleUpdateArticleSlug(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	body := ArticleSlugUpdate{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+
+	slug, err := UpdateArticleSlugFunc(id, body.Slug, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, ArticleSlugUpdate{Slug: slug})
+}
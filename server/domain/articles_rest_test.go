@@ -7,12 +7,14 @@ import (
 	"owlet/server/infra/fail"
 	"owlet/server/infra/sessions"
 	"owlet/server/testinfra"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fundwit/go-commons/types"
 	"github.com/gin-gonic/gin"
 	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
 )
 
 func TestQueryArticlesAPI(t *testing.T) {
@@ -36,9 +38,8 @@ func TestQueryArticlesAPI(t *testing.T) {
 	t.Run("should be able to handle error on binding", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, PathArticles+"?kw="+testinfra.Alphabeta100+testinfra.Alphabeta100+"1", nil)
 		status, body, _ := testinfra.ExecuteRequest(req, router)
-		Expect(body).To(MatchJSON(`{"code":"common.bad_param",
-			"message":"Key: 'ArticleQuery.KeyWord' Error:Field validation for 'KeyWord' failed on the 'lte' tag",
-			"data":null}`))
+		Expect(body).To(MatchJSON(`{"code":"bad_request.validation_failed", "message":"validation failed",
+			"data":[{"field": "kw", "rule": "lte", "param": "200", "message": "kw must be less than or equal to 200"}]}`))
 		Expect(status).To(Equal(http.StatusBadRequest))
 	})
 
@@ -47,11 +48,13 @@ func TestQueryArticlesAPI(t *testing.T) {
 		QueryArticlesFunc = func(q ArticleQuery, s *sessions.Session) ([]ArticleMetaExt, error) {
 			in = q
 			am := ArticleMeta{
-				ID: 100, Type: GenericTypeIT, Title: "demo article", UID: 10,
+				ID: 100, Type: GenericTypeIT, Title: "demo article", Slug: "demo-article", UID: 10,
 				CreateTime: types.TimestampOfDate(2022, 1, 2, 3, 4, 5, 0, time.UTC),
 				ModifyTime: types.TimestampOfDate(2022, 1, 2, 3, 4, 5, 0, time.UTC),
 				Status:     ArticleStatusPublished, IsInvalid: false, Abstracts: "demo",
 				Source: ArticleSourceOriginal, IsElite: true, IsTop: true, ViewNum: 30, CommentNum: 20,
+				TOC: ArticleTOC{{Level: 1, Title: "Intro", Anchor: "intro"}}, WordCount: 300, CharCount: 1500,
+				ReadingMinutes: 2, CodeBlockCount: 1, ImageCount: 3,
 			}
 			return []ArticleMetaExt{
 				{ArticleMeta: am, Tags: []Tag{{ID: 1000, Name: "go", Image: "go.png", Note: "golang"}}},
@@ -61,10 +64,12 @@ func TestQueryArticlesAPI(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, PathArticles+"?kw=demo&page=2", nil)
 		status, body, _ := testinfra.ExecuteRequest(req, router)
 		Expect(status).To(Equal(http.StatusOK))
-		Expect(body).To(MatchJSON(`[{"id": "100", "type": 2, "title": "demo article", "uid": "10",
+		Expect(body).To(MatchJSON(`[{"id": "100", "type": 2, "title": "demo article", "slug": "demo-article", "uid": "10",
 			"create_time": "2022-01-02T03:04:05Z", "modify_time": "2022-01-02T03:04:05Z",
-			"status": 1, "is_invalid": false, "abstracts": "demo", "source": 1,
+			"status": 1, "is_invalid": false, "abstracts": "demo", "abstracts_generated": false, "source": 1,
 			"is_elite": true, "is_top": true, "view_num": 30, "comment_num": 20,
+			"toc": [{"level": 1, "title": "Intro", "anchor": "intro"}], "word_count": 300, "char_count": 1500,
+			"reading_minutes": 2, "code_block_count": 1, "image_count": 3,
 			"tags": [{"id": "1000", "name":"go", "image":"go.png", "note": "golang"}]}]`))
 
 		Expect(in).To(Equal(ArticleQuery{KeyWord: "demo", Page: 2}))
@@ -88,7 +93,7 @@ func TestDetailArticlesAPI(t *testing.T) {
 		}
 		tags := []Tag{{ID: 1000, Name: "go", Image: "go.png", Note: "golang"}}
 
-		DetailArticleFunc = func(id types.ID, s *sessions.Session) (*ArticleDetail, error) {
+		DetailArticleFunc = func(id types.ID, q ArticleDetailQuery, s *sessions.Session) (*ArticleDetail, error) {
 			Expect(id).To(Equal(types.ID(200)))
 			return &ArticleDetail{ArticleRecord: ArticleRecord{ArticleMeta: meta, Content: "content 100"}, Tags: tags}, nil
 		}
@@ -97,16 +102,35 @@ func TestDetailArticlesAPI(t *testing.T) {
 		status, body, _ := testinfra.ExecuteRequest(req, router)
 
 		Expect(status).To(Equal(http.StatusOK))
-		Expect(body).To(MatchJSON(`{"id": "100", "type": 2, "title": "demo article", "uid": "10",
+		Expect(body).To(MatchJSON(`{"id": "100", "type": 2, "title": "demo article", "slug": "", "uid": "10",
 			"create_time": "2022-01-02T03:04:05Z", "modify_time": "2022-01-02T03:04:05Z", "status": 1,
-			"is_invalid": false, "abstracts": "demo", "source": 1, "is_elite": true, "is_top": true,
-			"view_num": 30, "comment_num": 20, "content": "content 100",
+			"is_invalid": false, "abstracts": "demo", "abstracts_generated": false, "source": 1, "is_elite": true, "is_top": true,
+			"view_num": 30, "comment_num": 20, "content": "content 100", "toc": null, "word_count": 0, "char_count": 0,
+			"reading_minutes": 0, "code_block_count": 0, "image_count": 0,
 			"tags": [{"id": "1000", "name":"go", "image":"go.png", "note": "golang"}]
 			}`))
 	})
 
+	t.Run("should be able to get rendered article", func(t *testing.T) {
+		var in ArticleDetailQuery
+		DetailArticleFunc = func(id types.ID, q ArticleDetailQuery, s *sessions.Session) (*ArticleDetail, error) {
+			in = q
+			return &ArticleDetail{ArticleRecord: ArticleRecord{Content: "*a*"}, ContentHTML: "<p><em>a</em></p>"}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/200?format=html", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(in).To(Equal(ArticleDetailQuery{Format: ArticleFormatHTML}))
+		Expect(body).To(ContainSubstring(`"content_html":"\u003cp\u003e\u003cem\u003ea\u003c/em\u003e\u003c/p\u003e"`))
+
+		req = httptest.NewRequest(http.MethodGet, PathArticles+"/200?format=pdf", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
 	t.Run("should be able to handle error on detail article", func(t *testing.T) {
-		DetailArticleFunc = func(id types.ID, s *sessions.Session) (*ArticleDetail, error) {
+		DetailArticleFunc = func(id types.ID, q ArticleDetailQuery, s *sessions.Session) (*ArticleDetail, error) {
 			return nil, errors.New("some error")
 		}
 
@@ -123,3 +147,144 @@ func TestDetailArticlesAPI(t *testing.T) {
 		Expect(status).To(Equal(http.StatusBadRequest))
 	})
 }
+
+func TestArticleLinksAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterArticlesRestAPI(router)
+
+	t.Run("should be able to query links of article", func(t *testing.T) {
+		QueryArticleLinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleLinkDetail, error) {
+			Expect(id).To(Equal(types.ID(100)))
+			return []ArticleLinkDetail{{SourceID: 100, Target: "Intro", TargetID: 200, TargetTitle: "Intro"},
+				{SourceID: 100, Target: "Missing", Broken: true}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/100/links", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`[
+			{"source_id": "100", "target": "Intro", "target_id": "200", "target_title": "Intro", "broken": false},
+			{"source_id": "100", "target": "Missing", "target_id": "0", "target_title": "", "broken": true}]`))
+	})
+
+	t.Run("should be able to query backlinks of article", func(t *testing.T) {
+		QueryArticleBacklinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleMetaExt, error) {
+			Expect(id).To(Equal(types.ID(200)))
+			return []ArticleMetaExt{{ArticleMeta: ArticleMeta{ID: 100, Title: "linking"}, Tags: []Tag{}}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/200/backlinks", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"id":"100","type":0,"title":"linking"`))
+	})
+
+	t.Run("should be able to handle errors", func(t *testing.T) {
+		QueryArticleLinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleLinkDetail, error) {
+			return nil, errors.New("some error")
+		}
+		QueryArticleBacklinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleMetaExt, error) {
+			return nil, errors.New("some error")
+		}
+
+		for _, path := range []string{"/100/links", "/100/backlinks"} {
+			req := httptest.NewRequest(http.MethodGet, PathArticles+path, nil)
+			status, body, _ := testinfra.ExecuteRequest(req, router)
+			Expect(body).To(MatchJSON(`{"code":"common.internal_server_error", "message":"some error", "data":null}`))
+			Expect(status).To(Equal(http.StatusInternalServerError))
+		}
+
+		for _, path := range []string{"/abc/links", "/abc/backlinks"} {
+			req := httptest.NewRequest(http.MethodGet, PathArticles+path, nil)
+			status, _, _ := testinfra.ExecuteRequest(req, router)
+			Expect(status).To(Equal(http.StatusBadRequest))
+		}
+	})
+}
+
+func TestArticleSlugsAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterArticlesRestAPI(router)
+
+	sessions.TokenCache.Add("author-token", &sessions.Session{Token: "author-token", Identity: sessions.Identity{ID: 10}}, time.Minute)
+
+	t.Run("should get article by current slug", func(t *testing.T) {
+		ResolveArticleSlugFunc = func(slug string, s *sessions.Session) (types.ID, string, error) {
+			return 100, slug, nil
+		}
+		var in ArticleDetailQuery
+		DetailArticleFunc = func(id types.ID, q ArticleDetailQuery, s *sessions.Session) (*ArticleDetail, error) {
+			Expect(id).To(Equal(types.ID(100)))
+			in = q
+			return &ArticleDetail{ArticleRecord: ArticleRecord{ArticleMeta: ArticleMeta{ID: 100, Slug: "intro"}}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/by-slug/intro?format=html", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"id":"100","type":0,"title":"","slug":"intro"`))
+		Expect(in).To(Equal(ArticleDetailQuery{Format: ArticleFormatHTML}))
+	})
+
+	t.Run("should redirect retired slug to current slug", func(t *testing.T) {
+		ResolveArticleSlugFunc = func(slug string, s *sessions.Session) (types.ID, string, error) {
+			Expect(slug).To(Equal("old"))
+			return 100, "intro", nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/by-slug/old?format=html", nil)
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusMovedPermanently))
+		Expect(res.Header.Get("Location")).To(Equal(PathArticles + "/by-slug/intro?format=html"))
+	})
+
+	t.Run("should handle error on resolving slug", func(t *testing.T) {
+		ResolveArticleSlugFunc = func(slug string, s *sessions.Session) (types.ID, string, error) {
+			return 0, "", gorm.ErrRecordNotFound
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathArticles+"/by-slug/unknown", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	t.Run("should require login to update slug", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, PathArticles+"/100/slug", strings.NewReader(`{"slug": "intro"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	t.Run("should update slug", func(t *testing.T) {
+		UpdateArticleSlugFunc = func(id types.ID, slug string, s *sessions.Session) (string, error) {
+			Expect(id).To(Equal(types.ID(100)))
+			Expect(slug).To(Equal("Getting Started"))
+			Expect(s.Identity.ID).To(Equal(types.ID(10)))
+			return "getting-started", nil
+		}
+
+		req := httptest.NewRequest(http.MethodPut, PathArticles+"/100/slug", strings.NewReader(`{"slug": "Getting Started"}`))
+		req.Header.Add("cookie", "sec_token=author-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"slug": "getting-started"}`))
+
+		req = httptest.NewRequest(http.MethodPut, PathArticles+"/100/slug", strings.NewReader(`{}`))
+		req.Header.Add("cookie", "sec_token=author-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		UpdateArticleSlugFunc = func(id types.ID, slug string, s *sessions.Session) (string, error) {
+			return "", fail.ErrForbidden
+		}
+		req = httptest.NewRequest(http.MethodPut, PathArticles+"/100/slug", strings.NewReader(`{"slug": "intro"}`))
+		req.Header.Add("cookie", "sec_token=author-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+}
@@ -43,8 +43,9 @@ func TestQueryArticleMetas_MinArgsWithTagsExtend(t *testing.T) {
 		AddRow(article.ID, article.Type, article.Title, article.UID).
 		AddRow(article2.ID, article2.Type, article2.Title, article2.UID)
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE is_invalid = 0 AND (status = 1 || uid = ?) " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10"
 	mock.ExpectQuery(regexp.QuoteMeta(sqlExpr)).
@@ -92,8 +93,9 @@ func TestQueryArticleMetas_MaxArgs(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"id", "type", "title", "uid"}).
 		AddRow(article.ID, article.Type, article.Title, article.UID)
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE (is_invalid = 0 AND (status = 1 || uid = ?)) AND title LIKE ? " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10 OFFSET 20"
 	mock.ExpectQuery(regexp.QuoteMeta(sqlExpr)).
@@ -118,8 +120,9 @@ func TestQueryArticleMetas_MinArgsAndNoResult(t *testing.T) {
 
 	rows := sqlmock.NewRows([]string{"id", "type", "title", "uid"})
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE is_invalid = 0 AND (status = 1 || uid = ?) " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10"
 	mock.ExpectQuery(regexp.QuoteMeta(sqlExpr)).
@@ -145,8 +148,9 @@ func TestQueryArticleMetas_ErrorOnQueryTags(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"id", "type", "title", "uid"}).
 		AddRow(article.ID, article.Type, article.Title, article.UID)
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE is_invalid = 0 AND (status = 1 || uid = ?) " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10"
 
@@ -183,8 +187,9 @@ func TestQueryArticleMetas_ErrorOnQueryTagAssignments(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"id", "type", "title", "uid"}).
 		AddRow(article.ID, article.Type, article.Title, article.UID)
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE is_invalid = 0 AND (status = 1 || uid = ?) " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10"
 
@@ -208,8 +213,9 @@ func TestQueryArticleMetas_ErrorOnQueryArticles(t *testing.T) {
 
 	_, mock := testinfra.SetUpMockSql()
 
-	const sqlExpr = "SELECT id, type, title, uid, create_time, modify_time, status, is_invalid, " +
-		"abstracts, source, is_elite, is_top, view_num, comment_num " +
+	const sqlExpr = "SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, " +
+		"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, " +
+		"toc, word_count, char_count, reading_minutes, code_block_count, image_count " +
 		"FROM `article` WHERE is_invalid = 0 AND (status = 1 || uid = ?) " +
 		"ORDER BY is_top DESC, create_time DESC LIMIT 10"
 
@@ -263,7 +269,7 @@ func TestDetailArticle_FoundArticleWithTags(t *testing.T) {
 		return tags, nil
 	}
 
-	result, err := DetailArticle(100, &sessions.Session{Context: context.TODO()})
+	result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO()})
 	Expect(err).ToNot(HaveOccurred())
 
 	want := ArticleDetail{
@@ -302,7 +308,7 @@ func TestDetailArticle_ErrorOnAppendTags(t *testing.T) {
 		return nil, sql.ErrConnDone
 	}
 
-	result, err := DetailArticle(100, &sessions.Session{Context: context.TODO()})
+	result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO()})
 	Expect(err).To(Equal(sql.ErrConnDone))
 	Expect(result).To(BeNil())
 
@@ -322,9 +328,131 @@ func TestDetailArticle_ErrorOnQueryArticle(t *testing.T) {
 		return nil, sql.ErrConnDone
 	}
 
-	result, err := DetailArticle(100, &sessions.Session{Context: context.TODO()})
+	result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO()})
 	Expect(err).To(Equal(sql.ErrConnDone))
 	Expect(result).To(BeNil())
 
 	Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
 }
+
+func TestDetailArticle_SignFileLinksOfDraft(t *testing.T) {
+	RegisterTestingT(t)
+
+	QueryTagAssignmentsFunc = func(resIds []types.ID, s *sessions.Session) ([]TagAssignment, error) {
+		return nil, nil
+	}
+	content := "![a](/v1/files/1/content)"
+	expectDraft := func() sqlmock.Sqlmock {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article` WHERE id = ?")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uid", "status", "content"}).AddRow(100, 1000, ArticleStatusDraft, content))
+		return mock
+	}
+
+	t.Run("should sign links for author", func(t *testing.T) {
+		mock := expectDraft()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id IN (?)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 1000, "a.png", 5, "image/png", helloSHA256, BlobKey(helloSHA256), nil))
+
+		result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 1000}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Content).To(HavePrefix("![a](/v1/files/1/content?token="))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not sign links for others", func(t *testing.T) {
+		mock := expectDraft()
+
+		result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 2000}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Content).To(Equal(content))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestDetailArticle_RenderHTML(t *testing.T) {
+	RegisterTestingT(t)
+
+	QueryTagAssignmentsFunc = func(resIds []types.ID, s *sessions.Session) ([]TagAssignment, error) {
+		return nil, nil
+	}
+	expectArticle := func(status ArticleStatus, content string) sqlmock.Sqlmock {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article` WHERE id = ?")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uid", "status", "content"}).AddRow(100, 1000, status, content))
+		return mock
+	}
+
+	t.Run("should render content only if requested", func(t *testing.T) {
+		expectArticle(ArticleStatusPublished, "# Title")
+		result, err := DetailArticle(100, ArticleDetailQuery{}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.ContentHTML).To(BeEmpty())
+
+		expectArticle(ArticleStatusPublished, "# Title")
+		result, err = DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Content).To(Equal("# Title"))
+		Expect(result.ContentHTML).To(Equal(`<h1 id="title">Title</h1>` + "\n"))
+	})
+
+	t.Run("should cache HTML of each revision", func(t *testing.T) {
+		renderedArticles.Flush()
+		expectArticle(ArticleStatusPublished, "revision *1*")
+		_, err := DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renderedArticles.ItemCount()).To(Equal(1))
+
+		expectArticle(ArticleStatusPublished, "revision *1*")
+		result, err := DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.ContentHTML).To(Equal("<p>revision <em>1</em></p>\n"))
+		Expect(renderedArticles.ItemCount()).To(Equal(1))
+
+		expectArticle(ArticleStatusPublished, "revision *2*")
+		result, err = DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.ContentHTML).To(Equal("<p>revision <em>2</em></p>\n"))
+		Expect(renderedArticles.ItemCount()).To(Equal(2))
+	})
+
+	t.Run("should render signed links of draft without caching", func(t *testing.T) {
+		renderedArticles.Flush()
+		mock := expectArticle(ArticleStatusDraft, "![a](/v1/files/1/content)")
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id IN (?)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 1000, "a.png", 5, "image/png", helloSHA256, BlobKey(helloSHA256), nil))
+
+		result, err := DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML},
+			&sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 1000}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.ContentHTML).To(HavePrefix(`<p><img src="/v1/files/1/content?token=`))
+		Expect(renderedArticles.ItemCount()).To(BeZero())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should render wiki links as links to the linked articles", func(t *testing.T) {
+		expectArticle(ArticleStatusPublished, "see [[Intro]] and [[Draft]]")
+		QueryArticleLinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleLinkDetail, error) {
+			Expect(id).To(Equal(types.ID(100)))
+			return []ArticleLinkDetail{{Target: "Intro", TargetID: 200, TargetTitle: "Intro"},
+				{Target: "Draft", TargetID: 300, TargetTitle: "Draft", Broken: true}}, nil
+		}
+		defer func() { QueryArticleLinksFunc = QueryArticleLinks }()
+
+		result, err := DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Content).To(Equal("see [[Intro]] and [[Draft]]"))
+		Expect(result.ContentHTML).To(Equal(`<p>see <a href="/v1/articles/200" rel="nofollow">Intro</a> and Draft</p>` + "\n"))
+
+		expectArticle(ArticleStatusPublished, "see [[Intro]]")
+		QueryArticleLinksFunc = func(id types.ID, s *sessions.Session) ([]ArticleLinkDetail, error) {
+			return nil, sql.ErrConnDone
+		}
+		_, err = DetailArticle(100, ArticleDetailQuery{Format: ArticleFormatHTML}, &sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(sql.ErrConnDone))
+	})
+}
@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/oidc"
+	"owlet/server/infra/sessions"
+
+	"github.com/gin-gonic/gin"
+)
+
+var PathAuth = "/v1/auth"
+
+// oidcStateCookie binds the state of OIDC authorization to the user agent which began it, so that an attacker
+// can not make others complete the authorization began by the attacker (login CSRF)
+const oidcStateCookie = "oidc_state"
+
+type oidcBeginQuery struct {
+	ReturnTo string `form:"return_to" binding:"omitempty,lte=2048"`
+}
+
+type oidcCallbackQuery struct {
+	Code             string `form:"code"`
+	State            string `form:"state" binding:"required"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+}
+
+// RegisterAuthRestAPI login through external identity providers. For OIDC the user agent is redirected between
+// the service and the provider, so the endpoints respond redirections instead of json
+func RegisterAuthRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathAuth, middleWares...)
+	g.GET("oidc", handleListOIDCProviders)
+	g.GET("oidc/:provider/login", handleBeginOIDCLogin)
+	g.GET("oidc/:provider/link", sessions.SessionFilter(), handleBeginOIDCLink)
+	g.GET("oidc/:provider/callback", sessions.OptionalSessionFilter(), handleOIDCCallback)
+	g.POST("ldap/login", handleLDAPLogin)
+	g.POST("mfa/verify", handleVerifyMFA)
+}
+
+// @ID auth-oidc-provider-list
+// @Success 200 {array} string
+// @Router /v1/auth/oidc [get]
+func handleListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, ListOIDCProvidersFunc())
+}
+
+// @ID auth-oidc-login
+// @Param provider path string true "provider name"
+// @Param return_to query string false "local path to go after login"
+// @Success 302
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/auth/oidc/{provider}/login [get]
+func handleBeginOIDCLogin(c *gin.Context) {
+	q := oidcBeginQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	authURL, state, err := BeginOIDCLoginFunc(c.Param("provider"), q.ReturnTo,
+		&sessions.Session{Context: c.Request.Context()})
+	if err != nil {
+		panic(err)
+	}
+	setOIDCStateCookie(c, state, int(OIDCLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// @ID auth-oidc-link
+// @Param provider path string true "provider name"
+// @Param return_to query string false "local path to go after linked"
+// @Success 302
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/auth/oidc/{provider}/link [get]
+func handleBeginOIDCLink(c *gin.Context) {
+	q := oidcBeginQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	authURL, state, err := BeginOIDCLinkFunc(c.Param("provider"), q.ReturnTo, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	setOIDCStateCookie(c, state, int(OIDCLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// @ID auth-oidc-callback
+// @Param provider path string true "provider name"
+// @Param code query string false "authorization code"
+// @Param state query string true "state"
+// @Success 302
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/auth/oidc/{provider}/callback [get]
+func handleOIDCCallback(c *gin.Context) {
+	q := oidcCallbackQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	boundState, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if q.Error != "" {
+		LoginsTotal.WithLabelValues(LoginFailed).Inc()
+		panic(&oidcProviderError{Code: q.Error, Description: q.ErrorDescription})
+	}
+	if subtle.ConstantTimeCompare([]byte(boundState), []byte(q.State)) != 1 {
+		LoginsTotal.WithLabelValues(LoginFailed).Inc()
+		panic(fmt.Errorf("%w: state is not bound to the user agent", oidc.ErrAuthentication))
+	}
+
+	result, err := CompleteOIDCLoginFunc(c.Param("provider"), q.Code, q.State, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		LoginsTotal.WithLabelValues(LoginFailed).Inc()
+		panic(err)
+	}
+	if result.Linked {
+		c.Redirect(http.StatusFound, result.ReturnTo)
+		return
+	}
+
+	challenge, err := ChallengeMFAFunc(result.User, nil, result.ReturnTo, &sessions.Session{Context: c.Request.Context()})
+	if err != nil {
+		panic(err)
+	}
+	if challenge != "" {
+		c.Redirect(http.StatusFound, MFALoginPage+"?"+url.Values{"mfa_token": {challenge}, "return_to": {result.ReturnTo}}.Encode())
+		return
+	}
+	sessions.Establish(c, identityOf(result.User), nil)
+	LoginsTotal.WithLabelValues(LoginSucceeded).Inc()
+	c.Redirect(http.StatusFound, result.ReturnTo)
+}
+
+// @ID auth-ldap-login
+// @Accept  json
+// @Produce  json
+// @Param login body sessions.LoginRequest true "username and password in directory"
+// @Success 200 {object} UserProfile
+// @Success 202 {object} MFAChallenge "the second factor is required, see /v1/auth/mfa/verify"
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/auth/ldap/login [post]
+func handleLDAPLogin(c *gin.Context) {
+	login := sessions.LoginRequest{}
+	if err := c.ShouldBindJSON(&login); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	s := &sessions.Session{Context: c.Request.Context()}
+	user, roles, err := LoginByLDAPFunc(&login, s)
+	if err != nil {
+		LoginsTotal.WithLabelValues(LoginFailed).Inc()
+		panic(err)
+	}
+	challenge, err := ChallengeMFAFunc(user, roles, "", s)
+	if err != nil {
+		panic(err)
+	}
+	if challenge != "" {
+		c.JSON(http.StatusAccepted, MFAChallenge{Token: challenge})
+		return
+	}
+	sessions.Establish(c, identityOf(user), roles)
+	LoginsTotal.WithLabelValues(LoginSucceeded).Inc()
+	c.JSON(http.StatusOK, newUserProfile(user))
+}
+
+// @ID auth-mfa-verify
+// @Accept  json
+// @Produce  json
+// @Param verification body MFAVerification true "the challenge token and a TOTP code or a recovery code"
+// @Success 200 {object} UserProfile
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/auth/mfa/verify [post]
+func handleVerifyMFA(c *gin.Context) {
+	v := MFAVerification{}
+	if err := c.ShouldBindJSON(&v); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	result, err := VerifyMFAFunc(&v, &sessions.Session{Context: c.Request.Context()})
+	if err != nil {
+		LoginsTotal.WithLabelValues(LoginFailed).Inc()
+		panic(err)
+	}
+	sessions.Establish(c, identityOf(result.User), result.Perms)
+	LoginsTotal.WithLabelValues(LoginSucceeded).Inc()
+	c.JSON(http.StatusOK, newUserProfile(result.User))
+}
+
+// setOIDCStateCookie the cookie is sent to the callback only, it is removed if maxAge is negative.
+// It must not be SameSite strict, the callback is redirected from the provider.
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, PathAuth+"/oidc/"+c.Param("provider")+"/callback", "", secure, true)
+}
+
+// identityOf the identity of session after the user logged in
+func identityOf(u *User) sessions.Identity {
+	return sessions.Identity{ID: u.ID, Name: u.Username, Nickname: u.RealName, Locale: u.Locale}
+}
+
+// oidcProviderError the provider redirected back with an error, e.g. the user denied the authorization
+type oidcProviderError struct {
+	Code        string
+	Description string
+}
+
+func (e *oidcProviderError) Error() string {
+	return oidc.ErrAuthentication.Error() + ": " + e.Code + " " + e.Description
+}
+
+func (e *oidcProviderError) Unwrap() error {
+	return oidc.ErrAuthentication
+}
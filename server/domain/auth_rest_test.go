@@ -0,0 +1,365 @@
+package domain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/ldapauth"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOIDCAuthAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterAuthRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+	ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+		return "", nil
+	}
+
+	t.Run("should list providers", func(t *testing.T) {
+		ListOIDCProvidersFunc = func() []string { return []string{"corp", "github"} }
+
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`["corp", "github"]`))
+	})
+
+	t.Run("should redirect to provider to login", func(t *testing.T) {
+		var inProvider, inReturnTo string
+		BeginOIDCLoginFunc = func(provider, returnTo string, s *sessions.Session) (string, string, error) {
+			inProvider, inReturnTo = provider, returnTo
+			return "http://idp/authorize?state=s", "s", nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/login?return_to=/articles", nil)
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusFound))
+		Expect(res.Header.Get("Location")).To(Equal("http://idp/authorize?state=s"))
+		Expect(inProvider).To(Equal("corp"))
+		Expect(inReturnTo).To(Equal("/articles"))
+
+		// the state is bound to the user agent
+		cookies := res.Cookies()
+		Expect(len(cookies)).To(Equal(1))
+		Expect(cookies[0].Name).To(Equal(oidcStateCookie))
+		Expect(cookies[0].Value).To(Equal("s"))
+		Expect(cookies[0].Path).To(Equal(PathAuth + "/oidc/corp/callback"))
+		Expect(cookies[0].HttpOnly).To(BeTrue())
+		Expect(cookies[0].SameSite).To(Equal(http.SameSiteLaxMode))
+	})
+
+	t.Run("should require login to link identity", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/link", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		var in *sessions.Session
+		BeginOIDCLinkFunc = func(provider, returnTo string, s *sessions.Session) (string, string, error) {
+			in = s
+			return "http://idp/authorize?state=s", "s", nil
+		}
+		req = httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/link", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusFound))
+		Expect(in.Identity.ID).To(Equal(types.ID(100)))
+		Expect(res.Cookies()[0].Name).To(Equal(oidcStateCookie))
+		Expect(res.Cookies()[0].Value).To(Equal("s"))
+	})
+
+	t.Run("should establish session after login", func(t *testing.T) {
+		var inCode, inState string
+		CompleteOIDCLoginFunc = func(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+			inCode, inState = code, state
+			return &OIDCLoginResult{User: &User{ID: 100, Username: "tom", Locale: "zh"}, ReturnTo: "/articles"}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?code=c&state=s", nil)
+		req.Header.Add("cookie", oidcStateCookie+"=s")
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusFound))
+		Expect(res.Header.Get("Location")).To(Equal("/articles"))
+		Expect(inCode).To(Equal("c"))
+		Expect(inState).To(Equal("s"))
+
+		cookies := cookiesExcept(res, oidcStateCookie)
+		Expect(len(cookies)).To(Equal(1))
+		Expect(cookies[0].Name).To(Equal(sessions.KeySecToken))
+		cached, found := sessions.TokenCache.Get(cookies[0].Value)
+		Expect(found).To(BeTrue())
+		Expect(cached.(*sessions.Session).Identity).To(Equal(sessions.Identity{ID: 100, Name: "tom", Locale: "zh"}))
+	})
+
+	t.Run("should not establish session after linked", func(t *testing.T) {
+		var in *sessions.Session
+		CompleteOIDCLoginFunc = func(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+			in = s
+			return &OIDCLoginResult{User: &User{ID: 100}, Linked: true, ReturnTo: "/settings"}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?code=c&state=s", nil)
+		req.Header.Add("cookie", "sec_token=tom-token; "+oidcStateCookie+"=s")
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusFound))
+		Expect(res.Header.Get("Location")).To(Equal("/settings"))
+		Expect(cookiesExcept(res, oidcStateCookie)).To(BeEmpty())
+		Expect(in.Identity.ID).To(Equal(types.ID(100)))
+	})
+
+	t.Run("should redirect to the second step if MFA is enabled", func(t *testing.T) {
+		CompleteOIDCLoginFunc = func(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+			return &OIDCLoginResult{User: &User{ID: 100}, ReturnTo: "/articles"}, nil
+		}
+		ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+			return "challenge", nil
+		}
+		defer func() {
+			ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+				return "", nil
+			}
+		}()
+
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?code=c&state=s", nil)
+		req.Header.Add("cookie", oidcStateCookie+"=s")
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusFound))
+		Expect(res.Header.Get("Location")).To(Equal("/login/2fa?mfa_token=challenge&return_to=%2Farticles"))
+		Expect(cookiesExcept(res, oidcStateCookie)).To(BeEmpty())
+	})
+
+	t.Run("should reject the callback if the state is not bound to the user agent", func(t *testing.T) {
+		called := false
+		CompleteOIDCLoginFunc = func(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+			called = true
+			return &OIDCLoginResult{User: &User{ID: 100}, ReturnTo: "/articles"}, nil
+		}
+
+		for _, cookie := range []string{"", oidcStateCookie + "=other"} {
+			req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?code=c&state=s", nil)
+			if cookie != "" {
+				req.Header.Add("cookie", cookie)
+			}
+			status, body, res := testinfra.ExecuteRequest(req, router)
+			Expect(status).To(Equal(http.StatusUnauthorized))
+			Expect(body).To(ContainSubstring(`"code":"security.unauthenticated"`))
+			Expect(cookiesExcept(res, oidcStateCookie)).To(BeEmpty())
+		}
+		Expect(called).To(BeFalse())
+	})
+
+	t.Run("should respond unauthenticated when provider rejects", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?error=access_denied&state=s", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		Expect(body).To(ContainSubstring(`"code":"security.unauthenticated"`))
+	})
+}
+
+func TestLDAPAuthAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterAuthRestAPI(router)
+	ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+		return "", nil
+	}
+
+	t.Run("should establish session with the roles mapped from groups", func(t *testing.T) {
+		var in *sessions.LoginRequest
+		LoginByLDAPFunc = func(login *sessions.LoginRequest, s *sessions.Session) (*User, authority.Permissions, error) {
+			in = login
+			return &User{ID: 100, Username: "tom", RealName: "Tom Cat"}, authority.Permissions{authority.RoleAdmin}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login",
+			strings.NewReader(`{"name": "tom", "password": "tom-secret"}`))
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"username":"tom"`))
+		Expect(*in).To(Equal(sessions.LoginRequest{Name: "tom", Password: "tom-secret"}))
+
+		cookies := res.Cookies()
+		Expect(len(cookies)).To(Equal(1))
+		cached, found := sessions.TokenCache.Get(cookies[0].Value)
+		Expect(found).To(BeTrue())
+		Expect(cached.(*sessions.Session).Identity).To(Equal(sessions.Identity{ID: 100, Name: "tom", Nickname: "Tom Cat"}))
+		Expect(cached.(*sessions.Session).Perms).To(Equal(authority.Permissions{authority.RoleAdmin}))
+	})
+
+	t.Run("should validate request and respond unauthenticated for invalid credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login", strings.NewReader(`{"name": "tom"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		LoginByLDAPFunc = func(login *sessions.LoginRequest, s *sessions.Session) (*User, authority.Permissions, error) {
+			return nil, nil, ldapauth.ErrInvalidCredentials
+		}
+		req = httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login",
+			strings.NewReader(`{"name": "tom", "password": "wrong"}`))
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+		Expect(body).To(ContainSubstring(`"code":"security.unauthenticated"`))
+		Expect(res.Cookies()).To(BeEmpty())
+	})
+}
+
+func TestMFAAuthAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterAuthRestAPI(router)
+
+	t.Run("should respond challenge instead of session if MFA is enabled", func(t *testing.T) {
+		LoginByLDAPFunc = func(login *sessions.LoginRequest, s *sessions.Session) (*User, authority.Permissions, error) {
+			return &User{ID: 100, Username: "tom"}, authority.Permissions{authority.RoleAdmin}, nil
+		}
+		var inPerms authority.Permissions
+		ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+			inPerms = perms
+			return "challenge", nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login",
+			strings.NewReader(`{"name": "tom", "password": "tom-secret"}`))
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(body).To(MatchJSON(`{"mfa_token": "challenge"}`))
+		Expect(res.Cookies()).To(BeEmpty())
+		Expect(inPerms).To(Equal(authority.Permissions{authority.RoleAdmin}))
+	})
+
+	t.Run("should establish session after the second step", func(t *testing.T) {
+		var in *MFAVerification
+		VerifyMFAFunc = func(v *MFAVerification, s *sessions.Session) (*MFALoginResult, error) {
+			in = v
+			return &MFALoginResult{User: &User{ID: 100, Username: "tom"}, Perms: authority.Permissions{authority.RoleAdmin}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/mfa/verify",
+			strings.NewReader(`{"mfa_token": "challenge", "code": "123456"}`))
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"username":"tom"`))
+		Expect(*in).To(Equal(MFAVerification{Token: "challenge", Code: "123456"}))
+
+		cookies := res.Cookies()
+		Expect(len(cookies)).To(Equal(1))
+		cached, found := sessions.TokenCache.Get(cookies[0].Value)
+		Expect(found).To(BeTrue())
+		Expect(cached.(*sessions.Session).Identity.ID).To(Equal(types.ID(100)))
+		Expect(cached.(*sessions.Session).Perms).To(Equal(authority.Permissions{authority.RoleAdmin}))
+	})
+
+	t.Run("should not establish session if the code is invalid", func(t *testing.T) {
+		VerifyMFAFunc = func(v *MFAVerification, s *sessions.Session) (*MFALoginResult, error) {
+			return nil, &ErrMFAInvalidCode{}
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/mfa/verify",
+			strings.NewReader(`{"mfa_token": "challenge", "code": "000000"}`))
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring(`"code":"user.mfa_invalid_code"`))
+		Expect(res.Cookies()).To(BeEmpty())
+
+		req = httptest.NewRequest(http.MethodPost, PathAuth+"/mfa/verify", strings.NewReader(`{"mfa_token": "challenge"}`))
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+}
+
+func TestLoginsMetric(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterAuthRestAPI(router)
+	ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+		return "", nil
+	}
+	count := func(result string) float64 {
+		return testutil.ToFloat64(LoginsTotal.WithLabelValues(result))
+	}
+
+	t.Run("should count logins by result", func(t *testing.T) {
+		succeeded, failed := count(LoginSucceeded), count(LoginFailed)
+
+		LoginByLDAPFunc = func(login *sessions.LoginRequest, s *sessions.Session) (*User, authority.Permissions, error) {
+			if login.Password != "tom-secret" {
+				return nil, nil, ldapauth.ErrInvalidCredentials
+			}
+			return &User{ID: 100, Username: "tom"}, nil, nil
+		}
+		for _, password := range []string{"tom-secret", "wrong"} {
+			req := httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login",
+				strings.NewReader(`{"name": "tom", "password": "`+password+`"}`))
+			testinfra.ExecuteRequest(req, router)
+		}
+
+		VerifyMFAFunc = func(v *MFAVerification, s *sessions.Session) (*MFALoginResult, error) {
+			return nil, &ErrMFAInvalidCode{}
+		}
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/mfa/verify",
+			strings.NewReader(`{"mfa_token": "challenge", "code": "000000"}`))
+		testinfra.ExecuteRequest(req, router)
+
+		CompleteOIDCLoginFunc = func(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+			return &OIDCLoginResult{User: &User{ID: 100}, ReturnTo: "/articles"}, nil
+		}
+		req = httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?code=c&state=s", nil)
+		req.Header.Add("cookie", oidcStateCookie+"=s")
+		testinfra.ExecuteRequest(req, router)
+		req = httptest.NewRequest(http.MethodGet, PathAuth+"/oidc/corp/callback?error=access_denied&state=s", nil)
+		testinfra.ExecuteRequest(req, router)
+
+		Expect(count(LoginSucceeded) - succeeded).To(Equal(float64(2)))
+		Expect(count(LoginFailed) - failed).To(Equal(float64(3)))
+	})
+
+	t.Run("should not count the login waiting for the second factor", func(t *testing.T) {
+		succeeded, failed := count(LoginSucceeded), count(LoginFailed)
+		ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+			return "challenge", nil
+		}
+		defer func() {
+			ChallengeMFAFunc = func(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+				return "", nil
+			}
+		}()
+
+		req := httptest.NewRequest(http.MethodPost, PathAuth+"/ldap/login",
+			strings.NewReader(`{"name": "tom", "password": "tom-secret"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(count(LoginSucceeded)).To(Equal(succeeded))
+		Expect(count(LoginFailed)).To(Equal(failed))
+	})
+}
+
+// cookiesExcept the cookies set by response except the one named name
+func cookiesExcept(res *http.Response, name string) []*http.Cookie {
+	cookies := []*http.Cookie{}
+	for _, c := range res.Cookies() {
+		if c.Name != name {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
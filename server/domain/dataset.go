@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"strconv"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DatasetVersion = 1
+
+// Dataset is the portable snapshot of wiki contents, used by export and import.
+type Dataset struct {
+	Version    int             `json:"version"`
+	ExportTime types.Timestamp `json:"export_time"`
+
+	Articles       []ArticleRecord `json:"articles"`
+	Tags           []Tag           `json:"tags"`
+	TagAssignments []TagAssignment `json:"tag_assignments"`
+}
+
+var (
+	ExportDatasetFunc = ExportDataset
+	ImportDatasetFunc = ImportDataset
+)
+
+func ExportDataset(s *sessions.Session) (*Dataset, error) {
+	ds := Dataset{Version: DatasetVersion, ExportTime: types.CurrentTimestamp(),
+		Articles: []ArticleRecord{}, Tags: []Tag{}, TagAssignments: []TagAssignment{}}
+
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	if err := db.Model(&ArticleRecord{}).Order("id").Scan(&ds.Articles).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&Tag{}).Order("id").Scan(&ds.Tags).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&TagAssignment{}).Order("id").Scan(&ds.TagAssignments).Error; err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+// ImportDataset save all records of dataset in one transaction, existed records will be overwritten.
+// The statistics and generated abstracts of articles are derived from the contents rather than trusting the dataset,
+// the wiki links to the renamed articles are rewritten and the links of articles are recorded.
+// The slugs of articles are generated if absent, see assignArticleSlugs.
+func ImportDataset(ds *Dataset, s *sessions.Session) error {
+	if ds.Version != DatasetVersion {
+		return &ErrUnsupportedDataset{Version: ds.Version}
+	}
+
+	return persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		upsert := clause.OnConflict{UpdateAll: true}
+		if len(ds.Tags) > 0 {
+			if err := tx.Clauses(upsert).Create(&ds.Tags).Error; err != nil {
+				return err
+			}
+		}
+		if len(ds.Articles) > 0 {
+			if err := rewriteRenamedArticleLinks(tx, ds.Articles); err != nil {
+				return err
+			}
+			if err := assignArticleSlugs(tx, ds.Articles); err != nil {
+				return err
+			}
+			for idx := range ds.Articles {
+				deriveArticleStats(&ds.Articles[idx])
+				deriveArticleAbstracts(&ds.Articles[idx])
+			}
+			if err := tx.Clauses(upsert).Create(&ds.Articles).Error; err != nil {
+				return err
+			}
+			if err := syncArticleLinks(tx, ds.Articles); err != nil {
+				return err
+			}
+			if err := resolveDanglingArticleLinks(tx, ds.Articles); err != nil {
+				return err
+			}
+		}
+		if len(ds.TagAssignments) > 0 {
+			if err := tx.Clauses(upsert).Create(&ds.TagAssignments).Error; err != nil {
+				return err
+			}
+		}
+		return syncFileRefs(tx, fileRefsOfDataset(ds))
+	})
+}
+
+// fileRefsOfDataset the articles reference files in the contents, the tags reference files by the images
+func fileRefsOfDataset(ds *Dataset) map[FileRefOwner]string {
+	texts := map[FileRefOwner]string{}
+	for _, a := range ds.Articles {
+		texts[FileRefOwner{Type: FileRefArticle, ID: a.ID}] = a.Content
+	}
+	for _, t := range ds.Tags {
+		texts[FileRefOwner{Type: FileRefTag, ID: t.ID}] = t.Image
+	}
+	return texts
+}
+
+type ErrUnsupportedDataset struct {
+	Version int
+}
+
+func (e *ErrUnsupportedDataset) Error() string {
+	return "unsupported dataset version " + strconv.Itoa(e.Version)
+}
@@ -0,0 +1,154 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+func TestExportDataset(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be able to export all records", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article` ORDER BY id")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content"}).AddRow(100, "title", "content"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `tag` ORDER BY id")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tname"}).AddRow(10, "go"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `tag_assign` ORDER BY id")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "res_id", "tag"}).AddRow(1000, 100, 10))
+
+		ds, err := ExportDataset(&sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ds.Version).To(Equal(DatasetVersion))
+		Expect(ds.Articles).To(Equal([]ArticleRecord{{ArticleMeta: ArticleMeta{ID: 100, Title: "title"}, Content: "content"}}))
+		Expect(ds.Tags).To(Equal([]Tag{{ID: 10, Name: "go"}}))
+		Expect(ds.TagAssignments).To(Equal([]TagAssignment{{ID: 1000, ResID: 100, TagID: 10}}))
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `article` ORDER BY id")).WillReturnError(sql.ErrConnDone)
+
+		ds, err := ExportDataset(&sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(sql.ErrConnDone))
+		Expect(ds).To(BeNil())
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestImportDataset(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be able to import records with upsert", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tag` (`tname`,`note`,`img`,`id`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE")).
+			WithArgs("go", "", "", 10).WillReturnResult(sqlmock.NewResult(10, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article` WHERE id IN (?) ORDER BY id")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(100, "title"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE id IN (?) ORDER BY id")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(100, "title"))
+		expectUsedArticleSlugs(mock, "title", 100)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article`")).WillReturnResult(sqlmock.NewResult(100, 1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_link` WHERE source_id IN (?)")).
+			WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article_link` SET `target_id`=? WHERE target_id = 0 AND target IN (?,?)")).
+			WithArgs(100, "100", "title").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefArticle, 100).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefTag, 10).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectCommit()
+
+		ds := Dataset{Version: DatasetVersion,
+			Articles: []ArticleRecord{{ArticleMeta: ArticleMeta{ID: 100, Title: "title", WordCount: 999}, Content: "# content"}},
+			Tags:     []Tag{{ID: 10, Name: "go"}}}
+		Expect(ImportDataset(&ds, &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(ds.Articles[0].WordCount).To(Equal(1))
+		Expect(ds.Articles[0].TOC).To(Equal(ArticleTOC{{Level: 1, Title: "content", Anchor: "content"}}))
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should rewrite links to renamed articles and record links of articles", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article` WHERE id IN (?,?) ORDER BY id")).
+			WithArgs(100, 200).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(100, "Old").AddRow(200, "B"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `source_id` FROM `article_link` WHERE target_id = ? AND target = ? ORDER BY source_id")).
+			WithArgs(100, "Old").WillReturnRows(sqlmock.NewRows([]string{"source_id"}).AddRow(200).AddRow(300))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, abstracts, abstracts_generated, content FROM `article` WHERE id IN (?) ORDER BY id")).
+			WithArgs(300).WillReturnRows(sqlmock.NewRows([]string{"id", "abstracts", "abstracts_generated", "content"}).
+			AddRow(300, "", false, "[[Old|x]] text."))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article` SET `abstracts`=?,`abstracts_generated`=?,`char_count`=?,"+
+			"`code_block_count`=?,`content`=?,`image_count`=?,`reading_minutes`=?,`toc`=?,`word_count`=? WHERE id = ?")).
+			WithArgs("x text.", true, sqlmock.AnyArg(), 0, "[[New|x]] text.", 0, 1, "[]", sqlmock.AnyArg(), 300).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article_link` SET `target`=? WHERE target_id = ? AND target = ?")).
+			WithArgs("New", 100, "Old").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, slug FROM `article` WHERE id IN (?,?) ORDER BY id")).
+			WithArgs(100, 200).WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(100, "old").AddRow(200, "b"))
+		expectUsedArticleSlugs(mock, "old", 100)
+		expectUsedArticleSlugs(mock, "b", 200)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article`")).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title FROM `article` WHERE id IN (?) OR title IN (?,?) ORDER BY id")).
+			WithArgs(300, "New", "300").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(100, "New").AddRow(300, "C"))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `article_link` WHERE source_id IN (?,?)")).
+			WithArgs(100, 200).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `article_link` (`source_id`,`target`,`target_id`,`id`) VALUES (?,?,?,?),(?,?,?,?)")).
+			WithArgs(200, "New", 100, sqlmock.AnyArg(), 200, "300", 300, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article_link` SET `target_id`=?")).
+			WithArgs(100, "100", "New").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `article_link` SET `target_id`=?")).
+			WithArgs(200, "200", "B").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?,?)")).
+			WithArgs(FileRefArticle, 100, 200).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectCommit()
+
+		ds := Dataset{Version: DatasetVersion, Articles: []ArticleRecord{
+			{ArticleMeta: ArticleMeta{ID: 100, Title: "New"}, Content: "# content"},
+			{ArticleMeta: ArticleMeta{ID: 200, Title: "B"}, Content: "see [[Old]] and [[300|c]]"},
+		}}
+		Expect(ImportDataset(&ds, &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(ds.Articles[1].Content).To(Equal("see [[New]] and [[300|c]]"))
+		Expect(ds.Articles[1].Abstracts).To(Equal("see New and c"))
+		Expect(ds.Articles[0].Slug).To(Equal("old"))
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should rollback on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `tag`")).WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		ds := Dataset{Version: DatasetVersion, Tags: []Tag{{ID: 10, Name: "go"}}}
+		Expect(ImportDataset(&ds, &sessions.Session{Context: context.TODO()})).To(Equal(sql.ErrConnDone))
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject unsupported dataset", func(t *testing.T) {
+		err := ImportDataset(&Dataset{Version: 99}, &sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(&ErrUnsupportedDataset{Version: 99}))
+		Expect(err.Error()).To(Equal("unsupported dataset version 99"))
+	})
+}
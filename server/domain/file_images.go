@@ -0,0 +1,197 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/imaging"
+	"owlet/server/infra/sessions"
+	"path"
+	"sort"
+)
+
+const (
+	CodeImageInvalid  = "file.image_invalid"
+	CodeImageTooLarge = "file.image_too_large"
+)
+
+func init() {
+	fail.RegisterErrorCodes(
+		fail.ErrorCode{Code: CodeImageInvalid, Status: http.StatusBadRequest, Message: "the image can not be decoded"},
+		fail.ErrorCode{Code: CodeImageTooLarge, Status: http.StatusBadRequest, Message: "the image is too large"},
+	)
+}
+
+// ErrImageInvalid the uploaded content claims to be an image but it is not decodable
+type ErrImageInvalid struct {
+	Cause error
+}
+
+func (e *ErrImageInvalid) Error() string {
+	return fmt.Sprintf("the image can not be decoded: %v", e.Cause)
+}
+
+func (e *ErrImageInvalid) Unwrap() error {
+	return e.Cause
+}
+
+func (e *ErrImageInvalid) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusBadRequest, Code: CodeImageInvalid, Message: e.Error()}
+}
+
+// ErrImageTooLarge the width * height of the uploaded image exceeds MaxPixels
+type ErrImageTooLarge struct {
+	MaxPixels int
+}
+
+func (e *ErrImageTooLarge) Error() string {
+	return fmt.Sprintf("the image is too large, at most %d pixels are allowed", e.MaxPixels)
+}
+
+func (e *ErrImageTooLarge) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusBadRequest, Code: CodeImageTooLarge, Message: e.Error(),
+		MessageData: map[string]interface{}{"MaxPixels": e.MaxPixels}}
+}
+
+// FileContent the content of a file, or of a resized variant if the file is an image
+type FileContent struct {
+	io.ReadCloser
+	File *File
+	// Variant the box of the variant like '320x0', it is empty for the original content
+	Variant  string
+	MimeType string
+	Size     int64 // -1 if unknown
+	// Private the content should not be kept by shared caches, see isPrivateBlob
+	Private bool
+}
+
+// uploadedImage an uploaded image without metadata, it is decoded completely to make sure it is servable
+type uploadedImage struct {
+	data  []byte
+	image image.Image
+	info  *imaging.Info
+}
+
+// VariantKey the storage key of the variant of content fitting in the box of width * height,
+// e.g. 'variants/b94d27b9.../320x0'. The variants of a blob are deleted together with it.
+func VariantKey(sha256 string, width, height int) string {
+	return path.Join(variantPrefix(sha256), variantName(width, height))
+}
+
+func variantPrefix(sha256 string) string {
+	return "variants/" + sha256 + "/"
+}
+
+func variantName(width, height int) string {
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// sanitizeImage strip the metadata of an uploaded image, the errors of imaging are translated to the
+// errors responded to the client
+func sanitizeImage(r io.Reader) (*uploadedImage, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	opts := imaging.Active
+	sanitized, _, err := opts.Sanitize(data)
+	if err == nil {
+		var img image.Image
+		var info *imaging.Info
+		if img, info, err = opts.Decode(sanitized); err == nil {
+			return &uploadedImage{data: sanitized, image: img, info: info}, nil
+		}
+	}
+	if errors.Is(err, imaging.ErrTooLarge) {
+		return nil, &ErrImageTooLarge{MaxPixels: opts.MaxPixels}
+	}
+	if errors.Is(err, imaging.ErrInvalid) {
+		return nil, &ErrImageInvalid{Cause: err}
+	}
+	return nil, err
+}
+
+// generateThumbnails store the variants of each configured width smaller than the image, the larger
+// thumbnails are resized first and used as the sources of the smaller ones.
+// Failures are only logged, the missing variants are generated on demand.
+func generateThumbnails(file *File, img *uploadedImage, s *sessions.Session) {
+	sizes := append([]int{}, imaging.Active.Sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	src := img.image
+	for _, size := range sizes {
+		if size >= img.info.Width {
+			continue
+		}
+		resized, _ := imaging.Resize(src, size, 0)
+		data, err := imaging.EncodeVariant(resized, img.info.Format)
+		if err == nil {
+			err = blobstore.Active.Put(s.Context, VariantKey(file.SHA256, size, 0), bytes.NewReader(data),
+				int64(len(data)), imaging.VariantType(img.info.Format))
+		}
+		if err != nil {
+			s.Logger().Warnf("failed to generate thumbnail %d of %s: %v", size, file.SHA256, err)
+			return
+		}
+		src = resized
+	}
+}
+
+// openVariant open the cached variant of file fitting in the box, it is generated and cached if absent
+func openVariant(file *File, width, height int, s *sessions.Session) (*FileContent, error) {
+	format, _ := imaging.FormatOf(file.MimeType)
+	variant := &FileContent{File: file, Variant: variantName(width, height), MimeType: imaging.VariantType(format), Size: -1}
+	key := VariantKey(file.SHA256, width, height)
+	cached, err := blobstore.Active.Get(s.Context, key)
+	if err == nil {
+		variant.ReadCloser = cached
+		return variant, nil
+	}
+	if !errors.Is(err, blobstore.ErrBlobNotFound) {
+		return nil, err
+	}
+
+	original, err := blobstore.Active.Get(s.Context, file.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(original)
+	original.Close()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := imaging.Active.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	resized, _ := imaging.Resize(img, width, height)
+	if data, err = imaging.EncodeVariant(resized, format); err != nil {
+		return nil, err
+	}
+	if err := blobstore.Active.Put(s.Context, key, bytes.NewReader(data), int64(len(data)), variant.MimeType); err != nil {
+		s.Logger().Warnf("failed to cache variant %s: %v", key, err)
+	}
+	variant.ReadCloser = ioutil.NopCloser(bytes.NewReader(data))
+	variant.Size = int64(len(data))
+	return variant, nil
+}
+
+// deleteVariants remove all variants of the blob, failures are only logged
+func deleteVariants(sha256 string, s *sessions.Session) {
+	keys, err := blobstore.Active.List(s.Context, variantPrefix(sha256))
+	if err != nil {
+		s.Logger().Warnf("failed to list variants of blob %s: %v", sha256, err)
+		return
+	}
+	for _, key := range keys {
+		if err := blobstore.Active.Delete(s.Context, key); err != nil {
+			s.Logger().Warnf("failed to delete variant %s: %v", key, err)
+		}
+	}
+}
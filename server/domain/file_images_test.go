@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"owlet/server/infra/imaging"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+var imageFileColumns = append(append([]string{}, fileColumns...), "width", "height")
+
+func encodeTestPNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+func imageConfig(data []byte) image.Config {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	Expect(err).ToNot(HaveOccurred())
+	return cfg
+}
+
+func TestCreateImageFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+
+	t.Run("should strip metadata, record dimensions and generate thumbnails", func(t *testing.T) {
+		original := encodeTestPNG(400, 200)
+		// a tEXt chunk after IHDR, e.g. written by a camera application
+		text := []byte("\x00\x00\x00\x0atEXtLocation\x00x\x00\x00\x00\x00")
+		uploaded := append(append(append([]byte{}, original[:33]...), text...), original[33:]...)
+		sum := fmt.Sprintf("%x", sha256.Sum256(original))
+
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `touch_time`=? WHERE sha256 = ?")).
+			WithArgs(testinfra.AnyArgument{}, sum).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_blob`")).
+			WithArgs(sum, len(original), BlobKey(sum), 0, testinfra.AnyArgument{}, testinfra.AnyArgument{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		expectChargeFileUsage(mock, 100, len(original))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file`")).
+			WithArgs(100, "photo.png", len(original), "image/png", sum, BlobKey(sum),
+				400, 200, testinfra.AnyArgument{}, testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		file, err := CreateFile(&FileUpload{Name: "photo.png", Size: int64(len(uploaded)), Content: bytes.NewReader(uploaded)}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.SHA256).To(Equal(sum))
+		Expect([]int{file.Width, file.Height}).To(Equal([]int{400, 200}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		r, err := store.Get(context.TODO(), file.StorageKey)
+		Expect(err).ToNot(HaveOccurred())
+		stored, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(stored).To(Equal(original))
+
+		keys, err := store.List(context.TODO(), variantPrefix(sum))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{VariantKey(sum, 160, 0), VariantKey(sum, 320, 0)}))
+		r, _ = store.Get(context.TODO(), VariantKey(sum, 160, 0))
+		thumbnail, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(imageConfig(thumbnail)).To(Equal(image.Config{ColorModel: color.RGBAModel, Width: 160, Height: 80}))
+	})
+
+	t.Run("should reject undecodable images", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		data := encodeTestPNG(40, 20)
+		_, err := CreateFile(&FileUpload{Name: "broken.png", Size: -1, Content: bytes.NewReader(data[:len(data)-20])}, s)
+		Expect(err).To(BeAssignableToTypeOf(&ErrImageInvalid{}))
+		Expect(errors.Is(err, imaging.ErrInvalid)).To(BeTrue())
+		Expect(err.(*ErrImageInvalid).Respond().Code).To(Equal(CodeImageInvalid))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject too large images", func(t *testing.T) {
+		origin := imaging.Active
+		imaging.Active = imaging.Options{Sizes: origin.Sizes, MaxPixels: 100}
+		defer func() { imaging.Active = origin }()
+
+		_, err := CreateFile(&FileUpload{Name: "large.png", Size: -1, Content: bytes.NewReader(encodeTestPNG(20, 10))}, s)
+		Expect(err).To(Equal(&ErrImageTooLarge{MaxPixels: 100}))
+		detail := err.(*ErrImageTooLarge).Respond()
+		Expect(detail.Status).To(Equal(http.StatusBadRequest))
+		Expect(detail.MessageData).To(Equal(map[string]interface{}{"MaxPixels": 100}))
+	})
+}
+
+func TestOpenImageFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO()}
+	original := encodeTestPNG(400, 200)
+	sum := fmt.Sprintf("%x", sha256.Sum256(original))
+	Expect(store.Put(context.TODO(), BlobKey(sum), bytes.NewReader(original), int64(len(original)), "")).ToNot(HaveOccurred())
+
+	expectImageFile := func() sqlmock.Sqlmock {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(imageFileColumns).
+				AddRow(1, 100, "photo.png", len(original), "image/png", sum, BlobKey(sum), nil, 400, 200))
+		expectPublicBlob(mock, sum)
+		return mock
+	}
+
+	t.Run("should generate variant on demand and cache it", func(t *testing.T) {
+		mock := expectImageFile()
+		r, err := OpenFile(1, FileContentQuery{Width: 300, Height: 100}, s)
+		Expect(err).ToNot(HaveOccurred())
+		generated, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(r.Variant).To(Equal("320x160"))
+		Expect(r.MimeType).To(Equal("image/png"))
+		Expect(r.Size).To(Equal(int64(len(generated))))
+		Expect(imageConfig(generated).Width).To(Equal(320))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		// served from the cache
+		Expect(store.Put(context.TODO(), VariantKey(sum, 320, 160), bytes.NewReader([]byte("cached")), 6, "")).ToNot(HaveOccurred())
+		expectImageFile()
+		r, err = OpenFile(1, FileContentQuery{Width: 320, Height: 160}, s)
+		Expect(err).ToNot(HaveOccurred())
+		cached, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(string(cached)).To(Equal("cached"))
+		Expect(r.Size).To(Equal(int64(-1)))
+	})
+
+	t.Run("should open original if image fits in the box", func(t *testing.T) {
+		expectImageFile()
+		r, err := OpenFile(1, FileContentQuery{Width: 1000}, s)
+		Expect(err).ToNot(HaveOccurred())
+		content, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(r.Variant).To(BeEmpty())
+		Expect(content).To(Equal(original))
+	})
+}
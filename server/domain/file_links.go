@@ -0,0 +1,195 @@
+package domain
+
+import (
+	"mime"
+	"net/url"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tokens"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	EnvFileLinkTTL       = "FILE_LINK_TTL"        // e.g. '30m'
+	EnvFileLinkSingleUse = "FILE_LINK_SINGLE_USE" // 'true' makes each signed link usable only once
+
+	DefaultFileLinkTTL = time.Hour
+
+	TokenPurposeFileDownload = "file_download"
+)
+
+// FileLinkOptions how the links of private files are signed. The single-use links are always signed by this
+// server, the blob store can not limit the times a presigned URL is used.
+type FileLinkOptions struct {
+	TTL       time.Duration
+	SingleUse bool
+}
+
+var (
+	// FileLinks the options used to sign the file links in the content of draft articles, see FileLinkOptionsFromEnv
+	FileLinks = FileLinkOptions{TTL: DefaultFileLinkTTL}
+
+	// usedFileLinks the nonces of the single-use links used, they are kept until the links expire.
+	// They are kept in memory, a link may be used once on each instance.
+	usedFileLinks = cache.New(DefaultFileLinkTTL, time.Minute)
+
+	// fileContentURLPattern matches the content URLs of files with the query, e.g. '/v1/files/123/content?w=320'
+	fileContentURLPattern = regexp.MustCompile(regexp.QuoteMeta(PathFiles) + `/(\d+)/content(\?[^\s"'<>()\[\]#]*)?`)
+)
+
+func FileLinkOptionsFromEnv() FileLinkOptions {
+	opts := FileLinkOptions{TTL: durationFromEnv(EnvFileLinkTTL, DefaultFileLinkTTL)}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultFileLinkTTL
+	}
+	if value := os.Getenv(EnvFileLinkSingleUse); value != "" {
+		singleUse, err := strconv.ParseBool(value)
+		if err != nil {
+			logrus.Warnf("%s: invalid bool '%s', links are reusable", EnvFileLinkSingleUse, value)
+		}
+		opts.SingleUse = singleUse
+	}
+	return opts
+}
+
+// signFileLinks replace the content URLs of files in text with the signed links. The links are presigned by
+// the blob store if it supports (see blobstore.URLSigner), unless they are single-use or refer to image variants.
+func signFileLinks(text string, opts FileLinkOptions, s *sessions.Session) (string, error) {
+	matches := fileContentURLPattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return text, nil
+	}
+	ids := []types.ID{}
+	for _, m := range matches {
+		if id, err := types.ParseID(m[1]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	var files []File
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Where("id IN ?", ids).Find(&files).Error; err != nil {
+		return "", err
+	}
+	fileOfID := map[types.ID]*File{}
+	for idx := range files {
+		fileOfID[files[idx].ID] = &files[idx]
+	}
+
+	var failure error
+	signed := fileContentURLPattern.ReplaceAllStringFunc(text, func(link string) string {
+		m := fileContentURLPattern.FindStringSubmatch(link)
+		id, _ := types.ParseID(m[1])
+		file, found := fileOfID[id]
+		if !found || failure != nil {
+			return link
+		}
+		query, err := url.ParseQuery(strings.TrimPrefix(m[2], "?"))
+		if err != nil {
+			return link
+		}
+		query.Del("token")
+		if signer, ok := blobstore.Active.(blobstore.URLSigner); ok && !opts.SingleUse && len(query) == 0 {
+			presigned, err := signer.SignURL(s.Context, file.StorageKey, opts.TTL, file.MimeType,
+				mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+			if err != nil {
+				failure = err
+				return link
+			}
+			return presigned
+		}
+		token, err := issueFileToken(file.ID, opts)
+		if err != nil {
+			failure = err
+			return link
+		}
+		query.Set("token", token)
+		return strings.TrimSuffix(link, m[2]) + "?" + query.Encode()
+	})
+	if failure != nil {
+		return "", failure
+	}
+	return signed, nil
+}
+
+// issueFileToken the fingerprint of a single-use token is a nonce, which is recorded once the token is used
+func issueFileToken(id types.ID, opts FileLinkOptions) (string, error) {
+	nonce := ""
+	if opts.SingleUse {
+		nonce = strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	return tokens.Issue(TokenPurposeFileDownload, id, nonce, opts.TTL)
+}
+
+// verifyFileToken the errors wrap fail.ErrInvalidToken
+func verifyFileToken(token string, id types.ID) error {
+	claims, err := tokens.Parse(token, TokenPurposeFileDownload)
+	if err != nil {
+		return err
+	}
+	if claims.Subject != id {
+		return tokens.ErrTokenPurpose
+	}
+	if claims.Fingerprint != "" {
+		ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+		if err := usedFileLinks.Add(claims.Fingerprint, true, ttl); err != nil {
+			return tokens.ErrTokenUsed
+		}
+	}
+	return nil
+}
+
+// isPrivateBlob a blob is private if it is unreferenced or referenced by draft articles only,
+// e.g. the files just uploaded are readable by the owners only until they are referenced by avatars, tags
+// or published articles.
+func isPrivateBlob(sha256 string, s *sessions.Session) (bool, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	var refs []FileRef
+	if err := db.Where("sha256 = ?", sha256).Find(&refs).Error; err != nil {
+		return false, err
+	}
+	articles := []types.ID{}
+	for _, ref := range refs {
+		if ref.ResourceType != FileRefArticle {
+			return false, nil
+		}
+		articles = append(articles, ref.ResourceID)
+	}
+	if len(articles) == 0 {
+		return true, nil
+	}
+	var published int64
+	if err := db.Model(&ArticleRecord{}).Where("id IN ? AND status = ?", articles, ArticleStatusPublished).
+		Count(&published).Error; err != nil {
+		return false, err
+	}
+	return published == 0, nil
+}
+
+// authorizeFile the owner of file and administrators are able to read private files without token.
+// A file is not found for others without a valid token, the token is ignored for public files.
+func authorizeFile(file *File, token string, s *sessions.Session) (private bool, err error) {
+	if private, err = isPrivateBlob(file.SHA256, s); err != nil || !private {
+		return private, err
+	}
+	if s.Identity.ID != 0 && (file.Owner == s.Identity.ID || s.Perms.HasRole(authority.RoleAdmin)) {
+		return true, nil
+	}
+	if token == "" {
+		return true, fail.ErrNotFound
+	}
+	return true, verifyFileToken(token, file.ID)
+}
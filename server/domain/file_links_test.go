@@ -0,0 +1,275 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tokens"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+func expectPublicBlob(mock sqlmock.Sqlmock, sha string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE sha256 = ?")).
+		WithArgs(sha).WillReturnRows(sqlmock.NewRows(fileRefColumns).AddRow(1, sha, FileRefAvatar, 100))
+}
+
+func expectPrivateBlob(mock sqlmock.Sqlmock, sha string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE sha256 = ?")).
+		WithArgs(sha).WillReturnRows(sqlmock.NewRows(fileRefColumns).AddRow(1, sha, FileRefArticle, 10))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `article` WHERE id IN (?) AND status = ?")).
+		WithArgs(10, ArticleStatusPublished).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+}
+
+func TestFileLinkOptionsFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer os.Unsetenv(EnvFileLinkTTL)
+	defer os.Unsetenv(EnvFileLinkSingleUse)
+
+	Expect(FileLinkOptionsFromEnv()).To(Equal(FileLinkOptions{TTL: DefaultFileLinkTTL}))
+
+	os.Setenv(EnvFileLinkTTL, "10m")
+	os.Setenv(EnvFileLinkSingleUse, "true")
+	Expect(FileLinkOptionsFromEnv()).To(Equal(FileLinkOptions{TTL: 10 * time.Minute, SingleUse: true}))
+
+	os.Setenv(EnvFileLinkTTL, "off")
+	os.Setenv(EnvFileLinkSingleUse, "maybe")
+	Expect(FileLinkOptionsFromEnv()).To(Equal(FileLinkOptions{TTL: DefaultFileLinkTTL}))
+}
+
+func TestIsPrivateBlob(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+
+	t.Run("should be public if referenced by avatars or tags", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectPublicBlob(mock, helloSHA256)
+		Expect(isPrivateBlob(helloSHA256, s)).To(BeFalse())
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE sha256 = ?")).
+			WillReturnRows(sqlmock.NewRows(fileRefColumns).
+				AddRow(1, helloSHA256, FileRefArticle, 10).AddRow(2, helloSHA256, FileRefTag, 20))
+		Expect(isPrivateBlob(helloSHA256, s)).To(BeFalse())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should be private if unreferenced or referenced by draft articles only", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE sha256 = ?")).
+			WithArgs(helloSHA256).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		Expect(isPrivateBlob(helloSHA256, s)).To(BeTrue())
+
+		expectPrivateBlob(mock, helloSHA256)
+		Expect(isPrivateBlob(helloSHA256, s)).To(BeTrue())
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE sha256 = ?")).
+			WillReturnRows(sqlmock.NewRows(fileRefColumns).
+				AddRow(1, helloSHA256, FileRefArticle, 10).AddRow(2, helloSHA256, FileRefArticle, 11))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `article` WHERE id IN (?,?) AND status = ?")).
+			WithArgs(10, 11, ArticleStatusPublished).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		Expect(isPrivateBlob(helloSHA256, s)).To(BeFalse())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestOpenPrivateFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	Expect(store.Put(context.TODO(), "files/1", strings.NewReader("draft"), 5, "")).ToNot(HaveOccurred())
+	anonymous := &sessions.Session{Context: context.TODO()}
+
+	open := func(q FileContentQuery, s *sessions.Session) (*FileContent, error) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "draft.txt", 5, "text/plain", helloSHA256, "files/1", nil))
+		expectPrivateBlob(mock, helloSHA256)
+		content, err := OpenFile(1, q, s)
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		return content, err
+	}
+
+	t.Run("should be not found without token", func(t *testing.T) {
+		_, err := open(FileContentQuery{}, anonymous)
+		Expect(err).To(Equal(fail.ErrNotFound))
+		_, err = open(FileContentQuery{}, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200}})
+		Expect(err).To(Equal(fail.ErrNotFound))
+	})
+
+	t.Run("should be readable by owner and administrators", func(t *testing.T) {
+		content, err := open(FileContentQuery{}, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}})
+		Expect(err).ToNot(HaveOccurred())
+		content.Close()
+		Expect(content.Private).To(BeTrue())
+
+		content, err = open(FileContentQuery{}, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200},
+			Perms: authority.Permissions{authority.RoleAdmin}})
+		Expect(err).ToNot(HaveOccurred())
+		content.Close()
+	})
+
+	t.Run("should be readable with token of the file", func(t *testing.T) {
+		token, err := issueFileToken(1, FileLinkOptions{TTL: time.Minute})
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 2; i++ {
+			content, err := open(FileContentQuery{Token: token}, anonymous)
+			Expect(err).ToNot(HaveOccurred())
+			data, _ := ioutil.ReadAll(content)
+			content.Close()
+			Expect(string(data)).To(Equal("draft"))
+		}
+
+		other, _ := issueFileToken(2, FileLinkOptions{TTL: time.Minute})
+		_, err = open(FileContentQuery{Token: other}, anonymous)
+		Expect(errors.Is(err, fail.ErrInvalidToken)).To(BeTrue())
+
+		expired, _ := issueFileToken(1, FileLinkOptions{TTL: -time.Second})
+		_, err = open(FileContentQuery{Token: expired}, anonymous)
+		Expect(err).To(Equal(tokens.ErrTokenExpired))
+	})
+
+	t.Run("should reject single-use token used already", func(t *testing.T) {
+		token, err := issueFileToken(1, FileLinkOptions{TTL: time.Minute, SingleUse: true})
+		Expect(err).ToNot(HaveOccurred())
+		content, err := open(FileContentQuery{Token: token}, anonymous)
+		Expect(err).ToNot(HaveOccurred())
+		content.Close()
+
+		_, err = open(FileContentQuery{Token: token}, anonymous)
+		Expect(err).To(Equal(tokens.ErrTokenUsed))
+	})
+}
+
+func TestDraftFileAsAvatarOfOthers(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	Expect(store.Put(context.TODO(), "files/1", strings.NewReader("draft"), 5, "")).ToNot(HaveOccurred())
+
+	t.Run("should keep the draft attachment private when others set it as avatar", func(t *testing.T) {
+		// user 200 sets the avatar to the draft attachment of user 100, it is not referenced
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(200).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(200, "jerry", "jerry@example.com", "salt", "hashed", "", "", "", "Jerry", "", "", false))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `avatar`=?,`update_time`=? WHERE id = ?")).
+			WithArgs("/v1/files/1/content", testinfra.AnyArgument{}, 200).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, sha256 FROM `file` WHERE id IN (?) AND owner = ?")).
+			WithArgs(1, 200).WillReturnRows(sqlmock.NewRows([]string{"id", "sha256"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefAvatar, 200).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND `user`.`id` = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(200, 200).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(200, "jerry", "jerry@example.com", "salt", "hashed", "/v1/files/1/content", "", "", "Jerry", "", "", false))
+		mock.ExpectCommit()
+
+		jerry := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200}}
+		avatar := "/v1/files/1/content"
+		_, err := PatchMyProfile(&UserPatch{Avatar: &avatar}, jerry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		// the blob is still referenced by the draft article only, the download requires a token
+		_, mock = testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "draft.txt", 5, "text/plain", helloSHA256, "files/1", nil))
+		expectPrivateBlob(mock, helloSHA256)
+		_, err = OpenFile(1, FileContentQuery{}, &sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(fail.ErrNotFound))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestSignFileLinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+	text := "![a](/v1/files/1/content) ![b](https://example.com/v1/files/1/content?w=320&token=old) " +
+		"[absent](/v1/files/9/content) [meta](/v1/files/1)"
+	expectFiles := func() sqlmock.Sqlmock {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id IN (?,?,?)")).
+			WithArgs(1, 1, 9).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "a.png", 5, "image/png", helloSHA256, BlobKey(helloSHA256), nil))
+		return mock
+	}
+	tokenOf := func(link string) string {
+		u, err := url.Parse(link)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verifyFileToken(u.Query().Get("token"), 1)).ToNot(HaveOccurred())
+		return u.Query().Get("w")
+	}
+	links := regexp.MustCompile(`\(([^)]+)\)`)
+
+	t.Run("should sign links by tokens", func(t *testing.T) {
+		mock := expectFiles()
+		signed, err := signFileLinks(text, FileLinkOptions{TTL: time.Minute}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		m := links.FindAllStringSubmatch(signed, -1)
+		Expect(m).To(HaveLen(4))
+		Expect(m[0][1]).To(HavePrefix("/v1/files/1/content?token="))
+		Expect(tokenOf(m[0][1])).To(BeEmpty())
+		Expect(m[1][1]).To(HavePrefix("https://example.com/v1/files/1/content?"))
+		Expect(tokenOf(m[1][1])).To(Equal("320"))
+		Expect(m[2][1]).To(Equal("/v1/files/9/content"))
+		Expect(m[3][1]).To(Equal("/v1/files/1"))
+	})
+
+	t.Run("should presign links of originals by OSS", func(t *testing.T) {
+		stub := testinfra.StartOSSStub("owlet", "key-id")
+		defer stub.Close()
+		origin := blobstore.Active
+		defer func() { blobstore.Active = origin }()
+		var err error
+		blobstore.Active, err = blobstore.NewOSSStore(blobstore.OSSConfig{Endpoint: stub.URL, AccessKeyID: "key-id",
+			AccessKeySecret: "secret", Bucket: "owlet"})
+		Expect(err).ToNot(HaveOccurred())
+
+		mock := expectFiles()
+		signed, err := signFileLinks(text, FileLinkOptions{TTL: time.Minute}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		m := links.FindAllStringSubmatch(signed, -1)
+		Expect(m[0][1]).To(HavePrefix(stub.URL + "/owlet/"))
+		Expect(m[0][1]).To(ContainSubstring("Signature="))
+		Expect(tokenOf(m[1][1])).To(Equal("320"))
+
+		// single-use links can not be presigned
+		mock = expectFiles()
+		signed, err = signFileLinks(text, FileLinkOptions{TTL: time.Minute, SingleUse: true}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		m = links.FindAllStringSubmatch(signed, -1)
+		Expect(m[0][1]).To(HavePrefix("/v1/files/1/content?token="))
+	})
+
+	t.Run("should keep text without file links", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		Expect(signFileLinks("no links", FileLinks, s)).To(Equal("no links"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
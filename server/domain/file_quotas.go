@@ -0,0 +1,294 @@
+package domain
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"strconv"
+	"strings"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EnvFileMaxSize      = "FILE_MAX_SIZE"      // e.g. '32MiB'
+	EnvFileAllowedTypes = "FILE_ALLOWED_TYPES" // comma separated MIME types, e.g. 'image/*,application/pdf', '*/*' allows any
+	EnvFileUserQuota    = "FILE_USER_QUOTA"    // e.g. '1GiB', 0 means unlimited
+	EnvFileSpaceQuota   = "FILE_SPACE_QUOTA"   // the limit of all files of the site, e.g. '100GiB', 0 means unlimited
+
+	CodeFileTooLarge       = "file.too_large"
+	CodeFileTypeNotAllowed = "file.type_not_allowed"
+	CodeFileQuotaExceeded  = "file.quota_exceeded"
+
+	QuotaScopeUser  = "user"
+	QuotaScopeSpace = "space"
+)
+
+func init() {
+	fail.RegisterErrorCodes(
+		fail.ErrorCode{Code: CodeFileTooLarge, Status: http.StatusRequestEntityTooLarge, Message: "the file is too large"},
+		fail.ErrorCode{Code: CodeFileTypeNotAllowed, Status: http.StatusUnsupportedMediaType, Message: "the type of file is not allowed"},
+		fail.ErrorCode{Code: CodeFileQuotaExceeded, Status: http.StatusForbidden, Message: "the storage quota is exceeded"},
+	)
+}
+
+// ErrFileTooLarge the size of the uploaded file exceeds FileLimits.MaxSize
+type ErrFileTooLarge struct {
+	MaxSize int64
+}
+
+func (e *ErrFileTooLarge) Error() string {
+	return fmt.Sprintf("the file is too large, at most %d bytes are allowed", e.MaxSize)
+}
+
+func (e *ErrFileTooLarge) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusRequestEntityTooLarge, Code: CodeFileTooLarge, Message: e.Error(),
+		MessageData: map[string]interface{}{"MaxSize": formatByteSize(e.MaxSize)}}
+}
+
+// ErrFileTypeNotAllowed the sniffed type of the uploaded file is not in FileLimits.AllowedTypes
+type ErrFileTypeNotAllowed struct {
+	MimeType string
+}
+
+func (e *ErrFileTypeNotAllowed) Error() string {
+	return fmt.Sprintf("the type '%s' of file is not allowed", e.MimeType)
+}
+
+func (e *ErrFileTypeNotAllowed) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusUnsupportedMediaType, Code: CodeFileTypeNotAllowed, Message: e.Error(),
+		MessageData: map[string]interface{}{"MimeType": e.MimeType}}
+}
+
+// ErrQuotaExceeded the uploaded file does not fit in the quota of user or of the whole space
+type ErrQuotaExceeded struct {
+	Scope string
+	Quota int64
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("the storage quota of %s (%d bytes) is exceeded", e.Scope, e.Quota)
+}
+
+func (e *ErrQuotaExceeded) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusForbidden, Code: CodeFileQuotaExceeded, Message: e.Error(),
+		MessageData: map[string]interface{}{"Scope": e.Scope, "Quota": formatByteSize(e.Quota)}}
+}
+
+// FileUsage the storage consumed by the files of an owner, the logical size is charged even if the blob is shared.
+// It is charged when files are created, and released when they are deleted or collected.
+type FileUsage struct {
+	Owner types.ID `json:"owner" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	Files int64    `json:"files" gorm:"type:BIGINT NOT NULL DEFAULT '0'"`
+	Bytes int64    `json:"bytes" gorm:"type:BIGINT NOT NULL DEFAULT '0'"`
+
+	UpdateTime types.Timestamp `json:"update_time" gorm:"type:DATETIME NOT NULL"`
+}
+
+func (r *FileUsage) TableName() string {
+	return "file_usage"
+}
+
+// FileUsageReport the consumption of the current user, and of the whole space. The quotas are 0 if unlimited.
+type FileUsageReport struct {
+	Files      int64 `json:"files"`
+	Bytes      int64 `json:"bytes"`
+	Quota      int64 `json:"quota"`
+	SpaceBytes int64 `json:"space_bytes"`
+	SpaceQuota int64 `json:"space_quota"`
+}
+
+// FileLimitOptions the guardrails of uploading, sizes are in bytes and 0 means unlimited
+type FileLimitOptions struct {
+	MaxSize      int64
+	AllowedTypes []string
+	UserQuota    int64
+	SpaceQuota   int64
+}
+
+var (
+	DefaultFileLimits = FileLimitOptions{
+		MaxSize:      32 << 20,
+		AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf", "text/plain", "application/zip"},
+		UserQuota:    1 << 30,
+	}
+
+	// FileLimits the limits checked by CreateFile, see FileLimitsFromEnv
+	FileLimits = DefaultFileLimits
+
+	QueryMyFileUsageFunc = QueryMyFileUsage
+)
+
+// FileLimitsFromEnv the defaults are used for the absent or invalid envs
+func FileLimitsFromEnv() FileLimitOptions {
+	limits := DefaultFileLimits
+	limits.MaxSize = byteSizeFromEnv(EnvFileMaxSize, limits.MaxSize)
+	limits.UserQuota = byteSizeFromEnv(EnvFileUserQuota, limits.UserQuota)
+	limits.SpaceQuota = byteSizeFromEnv(EnvFileSpaceQuota, limits.SpaceQuota)
+	if value := os.Getenv(EnvFileAllowedTypes); value != "" {
+		allowed := []string{}
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				allowed = append(allowed, t)
+			}
+		}
+		limits.AllowedTypes = allowed
+	}
+	return limits
+}
+
+// Allowed match the media type (without parameters) with the allowed types, which may be wildcards like 'image/*'
+func (l FileLimitOptions) Allowed(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range l.AllowedTypes {
+		if allowed == "*/*" || allowed == mediaType ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryMyFileUsage the usage of user without files is 0
+func QueryMyFileUsage(s *sessions.Session) (*FileUsageReport, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	var usages []FileUsage
+	if err := db.Where("owner = ?", s.Identity.ID).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	report := FileUsageReport{Quota: FileLimits.UserQuota, SpaceQuota: FileLimits.SpaceQuota}
+	if len(usages) > 0 {
+		report.Files, report.Bytes = usages[0].Files, usages[0].Bytes
+	}
+	var err error
+	if report.SpaceBytes, err = spaceUsage(db); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// checkFileQuota check the quotas before storing the content, they are checked again when the usage is charged
+func checkFileQuota(owner types.ID, size int64, s *sessions.Session) error {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	if quota := FileLimits.UserQuota; quota > 0 {
+		var usages []FileUsage
+		if err := db.Where("owner = ?", owner).Find(&usages).Error; err != nil {
+			return err
+		}
+		if len(usages) > 0 && usages[0].Bytes+size > quota || size > quota {
+			return &ErrQuotaExceeded{Scope: QuotaScopeUser, Quota: quota}
+		}
+	}
+	return checkSpaceQuota(db, size)
+}
+
+// chargeFileUsage the usage of user is increased by a conditional update, so concurrent uploads never exceed the quota.
+// The space quota is checked by the sum of all users, it may be exceeded slightly by concurrent uploads.
+func chargeFileUsage(tx *gorm.DB, owner types.ID, size int64) error {
+	if err := checkSpaceQuota(tx, size); err != nil {
+		return err
+	}
+	now := types.CurrentTimestamp()
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&FileUsage{Owner: owner, UpdateTime: now}).Error; err != nil {
+		return err
+	}
+	q := tx.Model(&FileUsage{}).Where("owner = ?", owner)
+	if quota := FileLimits.UserQuota; quota > 0 {
+		q = q.Where("bytes + ? <= ?", size, quota)
+	}
+	result := q.Updates(map[string]interface{}{"files": gorm.Expr("files + 1"), "bytes": gorm.Expr("bytes + ?", size), "update_time": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ErrQuotaExceeded{Scope: QuotaScopeUser, Quota: FileLimits.UserQuota}
+	}
+	return nil
+}
+
+// releaseFileUsage decrease the usage of owner when the files are deleted
+func releaseFileUsage(tx *gorm.DB, owner types.ID, files, bytes int64) error {
+	return tx.Model(&FileUsage{}).Where("owner = ?", owner).Updates(map[string]interface{}{
+		"files": gorm.Expr("files - ?", files), "bytes": gorm.Expr("bytes - ?", bytes), "update_time": types.CurrentTimestamp(),
+	}).Error
+}
+
+func checkSpaceQuota(db *gorm.DB, size int64) error {
+	quota := FileLimits.SpaceQuota
+	if quota <= 0 {
+		return nil
+	}
+	used, err := spaceUsage(db)
+	if err != nil {
+		return err
+	}
+	if used+size > quota {
+		return &ErrQuotaExceeded{Scope: QuotaScopeSpace, Quota: quota}
+	}
+	return nil
+}
+
+func spaceUsage(db *gorm.DB) (int64, error) {
+	var used int64
+	err := db.Model(&FileUsage{}).Select("COALESCE(SUM(bytes), 0)").Scan(&used).Error
+	return used, err
+}
+
+var byteSizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// parseByteSize parse the sizes like '1024', '512KiB', '10MB' and '1GiB'
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	scale := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(unit.suffix)) {
+			s, scale = strings.TrimSpace(s[:len(s)-len(unit.suffix)]), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return n * scale, nil
+}
+
+// formatByteSize the size in the largest binary unit it is a multiple of, e.g. 33554432 => '32MiB'
+func formatByteSize(size int64) string {
+	for idx := 3; idx >= 0; idx-- {
+		if unit := byteSizeUnits[idx]; size >= unit.scale && size%unit.scale == 0 {
+			return strconv.FormatInt(size/unit.scale, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
+
+func byteSizeFromEnv(env string, def int64) int64 {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	size, err := parseByteSize(value)
+	if err != nil {
+		logrus.Warnf("%s: %v, the default %d is used", env, err, def)
+		return def
+	}
+	return size
+}
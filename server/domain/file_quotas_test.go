@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+)
+
+var fileUsageColumns = []string{"owner", "files", "bytes", "update_time"}
+
+// expectFileUsage expect the usage of owner queried, the usage is absent if bytes is 0
+func expectFileUsage(mock sqlmock.Sqlmock, owner types.ID, bytes int64) {
+	rows := sqlmock.NewRows(fileUsageColumns)
+	if bytes > 0 {
+		rows.AddRow(owner, 1, bytes, nil)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_usage` WHERE owner = ?")).WithArgs(owner).WillReturnRows(rows)
+}
+
+// expectChargeFileUsage expect the usage of owner charged successfully with the default limits
+func expectChargeFileUsage(mock sqlmock.Sqlmock, owner types.ID, size int) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_usage` (`files`,`bytes`,`update_time`,`owner`) VALUES (?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE `owner`=`owner`")).
+		WithArgs(0, 0, testinfra.AnyArgument{}, owner).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_usage` SET `bytes`=bytes + ?,`files`=files + 1,`update_time`=? "+
+		"WHERE owner = ? AND bytes + ? <= ?")).
+		WithArgs(size, testinfra.AnyArgument{}, owner, size, FileLimits.UserQuota).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectReleaseFileUsage(mock sqlmock.Sqlmock, owner types.ID, files, bytes int) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_usage` SET `bytes`=bytes - ?,`files`=files - ?,`update_time`=? WHERE owner = ?")).
+		WithArgs(bytes, files, testinfra.AnyArgument{}, owner).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestFileLimitsFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer os.Unsetenv(EnvFileMaxSize)
+	defer os.Unsetenv(EnvFileAllowedTypes)
+	defer os.Unsetenv(EnvFileUserQuota)
+	defer os.Unsetenv(EnvFileSpaceQuota)
+
+	Expect(FileLimitsFromEnv()).To(Equal(DefaultFileLimits))
+
+	os.Setenv(EnvFileMaxSize, "10MB")
+	os.Setenv(EnvFileAllowedTypes, " image/* , Application/PDF,")
+	os.Setenv(EnvFileUserQuota, "0")
+	os.Setenv(EnvFileSpaceQuota, "2GiB")
+	Expect(FileLimitsFromEnv()).To(Equal(FileLimitOptions{MaxSize: 10_000_000, AllowedTypes: []string{"image/*", "application/pdf"},
+		UserQuota: 0, SpaceQuota: 2 << 30}))
+
+	os.Setenv(EnvFileMaxSize, "huge")
+	os.Setenv(EnvFileSpaceQuota, "-1")
+	limits := FileLimitsFromEnv()
+	Expect(limits.MaxSize).To(Equal(DefaultFileLimits.MaxSize))
+	Expect(limits.SpaceQuota).To(BeZero())
+}
+
+func TestFileLimitOptions_Allowed(t *testing.T) {
+	RegisterTestingT(t)
+
+	limits := FileLimitOptions{AllowedTypes: []string{"image/*", "text/plain"}}
+	Expect(limits.Allowed("image/png")).To(BeTrue())
+	Expect(limits.Allowed("text/plain; charset=utf-8")).To(BeTrue())
+	Expect(limits.Allowed("text/html; charset=utf-8")).To(BeFalse())
+	Expect(limits.Allowed("application/octet-stream")).To(BeFalse())
+	Expect(limits.Allowed("")).To(BeFalse())
+	Expect(FileLimitOptions{AllowedTypes: []string{"*/*"}}.Allowed("application/octet-stream")).To(BeTrue())
+}
+
+func TestByteSize(t *testing.T) {
+	RegisterTestingT(t)
+
+	for s, size := range map[string]int64{"1024": 1024, "512KiB": 512 << 10, "10 mb": 10_000_000, "1GiB": 1 << 30, "7B": 7} {
+		Expect(parseByteSize(s)).To(Equal(size), s)
+	}
+	for _, s := range []string{"", "MiB", "-1", "1.5GiB", "1PB"} {
+		_, err := parseByteSize(s)
+		Expect(err).To(HaveOccurred(), s)
+	}
+
+	Expect(formatByteSize(32 << 20)).To(Equal("32MiB"))
+	Expect(formatByteSize(1 << 30)).To(Equal("1GiB"))
+	Expect(formatByteSize(1536)).To(Equal("1536B"))
+	Expect(formatByteSize(2048)).To(Equal("2KiB"))
+}
+
+func TestCreateFile_Limits(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+	origin := FileLimits
+	defer func() { FileLimits = origin }()
+
+	t.Run("should reject too large files", func(t *testing.T) {
+		FileLimits = FileLimitOptions{MaxSize: 10, AllowedTypes: []string{"text/plain"}}
+		_, mock := testinfra.SetUpMockSql()
+
+		_, err := CreateFile(&FileUpload{Name: "a.txt", Size: -1, Content: strings.NewReader("hello world")}, s)
+		Expect(err).To(Equal(&ErrFileTooLarge{MaxSize: 10}))
+		detail := err.(*ErrFileTooLarge).Respond()
+		Expect(detail.Status).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(detail.MessageData).To(Equal(map[string]interface{}{"MaxSize": "10B"}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject types not allowed", func(t *testing.T) {
+		FileLimits = FileLimitOptions{AllowedTypes: []string{"image/*"}}
+		_, mock := testinfra.SetUpMockSql()
+
+		// the extension is not trusted
+		_, err := CreateFile(&FileUpload{Name: "a.png", Size: -1, Content: strings.NewReader("<html></html>")}, s)
+		Expect(err).To(Equal(&ErrFileTypeNotAllowed{MimeType: "text/html; charset=utf-8"}))
+		Expect(err.(*ErrFileTypeNotAllowed).Respond().Status).To(Equal(http.StatusUnsupportedMediaType))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject files exceeding quota of user before storing", func(t *testing.T) {
+		FileLimits = FileLimitOptions{AllowedTypes: []string{"text/plain"}, UserQuota: 20}
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 10)
+
+		_, err := CreateFile(&FileUpload{Name: "a.txt", Size: -1, Content: strings.NewReader("hello world")}, s)
+		Expect(err).To(Equal(&ErrQuotaExceeded{Scope: QuotaScopeUser, Quota: 20}))
+		detail := err.(*ErrQuotaExceeded).Respond()
+		Expect(detail.Status).To(Equal(http.StatusForbidden))
+		Expect(detail.MessageData).To(Equal(map[string]interface{}{"Scope": QuotaScopeUser, "Quota": "20B"}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject files exceeding quota of space", func(t *testing.T) {
+		FileLimits = FileLimitOptions{AllowedTypes: []string{"text/plain"}, SpaceQuota: 100}
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(bytes), 0) FROM `file_usage`")).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(95))
+
+		_, err := CreateFile(&FileUpload{Name: "a.txt", Size: -1, Content: strings.NewReader("hello world")}, s)
+		Expect(err).To(Equal(&ErrQuotaExceeded{Scope: QuotaScopeSpace, Quota: 100}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not save file if quota is exceeded by concurrent uploads", func(t *testing.T) {
+		FileLimits = FileLimitOptions{AllowedTypes: []string{"text/plain"}, UserQuota: 20}
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob`")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_usage`")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_usage`")).WithArgs(11, testinfra.AnyArgument{}, 100, 11, 20).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		_, err := CreateFile(&FileUpload{Name: "a.txt", Size: -1, Content: strings.NewReader("hello world")}, s)
+		Expect(err).To(Equal(&ErrQuotaExceeded{Scope: QuotaScopeUser, Quota: 20}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestQueryMyFileUsage(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+	origin := FileLimits
+	defer func() { FileLimits = origin }()
+	FileLimits = FileLimitOptions{UserQuota: 1 << 30, SpaceQuota: 10 << 30}
+
+	t.Run("should report usages and quotas", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_usage` WHERE owner = ?")).WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(fileUsageColumns).AddRow(100, 3, 2048, nil))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(bytes), 0) FROM `file_usage`")).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(4096))
+
+		report, err := QueryMyFileUsage(s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*report).To(Equal(FileUsageReport{Files: 3, Bytes: 2048, Quota: 1 << 30, SpaceBytes: 4096, SpaceQuota: 10 << 30}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should report zero usage of user without files", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(bytes), 0) FROM `file_usage`")).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+
+		report, err := QueryMyFileUsage(s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Files).To(BeZero())
+		Expect(report.Bytes).To(BeZero())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_usage`")).WillReturnError(errors.New("some error"))
+
+		_, err := QueryMyFileUsage(s)
+		Expect(err).To(MatchError("some error"))
+	})
+}
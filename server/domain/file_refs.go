@@ -0,0 +1,238 @@
+package domain
+
+import (
+	"owlet/server/infra/idgen"
+	"regexp"
+	"sort"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+)
+
+// FileRefType the type of resources referencing files
+type FileRefType int
+
+const (
+	FileRefArticle = FileRefType(1) // the content of article
+	FileRefTag     = FileRefType(2) // the image of tag
+	FileRefAvatar  = FileRefType(3) // the avatar of user
+)
+
+// FileRef a resource references a blob through the URL of a file, the blob is not collected while it is referenced
+type FileRef struct {
+	ID           types.ID    `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	SHA256       string      `json:"sha256" gorm:"column:sha256;type:CHAR(64) NOT NULL;index:idx_file_ref_blob;uniqueIndex:uk_file_ref,priority:3"`
+	ResourceType FileRefType `json:"resource_type" gorm:"type:TINYINT NOT NULL;uniqueIndex:uk_file_ref,priority:1"`
+	ResourceID   types.ID    `json:"resource_id" gorm:"type:BIGINT UNSIGNED NOT NULL;uniqueIndex:uk_file_ref,priority:2"`
+}
+
+func (r *FileRef) TableName() string {
+	return "file_ref"
+}
+
+// FileRefOwner a resource which may reference files
+type FileRefOwner struct {
+	Type FileRefType
+	ID   types.ID
+}
+
+// fileURLPattern matches the URLs of files like '/v1/files/123/content', absolute or relative
+var fileURLPattern = regexp.MustCompile(regexp.QuoteMeta(PathFiles) + `/(\d+)`)
+
+// referencedFileIDs the distinct ids of files whose URLs appear in text
+func referencedFileIDs(text string) []types.ID {
+	ids := []types.ID{}
+	seen := map[types.ID]bool{}
+	for _, m := range fileURLPattern.FindAllStringSubmatch(text, -1) {
+		id, err := types.ParseID(m[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// syncFileRefs replace the references of owners with the files appearing in their texts, and adjust the
+// reference counts of blobs accordingly. It should be called in the transaction saving the owners.
+func syncFileRefs(tx *gorm.DB, texts map[FileRefOwner]string) error {
+	return syncOwnedFileRefs(tx, texts, 0)
+}
+
+// syncOwnedFileRefs the same as syncFileRefs, but only the files of user are referenced unless user is 0.
+// The referenced blobs may become public (see isPrivateBlob), so users must not reference the files of others.
+func syncOwnedFileRefs(tx *gorm.DB, texts map[FileRefOwner]string, user types.ID) error {
+	if len(texts) == 0 {
+		return nil
+	}
+
+	// the blobs referenced by each owner now
+	fileIDs := []types.ID{}
+	for _, text := range texts {
+		fileIDs = append(fileIDs, referencedFileIDs(text)...)
+	}
+	blobOfFile := map[types.ID]string{}
+	if len(fileIDs) > 0 {
+		q := tx.Model(&File{}).Select("id, sha256").Where("id IN ?", fileIDs)
+		if user != 0 {
+			q = q.Where("owner = ?", user)
+		}
+		var files []File
+		if err := q.Find(&files).Error; err != nil {
+			return err
+		}
+		for _, f := range files {
+			blobOfFile[f.ID] = f.SHA256
+		}
+	}
+	wanted := map[FileRefOwner]map[string]bool{}
+	for owner, text := range texts {
+		wanted[owner] = map[string]bool{}
+		for _, id := range referencedFileIDs(text) {
+			if sha, found := blobOfFile[id]; found {
+				wanted[owner][sha] = true
+			}
+		}
+	}
+
+	// the references recorded before, queried by type in a stable order
+	idsOfType := map[FileRefType][]types.ID{}
+	for owner := range texts {
+		idsOfType[owner.Type] = append(idsOfType[owner.Type], owner.ID)
+	}
+	refTypes := []FileRefType{}
+	for refType, ids := range idsOfType {
+		sortIDs(ids)
+		refTypes = append(refTypes, refType)
+	}
+	sort.Slice(refTypes, func(i, j int) bool { return refTypes[i] < refTypes[j] })
+
+	deltas := map[string]int{}
+	staleRefs := []types.ID{}
+	for _, refType := range refTypes {
+		var existing []FileRef
+		if err := tx.Where("resource_type = ? AND resource_id IN ?", refType, idsOfType[refType]).
+			Find(&existing).Error; err != nil {
+			return err
+		}
+		for _, ref := range existing {
+			owner := FileRefOwner{Type: ref.ResourceType, ID: ref.ResourceID}
+			if wanted[owner][ref.SHA256] {
+				delete(wanted[owner], ref.SHA256) // kept as it is
+				continue
+			}
+			staleRefs = append(staleRefs, ref.ID)
+			deltas[ref.SHA256]--
+		}
+	}
+
+	newRefs := []FileRef{}
+	for owner, blobs := range wanted {
+		for sha := range blobs {
+			newRefs = append(newRefs, FileRef{SHA256: sha, ResourceType: owner.Type, ResourceID: owner.ID})
+			deltas[sha]++
+		}
+	}
+	sort.Slice(newRefs, func(i, j int) bool {
+		a, b := newRefs[i], newRefs[j]
+		if a.ResourceType != b.ResourceType {
+			return a.ResourceType < b.ResourceType
+		}
+		if a.ResourceID != b.ResourceID {
+			return a.ResourceID < b.ResourceID
+		}
+		return a.SHA256 < b.SHA256
+	})
+	for idx := range newRefs {
+		newRefs[idx].ID = idgen.NextID(idWorker)
+	}
+
+	if len(staleRefs) > 0 {
+		if err := tx.Where("id IN ?", staleRefs).Delete(&FileRef{}).Error; err != nil {
+			return err
+		}
+	}
+	if len(newRefs) > 0 {
+		if err := tx.Create(&newRefs).Error; err != nil {
+			return err
+		}
+	}
+	return adjustBlobRefCounts(tx, deltas)
+}
+
+// adjustBlobRefCounts the touch time is refreshed too, so the grace period of an unreferenced blob
+// starts from the time it is dereferenced
+func adjustBlobRefCounts(tx *gorm.DB, deltas map[string]int) error {
+	blobs := []string{}
+	for sha, delta := range deltas {
+		if delta != 0 {
+			blobs = append(blobs, sha)
+		}
+	}
+	sort.Strings(blobs)
+	now := types.CurrentTimestamp()
+	for _, sha := range blobs {
+		err := tx.Model(&Blob{}).Where("sha256 = ?", sha).
+			Updates(map[string]interface{}{"ref_count": gorm.Expr("ref_count + ?", deltas[sha]), "touch_time": now}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileRefsBatchSize the number of resources scanned in a batch when backfilling the references
+const fileRefsBatchSize = 200
+
+// backfillFileRefs record the references of the resources saved before the references are introduced,
+// the reference counts of blobs are set accordingly
+func backfillFileRefs(db *gorm.DB) error {
+	if err := backfillFileRefsOf(db, &ArticleRecord{}, "content", FileRefArticle, false); err != nil {
+		return err
+	}
+	if err := backfillFileRefsOf(db, &Tag{}, "img", FileRefTag, false); err != nil {
+		return err
+	}
+	return backfillFileRefsOf(db, &User{}, "avatar", FileRefAvatar, true)
+}
+
+// backfillFileRefsOf scan the resources whose column may contain the URLs of files,
+// the resources reference their own files only if owned is true, e.g. the avatars of users
+func backfillFileRefsOf(db *gorm.DB, model interface{}, column string, refType FileRefType, owned bool) error {
+	var lastID types.ID
+	for {
+		var rows []struct {
+			ID   types.ID
+			Text string
+		}
+		if err := db.Model(model).Select("id, "+column+" AS text").Where("id > ? AND "+column+" LIKE ?",
+			lastID, "%"+PathFiles+"/%").Order("id").Limit(fileRefsBatchSize).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if owned {
+			for _, row := range rows {
+				text := map[FileRefOwner]string{{Type: refType, ID: row.ID}: row.Text}
+				if err := syncOwnedFileRefs(db, text, row.ID); err != nil {
+					return err
+				}
+			}
+		} else {
+			texts := map[FileRefOwner]string{}
+			for _, row := range rows {
+				texts[FileRefOwner{Type: refType, ID: row.ID}] = row.Text
+			}
+			if err := syncFileRefs(db, texts); err != nil {
+				return err
+			}
+		}
+		if len(rows) < fileRefsBatchSize {
+			return nil
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
+
+func sortIDs(ids []types.ID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"owlet/server/infra/persistence"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+var fileRefColumns = []string{"id", "sha256", "resource_type", "resource_id"}
+
+func TestReferencedFileIDs(t *testing.T) {
+	RegisterTestingT(t)
+
+	text := "![a](/v1/files/1/content) ![b](https://example.com/v1/files/2/content?w=100) " +
+		"[a again](/v1/files/1) /v1/files/x /v2/files/3"
+	Expect(referencedFileIDs(text)).To(Equal([]types.ID{1, 2}))
+	Expect(referencedFileIDs("")).To(BeEmpty())
+}
+
+func syncFileRefsInTx(texts map[FileRefOwner]string) error {
+	return persistence.ActiveGormDB.WithContext(context.TODO()).Transaction(func(tx *gorm.DB) error {
+		return syncFileRefs(tx, texts)
+	})
+}
+
+func TestSyncFileRefs(t *testing.T) {
+	RegisterTestingT(t)
+
+	shaA, shaB, shaC := helloSHA256, "aa"+helloSHA256[2:], "cc"+helloSHA256[2:]
+
+	t.Run("should add new references and remove stale references", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, sha256 FROM `file` WHERE id IN (?,?,?)")).
+			WithArgs(1, 2, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "sha256"}).AddRow(1, shaA).AddRow(2, shaB))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefArticle, 100).
+			WillReturnRows(sqlmock.NewRows(fileRefColumns).
+				AddRow(1000, shaA, FileRefArticle, 100).AddRow(1001, shaC, FileRefArticle, 100))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file_ref` WHERE id IN (?)")).
+			WithArgs(1001).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_ref` (`sha256`,`resource_type`,`resource_id`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(shaB, FileRefArticle, 100, testinfra.AnyId{}).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `ref_count`=ref_count + ?,`touch_time`=? WHERE sha256 = ?")).
+			WithArgs(1, testinfra.AnyArgument{}, shaB).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `ref_count`=ref_count + ?,`touch_time`=? WHERE sha256 = ?")).
+			WithArgs(-1, testinfra.AnyArgument{}, shaC).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// file 3 is absent, it is ignored
+		err := syncFileRefsInTx(map[FileRefOwner]string{
+			{Type: FileRefArticle, ID: 100}: "![a](/v1/files/1/content) ![b](/v1/files/2/content) ![c](/v1/files/3/content)",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should remove all references if nothing is referenced", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefTag, 10).
+			WillReturnRows(sqlmock.NewRows(fileRefColumns).AddRow(1000, shaA, FileRefTag, 10))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file_ref` WHERE id IN (?)")).
+			WithArgs(1000).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `ref_count`=ref_count + ?,`touch_time`=? WHERE sha256 = ?")).
+			WithArgs(-1, testinfra.AnyArgument{}, shaA).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := syncFileRefsInTx(map[FileRefOwner]string{{Type: FileRefTag, ID: 10}: "go.png"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should do nothing if references are not changed", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, sha256 FROM `file` WHERE id IN (?)")).
+			WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "sha256"}).AddRow(1, shaA))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefTag, 10).
+			WillReturnRows(sqlmock.NewRows(fileRefColumns).AddRow(1000, shaA, FileRefTag, 10))
+		mock.ExpectCommit()
+
+		err := syncFileRefsInTx(map[FileRefOwner]string{{Type: FileRefTag, ID: 10}: "/v1/files/1/content"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestBackfillFileRefs(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should record the references of articles, tags and users", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, content AS text FROM `article` WHERE id > ? AND content LIKE ? ORDER BY id LIMIT 200")).
+			WithArgs(0, "%/v1/files/%").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "text"}).AddRow(100, "![a](/v1/files/1/content)"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, sha256 FROM `file` WHERE id IN (?)")).
+			WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "sha256"}).AddRow(1, helloSHA256))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefArticle, 100).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_ref` (`sha256`,`resource_type`,`resource_id`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(helloSHA256, FileRefArticle, 100, testinfra.AnyId{}).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `ref_count`=ref_count + ?,`touch_time`=? WHERE sha256 = ?")).
+			WithArgs(1, testinfra.AnyArgument{}, helloSHA256).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, img AS text FROM `tag` WHERE id > ? AND img LIKE ? ORDER BY id LIMIT 200")).
+			WithArgs(0, "%/v1/files/%").WillReturnRows(sqlmock.NewRows([]string{"id", "text"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, avatar AS text FROM `user` WHERE id > ? AND avatar LIKE ? ORDER BY id LIMIT 200")).
+			WithArgs(0, "%/v1/files/%").WillReturnRows(sqlmock.NewRows([]string{"id", "text"}))
+
+		Expect(backfillFileRefs(db)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		db, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, content AS text FROM `article`")).WillReturnError(errors.New("some error"))
+
+		Expect(backfillFileRefs(db)).To(MatchError("some error"))
+	})
+}
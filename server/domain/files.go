@@ -1,5 +1,303 @@
 package domain
 
-func CreateFile() {
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/idgen"
+	"owlet/server/infra/imaging"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// File the metadata of an uploaded file, the content is kept in the blob identified by SHA256.
+// Files with the same content share one blob. Width and Height are the dimensions of images, they are 0
+// for other files and the images uploaded before the image processing was introduced.
+type File struct {
+	ID         types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	Owner      types.ID `json:"owner" gorm:"type:BIGINT UNSIGNED NOT NULL;index:idx_file_owner"`
+	Name       string   `json:"name" gorm:"type:NVARCHAR(255) NOT NULL"`
+	Size       int64    `json:"size" gorm:"type:BIGINT NOT NULL"`
+	MimeType   string   `json:"mime_type" gorm:"type:VARCHAR(255) NOT NULL"`
+	SHA256     string   `json:"sha256" gorm:"column:sha256;type:CHAR(64) NOT NULL;index:idx_file_sha256"`
+	StorageKey string   `json:"-" gorm:"type:VARCHAR(255) NOT NULL"`
+	Width      int      `json:"width,omitempty" gorm:"type:INT NOT NULL DEFAULT '0'"`
+	Height     int      `json:"height,omitempty" gorm:"type:INT NOT NULL DEFAULT '0'"`
+
+	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
+}
+
+// Blob a content kept in blobstore.Active, it is addressed by the SHA-256 digest.
+// RefCount is the number of resources referencing it (see FileRef), a blob neither referenced nor kept by any file
+// is collected after the grace period since TouchTime, the last time it is uploaded, referenced or dereferenced.
+type Blob struct {
+	SHA256     string `json:"sha256" gorm:"column:sha256;primary_key;type:CHAR(64) NOT NULL"`
+	Size       int64  `json:"size" gorm:"type:BIGINT NOT NULL"`
+	StorageKey string `json:"-" gorm:"type:VARCHAR(255) NOT NULL"`
+	RefCount   int    `json:"ref_count" gorm:"type:INT NOT NULL DEFAULT '0'"`
+
+	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
+	TouchTime  types.Timestamp `json:"touch_time" gorm:"type:DATETIME NOT NULL;index:idx_file_blob_touch_time"`
+}
+
+func (r *File) TableName() string {
+	return "file"
+}
+
+func (r *Blob) TableName() string {
+	return "file_blob"
+}
+
+// FileUpload the content of an uploaded file, Size is -1 if it is unknown
+type FileUpload struct {
+	Name    string
+	Size    int64
+	Content io.Reader
+}
+
+var (
+	CreateFileFunc = CreateFile
+	DetailFileFunc = DetailFile
+	OpenFileFunc   = OpenFile
+	DeleteFileFunc = DeleteFile
+)
+
+// sniffLen the number of leading bytes used to detect the MIME type, see http.DetectContentType
+const sniffLen = 512
+
+// BlobKey the storage key of content, e.g. 'blobs/b9/b94d27b9...'
+func BlobKey(sha256 string) string {
+	return path.Join("blobs", sha256[:2], sha256)
+}
+
+// CreateFile record the metadata of upload, the content is stored only if there is no blob with the same digest.
+// The MIME type is detected from the content rather than trusting the client, and it must be allowed by FileLimits.
+// Images are stripped of metadata before addressed by digest, and the thumbnails are generated when the blob is stored.
+// The size is charged to the usage of owner (see FileUsage) together with saving the metadata.
+func CreateFile(upload *FileUpload, s *sessions.Session) (*File, error) {
+	name := fileName(upload.Name)
+	if name == "" {
+		return nil, &fail.ErrBadParam{Param: "name", InvalidValue: upload.Name}
+	}
+
+	// the content is read twice, to address it by digest before storing
+	content, release, err := seekable(upload.Content)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	mimeType := detectMimeType(head[:n])
+	if !FileLimits.Allowed(mimeType) {
+		return nil, &ErrFileTypeNotAllowed{MimeType: mimeType}
+	}
+	total, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if FileLimits.MaxSize > 0 && total > FileLimits.MaxSize {
+		return nil, &ErrFileTooLarge{MaxSize: FileLimits.MaxSize}
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var img *uploadedImage
+	if imaging.Supported(mimeType) {
+		if img, err = sanitizeImage(content); err != nil {
+			return nil, err
+		}
+		content = bytes.NewReader(img.data)
+	}
+
+	digest := sha256.New()
+	size, err := io.Copy(digest, content)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(digest.Sum(nil))
+	file := File{
+		ID:         idgen.NextID(idWorker),
+		Owner:      s.Identity.ID,
+		Name:       name,
+		Size:       size,
+		MimeType:   mimeType,
+		SHA256:     sum,
+		StorageKey: BlobKey(sum),
+		CreateTime: types.CurrentTimestamp(),
+	}
+	if img != nil {
+		file.Width, file.Height = img.info.Width, img.info.Height
+	}
+	if err := checkFileQuota(file.Owner, file.Size, s); err != nil {
+		return nil, err
+	}
+	stored, err := storeBlob(&file, content, s)
+	if err != nil {
+		return nil, err
+	}
+	if stored && img != nil {
+		generateThumbnails(&file, img, s)
+	}
+
+	// the blob is left to the garbage collector if the metadata is not saved, it may be shared already
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		if err := chargeFileUsage(tx, file.Owner, file.Size); err != nil {
+			return err
+		}
+		return tx.Create(&file).Error
+	}); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// storeBlob touch the blob of file if it exists, otherwise store the content and record the blob, it returns
+// true if the content is stored.
+// The touch keeps the blob from being collected meanwhile; it affects no row if the blob is collected just now,
+// or it is touched within the same second (MySQL does not count unchanged rows), then the content is stored
+// again with the same key, which is harmless.
+func storeBlob(file *File, content io.Reader, s *sessions.Session) (bool, error) {
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	now := types.CurrentTimestamp()
+	touched := db.Model(&Blob{}).Where("sha256 = ?", file.SHA256).Update("touch_time", now)
+	if touched.Error != nil {
+		return false, touched.Error
+	}
+	if touched.RowsAffected > 0 {
+		return false, nil
+	}
+
+	if err := blobstore.Active.Put(s.Context, file.StorageKey, content, file.Size, file.MimeType); err != nil {
+		return false, err
+	}
+	blob := Blob{SHA256: file.SHA256, Size: file.Size, StorageKey: file.StorageKey, CreateTime: now, TouchTime: now}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// seekable spool the content into a temporary file unless it is seekable already, e.g. a multipart.File
+func seekable(r io.Reader) (io.ReadSeeker, func(), error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, func() {}, nil
+	}
+	tmp, err := ioutil.TempFile("", "upload-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		release()
+		return nil, nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return tmp, release, nil
+}
+
+func DetailFile(id types.ID, s *sessions.Session) (*File, error) {
+	var file File
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Where("id = ?", id).First(&file).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// OpenFile the content of file, the caller should close it. If the file is an image larger than the box of
+// q.Width * q.Height (0 means unbounded), a variant fitting in the box is opened instead. The box is rounded
+// up to the configured sizes (see imaging.Options.Snap), so the number of cached variants is bounded.
+// Private files (see isPrivateBlob) require q.Token unless the session is the owner or an administrator.
+func OpenFile(id types.ID, q FileContentQuery, s *sessions.Session) (*FileContent, error) {
+	file, err := DetailFile(id, s)
+	if err != nil {
+		return nil, err
+	}
+	private, err := authorizeFile(file, q.Token, s)
+	if err != nil {
+		return nil, err
+	}
+
+	var content *FileContent
+	width, height := imaging.Active.Snap(q.Width), imaging.Active.Snap(q.Height)
+	if (width > 0 || height > 0) && file.Width > 0 && file.Height > 0 && imaging.Supported(file.MimeType) {
+		if w, h := imaging.Fit(file.Width, file.Height, width, height); w < file.Width || h < file.Height {
+			if content, err = openVariant(file, width, height, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if content == nil {
+		r, err := blobstore.Active.Get(s.Context, file.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		content = &FileContent{ReadCloser: r, File: file, MimeType: file.MimeType, Size: file.Size}
+	}
+	content.Private = private
+	return content, nil
+}
+
+// DeleteFile remove the file owned by the user in session, administrators are able to remove any file.
+// The size is released from the usage of owner. The blob is kept, it may be shared by other files or referenced, the garbage collector takes care of it.
+func DeleteFile(id types.ID, s *sessions.Session) error {
+	return persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var file File
+		if err := tx.Where("id = ?", id).First(&file).Error; err != nil {
+			return err
+		}
+		if file.Owner != s.Identity.ID && !s.Perms.HasRole(authority.RoleAdmin) {
+			return fail.ErrForbidden
+		}
+		if err := tx.Where("id = ?", id).Delete(&File{}).Error; err != nil {
+			return err
+		}
+		return releaseFileUsage(tx, file.Owner, 1, file.Size)
+	})
+}
+
+// fileName the base name of the client side path, e.g. 'C:\docs\a.png' => 'a.png'
+func fileName(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+// detectMimeType sniff the content, the extension is never trusted since it is chosen by the client
+func detectMimeType(head []byte) string {
+	return http.DetectContentType(head)
 }
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"context"
+	"os"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	EnvFileGCInterval = "FILE_GC_INTERVAL" // e.g. '30m', 'off' disables the periodic collection
+	EnvFileGCGrace    = "FILE_GC_GRACE"    // e.g. '72h'
+
+	DefaultFileGCInterval = time.Hour
+	// DefaultFileGCGrace the time an orphan blob is kept since it is touched, e.g. it is uploaded but the file
+	// is not saved yet
+	DefaultFileGCGrace = 24 * time.Hour
+
+	// fileGCBatchSize the number of blobs collected in each round
+	fileGCBatchSize = 100
+)
+
+// orphanBlob the condition of the blobs to collect, they are neither referenced nor kept by any file.
+// The files are kept until their owners delete them, even if they are never referenced.
+const orphanBlob = "ref_count <= 0 AND touch_time < ? AND NOT EXISTS (SELECT 1 FROM `file` WHERE `file`.sha256 = `file_blob`.sha256)"
+
+// FileGCReport the result of a garbage collection
+type FileGCReport struct {
+	Blobs          int   `json:"blobs"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+var (
+	// FileGCGrace the grace period used by the periodic and the manual collections, see FileGCConfigFromEnv
+	FileGCGrace = DefaultFileGCGrace
+
+	CollectFileGarbageFunc = CollectFileGarbage
+
+	FileGCReclaimedBlobs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "owlet",
+		Subsystem: "files",
+		Name:      "gc_reclaimed_blobs_total",
+		Help:      "Total number of blobs deleted by garbage collection.",
+	})
+	FileGCReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "owlet",
+		Subsystem: "files",
+		Name:      "gc_reclaimed_bytes_total",
+		Help:      "Total size of blobs deleted by garbage collection.",
+	})
+)
+
+// FileGCConfigFromEnv the interval and the grace period of garbage collection, the interval is 0 if it is disabled
+func FileGCConfigFromEnv() (interval, grace time.Duration) {
+	return durationFromEnv(EnvFileGCInterval, DefaultFileGCInterval), durationFromEnv(EnvFileGCGrace, DefaultFileGCGrace)
+}
+
+func durationFromEnv(env string, def time.Duration) time.Duration {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	if value == "off" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		logrus.Warnf("%s: invalid duration '%s', the default %v is used", env, value, def)
+		return def
+	}
+	return d
+}
+
+// CollectFileGarbage delete the blobs which are orphans for longer than grace (see orphanBlob), together with
+// the image variants of them.
+// Each blob is deleted by a conditional statement, so it is safe to run on several instances at the same time,
+// and a blob referenced or uploaded again meanwhile is kept.
+func CollectFileGarbage(grace time.Duration, s *sessions.Session) (*FileGCReport, error) {
+	report := FileGCReport{}
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	cutoff := types.Timestamp(time.Now().Add(-grace))
+
+	for {
+		var blobs []Blob
+		if err := db.Where(orphanBlob, cutoff).Order("touch_time").Limit(fileGCBatchSize).Find(&blobs).Error; err != nil {
+			return &report, err
+		}
+
+		collected := 0
+		for _, blob := range blobs {
+			result := db.Where("sha256 = ? AND "+orphanBlob, blob.SHA256, cutoff).Delete(&Blob{})
+			if result.Error != nil {
+				return &report, result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			collected++
+
+			// the content is unreachable once the record is deleted, a failure only leaks the storage
+			if err := blobstore.Active.Delete(s.Context, blob.StorageKey); err != nil {
+				s.Logger().Warnf("failed to delete blob %s: %v", blob.StorageKey, err)
+			}
+			deleteVariants(blob.SHA256, s)
+			report.Blobs++
+			report.ReclaimedBytes += blob.Size
+			FileGCReclaimedBlobs.Inc()
+			FileGCReclaimedBytes.Add(float64(blob.Size))
+		}
+
+		if len(blobs) < fileGCBatchSize || collected == 0 {
+			return &report, nil
+		}
+	}
+}
+
+// RunFileGC collect garbage with FileGCGrace every interval until ctx is done
+func RunFileGC(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		logrus.Infoln("file garbage collection is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := CollectFileGarbageFunc(FileGCGrace, &sessions.Session{Context: ctx})
+			if err != nil {
+				logrus.Warnf("file garbage collection: %v", err)
+			}
+			if report != nil && report.Blobs > 0 {
+				logrus.Infof("file garbage collection: %d blobs deleted, %d bytes reclaimed",
+					report.Blobs, report.ReclaimedBytes)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+var blobColumns = []string{"sha256", "size", "storage_key", "ref_count", "create_time", "touch_time"}
+
+func TestFileGCConfigFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer os.Unsetenv(EnvFileGCInterval)
+	defer os.Unsetenv(EnvFileGCGrace)
+
+	interval, grace := FileGCConfigFromEnv()
+	Expect(interval).To(Equal(DefaultFileGCInterval))
+	Expect(grace).To(Equal(DefaultFileGCGrace))
+
+	os.Setenv(EnvFileGCInterval, "off")
+	os.Setenv(EnvFileGCGrace, "72h")
+	interval, grace = FileGCConfigFromEnv()
+	Expect(interval).To(BeZero())
+	Expect(grace).To(Equal(72 * time.Hour))
+
+	os.Setenv(EnvFileGCInterval, "often")
+	interval, _ = FileGCConfigFromEnv()
+	Expect(interval).To(Equal(DefaultFileGCInterval))
+}
+
+func TestCollectFileGarbage(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO()}
+	shaA, shaB := helloSHA256, "aa"+helloSHA256[2:]
+
+	t.Run("should delete orphan blobs and keep the files", func(t *testing.T) {
+		Expect(store.Put(context.TODO(), BlobKey(shaA), strings.NewReader("hello world"), 11, "")).ToNot(HaveOccurred())
+		Expect(store.Put(context.TODO(), VariantKey(shaA, 160, 0), strings.NewReader("a"), 1, "")).ToNot(HaveOccurred())
+		Expect(store.Put(context.TODO(), VariantKey(shaA, 320, 320), strings.NewReader("a"), 1, "")).ToNot(HaveOccurred())
+		Expect(store.Put(context.TODO(), BlobKey(shaB), strings.NewReader("touched"), 7, "")).ToNot(HaveOccurred())
+		Expect(store.Put(context.TODO(), VariantKey(shaB, 160, 0), strings.NewReader("b"), 1, "")).ToNot(HaveOccurred())
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_blob` WHERE ref_count <= 0 AND touch_time < ? AND " +
+			"NOT EXISTS (SELECT 1 FROM `file` WHERE `file`.sha256 = `file_blob`.sha256) ORDER BY touch_time LIMIT 100")).
+			WithArgs(testinfra.AnyArgument{}).
+			WillReturnRows(sqlmock.NewRows(blobColumns).
+				AddRow(shaA, 11, BlobKey(shaA), 0, nil, nil).AddRow(shaB, 7, BlobKey(shaB), 0, nil, nil))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file_blob` WHERE sha256 = ? AND ref_count <= 0 AND touch_time < ? AND "+
+			"NOT EXISTS (SELECT 1 FROM `file` WHERE `file`.sha256 = `file_blob`.sha256)")).
+			WithArgs(shaA, testinfra.AnyArgument{}).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		// uploaded again meanwhile
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file_blob` WHERE sha256 = ? AND ref_count <= 0")).
+			WithArgs(shaB, testinfra.AnyArgument{}).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		report, err := CollectFileGarbage(time.Hour, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*report).To(Equal(FileGCReport{Blobs: 1, ReclaimedBytes: 11}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		_, err = store.Get(context.TODO(), BlobKey(shaA))
+		Expect(err).To(Equal(blobstore.ErrBlobNotFound))
+		Expect(store.List(context.TODO(), variantPrefix(shaA))).To(BeEmpty())
+		r, err := store.Get(context.TODO(), BlobKey(shaB))
+		Expect(err).ToNot(HaveOccurred())
+		r.Close()
+		Expect(store.List(context.TODO(), variantPrefix(shaB))).To(Equal([]string{VariantKey(shaB, 160, 0)}))
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_blob`")).WillReturnError(errors.New("some error"))
+
+		_, err := CollectFileGarbage(time.Hour, s)
+		Expect(err).To(MatchError("some error"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"mime"
+	"net/http"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/misc"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	PathFiles      = "/v1/files"
+	PathAdminFiles = "/v1/admin/files"
+)
+
+// multipartOverhead the room left for the boundaries and headers of the multipart request body
+const multipartOverhead = 64 << 10
+
+// RegisterFilesRestAPI uploading and deleting require login. Public files are readable by anyone knows the id,
+// private files (see isPrivateBlob) are readable by the owners and administrators, or by others with signed links.
+func RegisterFilesRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathFiles, middleWares...)
+	g.POST("", sessions.SessionFilter(), handleCreateFile)
+	g.GET(":id", handleDetailFile)
+	g.GET(":id/content", sessions.OptionalSessionFilter(), handleDownloadFile)
+	g.DELETE(":id", sessions.SessionFilter(), handleDeleteFile)
+}
+
+// RegisterFilesAdminRestAPI the middleWares should restrict the access to administrators
+func RegisterFilesAdminRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathAdminFiles, middleWares...)
+	g.POST("gc", handleCollectFileGarbage)
+}
+
+// @ID file-create
+// @Accept multipart/form-data
+// @Param file formData file true "the file to upload"
+// @Success 201 {object} domain.File
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/files [post]
+func handleCreateFile(c *gin.Context) {
+	if maxSize := FileLimits.MaxSize; maxSize > 0 {
+		if c.Request.ContentLength > maxSize+multipartOverhead {
+			panic(&ErrFileTooLarge{MaxSize: maxSize})
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		// http.MaxBytesError is not available before go 1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			panic(&ErrFileTooLarge{MaxSize: FileLimits.MaxSize})
+		}
+		panic(&fail.ErrBadParam{Param: "file", Cause: err})
+	}
+	content, err := header.Open()
+	if err != nil {
+		panic(err)
+	}
+	defer content.Close()
+
+	file, err := CreateFileFunc(&FileUpload{Name: header.Filename, Size: header.Size, Content: content},
+		sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusCreated, file)
+}
+
+// @ID file-detail
+// @Param id path uint64 true "id"
+// @Success 200 {object} domain.File
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/files/{id} [get]
+func handleDetailFile(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	file, err := DetailFileFunc(id, &sessions.Session{Context: c.Request.Context()})
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+// FileContentQuery the box the image should fit in (0 means unbounded), and the token of signed link
+type FileContentQuery struct {
+	Width  int    `form:"w" binding:"gte=0"`
+	Height int    `form:"h" binding:"gte=0"`
+	Token  string `form:"token"`
+}
+
+// @ID file-content
+// @Produce octet-stream
+// @Param id path uint64 true "id"
+// @Param w query int false "the max width of image"
+// @Param h query int false "the max height of image"
+// @Param token query string false "the token of signed link, it is required by private files"
+// @Success 200 {file} binary
+// @Success 304
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/files/{id}/content [get]
+func handleDownloadFile(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	q := FileContentQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	content, err := OpenFileFunc(id, q, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	defer content.Close()
+	file := content.File
+
+	// the content of a file never changes, the digest and the box of variant are a strong validator
+	etag := `"` + file.SHA256 + `"`
+	if content.Variant != "" {
+		etag = `"` + file.SHA256 + "-" + content.Variant + `"`
+	}
+	c.Header("ETag", etag)
+	if content.Private {
+		c.Header("Cache-Control", "private, no-cache")
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// the uploaded content is untrusted, it must not be executed as a page of this site
+	c.DataFromReader(http.StatusOK, content.Size, content.MimeType, content, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType("inline", map[string]string{"filename": file.Name}),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "default-src 'none'; sandbox",
+	})
+}
+
+// @ID file-delete
+// @Param id path uint64 true "id"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/files/{id} [delete]
+func handleDeleteFile(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	if err := DeleteFileFunc(id, sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID file-gc
+// @Success 200 {object} domain.FileGCReport
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/files/gc [post]
+func handleCollectFileGarbage(c *gin.Context) {
+	report, err := CollectFileGarbageFunc(FileGCGrace, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, report)
+}
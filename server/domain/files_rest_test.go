@@ -0,0 +1,251 @@
+package domain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+func multipartFile(field, name, content string) (*bytes.Buffer, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	part, _ := w.CreateFormFile(field, name)
+	part.Write([]byte(content))
+	w.Close()
+	return buf, w.FormDataContentType()
+}
+
+func TestFilesAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterFilesRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+	helloFile := &File{ID: 1, Owner: 100, Name: "你好.txt", Size: 11, MimeType: "text/plain; charset=utf-8", SHA256: helloSHA256}
+
+	t.Run("should require login to upload", func(t *testing.T) {
+		body, contentType := multipartFile("file", "hello.txt", "hello world")
+		req := httptest.NewRequest(http.MethodPost, PathFiles, body)
+		req.Header.Set("Content-Type", contentType)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	t.Run("should be able to upload file", func(t *testing.T) {
+		var in *FileUpload
+		var content string
+		var session *sessions.Session
+		CreateFileFunc = func(upload *FileUpload, s *sessions.Session) (*File, error) {
+			in, session = upload, s
+			b, _ := ioutil.ReadAll(upload.Content)
+			content = string(b)
+			return helloFile, nil
+		}
+
+		body, contentType := multipartFile("file", "hello.txt", "hello world")
+		req := httptest.NewRequest(http.MethodPost, PathFiles, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, resBody, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusCreated))
+		Expect(resBody).To(MatchJSON(`{"id": "1", "owner": "100", "name": "你好.txt", "size": 11,
+			"mime_type": "text/plain; charset=utf-8", "sha256": "` + helloSHA256 + `", "create_time": null}`))
+		Expect(in.Name).To(Equal("hello.txt"))
+		Expect(in.Size).To(Equal(int64(11)))
+		Expect(content).To(Equal("hello world"))
+		Expect(session.Identity.ID).To(Equal(types.ID(100)))
+	})
+
+	t.Run("should reject request without file", func(t *testing.T) {
+		body, contentType := multipartFile("other", "hello.txt", "hello world")
+		req := httptest.NewRequest(http.MethodPost, PathFiles, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
+	t.Run("should reject too large request", func(t *testing.T) {
+		origin := FileLimits
+		FileLimits = FileLimitOptions{MaxSize: 100}
+		defer func() { FileLimits = origin }()
+
+		body, contentType := multipartFile("file", "hello.txt", strings.Repeat("x", multipartOverhead+200))
+		req := httptest.NewRequest(http.MethodPost, PathFiles, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, resBody, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(resBody).To(ContainSubstring(`"code":"file.too_large"`))
+
+		// the length is unknown until the body is read
+		body, contentType = multipartFile("file", "hello.txt", strings.Repeat("x", multipartOverhead+200))
+		req = httptest.NewRequest(http.MethodPost, PathFiles, body)
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	t.Run("should be able to get metadata of file", func(t *testing.T) {
+		DetailFileFunc = func(id types.ID, s *sessions.Session) (*File, error) {
+			if id != 1 {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return helloFile, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathFiles+"/1", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"sha256":"` + helloSHA256 + `"`))
+
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/2", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	t.Run("should serve content of file", func(t *testing.T) {
+		OpenFileFunc = func(id types.ID, q FileContentQuery, s *sessions.Session) (*FileContent, error) {
+			return &FileContent{ReadCloser: ioutil.NopCloser(strings.NewReader("hello world")), File: helloFile,
+				MimeType: helloFile.MimeType, Size: helloFile.Size}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathFiles+"/1/content", nil)
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("hello world"))
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+		Expect(res.Header.Get("Content-Length")).To(Equal("11"))
+		Expect(res.Header.Get("ETag")).To(Equal(`"` + helloSHA256 + `"`))
+		Expect(res.Header.Get("Content-Disposition")).To(Equal("inline; filename*=utf-8''%E4%BD%A0%E5%A5%BD.txt"))
+		Expect(res.Header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(res.Header.Get("Content-Security-Policy")).To(ContainSubstring("sandbox"))
+
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/1/content", nil)
+		req.Header.Set("If-None-Match", `"`+helloSHA256+`"`)
+		status, body, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotModified))
+		Expect(body).To(BeEmpty())
+	})
+
+	t.Run("should serve variant of image", func(t *testing.T) {
+		var in FileContentQuery
+		OpenFileFunc = func(id types.ID, q FileContentQuery, s *sessions.Session) (*FileContent, error) {
+			in = q
+			return &FileContent{ReadCloser: ioutil.NopCloser(strings.NewReader("thumbnail")), File: helloFile,
+				Variant: "320x0", MimeType: "image/jpeg", Size: -1}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathFiles+"/1/content?w=300", nil)
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("thumbnail"))
+		Expect(in).To(Equal(FileContentQuery{Width: 300}))
+		Expect(res.Header.Get("Content-Type")).To(Equal("image/jpeg"))
+		Expect(res.Header.Get("ETag")).To(Equal(`"` + helloSHA256 + `-320x0"`))
+		Expect(res.Header.Get("Cache-Control")).To(Equal("no-cache"))
+
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/1/content?w=300", nil)
+		req.Header.Set("If-None-Match", `"`+helloSHA256+`"`)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/1/content?w=-1", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/1/content?h=abc", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
+	t.Run("should pass token and session to private file", func(t *testing.T) {
+		var in FileContentQuery
+		var inUser types.ID
+		OpenFileFunc = func(id types.ID, q FileContentQuery, s *sessions.Session) (*FileContent, error) {
+			in, inUser = q, s.Identity.ID
+			return &FileContent{ReadCloser: ioutil.NopCloser(strings.NewReader("draft")), File: helloFile,
+				MimeType: helloFile.MimeType, Size: 5, Private: true}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathFiles+"/1/content?token=abc", nil)
+		status, _, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(in.Token).To(Equal("abc"))
+		Expect(inUser).To(BeZero())
+		Expect(res.Header.Get("Cache-Control")).To(Equal("private, no-cache"))
+
+		req = httptest.NewRequest(http.MethodGet, PathFiles+"/1/content", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(inUser).ToNot(BeZero())
+	})
+
+	t.Run("should be able to delete file", func(t *testing.T) {
+		var in types.ID
+		DeleteFileFunc = func(id types.ID, s *sessions.Session) error {
+			in = id
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, PathFiles+"/1", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		req = httptest.NewRequest(http.MethodDelete, PathFiles+"/1", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(in).To(Equal(types.ID(1)))
+
+		DeleteFileFunc = func(id types.ID, s *sessions.Session) error {
+			return fail.ErrForbidden
+		}
+		req = httptest.NewRequest(http.MethodDelete, PathFiles+"/1", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+}
+
+func TestFilesAdminAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterFilesAdminRestAPI(router, sessions.SessionFilter())
+
+	sessions.TokenCache.Add("admin-token", &sessions.Session{Token: "admin-token", Identity: sessions.Identity{ID: 1}}, time.Minute)
+
+	t.Run("should be able to collect garbage with configured grace period", func(t *testing.T) {
+		var in time.Duration
+		CollectFileGarbageFunc = func(grace time.Duration, s *sessions.Session) (*FileGCReport, error) {
+			in = grace
+			return &FileGCReport{Blobs: 2, ReclaimedBytes: 1024}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathAdminFiles+"/gc", nil)
+		req.Header.Add("cookie", "sec_token=admin-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"blobs": 2, "reclaimed_bytes": 1024}`))
+		Expect(in).To(Equal(FileGCGrace))
+	})
+}
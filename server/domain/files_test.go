@@ -0,0 +1,238 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/blobstore"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+)
+
+var fileColumns = []string{"id", "owner", "name", "size", "mime_type", "sha256", "storage_key", "create_time"}
+
+// helloSHA256 the SHA-256 digest of 'hello world'
+const helloSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+func useLocalBlobStore(t *testing.T) (*blobstore.LocalStore, func()) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := blobstore.Active
+	store := blobstore.NewLocalStore(dir)
+	blobstore.Active = store
+	return store, func() {
+		blobstore.Active = origin
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+
+	t.Run("should store content by digest and record metadata", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `touch_time`=? WHERE sha256 = ?")).
+			WithArgs(testinfra.AnyArgument{}, helloSHA256).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_blob` (`sha256`,`size`,`storage_key`,`ref_count`,`create_time`,`touch_time`) "+
+			"VALUES (?,?,?,?,?,?) ON DUPLICATE KEY UPDATE `sha256`=`sha256`")).
+			WithArgs(helloSHA256, 11, BlobKey(helloSHA256), 0, testinfra.AnyArgument{}, testinfra.AnyArgument{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		expectChargeFileUsage(mock, 100, 11)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file` (`owner`,`name`,`size`,`mime_type`,`sha256`,`storage_key`,"+
+			"`width`,`height`,`create_time`,`id`) VALUES (?,?,?,?,?,?,?,?,?,?)")).
+			WithArgs(100, "hello.txt", 11, "text/plain; charset=utf-8", helloSHA256, BlobKey(helloSHA256),
+				0, 0, testinfra.AnyArgument{}, testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		// not seekable, it is spooled at first
+		content := io.MultiReader(strings.NewReader("hello "), strings.NewReader("world"))
+		file, err := CreateFile(&FileUpload{Name: `C:\docs\hello.txt`, Size: -1, Content: content}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.ID).ToNot(BeZero())
+		Expect(file.Owner).To(Equal(types.ID(100)))
+		Expect(file.Name).To(Equal("hello.txt"))
+		Expect(file.Size).To(Equal(int64(11)))
+		Expect(file.SHA256).To(Equal(helloSHA256))
+		Expect(file.StorageKey).To(Equal("blobs/b9/" + helloSHA256))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		r, err := store.Get(context.TODO(), file.StorageKey)
+		Expect(err).ToNot(HaveOccurred())
+		stored, _ := ioutil.ReadAll(r)
+		r.Close()
+		Expect(string(stored)).To(Equal("hello world"))
+	})
+
+	t.Run("should share the blob with the same content", func(t *testing.T) {
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte("shared")))
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `touch_time`=? WHERE sha256 = ?")).
+			WithArgs(testinfra.AnyArgument{}, sum).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		expectChargeFileUsage(mock, 100, 6)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file`")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		file, err := CreateFile(&FileUpload{Name: "shared.txt", Size: 6, Content: strings.NewReader("shared")}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.StorageKey).To(Equal(BlobKey(sum)))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		_, err = store.Get(context.TODO(), file.StorageKey)
+		Expect(err).To(Equal(blobstore.ErrBlobNotFound))
+	})
+
+	t.Run("should detect MIME type from content rather than name", func(t *testing.T) {
+		png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 600)
+		Expect(detectMimeType([]byte(png))).To(Equal("image/png"))
+		Expect(detectMimeType([]byte{0x00, 0x01, 0x02})).To(Equal("application/octet-stream"))
+
+		_, mock := testinfra.SetUpMockSql()
+		_, err := CreateFile(&FileUpload{Name: "a.pdf", Size: 3, Content: strings.NewReader("\x00\x01\x02")}, s)
+		Expect(err).To(Equal(&ErrFileTypeNotAllowed{MimeType: "application/octet-stream"}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error if metadata is not saved", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		expectFileUsage(mock, 100, 0)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob`")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		expectChargeFileUsage(mock, 100, 11)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file`")).WillReturnError(errors.New("some error"))
+		mock.ExpectRollback()
+
+		_, err := CreateFile(&FileUpload{Name: "hello.txt", Size: 11, Content: strings.NewReader("hello world")}, s)
+		Expect(err).To(MatchError("some error"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject empty name", func(t *testing.T) {
+		_, err := CreateFile(&FileUpload{Name: " ", Size: 1, Content: strings.NewReader("x")}, s)
+		Expect(err).To(BeAssignableToTypeOf(&fail.ErrBadParam{}))
+	})
+}
+
+func TestOpenFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO()}
+	Expect(store.Put(context.TODO(), "files/1", strings.NewReader("hello world"), 11, "")).ToNot(HaveOccurred())
+
+	t.Run("should open content of file", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "hello.txt", 11, "text/plain", helloSHA256, "files/1", nil))
+		expectPublicBlob(mock, helloSHA256)
+
+		r, err := OpenFile(1, FileContentQuery{Width: 160}, s)
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+		Expect(r.File.Name).To(Equal("hello.txt"))
+		Expect(r.Variant).To(BeEmpty())
+		Expect(r.Size).To(Equal(int64(11)))
+		content, _ := ioutil.ReadAll(r)
+		Expect(string(content)).To(Equal("hello world"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should be not found if content is absent", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(2, 100, "lost.txt", 1, "text/plain", "", "files/2", nil))
+		expectPublicBlob(mock, "")
+
+		_, err := OpenFile(2, FileContentQuery{}, s)
+		Expect(errors.Is(err, fail.ErrNotFound)).To(BeTrue())
+	})
+}
+
+func TestDeleteFile(t *testing.T) {
+	RegisterTestingT(t)
+
+	store, restore := useLocalBlobStore(t)
+	defer restore()
+
+	t.Run("should delete metadata of own file and keep the blob", func(t *testing.T) {
+		Expect(store.Put(context.TODO(), "files/1", strings.NewReader("x"), 1, "")).ToNot(HaveOccurred())
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "a.txt", 1, "text/plain", "", "files/1", nil))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file` WHERE id = ?")).WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectReleaseFileUsage(mock, 100, 1, 1)
+		mock.ExpectCommit()
+
+		err := DeleteFile(1, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		r, err := store.Get(context.TODO(), "files/1")
+		Expect(err).ToNot(HaveOccurred())
+		r.Close()
+	})
+
+	t.Run("should forbid deleting files of others unless administrator", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "a.txt", 1, "text/plain", "", "files/1", nil))
+		mock.ExpectRollback()
+
+		err := DeleteFile(1, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200}})
+		Expect(err).To(Equal(fail.ErrForbidden))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		_, mock = testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file` WHERE id = ? ORDER BY `file`.`id` LIMIT 1")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(fileColumns).AddRow(1, 100, "a.txt", 1, "text/plain", "", "files/1", nil))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `file` WHERE id = ?")).WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectReleaseFileUsage(mock, 100, 1, 1)
+		mock.ExpectCommit()
+
+		err = DeleteFile(1, &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200},
+			Perms: authority.Permissions{authority.RoleAdmin}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"context"
+	"math"
+	"owlet/server/infra/persistence"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// the results of login counted by LoginsTotal, the login waiting for the second factor is counted after verified
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failure"
+)
+
+// LoginsTotal counted by the login result
+var LoginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "owlet",
+	Subsystem: "users",
+	Name:      "logins_total",
+	Help:      "Total number of login attempts.",
+}, []string{"result"})
+
+// MetricCollectors business metrics contributed by domain
+var MetricCollectors = []prometheus.Collector{
+	LoginsTotal,
+	FileGCReclaimedBlobs,
+	FileGCReclaimedBytes,
+	prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "owlet",
+		Subsystem: "articles",
+		Name:      "published",
+		Help:      "Number of published articles.",
+	}, countPublishedArticles),
+}
+
+func countPublishedArticles() float64 {
+	if persistence.ActiveGormDB == nil {
+		return math.NaN()
+	}
+	var count int64
+	err := persistence.ActiveGormDB.WithContext(context.Background()).Model(&ArticleRecord{}).
+		Where("status = ? AND is_invalid = 0", ArticleStatusPublished).Count(&count).Error
+	if err != nil {
+		logrus.Warnln("failed to count published articles:", err)
+		return math.NaN()
+	}
+	return float64(count)
+}
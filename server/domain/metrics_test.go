@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"owlet/server/infra/persistence"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/gomega"
+)
+
+func TestCountPublishedArticles(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should count published and valid articles", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `article` WHERE status = ? AND is_invalid = 0")).
+			WithArgs(ArticleStatusPublished).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		Expect(countPublishedArticles()).To(Equal(float64(3)))
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+
+	t.Run("should be NaN when failed to count", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `article`")).WillReturnError(errors.New("some error"))
+		Expect(math.IsNaN(countPublishedArticles())).To(BeTrue())
+
+		persistence.ActiveGormDB = nil
+		Expect(math.IsNaN(countPublishedArticles())).To(BeTrue())
+	})
+}
@@ -0,0 +1,252 @@
+package domain
+
+import (
+	"owlet/server/infra/persistence"
+
+	"gorm.io/gorm"
+)
+
+// Migrations versioned schema changes of domain tables, the version must be unique and increasing.
+var Migrations = []persistence.Migration{
+	{
+		// the user and user_identity tables are created by init/db/owlet.sql
+		Version: 1, Description: "add password column to user",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&User{}, "Password") {
+				return nil
+			}
+			return m.AddColumn(&User{}, "Password")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&User{}, "Password")
+		},
+	},
+	{
+		Version: 2, Description: "add locale column to user",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&User{}, "Locale") {
+				return nil
+			}
+			return m.AddColumn(&User{}, "Locale")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&User{}, "Locale")
+		},
+	},
+	{
+		Version: 3, Description: "add email_verified column to user",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&User{}, "EmailVerified") {
+				return nil
+			}
+			return m.AddColumn(&User{}, "EmailVerified")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&User{}, "EmailVerified")
+		},
+	},
+	{
+		Version: 4, Description: "add unique index of auth channel to user_identity",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasIndex(&UserIdentity{}, "uk_user_identity_channel") {
+				return nil
+			}
+			return m.CreateIndex(&UserIdentity{}, "uk_user_identity_channel")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropIndex(&UserIdentity{}, "uk_user_identity_channel")
+		},
+	},
+	{
+		Version: 5, Description: "add user_mfa and user_recovery_code tables",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasTable(&UserMFA{}) {
+				return nil
+			}
+			return m.CreateTable(&UserMFA{}, &UserRecoveryCode{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&UserMFA{}, &UserRecoveryCode{})
+		},
+	},
+	{
+		Version: 6, Description: "add file table",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasTable(&File{}) {
+				return nil
+			}
+			return m.CreateTable(&File{})
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&File{})
+		},
+	},
+	{
+		Version: 7, Description: "add blob and file_ref tables, files uploaded before share blobs by digest",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasTable(&Blob{}) {
+				return nil
+			}
+			if err := m.CreateTable(&Blob{}, &FileRef{}); err != nil {
+				return err
+			}
+			// the index is created with the file table already if the database is created freshly
+			if !m.HasIndex(&File{}, "idx_file_sha256") {
+				if err := m.CreateIndex(&File{}, "idx_file_sha256"); err != nil {
+					return err
+				}
+			}
+			if err := db.Exec("INSERT INTO `file_blob` (sha256, size, storage_key, ref_count, create_time, touch_time) " +
+				"SELECT sha256, MAX(size), MIN(storage_key), 0, MIN(create_time), NOW() FROM `file` GROUP BY sha256").Error; err != nil {
+				return err
+			}
+			return backfillFileRefs(db)
+		},
+		Down: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if err := m.DropIndex(&File{}, "idx_file_sha256"); err != nil {
+				return err
+			}
+			return m.DropTable(&Blob{}, &FileRef{})
+		},
+	},
+	{
+		Version: 8, Description: "add width and height columns to file",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&File{}, "Width") {
+				return nil
+			}
+			if err := m.AddColumn(&File{}, "Width"); err != nil {
+				return err
+			}
+			return m.AddColumn(&File{}, "Height")
+		},
+		Down: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if err := m.DropColumn(&File{}, "Height"); err != nil {
+				return err
+			}
+			return m.DropColumn(&File{}, "Width")
+		},
+	},
+	{
+		Version: 9, Description: "add file_usage table, the usages of files uploaded before are counted",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasTable(&FileUsage{}) {
+				return nil
+			}
+			if err := m.CreateTable(&FileUsage{}); err != nil {
+				return err
+			}
+			return db.Exec("INSERT INTO `file_usage` (owner, files, bytes, update_time) " +
+				"SELECT owner, COUNT(*), SUM(size), NOW() FROM `file` GROUP BY owner").Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&FileUsage{})
+		},
+	},
+	{
+		Version: 10, Description: "add toc and statistics columns to article, they are derived from the contents saved before",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&ArticleRecord{}, "TOC") {
+				return nil
+			}
+			for _, field := range articleStatsFields {
+				if err := m.AddColumn(&ArticleRecord{}, field); err != nil {
+					return err
+				}
+			}
+			return backfillArticleStats(db)
+		},
+		Down: func(db *gorm.DB) error {
+			m := db.Migrator()
+			for _, field := range articleStatsFields {
+				if err := m.DropColumn(&ArticleRecord{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 11, Description: "add abstracts_generated column to article, the existed abstracts are written by the authors",
+		Up: func(db *gorm.DB) error {
+			if db.Migrator().HasColumn(&ArticleRecord{}, "AbstractsGenerated") {
+				return nil
+			}
+			return db.Migrator().AddColumn(&ArticleRecord{}, "AbstractsGenerated")
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropColumn(&ArticleRecord{}, "AbstractsGenerated")
+		},
+	},
+	{
+		Version: 12, Description: "add article_link table, the links of articles saved before are recorded",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasTable(&ArticleLink{}) {
+				return nil
+			}
+			if err := m.CreateTable(&ArticleLink{}); err != nil {
+				return err
+			}
+			return backfillArticleLinks(db)
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropTable(&ArticleLink{})
+		},
+	},
+	{
+		Version: 13, Description: "add slug column to article and article_slug table, the slugs are generated from the titles",
+		Up: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if m.HasColumn(&ArticleRecord{}, "Slug") {
+				return nil
+			}
+			if err := m.AddColumn(&ArticleRecord{}, "Slug"); err != nil {
+				return err
+			}
+			if err := m.CreateTable(&ArticleSlug{}); err != nil {
+				return err
+			}
+			if err := backfillArticleSlugs(db); err != nil {
+				return err
+			}
+			return m.CreateIndex(&ArticleRecord{}, "uk_article_slug")
+		},
+		Down: func(db *gorm.DB) error {
+			m := db.Migrator()
+			if err := m.DropTable(&ArticleSlug{}); err != nil {
+				return err
+			}
+			if err := m.DropIndex(&ArticleRecord{}, "uk_article_slug"); err != nil {
+				return err
+			}
+			return m.DropColumn(&ArticleRecord{}, "Slug")
+		},
+	},
+	{
+		// the empty emails are not indexed, e.g. the users provisioned by the providers without email.
+		// The functional index requires MySQL 8.0.13 or later, see README.md
+		Version: 14, Description: "add unique index of email to user",
+		Up: func(db *gorm.DB) error {
+			if db.Migrator().HasIndex(&User{}, userEmailIndex) {
+				return nil
+			}
+			return db.Exec("CREATE UNIQUE INDEX `" + userEmailIndex + "` ON `user` ((NULLIF(`email`, '')))").Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Migrator().DropIndex(&User{}, userEmailIndex)
+		},
+	},
+}
@@ -1,35 +1,59 @@
 package domain
 
-import "github.com/fundwit/go-commons/types"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/idgen"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/sony/sonyflake"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
 
 type AuthChannel int
 
 const (
 	AuthChannelInternal = AuthChannel(0)
+	// AuthChannelOIDC the channel key is '<provider>:<subject>' (see OIDCChannelKey)
+	AuthChannelOIDC = AuthChannel(1)
+	// AuthChannelLDAP the channel key is the username in directory
+	AuthChannelLDAP = AuthChannel(2)
 )
 
 type User struct {
-	ID          types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
-	Username    string   `json:"username" gorm:"type:VARCHAR(255) NOT NULL"`
-	Email       string   `json:"email" gorm:"type:VARCHAR(255) NOT NULL"`
-	Salt        string   `json:"salt" gorm:"type:VARCHAR(255) NOT NULL"`
-	Avatar      string   `json:"avatar" gorm:"type:VARCHAR(255)"`
-	Theme       string   `json:"theme" gorm:"type:VARCHAR(255)"`
-	ThemeEditor string   `json:"theme_editor" gorm:"type:VARCHAR(255)"`
-	RealName    string   `json:"real_name" gorm:"type:VARCHAR(255)"`
-	PhoneNo     string   `json:"phone_no" gorm:"type:VARCHAR(255)"`
-	IsLocked    bool     `json:"islock" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	ID            types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	Username      string   `json:"username" gorm:"type:VARCHAR(255) NOT NULL"`
+	Email         string   `json:"email" gorm:"type:VARCHAR(255) NOT NULL"`
+	EmailVerified bool     `json:"email_verified" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	Salt          string   `json:"-" gorm:"type:VARCHAR(255) NOT NULL"`
+	Password      string   `json:"-" gorm:"type:VARCHAR(255) NULL"`
+	Avatar        string   `json:"avatar" gorm:"type:VARCHAR(255)"`
+	Theme         string   `json:"theme" gorm:"type:VARCHAR(255)"`
+	ThemeEditor   string   `json:"theme_editor" gorm:"type:VARCHAR(255)"`
+	RealName      string   `json:"real_name" gorm:"type:VARCHAR(255)"`
+	PhoneNo       string   `json:"phone_no" gorm:"type:VARCHAR(255)"`
+	Locale        string   `json:"locale" gorm:"type:VARCHAR(35)"`
+	IsLocked      bool     `json:"islock" gorm:"column:islock;type:TINYINT NOT NULL DEFAULT '0'"`
 
 	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
-	ModifyTime types.Timestamp `json:"modify_time" gorm:"type:DATETIME NOT NULL"`
+	ModifyTime types.Timestamp `json:"modify_time" gorm:"column:update_time;type:DATETIME NOT NULL"`
 }
 
+// userEmailIndex the emails of users are unique except the empty ones, see migration 14
+const userEmailIndex = "uk_user_email"
+
 type UserIdentity struct {
 	ID types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
 
 	User        types.ID    `json:"user" gorm:"type:BIGINT UNSIGNED NOT NULL"`
-	AuthChannel AuthChannel `json:"auth_channel" gorm:"type:INT UNSIGNED NOT NULL"`
-	ChannelKey  string      `json:"channel_key" gorm:"type:VARCHAR(255) NOT NULL"`
+	AuthChannel AuthChannel `json:"auth_channel" gorm:"type:INT UNSIGNED NOT NULL;uniqueIndex:uk_user_identity_channel"`
+	ChannelKey  string      `json:"channel_key" gorm:"type:VARCHAR(255) NOT NULL;uniqueIndex:uk_user_identity_channel"`
 }
 
 func (r *User) TableName() string {
@@ -39,3 +63,165 @@ func (r *User) TableName() string {
 func (r *UserIdentity) TableName() string {
 	return "user_identity"
 }
+
+type UserCreate struct {
+	Username string `json:"username" binding:"required,lte=255"`
+	Email    string `json:"email" binding:"required,email,lte=255"`
+	Password string `json:"password" binding:"required,gte=6,lte=64"`
+	RealName string `json:"real_name" binding:"omitempty,lte=255"`
+}
+
+var (
+	ErrUsernameConflict = &fail.ErrConflict{Field: "username"}
+	ErrEmailConflict    = &fail.ErrConflict{Field: "email"}
+
+	CreateUserFunc    = CreateUser
+	LockUserFunc      = LockUser
+	LockUserByIDFunc  = LockUserByID
+	ResetPasswordFunc = ResetPassword
+
+	idWorker = sonyflake.NewSonyflake(sonyflake.Settings{})
+)
+
+// CreateUser create a user which can login through the internal auth channel.
+func CreateUser(c *UserCreate, s *sessions.Session) (*User, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := hashPassword(c.Password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	now := types.CurrentTimestamp()
+	user := User{
+		ID:         idgen.NextID(idWorker),
+		Username:   c.Username,
+		Email:      c.Email,
+		RealName:   c.RealName,
+		Salt:       salt,
+		Password:   hashed,
+		CreateTime: now,
+		ModifyTime: now,
+	}
+
+	err = persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Where("username = ?", c.Username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsernameConflict
+		}
+		if err := checkEmailConflict(tx, c.Email, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		identity := UserIdentity{ID: idgen.NextID(idWorker), User: user.ID,
+			AuthChannel: AuthChannelInternal, ChannelKey: user.Username}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		return nil, emailConflictOf(err)
+	}
+	return &user, nil
+}
+
+// checkEmailConflict the email must not be used by other users (except the user with id 'self')
+func checkEmailConflict(tx *gorm.DB, email string, self types.ID) error {
+	var count int64
+	if err := tx.Model(&User{}).Where("email = ? AND id <> ?", email, self).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailConflict
+	}
+	return nil
+}
+
+// emailConflictOf the concurrent writes of the same email pass checkEmailConflict, but the latter one is rejected
+// by the unique index uk_user_email
+func emailConflictOf(err error) error {
+	if persistence.IsDuplicateKeyError(err, userEmailIndex) {
+		return ErrEmailConflict
+	}
+	return err
+}
+
+// LockUser lock or unlock the user with specified username, locked user is not able to login.
+func LockUser(username string, locked bool, s *sessions.Session) error {
+	return lockUser("username = ?", username, locked, s)
+}
+
+// LockUserByID lock or unlock the user with specified id, locked user is not able to login.
+// The sessions of the locked user are ended, since the lock is not checked again in sessions.
+func LockUserByID(id types.ID, locked bool, s *sessions.Session) error {
+	if err := lockUser("id = ?", id, locked, s); err != nil {
+		return err
+	}
+	if locked {
+		sessions.RevokeSessions(id)
+	}
+	return nil
+}
+
+func lockUser(query string, arg interface{}, locked bool, s *sessions.Session) error {
+	db := persistence.ActiveGormDB.WithContext(s.Context).Model(&User{}).
+		Where(query, arg).
+		Updates(map[string]interface{}{"islock": locked, "update_time": types.CurrentTimestamp()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ResetPassword replace the password (and the salt) of the user with specified username.
+func ResetPassword(username, password string, s *sessions.Session) error {
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	hashed, err := hashPassword(password, salt)
+	if err != nil {
+		return err
+	}
+
+	db := persistence.ActiveGormDB.WithContext(s.Context).Model(&User{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{"salt": salt, "password": hashed, "update_time": types.CurrentTimestamp()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func newSalt() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashPassword digest the salted password before bcrypt, bcrypt only accept 72 bytes at most.
+func hashPassword(password, salt string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword(saltedDigest(password, salt), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func saltedDigest(password, salt string) []byte {
+	digest := sha256.Sum256([]byte(salt + password))
+	return []byte(base64.StdEncoding.EncodeToString(digest[:]))
+}
@@ -0,0 +1,320 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/idgen"
+	"owlet/server/infra/meta"
+	"owlet/server/infra/oidc"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+)
+
+const CodeLastIdentity = "user.last_identity"
+
+// OIDCLoginTTL the time limit for the user to complete the authorization at provider
+const OIDCLoginTTL = 10 * time.Minute
+
+func init() {
+	fail.RegisterErrorCodes(fail.ErrorCode{Code: CodeLastIdentity, Status: http.StatusConflict,
+		Message: "the last identity can not be unlinked"})
+}
+
+// ErrLastIdentity the user would not be able to login without any identity
+type ErrLastIdentity struct{}
+
+func (e *ErrLastIdentity) Error() string {
+	return "the last identity can not be unlinked"
+}
+
+func (e *ErrLastIdentity) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusConflict, Code: CodeLastIdentity, Message: e.Error()}
+}
+
+var (
+	ErrIdentityConflict = &fail.ErrConflict{Field: "identity"}
+	ErrUserLocked       = fmt.Errorf("%w: user is locked", fail.ErrForbidden)
+
+	ListOIDCProvidersFunc  = ListOIDCProviders
+	BeginOIDCLoginFunc     = BeginOIDCLogin
+	BeginOIDCLinkFunc      = BeginOIDCLink
+	CompleteOIDCLoginFunc  = CompleteOIDCLogin
+	QueryMyIdentitiesFunc  = QueryMyIdentities
+	UnlinkMyIdentityFunc   = UnlinkMyIdentity
+	oidcPendingLogins      = cache.New(OIDCLoginTTL, time.Minute)
+	oidcUsernameMaxAttempt = 5
+)
+
+// oidcPendingLogin the authorization request waiting for callback, it is keyed by state
+type oidcPendingLogin struct {
+	Provider string
+	Request  *oidc.AuthRequest
+	LinkUser types.ID // zero for login
+	ReturnTo string
+}
+
+// OIDCLoginResult the user who logged in or linked the identity, and where to go next
+type OIDCLoginResult struct {
+	User     *User
+	Linked   bool
+	ReturnTo string
+}
+
+// OIDCChannelKey the subject is unique within provider
+func OIDCChannelKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// OIDCCallbackURL the redirect url registered at provider
+func OIDCCallbackURL(provider string) string {
+	return meta.PublicURL() + PathAuth + "/oidc/" + provider + "/callback"
+}
+
+// ListOIDCProviders names of the configured providers
+func ListOIDCProviders() []string {
+	names := make([]string, 0, len(oidc.Providers))
+	for name := range oidc.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginOIDCLogin the url of provider to authorize and the state of authorization, returnTo is the local path
+// to go after login. The state must be bound to the user agent, so that the callback in other user agents is rejected.
+func BeginOIDCLogin(provider, returnTo string, s *sessions.Session) (string, string, error) {
+	return beginOIDC(provider, returnTo, 0, s)
+}
+
+// BeginOIDCLink like BeginOIDCLogin, but the identity is linked to the user in session after authorized
+func BeginOIDCLink(provider, returnTo string, s *sessions.Session) (string, string, error) {
+	if s.Identity.ID == 0 {
+		return "", "", fail.ErrUnauthenticated
+	}
+	return beginOIDC(provider, returnTo, s.Identity.ID, s)
+}
+
+func beginOIDC(provider, returnTo string, linkUser types.ID, s *sessions.Session) (string, string, error) {
+	p, err := oidc.Lookup(provider)
+	if err != nil {
+		return "", "", err
+	}
+	r, err := oidc.NewAuthRequest(OIDCCallbackURL(provider))
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err := p.AuthCodeURL(s.Context, r)
+	if err != nil {
+		return "", "", err
+	}
+	oidcPendingLogins.SetDefault(r.State,
+		&oidcPendingLogin{Provider: provider, Request: r, LinkUser: linkUser, ReturnTo: localPath(returnTo)})
+	return authURL, r.State, nil
+}
+
+// CompleteOIDCLogin redeem the code of the pending authorization with state.
+// The identity is linked to the user in session if it is required, otherwise the user of the identity logs in,
+// a new user is provisioned if the identity is unknown.
+func CompleteOIDCLogin(provider, code, state string, s *sessions.Session) (*OIDCLoginResult, error) {
+	value, found := oidcPendingLogins.Get(state)
+	if !found {
+		return nil, fmt.Errorf("%w: unknown state", oidc.ErrAuthentication)
+	}
+	oidcPendingLogins.Delete(state) // state is used once
+	pending := value.(*oidcPendingLogin)
+	if pending.Provider != provider {
+		return nil, fmt.Errorf("%w: provider mismatch", oidc.ErrAuthentication)
+	}
+	// the identity is linked to the user who began linking only
+	if pending.LinkUser != 0 && s.Identity.ID != pending.LinkUser {
+		return nil, fmt.Errorf("%w: session mismatch", oidc.ErrAuthentication)
+	}
+
+	p, err := oidc.Lookup(provider)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.Exchange(s.Context, code, pending.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	key := OIDCChannelKey(provider, claims.Subject)
+	var user User
+	linked := false
+	err = persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var identity UserIdentity
+		err := tx.Where("auth_channel = ? AND channel_key = ?", AuthChannelOIDC, key).First(&identity).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		bound := err == nil
+
+		if pending.LinkUser != 0 {
+			if bound && identity.User != pending.LinkUser {
+				return ErrIdentityConflict
+			}
+			if err := tx.Where("id = ?", pending.LinkUser).First(&user).Error; err != nil {
+				return err
+			}
+			linked = true
+			if bound {
+				return nil
+			}
+			return tx.Create(&UserIdentity{ID: idgen.NextID(idWorker), User: user.ID,
+				AuthChannel: AuthChannelOIDC, ChannelKey: key}).Error
+		}
+
+		if !bound {
+			return provisionUser(tx, AuthChannelOIDC, key, profileOfClaims(claims), &user)
+		}
+		if err := tx.Where("id = ?", identity.User).First(&user).Error; err != nil {
+			return err
+		}
+		if user.IsLocked {
+			return ErrUserLocked
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, emailConflictOf(err)
+	}
+	return &OIDCLoginResult{User: &user, Linked: linked, ReturnTo: pending.ReturnTo}, nil
+}
+
+// externalProfile the user attributes provided by an external auth channel
+type externalProfile struct {
+	Username      string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Avatar        string
+	Locale        string
+}
+
+func profileOfClaims(claims *oidc.Claims) *externalProfile {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	return &externalProfile{Username: username, Email: claims.Email, EmailVerified: claims.EmailVerified,
+		Name: claims.Name, Avatar: claims.Picture, Locale: claims.Locale}
+}
+
+// provisionUser create the user of an unknown identity just in time.
+// The email must not be used by others, the owner of the email should login and link the identity instead.
+func provisionUser(tx *gorm.DB, channel AuthChannel, key string, p *externalProfile, user *User) error {
+	if p.Email != "" {
+		if err := checkEmailConflict(tx, p.Email, 0); err != nil {
+			return err
+		}
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+
+	now := types.CurrentTimestamp()
+	*user = User{
+		ID:            idgen.NextID(idWorker),
+		Email:         p.Email,
+		EmailVerified: p.Email != "" && p.EmailVerified,
+		Salt:          salt,
+		Avatar:        p.Avatar,
+		RealName:      p.Name,
+		CreateTime:    now,
+		ModifyTime:    now,
+	}
+	if tag, err := language.Parse(p.Locale); err == nil {
+		user.Locale = tag.String()
+	}
+	if user.Username, err = availableUsername(tx, usernameOf(p.Username), user.ID); err != nil {
+		return err
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	// the avatar references no file, since the avatar references the files of the user only (see PatchMyProfile)
+	// and the new user owns none
+	return tx.Create(&UserIdentity{ID: idgen.NextID(idWorker), User: user.ID,
+		AuthChannel: channel, ChannelKey: key}).Error
+}
+
+func usernameOf(name string) string {
+	if name = strings.TrimSpace(name); name == "" {
+		name = "user"
+	}
+	if runes := []rune(name); len(runes) > 64 {
+		name = string(runes[:64]) // cut by characters, the multi-byte characters are kept whole
+	}
+	return name
+}
+
+// availableUsername append a suffix if the name is used
+func availableUsername(tx *gorm.DB, name string, id types.ID) (string, error) {
+	candidate := name
+	for i := 0; i < oidcUsernameMaxAttempt; i++ {
+		var count int64
+		if err := tx.Model(&User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", name, uint64(id)%10000+uint64(i))
+	}
+	return "", ErrUsernameConflict
+}
+
+// localPath only local paths are allowed to redirect to, to avoid open redirection
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
+
+// QueryMyIdentities the identities of the user in session
+func QueryMyIdentities(s *sessions.Session) ([]UserIdentity, error) {
+	var identities []UserIdentity
+	if err := persistence.ActiveGormDB.WithContext(s.Context).
+		Where("user = ?", s.Identity.ID).Order("id ASC").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	if identities == nil {
+		identities = []UserIdentity{}
+	}
+	return identities, nil
+}
+
+// UnlinkMyIdentity remove an external identity of the user in session, the last identity is kept
+func UnlinkMyIdentity(id types.ID, s *sessions.Session) error {
+	return persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var identities []UserIdentity
+		if err := tx.Where("user = ?", s.Identity.ID).Find(&identities).Error; err != nil {
+			return err
+		}
+		var target *UserIdentity
+		for idx := range identities {
+			if identities[idx].ID == id && identities[idx].AuthChannel != AuthChannelInternal {
+				target = &identities[idx]
+			}
+		}
+		if target == nil {
+			return gorm.ErrRecordNotFound
+		}
+		if len(identities) == 1 {
+			return &ErrLastIdentity{}
+		}
+		return tx.Delete(&UserIdentity{}, target.ID).Error
+	})
+}
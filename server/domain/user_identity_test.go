@@ -0,0 +1,297 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/oidc"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+var identityColumns = []string{"id", "user", "auth_channel", "channel_key"}
+
+func useOIDCStub(t *testing.T) (*testinfra.OIDCStub, func()) {
+	stub, err := testinfra.StartOIDCStub("owlet", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := oidc.Providers
+	oidc.Providers = map[string]*oidc.Provider{
+		"stub": oidc.NewProvider(oidc.ProviderConfig{Name: "stub", Issuer: stub.URL, ClientID: "owlet", ClientSecret: "secret"}),
+	}
+	return stub, func() {
+		oidc.Providers = origin
+		stub.Close()
+	}
+}
+
+// authorizeAtStub follow the authorization url, the code and state are extracted from the redirection
+func authorizeAtStub(authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	Expect(err).ToNot(HaveOccurred())
+	defer res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(location.Path).To(Equal("/v1/auth/oidc/stub/callback"))
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestCompleteOIDCLogin(t *testing.T) {
+	RegisterTestingT(t)
+
+	stub, restore := useOIDCStub(t)
+	defer restore()
+	stub.SetClaims(map[string]interface{}{"sub": "u-1", "email": "tom@example.com", "email_verified": true,
+		"name": "Tom", "preferred_username": "tom", "picture": "http://img/tom.png", "locale": "zh-tw"})
+	s := &sessions.Session{Context: context.TODO()}
+
+	t.Run("should provision user for unknown identity", func(t *testing.T) {
+		authURL, _, err := BeginOIDCLogin("stub", "/articles?page=2", s)
+		Expect(err).ToNot(HaveOccurred())
+		code, state := authorizeAtStub(authURL)
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelOIDC, "stub:u-1").WillReturnRows(sqlmock.NewRows(identityColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs(testinfra.AnyArgument{}).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user` (`username`,`email`,`email_verified`,`salt`,`password`,`avatar`,`theme`,"+
+			"`theme_editor`,`real_name`,`phone_no`,`locale`,`islock`,`create_time`,`update_time`,`id`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")).
+			WithArgs(testinfra.AnyArgument{}, "tom@example.com", true, testinfra.AnyArgument{}, "",
+				"http://img/tom.png", "", "", "Tom", "", "zh-TW", false, testinfra.AnyArgument{}, testinfra.AnyArgument{}, testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identity` (`user`,`auth_channel`,`channel_key`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(testinfra.AnyId{}, AuthChannelOIDC, "stub:u-1", testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		result, err := CompleteOIDCLogin("stub", code, state, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Linked).To(BeFalse())
+		Expect(result.ReturnTo).To(Equal("/articles?page=2"))
+		Expect(result.User.ID).ToNot(BeZero())
+		Expect(result.User.Username).To(HavePrefix("tom-"))
+		Expect(result.User.EmailVerified).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		// state is used once
+		_, err = CompleteOIDCLogin("stub", code, state, s)
+		Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+	})
+
+	t.Run("should not provision user if email is used by others", func(t *testing.T) {
+		authURL, _, _ := BeginOIDCLogin("stub", "", s)
+		code, state := authorizeAtStub(authURL)
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelOIDC, "stub:u-1").WillReturnRows(sqlmock.NewRows(identityColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		_, err := CompleteOIDCLogin("stub", code, state, s)
+		Expect(err).To(Equal(ErrEmailConflict))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should login the user of known identity unless locked", func(t *testing.T) {
+		for _, locked := range []bool{false, true} {
+			authURL, _, _ := BeginOIDCLogin("stub", "//evil.com", s)
+			code, state := authorizeAtStub(authURL)
+
+			_, mock := testinfra.SetUpMockSql()
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+				"ORDER BY `user_identity`.`id` LIMIT 1")).
+				WithArgs(AuthChannelOIDC, "stub:u-1").
+				WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(1, 100, AuthChannelOIDC, "stub:u-1"))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+				WithArgs(100).
+				WillReturnRows(sqlmock.NewRows(userColumns).
+					AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", locked))
+			if locked {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			result, err := CompleteOIDCLogin("stub", code, state, s)
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+			if locked {
+				Expect(err).To(Equal(ErrUserLocked))
+				Expect(errors.Is(err, fail.ErrForbidden)).To(BeTrue())
+				continue
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.User.ID).To(Equal(types.ID(100)))
+			Expect(result.ReturnTo).To(Equal("/"))
+		}
+	})
+
+	t.Run("should link identity to the user in session", func(t *testing.T) {
+		tom := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+		authURL, state, err := BeginOIDCLink("stub", "/settings", tom)
+		Expect(err).ToNot(HaveOccurred())
+		code, redirectedState := authorizeAtStub(authURL)
+		Expect(redirectedState).To(Equal(state))
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelOIDC, "stub:u-1").WillReturnRows(sqlmock.NewRows(identityColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identity` (`user`,`auth_channel`,`channel_key`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(100, AuthChannelOIDC, "stub:u-1", testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		result, err := CompleteOIDCLogin("stub", code, state, tom)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Linked).To(BeTrue())
+		Expect(result.ReturnTo).To(Equal("/settings"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should link identity in the session of the user who began linking only", func(t *testing.T) {
+		jerry := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200}}
+		for _, other := range []*sessions.Session{s, {Context: context.TODO(), Identity: sessions.Identity{ID: 300}}} {
+			authURL, _, _ := BeginOIDCLink("stub", "", jerry)
+			code, state := authorizeAtStub(authURL)
+			_, err := CompleteOIDCLogin("stub", code, state, other)
+			Expect(errors.Is(err, oidc.ErrAuthentication)).To(BeTrue())
+		}
+	})
+
+	t.Run("should not link identity of other user", func(t *testing.T) {
+		jerry := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 200}}
+		authURL, _, _ := BeginOIDCLink("stub", "", jerry)
+		code, state := authorizeAtStub(authURL)
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelOIDC, "stub:u-1").
+			WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(1, 100, AuthChannelOIDC, "stub:u-1"))
+		mock.ExpectRollback()
+
+		_, err := CompleteOIDCLogin("stub", code, state, jerry)
+		Expect(err).To(Equal(ErrIdentityConflict))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject unknown provider or state", func(t *testing.T) {
+		_, _, err := BeginOIDCLogin("absent", "", s)
+		Expect(err).To(Equal(fail.ErrNotFound))
+
+		_, _, err = BeginOIDCLink("stub", "", s)
+		Expect(err).To(Equal(fail.ErrUnauthenticated))
+
+		_, err = CompleteOIDCLogin("stub", "code", "unknown", s)
+		Expect(errors.Is(err, oidc.ErrAuthentication)).To(BeTrue())
+
+		authURL, _, _ := BeginOIDCLogin("stub", "", s)
+		code, state := authorizeAtStub(authURL)
+		_, err = CompleteOIDCLogin("other", code, state, s)
+		Expect(errors.Is(err, oidc.ErrAuthentication)).To(BeTrue())
+	})
+}
+
+func TestLocalPath(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("only local path is allowed", func(t *testing.T) {
+		Expect(localPath("/a/b?c=d")).To(Equal("/a/b?c=d"))
+		Expect(localPath("")).To(Equal("/"))
+		Expect(localPath("http://evil.com")).To(Equal("/"))
+		Expect(localPath("//evil.com")).To(Equal("/"))
+		Expect(localPath("/\\evil.com")).To(Equal("/"))
+	})
+}
+
+func TestUnlinkMyIdentity(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+
+	t.Run("should unlink external identity", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE user = ?")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(identityColumns).
+				AddRow(1, 100, AuthChannelInternal, "tom").AddRow(2, 100, AuthChannelOIDC, "stub:u-1"))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_identity` WHERE `user_identity`.`id` = ?")).
+			WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(UnlinkMyIdentity(2, s)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should keep the last identity", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE user = ?")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(2, 100, AuthChannelOIDC, "stub:u-1"))
+		mock.ExpectRollback()
+
+		Expect(UnlinkMyIdentity(2, s)).To(Equal(&ErrLastIdentity{}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not unlink internal identity or identities of others", func(t *testing.T) {
+		for _, id := range []types.ID{1, 3} {
+			_, mock := testinfra.SetUpMockSql()
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE user = ?")).
+				WithArgs(100).
+				WillReturnRows(sqlmock.NewRows(identityColumns).
+					AddRow(1, 100, AuthChannelInternal, "tom").AddRow(2, 100, AuthChannelOIDC, "stub:u-1"))
+			mock.ExpectRollback()
+
+			Expect(UnlinkMyIdentity(id, s)).To(Equal(gorm.ErrRecordNotFound))
+		}
+	})
+}
+
+func TestUsernameOf(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(usernameOf("  tom ")).To(Equal("tom"))
+	Expect(usernameOf(" ")).To(Equal("user"))
+	Expect(usernameOf(strings.Repeat("a", 100))).To(Equal(strings.Repeat("a", 64)))
+
+	name := usernameOf(strings.Repeat("汤姆", 50))
+	Expect(name).To(Equal(strings.Repeat("汤姆", 32)))
+	Expect(utf8.ValidString(name)).To(BeTrue())
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"errors"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/ldapauth"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/ratelimit"
+	"owlet/server/infra/sessions"
+	"strings"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+)
+
+var LoginByLDAPFunc = LoginByLDAP
+
+const (
+	// LoginMaxFailures the account is locked temporarily after too many wrong passwords or verification codes
+	LoginMaxFailures  = 5
+	LoginLockDuration = 15 * time.Minute
+)
+
+// loginLockout the failures are counted by account, rather than by client which is limited by the rate limiter
+var loginLockout = ratelimit.NewLockout(LoginMaxFailures, LoginLockDuration)
+
+// LoginByLDAP verify the password against the directory, the user of the directory entry is provisioned at the first
+// login and its email and name are synchronized at later logins. The roles mapped from the groups of entry are returned.
+func LoginByLDAP(login *sessions.LoginRequest, s *sessions.Session) (*User, authority.Permissions, error) {
+	if ldapauth.Active == nil {
+		return nil, nil, fail.ErrNotFound
+	}
+	lockKey := "ldap:" + strings.ToLower(login.Name)
+	if err := loginLockout.Check(lockKey); err != nil {
+		return nil, nil, err
+	}
+	entry, err := ldapauth.Active.Authenticate(login.Name, login.Password)
+	if err != nil {
+		if errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			loginLockout.Fail(lockKey)
+		}
+		return nil, nil, err
+	}
+	loginLockout.Reset(lockKey)
+
+	var user User
+	err = persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var identity UserIdentity
+		err := tx.Where("auth_channel = ? AND channel_key = ?", AuthChannelLDAP, entry.Username).First(&identity).Error
+		if err == gorm.ErrRecordNotFound {
+			// the directory is trusted, the email of entry is verified
+			return provisionUser(tx, AuthChannelLDAP, entry.Username,
+				&externalProfile{Username: entry.Username, Email: entry.Email, EmailVerified: true, Name: entry.Name}, &user)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", identity.User).First(&user).Error; err != nil {
+			return err
+		}
+		if user.IsLocked {
+			return ErrUserLocked
+		}
+		return syncLDAPEntry(tx, &user, entry)
+	})
+	if err != nil {
+		return nil, nil, emailConflictOf(err)
+	}
+	return &user, entry.Roles, nil
+}
+
+// syncLDAPEntry update the email and the name of user if they are changed in directory
+func syncLDAPEntry(tx *gorm.DB, user *User, entry *ldapauth.Entry) error {
+	changes := map[string]interface{}{}
+	if entry.Email != "" && entry.Email != user.Email {
+		if err := checkEmailConflict(tx, entry.Email, user.ID); err != nil {
+			return err
+		}
+		changes["email"], changes["email_verified"] = entry.Email, true
+		user.Email, user.EmailVerified = entry.Email, true
+	}
+	if entry.Name != "" && entry.Name != user.RealName {
+		changes["real_name"] = entry.Name
+		user.RealName = entry.Name
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	user.ModifyTime = types.CurrentTimestamp()
+	changes["update_time"] = user.ModifyTime
+	return tx.Model(&User{}).Where("id = ?", user.ID).Updates(changes).Error
+}
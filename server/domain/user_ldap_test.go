@@ -0,0 +1,152 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/ldapauth"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+)
+
+func useLDAPStub(t *testing.T) func() {
+	stub, err := testinfra.StartLDAPStub(
+		testinfra.LDAPStubEntry{DN: "dc=example,dc=com"},
+		testinfra.LDAPStubEntry{DN: "uid=tom,ou=people,dc=example,dc=com", Password: "tom-secret",
+			Attributes: map[string][]string{"uid": {"tom"}, "mail": {"tom@example.com"}, "cn": {"Tom Cat"}}},
+		testinfra.LDAPStubEntry{DN: "cn=admins,ou=groups,dc=example,dc=com",
+			Attributes: map[string][]string{"cn": {"admins"}, "member": {"uid=tom,ou=people,dc=example,dc=com"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := ldapauth.Active
+	ldapauth.Active = ldapauth.NewDirectory(ldapauth.Config{URL: stub.URL, BaseDN: "dc=example,dc=com",
+		GroupRoles: map[string]string{"admins": authority.RoleAdmin}})
+	return func() {
+		ldapauth.Active = origin
+		stub.Close()
+	}
+}
+
+func TestLoginByLDAP(t *testing.T) {
+	RegisterTestingT(t)
+
+	restore := useLDAPStub(t)
+	defer restore()
+	s := &sessions.Session{Context: context.TODO()}
+	login := &sessions.LoginRequest{Name: "tom", Password: "tom-secret"}
+
+	t.Run("should provision user at the first login", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelLDAP, "tom").WillReturnRows(sqlmock.NewRows(identityColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user` (`username`,`email`,`email_verified`,`salt`,`password`,`avatar`,`theme`,"+
+			"`theme_editor`,`real_name`,`phone_no`,`locale`,`islock`,`create_time`,`update_time`,`id`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")).
+			WithArgs("tom", "tom@example.com", true, testinfra.AnyArgument{}, "",
+				"", "", "", "Tom Cat", "", "", false, testinfra.AnyArgument{}, testinfra.AnyArgument{}, testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identity` (`user`,`auth_channel`,`channel_key`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(testinfra.AnyId{}, AuthChannelLDAP, "tom", testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		user, roles, err := LoginByLDAP(login, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(user.ID).ToNot(BeZero())
+		Expect(user.Username).To(Equal("tom"))
+		Expect(user.RealName).To(Equal("Tom Cat"))
+		Expect(roles).To(Equal(authority.Permissions{authority.RoleAdmin}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should synchronize email and name at later logins", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelLDAP, "tom").
+			WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(1, 100, AuthChannelLDAP, "tom"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "old@example.com", "salt", "", "", "", "", "Tom", "", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 100).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `email`=?,`email_verified`=?,`real_name`=?,`update_time`=? WHERE id = ?")).
+			WithArgs("tom@example.com", true, "Tom Cat", testinfra.AnyArgument{}, 100).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		user, _, err := LoginByLDAP(login, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(user.ID).To(Equal(types.ID(100)))
+		Expect(user.Email).To(Equal("tom@example.com"))
+		Expect(user.EmailVerified).To(BeTrue())
+		Expect(user.RealName).To(Equal("Tom Cat"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not login locked user", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_identity` WHERE auth_channel = ? AND channel_key = ? "+
+			"ORDER BY `user_identity`.`id` LIMIT 1")).
+			WithArgs(AuthChannelLDAP, "tom").
+			WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(1, 100, AuthChannelLDAP, "tom"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "", "", "", "", "Tom Cat", "", "", true))
+		mock.ExpectRollback()
+
+		_, _, err := LoginByLDAP(login, s)
+		Expect(err).To(Equal(ErrUserLocked))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject wrong password without touching database", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		_, _, err := LoginByLDAP(&sessions.LoginRequest{Name: "tom", Password: "wrong"}, s)
+		Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should lock account temporarily after too many wrong passwords", func(t *testing.T) {
+		defer loginLockout.Reset("ldap:tom")
+		loginLockout.Reset("ldap:tom")
+		for i := 0; i < LoginMaxFailures; i++ {
+			_, _, err := LoginByLDAP(&sessions.LoginRequest{Name: "Tom", Password: "wrong"}, s)
+			Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+		}
+
+		_, mock := testinfra.SetUpMockSql()
+		_, _, err := LoginByLDAP(login, s)
+		Expect(err).To(BeAssignableToTypeOf(&fail.ErrTooManyRequests{}))
+		Expect(err.(*fail.ErrTooManyRequests).RetryAfter).To(BeNumerically(">", LoginLockDuration-time.Minute))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should be not found if LDAP is disabled", func(t *testing.T) {
+		origin := ldapauth.Active
+		ldapauth.Active = nil
+		defer func() { ldapauth.Active = origin }()
+
+		_, _, err := LoginByLDAP(login, s)
+		Expect(err).To(Equal(fail.ErrNotFound))
+	})
+}
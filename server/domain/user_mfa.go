@@ -0,0 +1,414 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/idgen"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/totp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+)
+
+const CodeMFAInvalidCode = "user.mfa_invalid_code"
+
+const (
+	// MFAChallengeTTL the time limit to complete the second step of login
+	MFAChallengeTTL = 5 * time.Minute
+	// MFAMaxAttempts the challenge is discarded after too many wrong codes, the user has to login again
+	MFAMaxAttempts = 5
+	// MFARecoveryCodeCount the number of recovery codes generated at once
+	MFARecoveryCodeCount = 10
+)
+
+func init() {
+	fail.RegisterErrorCodes(fail.ErrorCode{Code: CodeMFAInvalidCode, Status: http.StatusBadRequest,
+		Message: "the verification code is invalid"})
+}
+
+// ErrMFAInvalidCode the TOTP code or the recovery code is wrong, or the TOTP code is used already
+type ErrMFAInvalidCode struct{}
+
+func (e *ErrMFAInvalidCode) Error() string {
+	return "the verification code is invalid"
+}
+
+func (e *ErrMFAInvalidCode) Respond() *fail.BizErrorDetail {
+	return &fail.BizErrorDetail{Status: http.StatusBadRequest, Code: CodeMFAInvalidCode, Message: e.Error()}
+}
+
+// UserMFA the TOTP secret of user, the second factor is required at login once it is enabled
+type UserMFA struct {
+	User     types.ID `json:"user" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	Secret   string   `json:"-" gorm:"type:VARCHAR(64) NOT NULL"`
+	Enabled  bool     `json:"enabled" gorm:"type:TINYINT NOT NULL DEFAULT '0'"`
+	LastStep int64    `json:"-" gorm:"type:BIGINT NOT NULL DEFAULT '0'"` // the last accepted time step, to prevent replay
+
+	CreateTime types.Timestamp `json:"create_time" gorm:"type:DATETIME NOT NULL"`
+	ModifyTime types.Timestamp `json:"modify_time" gorm:"column:update_time;type:DATETIME NOT NULL"`
+}
+
+// UserRecoveryCode a one-time code used instead of TOTP when the authenticator is lost, only the digest is stored
+type UserRecoveryCode struct {
+	ID       types.ID `json:"id" gorm:"primary_key;type:BIGINT UNSIGNED NOT NULL"`
+	User     types.ID `json:"user" gorm:"type:BIGINT UNSIGNED NOT NULL;index:idx_user_recovery_code_user"`
+	CodeHash string   `json:"-" gorm:"type:VARCHAR(64) NOT NULL"`
+}
+
+func (r *UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+func (r *UserRecoveryCode) TableName() string {
+	return "user_recovery_code"
+}
+
+// MFAEnrollment the secret to add into authenticator apps, URI is the payload of QR code
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFARecoveryCodes the plain recovery codes, they are shown only once
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFACode struct {
+	Code string `json:"code" binding:"required,lte=32"`
+}
+
+// MFAChallenge the first step of login is passed, the token is used to complete the second step
+type MFAChallenge struct {
+	Token string `json:"mfa_token"`
+}
+
+type MFAVerification struct {
+	Token string `json:"mfa_token" binding:"required,lte=128"`
+	Code  string `json:"code" binding:"required,lte=32"`
+}
+
+// MFALoginResult the user who passed both steps, and the roles granted at the first step
+type MFALoginResult struct {
+	User     *User
+	Perms    authority.Permissions
+	ReturnTo string
+}
+
+// mfaPendingLogin the login waiting for the second factor, it is keyed by challenge token
+type mfaPendingLogin struct {
+	User     types.ID
+	Perms    authority.Permissions
+	ReturnTo string
+	attempts int32
+}
+
+var (
+	// MFAIssuer the issuer shown in authenticator apps
+	MFAIssuer = "owlet"
+	// MFALoginPage the page (of web client) to input the code after OIDC login,
+	// the challenge token is in query parameter 'mfa_token'
+	MFALoginPage = "/login/2fa"
+
+	ErrMFAEnabled   = &fail.ErrConflict{Field: "mfa"}
+	ErrMFAChallenge = fmt.Errorf("%w: unknown or expired challenge", fail.ErrUnauthenticated)
+
+	BeginMFAEnrollmentFunc         = BeginMFAEnrollment
+	ConfirmMFAEnrollmentFunc       = ConfirmMFAEnrollment
+	RegenerateMFARecoveryCodesFunc = RegenerateMFARecoveryCodes
+	DisableMyMFAFunc               = DisableMyMFA
+	ResetUserMFAFunc               = ResetUserMFA
+	ChallengeMFAFunc               = ChallengeMFA
+	VerifyMFAFunc                  = VerifyMFA
+
+	mfaPendingLogins = cache.New(MFAChallengeTTL, time.Minute)
+	recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+)
+
+// BeginMFAEnrollment generate a new secret for the user in session, it takes effect after confirmed with a code.
+// A pending enrollment is replaced.
+func BeginMFAEnrollment(s *sessions.Session) (*MFAEnrollment, error) {
+	var enrollment *MFAEnrollment
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Where("id = ?", s.Identity.ID).First(&user).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&UserMFA{}).Where("user = ? AND enabled = ?", user.ID, true).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMFAEnabled
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user = ?", user.ID).Delete(&UserMFA{}).Error; err != nil {
+			return err
+		}
+		now := types.CurrentTimestamp()
+		if err := tx.Create(&UserMFA{User: user.ID, Secret: secret, CreateTime: now, ModifyTime: now}).Error; err != nil {
+			return err
+		}
+
+		account := user.Email
+		if account == "" {
+			account = user.Username
+		}
+		enrollment = &MFAEnrollment{Secret: secret, URI: totp.URI(MFAIssuer, account, secret)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// ConfirmMFAEnrollment enable the pending enrollment if the code is valid, the recovery codes are generated
+func ConfirmMFAEnrollment(code string, s *sessions.Session) (*MFARecoveryCodes, error) {
+	var codes *MFARecoveryCodes
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var mfa UserMFA
+		if err := tx.Where("user = ?", s.Identity.ID).First(&mfa).Error; err != nil {
+			return err
+		}
+		if mfa.Enabled {
+			return ErrMFAEnabled
+		}
+		step, ok := totp.Validate(mfa.Secret, code, time.Now(), mfa.LastStep)
+		if !ok {
+			return &ErrMFAInvalidCode{}
+		}
+		if err := tx.Model(&UserMFA{}).Where("user = ?", mfa.User).Updates(map[string]interface{}{
+			"enabled": true, "last_step": step, "update_time": types.CurrentTimestamp()}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, mfa.User)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateMFARecoveryCodes replace all recovery codes of the user in session, a valid TOTP code is required
+func RegenerateMFARecoveryCodes(code string, s *sessions.Session) (*MFARecoveryCodes, error) {
+	var codes *MFARecoveryCodes
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		mfa, err := enabledMFA(tx, s.Identity.ID)
+		if err != nil {
+			return err
+		}
+		if ok, err := consumeTOTP(tx, mfa, code); err != nil || !ok {
+			return invalidCodeOr(err)
+		}
+		codes, err = replaceRecoveryCodes(tx, mfa.User)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMyMFA remove the second factor of the user in session, a valid TOTP code or recovery code is required
+func DisableMyMFA(code string, s *sessions.Session) error {
+	return persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		mfa, err := enabledMFA(tx, s.Identity.ID)
+		if err != nil {
+			return err
+		}
+		if ok, err := verifySecondFactor(tx, mfa, code); err != nil || !ok {
+			return invalidCodeOr(err)
+		}
+		return deleteMFA(tx, mfa.User)
+	})
+}
+
+// ResetUserMFA remove the second factor of the user (by admin), e.g. the user lost both the authenticator
+// and the recovery codes
+func ResetUserMFA(id types.ID, s *sessions.Session) error {
+	return persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&UserMFA{}).Where("user = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return deleteMFA(tx, id)
+	})
+}
+
+// ChallengeMFA start the second step of login if the user enabled MFA, the challenge token is returned.
+// An empty token is returned if the second step is not required.
+func ChallengeMFA(user *User, perms authority.Permissions, returnTo string, s *sessions.Session) (string, error) {
+	var count int64
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Model(&UserMFA{}).
+		Where("user = ? AND enabled = ?", user.ID, true).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	mfaPendingLogins.SetDefault(token, &mfaPendingLogin{User: user.ID, Perms: perms, ReturnTo: returnTo})
+	return token, nil
+}
+
+// VerifyMFA complete the second step of login with a TOTP code or a recovery code
+func VerifyMFA(v *MFAVerification, s *sessions.Session) (*MFALoginResult, error) {
+	value, found := mfaPendingLogins.Get(v.Token)
+	if !found {
+		return nil, ErrMFAChallenge
+	}
+	pending := value.(*mfaPendingLogin)
+	// the failures are counted across challenges, the user may login again to get a new challenge
+	lockKey := "mfa:" + pending.User.String()
+	if err := loginLockout.Check(lockKey); err != nil {
+		return nil, err
+	}
+
+	var user User
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		mfa, err := enabledMFA(tx, pending.User)
+		if err != nil {
+			return err
+		}
+		if ok, err := verifySecondFactor(tx, mfa, v.Code); err != nil || !ok {
+			return invalidCodeOr(err)
+		}
+		if err := tx.Where("id = ?", pending.User).First(&user).Error; err != nil {
+			return err
+		}
+		if user.IsLocked {
+			return ErrUserLocked
+		}
+		return nil
+	})
+	if err != nil {
+		// the user is allowed to retry a wrong code for a few times
+		_, invalid := err.(*ErrMFAInvalidCode)
+		if invalid {
+			loginLockout.Fail(lockKey)
+		}
+		if !invalid || atomic.AddInt32(&pending.attempts, 1) >= MFAMaxAttempts {
+			mfaPendingLogins.Delete(v.Token)
+		}
+		return nil, err
+	}
+	loginLockout.Reset(lockKey)
+	mfaPendingLogins.Delete(v.Token) // challenge is used once
+	return &MFALoginResult{User: &user, Perms: pending.Perms, ReturnTo: pending.ReturnTo}, nil
+}
+
+func enabledMFA(tx *gorm.DB, user types.ID) (*UserMFA, error) {
+	var mfa UserMFA
+	if err := tx.Where("user = ? AND enabled = ?", user, true).First(&mfa).Error; err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+func invalidCodeOr(err error) error {
+	if err != nil {
+		return err
+	}
+	return &ErrMFAInvalidCode{}
+}
+
+// verifySecondFactor accept a TOTP code or an unused recovery code, the recovery code is consumed
+func verifySecondFactor(tx *gorm.DB, mfa *UserMFA, code string) (bool, error) {
+	if ok, err := consumeTOTP(tx, mfa, code); err != nil || ok {
+		return ok, err
+	}
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+	db := tx.Where("user = ? AND code_hash = ?", mfa.User, hashRecoveryCode(normalized)).Delete(&UserRecoveryCode{})
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// consumeTOTP the time step of code is recorded, the code can not be used again
+func consumeTOTP(tx *gorm.DB, mfa *UserMFA, code string) (bool, error) {
+	step, ok := totp.Validate(mfa.Secret, code, time.Now(), mfa.LastStep)
+	if !ok {
+		return false, nil
+	}
+	// the condition on last_step guards the concurrent use of the same code
+	db := tx.Model(&UserMFA{}).Where("user = ? AND last_step < ?", mfa.User, step).
+		Updates(map[string]interface{}{"last_step": step, "update_time": types.CurrentTimestamp()})
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+func deleteMFA(tx *gorm.DB, user types.ID) error {
+	if err := tx.Where("user = ?", user).Delete(&UserRecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user = ?", user).Delete(&UserMFA{}).Error
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, user types.ID) (*MFARecoveryCodes, error) {
+	if err := tx.Where("user = ?", user).Delete(&UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, MFARecoveryCodeCount)
+	records := make([]UserRecoveryCode, 0, MFARecoveryCodeCount)
+	for i := 0; i < MFARecoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := recoveryEncoding.EncodeToString(buf)
+		codes = append(codes, code[:4]+"-"+code[4:])
+		records = append(records, UserRecoveryCode{ID: idgen.NextID(idWorker), User: user, CodeHash: hashRecoveryCode(code)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return &MFARecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// normalizeRecoveryCode the separator and the case are ignored, empty is returned if it is not a recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 8 {
+		return ""
+	}
+	return code
+}
+
+// hashRecoveryCode the codes are random enough (40 bits) and used once, a fast digest is sufficient
+func hashRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
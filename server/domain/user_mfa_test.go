@@ -0,0 +1,298 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/totp"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+const testMFASecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var mfaColumns = []string{"user", "secret", "enabled", "last_step"}
+
+func currentCode() string {
+	code, _ := totp.Code(testMFASecret, totp.Step(time.Now()))
+	return code
+}
+
+func expectRecoveryCodesReplaced(mock sqlmock.Sqlmock, user types.ID) {
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_recovery_code` WHERE user = ?")).
+		WithArgs(user).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_recovery_code` (`user`,`code_hash`,`id`) VALUES (?,?,?)," +
+		strings.Repeat("(?,?,?),", MFARecoveryCodeCount-2) + "(?,?,?)")).
+		WillReturnResult(sqlmock.NewResult(1, MFARecoveryCodeCount))
+}
+
+func TestMFATableName(t *testing.T) {
+	RegisterTestingT(t)
+	Expect((&UserMFA{}).TableName()).To(Equal("user_mfa"))
+	Expect((&UserRecoveryCode{}).TableName()).To(Equal("user_recovery_code"))
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+
+	t.Run("should replace pending enrollment with a new secret", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ? AND enabled = ?")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_mfa` WHERE user = ?")).
+			WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_mfa` (`secret`,`enabled`,`last_step`,`create_time`,`update_time`,`user`) "+
+			"VALUES (?,?,?,?,?,?)")).
+			WithArgs(testinfra.AnyArgument{}, false, 0, testinfra.AnyArgument{}, testinfra.AnyArgument{}, 100).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		enrollment, err := BeginMFAEnrollment(s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(enrollment.Secret)).To(Equal(32))
+		Expect(enrollment.URI).To(HavePrefix("otpauth://totp/owlet:tom@example.com?"))
+		Expect(enrollment.URI).To(ContainSubstring("secret=" + enrollment.Secret))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not enroll again if enabled", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ? AND enabled = ?")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		_, err := BeginMFAEnrollment(s)
+		Expect(err).To(Equal(ErrMFAEnabled))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should enable enrollment and generate recovery codes if code is valid", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, false, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user_mfa` SET `enabled`=?,`last_step`=?,`update_time`=? WHERE user = ?")).
+			WithArgs(true, testinfra.AnyArgument{}, testinfra.AnyArgument{}, 100).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectRecoveryCodesReplaced(mock, 100)
+		mock.ExpectCommit()
+
+		codes, err := ConfirmMFAEnrollment(currentCode(), s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(codes.RecoveryCodes)).To(Equal(MFARecoveryCodeCount))
+		for _, code := range codes.RecoveryCodes {
+			Expect(code).To(MatchRegexp(`^[a-z2-9]{4}-[a-z2-9]{4}$`))
+		}
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not enable enrollment if code is invalid", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, false, 0))
+		mock.ExpectRollback()
+
+		_, err := ConfirmMFAEnrollment("abcdef", s)
+		Expect(err).To(Equal(&ErrMFAInvalidCode{}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestMFALogin(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO()}
+	user := &User{ID: 100}
+	perms := authority.Permissions{authority.RoleAdmin}
+
+	expectEnabledMFA := func(mock sqlmock.Sqlmock, lastStep int64) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? AND enabled = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, true, lastStep))
+	}
+	challenge := func() string {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ? AND enabled = ?")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		token, err := ChallengeMFA(user, perms, "/articles", s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).ToNot(BeEmpty())
+		return token
+	}
+
+	t.Run("should not challenge if MFA is not enabled", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ? AND enabled = ?")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		token, err := ChallengeMFA(user, perms, "", s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(BeEmpty())
+	})
+
+	t.Run("should pass the second step with TOTP code once", func(t *testing.T) {
+		token := challenge()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		expectEnabledMFA(mock, 0)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user_mfa` SET `last_step`=?,`update_time`=? WHERE user = ? AND last_step < ?")).
+			WithArgs(testinfra.AnyArgument{}, testinfra.AnyArgument{}, 100, testinfra.AnyArgument{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectCommit()
+
+		result, err := VerifyMFA(&MFAVerification{Token: token, Code: currentCode()}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.User.ID).To(Equal(types.ID(100)))
+		Expect(result.Perms).To(Equal(perms))
+		Expect(result.ReturnTo).To(Equal("/articles"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		_, err = VerifyMFA(&MFAVerification{Token: token, Code: currentCode()}, s)
+		Expect(err).To(Equal(ErrMFAChallenge))
+		Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+	})
+
+	t.Run("should pass the second step with recovery code", func(t *testing.T) {
+		token := challenge()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		expectEnabledMFA(mock, 0)
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_recovery_code` WHERE user = ? AND code_hash = ?")).
+			WithArgs(100, hashRecoveryCode("abcd2345")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectCommit()
+
+		_, err := VerifyMFA(&MFAVerification{Token: token, Code: "ABCD-2345"}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should discard challenge and lock account after too many invalid codes", func(t *testing.T) {
+		defer loginLockout.Reset("mfa:100")
+		token := challenge()
+
+		for i := 0; i < MFAMaxAttempts; i++ {
+			_, mock := testinfra.SetUpMockSql()
+			mock.ExpectBegin()
+			// the code of the used step is rejected
+			expectEnabledMFA(mock, totp.Step(time.Now())+1)
+			mock.ExpectRollback()
+
+			_, err := VerifyMFA(&MFAVerification{Token: token, Code: currentCode()}, s)
+			Expect(err).To(Equal(&ErrMFAInvalidCode{}))
+			Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		}
+
+		_, err := VerifyMFA(&MFAVerification{Token: token, Code: currentCode()}, s)
+		Expect(err).To(Equal(ErrMFAChallenge))
+
+		// the new challenge is rejected before checking the code
+		_, err = VerifyMFA(&MFAVerification{Token: challenge(), Code: currentCode()}, s)
+		Expect(err).To(BeAssignableToTypeOf(&fail.ErrTooManyRequests{}))
+	})
+}
+
+func TestDisableAndResetMFA(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+
+	t.Run("should disable MFA with a valid code", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? AND enabled = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, true, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user_mfa` SET `last_step`=?,`update_time`=? WHERE user = ? AND last_step < ?")).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_recovery_code` WHERE user = ?")).
+			WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_mfa` WHERE user = ?")).
+			WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(DisableMyMFA(currentCode(), s)).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should not disable MFA with an invalid code", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? AND enabled = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, true, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_recovery_code` WHERE user = ? AND code_hash = ?")).
+			WithArgs(100, hashRecoveryCode("wrongcod")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		Expect(DisableMyMFA("wrong-cod", s)).To(Equal(&ErrMFAInvalidCode{}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should regenerate recovery codes with a valid TOTP code", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user_mfa` WHERE user = ? AND enabled = ? ORDER BY `user_mfa`.`user` LIMIT 1")).
+			WithArgs(100, true).WillReturnRows(sqlmock.NewRows(mfaColumns).AddRow(100, testMFASecret, true, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user_mfa` SET `last_step`=?,`update_time`=? WHERE user = ? AND last_step < ?")).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectRecoveryCodesReplaced(mock, 100)
+		mock.ExpectCommit()
+
+		codes, err := RegenerateMFARecoveryCodes(currentCode(), s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(codes.RecoveryCodes)).To(Equal(MFARecoveryCodeCount))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reset MFA of user by admin", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ?")).
+			WithArgs(200).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_recovery_code` WHERE user = ?")).
+			WithArgs(200).WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `user_mfa` WHERE user = ?")).
+			WithArgs(200).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(ResetUserMFA(200, s)).ToNot(HaveOccurred())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		_, mock = testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user_mfa` WHERE user = ?")).
+			WithArgs(300).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectRollback()
+
+		Expect(ResetUserMFA(300, s)).To(Equal(gorm.ErrRecordNotFound))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+
+	"github.com/fundwit/go-commons/types"
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+)
+
+// UserProfile the profile visible to the user itself, salt and password are never exposed
+type UserProfile struct {
+	ID            types.ID `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Avatar        string   `json:"avatar"`
+	Theme         string   `json:"theme"`
+	ThemeEditor   string   `json:"theme_editor"`
+	RealName      string   `json:"real_name"`
+	PhoneNo       string   `json:"phone_no"`
+	Locale        string   `json:"locale"`
+
+	CreateTime types.Timestamp `json:"create_time"`
+	ModifyTime types.Timestamp `json:"modify_time"`
+}
+
+// UserSummary the user visible to administrators
+type UserSummary struct {
+	UserProfile
+	IsLocked bool `json:"is_locked"`
+}
+
+// AuthorProfile the public profile of user, with the published articles
+type AuthorProfile struct {
+	ID       types.ID `json:"id"`
+	Username string   `json:"username"`
+	Avatar   string   `json:"avatar"`
+	RealName string   `json:"real_name"`
+
+	Articles []ArticleMetaExt `json:"articles"`
+}
+
+// UserPatch the absent fields are kept unchanged
+type UserPatch struct {
+	Email       *string `json:"email" binding:"omitempty,email,lte=255"`
+	Avatar      *string `json:"avatar" binding:"omitempty,lte=255"`
+	Theme       *string `json:"theme" binding:"omitempty,lte=255"`
+	ThemeEditor *string `json:"theme_editor" binding:"omitempty,lte=255"`
+	RealName    *string `json:"real_name" binding:"omitempty,lte=255"`
+	PhoneNo     *string `json:"phone_no" binding:"omitempty,lte=32"`
+	Locale      *string `json:"locale" binding:"omitempty,lte=35"`
+}
+
+type UserQuery struct {
+	KeyWord string `form:"kw" binding:"omitempty,lte=200"`
+	Page    int    `form:"page"` // base 1
+}
+
+var (
+	DetailMyProfileFunc     = DetailMyProfile
+	PatchMyProfileFunc      = PatchMyProfile
+	DetailAuthorProfileFunc = DetailAuthorProfile
+	QueryUsersFunc          = QueryUsers
+)
+
+func newUserProfile(u *User) UserProfile {
+	return UserProfile{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Avatar:        u.Avatar,
+		Theme:         u.Theme,
+		ThemeEditor:   u.ThemeEditor,
+		RealName:      u.RealName,
+		PhoneNo:       u.PhoneNo,
+		Locale:        u.Locale,
+		CreateTime:    u.CreateTime,
+		ModifyTime:    u.ModifyTime,
+	}
+}
+
+// DetailMyProfile the profile of the user in session
+func DetailMyProfile(s *sessions.Session) (*UserProfile, error) {
+	var u User
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Where("id = ?", s.Identity.ID).First(&u).Error; err != nil {
+		return nil, err
+	}
+	profile := newUserProfile(&u)
+	return &profile, nil
+}
+
+// PatchMyProfile update the profile of the user in session, the email must not be used by other users.
+// The email needs to be verified again once it is changed.
+func PatchMyProfile(p *UserPatch, s *sessions.Session) (*UserProfile, error) {
+	changes := map[string]interface{}{}
+	if p.Avatar != nil {
+		changes["avatar"] = *p.Avatar
+	}
+	if p.Theme != nil {
+		changes["theme"] = *p.Theme
+	}
+	if p.ThemeEditor != nil {
+		changes["theme_editor"] = *p.ThemeEditor
+	}
+	if p.RealName != nil {
+		changes["real_name"] = *p.RealName
+	}
+	if p.PhoneNo != nil {
+		changes["phone_no"] = *p.PhoneNo
+	}
+	if p.Locale != nil {
+		locale := *p.Locale
+		if locale != "" {
+			tag, err := language.Parse(locale)
+			if err != nil {
+				return nil, &fail.ErrBadParam{Param: "locale", InvalidValue: locale, Cause: err}
+			}
+			locale = tag.String()
+		}
+		changes["locale"] = locale
+	}
+
+	var u User
+	err := persistence.ActiveGormDB.WithContext(s.Context).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", s.Identity.ID).First(&u).Error; err != nil {
+			return err
+		}
+		if p.Email != nil && *p.Email != u.Email {
+			if err := checkEmailConflict(tx, *p.Email, s.Identity.ID); err != nil {
+				return err
+			}
+			changes["email"] = *p.Email
+			changes["email_verified"] = false
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		changes["update_time"] = types.CurrentTimestamp()
+		if err := tx.Model(&User{}).Where("id = ?", s.Identity.ID).Updates(changes).Error; err != nil {
+			return err
+		}
+		if p.Avatar != nil && *p.Avatar != u.Avatar {
+			// the avatar references the files of the user only, administrators are able to reference any file
+			owner := u.ID
+			if s.Perms.HasRole(authority.RoleAdmin) {
+				owner = 0
+			}
+			texts := map[FileRefOwner]string{{Type: FileRefAvatar, ID: u.ID}: *p.Avatar}
+			if err := syncOwnedFileRefs(tx, texts, owner); err != nil {
+				return err
+			}
+		}
+		return tx.Where("id = ?", s.Identity.ID).First(&u).Error
+	})
+	if err != nil {
+		return nil, emailConflictOf(err)
+	}
+	profile := newUserProfile(&u)
+	return &profile, nil
+}
+
+// DetailAuthorProfile the public profile of user with id, and a page of the published articles of the user
+func DetailAuthorProfile(id types.ID, page int, s *sessions.Session) (*AuthorProfile, error) {
+	var u User
+	db := persistence.ActiveGormDB.WithContext(s.Context)
+	if err := db.Where("id = ? AND islock = 0", id).First(&u).Error; err != nil {
+		return nil, err
+	}
+
+	offset := (page - 1) * PageSize
+	if offset < 0 {
+		offset = 0
+	}
+	var articles []ArticleMetaExt
+	err := db.Model(&ArticleRecord{}).
+		Select("id, type, title, slug, uid, create_time, modify_time, status, is_invalid, "+
+			"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, "+
+			"toc, word_count, char_count, reading_minutes, code_block_count, image_count").
+		Where("uid = ? AND is_invalid = 0 AND status = ?", id, ArticleStatusPublished).
+		Order("create_time DESC").
+		Offset(offset).
+		Limit(PageSize).
+		Scan(&articles).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := appendTags(articles, s); err != nil {
+		return nil, err
+	}
+	if articles == nil {
+		articles = []ArticleMetaExt{}
+	}
+
+	return &AuthorProfile{ID: u.ID, Username: u.Username, Avatar: u.Avatar, RealName: u.RealName, Articles: articles}, nil
+}
+
+// QueryUsers list users for administrators, the keyword matches username, email or real name
+func QueryUsers(q UserQuery, s *sessions.Session) ([]UserSummary, error) {
+	offset := (q.Page - 1) * PageSize
+	if offset < 0 {
+		offset = 0
+	}
+
+	db := persistence.ActiveGormDB.WithContext(s.Context).Model(&User{}).
+		Order("create_time DESC").
+		Offset(offset).
+		Limit(PageSize)
+	if len(q.KeyWord) > 0 {
+		kw := "%" + q.KeyWord + "%"
+		db = db.Where("username LIKE ? OR email LIKE ? OR real_name LIKE ?", kw, kw, kw)
+	}
+
+	var users []User
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	summaries := make([]UserSummary, 0, len(users))
+	for idx := range users {
+		summaries = append(summaries, UserSummary{UserProfile: newUserProfile(&users[idx]), IsLocked: users[idx].IsLocked})
+	}
+	return summaries, nil
+}
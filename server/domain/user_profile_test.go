@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"context"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+var userColumns = []string{"id", "username", "email", "salt", "password", "avatar", "theme", "theme_editor",
+	"real_name", "phone_no", "locale", "islock"}
+
+func TestDetailMyProfile(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should return profile of the user in session", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "tom.png", "dark", "vim", "Tom", "123", "zh", false))
+
+		profile, err := DetailMyProfile(&sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*profile).To(Equal(UserProfile{ID: 100, Username: "tom", Email: "tom@example.com", Avatar: "tom.png",
+			Theme: "dark", ThemeEditor: "vim", RealName: "Tom", PhoneNo: "123", Locale: "zh"}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return not found when user is absent", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows(userColumns))
+
+		profile, err := DetailMyProfile(&sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}})
+		Expect(err).To(Equal(gorm.ErrRecordNotFound))
+		Expect(profile).To(BeNil())
+	})
+}
+
+func TestPatchMyProfile(t *testing.T) {
+	RegisterTestingT(t)
+
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+	strOf := func(v string) *string { return &v }
+
+	t.Run("should update the specified fields only", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("new@example.com", 100).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `email`=?,`email_verified`=?,`locale`=?,`update_time`=? WHERE id = ?")).
+			WithArgs("new@example.com", false, "zh-TW", testinfra.AnyArgument{}, 100).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND `user`.`id` = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100, 100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "new@example.com", "salt", "hashed", "", "", "", "Tom", "", "zh-TW", false))
+		mock.ExpectCommit()
+
+		profile, err := PatchMyProfile(&UserPatch{Email: strOf("new@example.com"), Locale: strOf("zh-tw")}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*profile).To(Equal(UserProfile{ID: 100, Username: "tom", Email: "new@example.com",
+			RealName: "Tom", Locale: "zh-TW"}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should keep email verification if email is unchanged", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(append(userColumns, "email_verified")).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false, true))
+		mock.ExpectCommit()
+
+		profile, err := PatchMyProfile(&UserPatch{Email: strOf("tom@example.com")}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(profile.EmailVerified).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should record the reference of avatar when avatar is changed", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "tom.png", "", "", "Tom", "", "", false))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `avatar`=?,`update_time`=? WHERE id = ?")).
+			WithArgs("/v1/files/1/content", testinfra.AnyArgument{}, 100).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, sha256 FROM `file` WHERE id IN (?) AND owner = ?")).
+			WithArgs(1, 100).WillReturnRows(sqlmock.NewRows([]string{"id", "sha256"}).AddRow(1, helloSHA256))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `file_ref` WHERE resource_type = ? AND resource_id IN (?)")).
+			WithArgs(FileRefAvatar, 100).WillReturnRows(sqlmock.NewRows(fileRefColumns))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `file_ref` (`sha256`,`resource_type`,`resource_id`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(helloSHA256, FileRefAvatar, 100, testinfra.AnyId{}).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `file_blob` SET `ref_count`=ref_count + ?,`touch_time`=? WHERE sha256 = ?")).
+			WithArgs(1, testinfra.AnyArgument{}, helloSHA256).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND `user`.`id` = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100, 100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "/v1/files/1/content", "", "", "Tom", "", "", false))
+		mock.ExpectCommit()
+
+		profile, err := PatchMyProfile(&UserPatch{Avatar: strOf("/v1/files/1/content")}, s)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(profile.Avatar).To(Equal("/v1/files/1/content"))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fail when email is used by others", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("jerry@example.com", 100).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		profile, err := PatchMyProfile(&UserPatch{Email: strOf("jerry@example.com")}, s)
+		Expect(err).To(Equal(ErrEmailConflict))
+		Expect(profile).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject malformed locale", func(t *testing.T) {
+		testinfra.SetUpMockSql()
+
+		profile, err := PatchMyProfile(&UserPatch{Locale: strOf("not a locale")}, s)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*fail.ErrBadParam).Param).To(Equal("locale"))
+		Expect(profile).To(BeNil())
+	})
+}
+
+func TestDetailAuthorProfile(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should return public profile with published articles", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "tom.png", "", "", "Tom", "123", "", false))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, type, title, slug, uid, create_time, modify_time, status, is_invalid, "+
+			"abstracts, abstracts_generated, source, is_elite, is_top, view_num, comment_num, "+
+			"toc, word_count, char_count, reading_minutes, code_block_count, image_count FROM `article` "+
+			"WHERE uid = ? AND is_invalid = 0 AND status = ? ORDER BY create_time DESC LIMIT 10 OFFSET 10")).
+			WithArgs(100, ArticleStatusPublished).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "type", "title", "uid", "status"}).AddRow(1000, 1, "title", 100, 1))
+
+		QueryTagAssignmentsFunc = func(resIds []types.ID, s *sessions.Session) ([]TagAssignment, error) {
+			return []TagAssignment{}, nil
+		}
+		QueryTagsFunc = func(q TagQuery, s *sessions.Session) ([]Tag, error) {
+			return []Tag{}, nil
+		}
+
+		profile, err := DetailAuthorProfile(100, 2, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(profile.ID).To(Equal(types.ID(100)))
+		Expect(profile.Username).To(Equal("tom"))
+		Expect(profile.Avatar).To(Equal("tom.png"))
+		Expect(profile.RealName).To(Equal("Tom"))
+		Expect(len(profile.Articles)).To(Equal(1))
+		Expect(profile.Articles[0].ID).To(Equal(types.ID(1000)))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return not found when user is absent or locked", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).WillReturnRows(sqlmock.NewRows(userColumns))
+
+		profile, err := DetailAuthorProfile(100, 1, &sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(gorm.ErrRecordNotFound))
+		Expect(profile).To(BeNil())
+	})
+}
+
+func TestQueryUsers(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should list users with keyword", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE username LIKE ? OR email LIKE ? OR real_name LIKE ? "+
+			"ORDER BY create_time DESC LIMIT 10")).
+			WithArgs("%to%", "%to%", "%to%").
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", true))
+
+		users, err := QueryUsers(UserQuery{KeyWord: "to"}, &sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(Equal([]UserSummary{{UserProfile: UserProfile{ID: 100, Username: "tom",
+			Email: "tom@example.com", RealName: "Tom"}, IsLocked: true}}))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
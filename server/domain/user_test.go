@@ -1,9 +1,21 @@
 package domain
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	. "github.com/onsi/gomega"
+	"github.com/sony/sonyflake"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func TestUserTableName(t *testing.T) {
@@ -23,3 +35,194 @@ func TestUserIdentityTableName(t *testing.T) {
 		Expect(r.TableName()).To(Equal("user_identity"))
 	})
 }
+
+func TestCreateUser(t *testing.T) {
+	RegisterTestingT(t)
+
+	idWorker = sonyflake.NewSonyflake(sonyflake.Settings{MachineID: func() (uint16, error) { return 1, nil }})
+
+	t.Run("should be able to create user", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user` (`username`,`email`,`email_verified`,`salt`,`password`,`avatar`,`theme`,"+
+			"`theme_editor`,`real_name`,`phone_no`,`locale`,`islock`,`create_time`,`update_time`,`id`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")).
+			WithArgs("tom", "tom@example.com", false, testinfra.AnyArgument{}, testinfra.AnyArgument{},
+				"", "", "", "Tom", "", "", false, testinfra.AnyArgument{}, testinfra.AnyArgument{}, testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user_identity` (`user`,`auth_channel`,`channel_key`,`id`) VALUES (?,?,?,?)")).
+			WithArgs(testinfra.AnyId{}, AuthChannelInternal, "tom", testinfra.AnyId{}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		u, err := CreateUser(&UserCreate{Username: "tom", Email: "tom@example.com", Password: "secret", RealName: "Tom"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(u.ID).ToNot(BeZero())
+		Expect(u.Salt).ToNot(BeEmpty())
+		Expect(u.Password).ToNot(Equal("secret"))
+		Expect(bcrypt.CompareHashAndPassword([]byte(u.Password), saltedDigest("secret", u.Salt))).To(BeNil())
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fail when username conflict", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		u, err := CreateUser(&UserCreate{Username: "tom", Email: "tom@example.com", Password: "secret"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(ErrUsernameConflict))
+		Expect(u).To(BeNil())
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fail when email conflict", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		u, err := CreateUser(&UserCreate{Username: "tom", Email: "tom@example.com", Password: "secret"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(ErrEmailConflict))
+		Expect(u).To(BeNil())
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fail when email is taken concurrently", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE username = ?")).
+			WithArgs("tom").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `user` WHERE email = ? AND id <> ?")).
+			WithArgs("tom@example.com", 0).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `user`")).WillReturnError(&mysql.MySQLError{Number: 1062,
+			Message: "Duplicate entry 'tom@example.com' for key 'user.uk_user_email'"})
+		mock.ExpectRollback()
+
+		u, err := CreateUser(&UserCreate{Username: "tom", Email: "tom@example.com", Password: "secret"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(ErrEmailConflict))
+		Expect(u).To(BeNil())
+
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestLockUser(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be able to lock user", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `islock`=?,`update_time`=? WHERE username = ?")).
+			WithArgs(true, testinfra.AnyArgument{}, "tom").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(LockUser("tom", true, &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return not found when user is absent", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `islock`=?,`update_time`=? WHERE username = ?")).
+			WithArgs(false, testinfra.AnyArgument{}, "tom").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		Expect(LockUser("tom", false, &sessions.Session{Context: context.TODO()})).To(Equal(gorm.ErrRecordNotFound))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestLockUserByID(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be able to lock user by id", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `islock`=?,`update_time`=? WHERE id = ?")).
+			WithArgs(true, testinfra.AnyArgument{}, 100).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(LockUserByID(100, true, &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should end the sessions of the locked user", func(t *testing.T) {
+		sessions.TokenCache.Add("locked-token", &sessions.Session{Token: "locked-token", Identity: sessions.Identity{ID: 300}}, time.Minute)
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `islock`=?,`update_time`=? WHERE id = ?")).
+			WithArgs(true, testinfra.AnyArgument{}, 300).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(LockUserByID(300, true, &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		_, found := sessions.TokenCache.Get("locked-token")
+		Expect(found).To(BeFalse())
+	})
+}
+
+func TestUserSerialization(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("salt and password should never be serialized", func(t *testing.T) {
+		buf, err := json.Marshal(&User{ID: 1, Username: "tom", Salt: "salt-value", Password: "password-value"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf)).ToNot(ContainSubstring("salt"))
+		Expect(string(buf)).ToNot(ContainSubstring("password"))
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be able to reset password", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `password`=?,`salt`=?,`update_time`=? WHERE username = ?")).
+			WithArgs(testinfra.AnyArgument{}, testinfra.AnyArgument{}, testinfra.AnyArgument{}, "tom").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(ResetPassword("tom", "new-secret", &sessions.Session{Context: context.TODO()})).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should return error on database error", func(t *testing.T) {
+		_, mock := testinfra.SetUpMockSql()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `password`=?,`salt`=?,`update_time`=? WHERE username = ?")).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		Expect(ResetPassword("tom", "new-secret", &sessions.Session{Context: context.TODO()})).To(Equal(sql.ErrConnDone))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+}
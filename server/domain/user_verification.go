@@ -0,0 +1,230 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"owlet/server/infra/localize"
+	"owlet/server/infra/logging"
+	"owlet/server/infra/mailer"
+	"owlet/server/infra/meta"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tokens"
+	"strconv"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"gorm.io/gorm"
+)
+
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailVerify   = "email_verify"
+)
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email,lte=255"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token" binding:"required,lte=1024"`
+	Password string `json:"password" binding:"required,gte=6,lte=64"`
+}
+
+type EmailVerification struct {
+	Token string `json:"token" binding:"required,lte=1024"`
+}
+
+var (
+	PasswordResetTokenTTL = 30 * time.Minute
+	EmailVerifyTokenTTL   = 24 * time.Hour
+
+	// the pages (of web client) which the links in mails point to, the token is in query parameter 'token'
+	PasswordResetPage = "/reset-password"
+	EmailVerifyPage   = "/verify-email"
+
+	RequestPasswordResetFunc     = RequestPasswordReset
+	ResetPasswordByTokenFunc     = ResetPasswordByToken
+	RequestEmailVerificationFunc = RequestEmailVerification
+	VerifyEmailFunc              = VerifyEmail
+)
+
+// the messages of mails in i18n bundles, each has a '.subject' and a '.body'
+const (
+	mailPasswordReset = "mail.password_reset"
+	mailEmailVerify   = "mail.email_verify"
+)
+
+// MailSendTimeout the timeout of the mails sent in background
+var MailSendTimeout = time.Minute
+
+// passwordFingerprint changes once the password is reset, so a reset token can be used only once
+func passwordFingerprint(u *User) string {
+	return tokens.Fingerprint(u.Salt, u.Password)
+}
+
+// emailFingerprint changes once the email is verified or changed, so a verify token can be used only once
+func emailFingerprint(u *User) string {
+	return tokens.Fingerprint(u.Email, strconv.FormatBool(u.EmailVerified))
+}
+
+// RequestPasswordReset mail a password reset link to the user with email.
+// Nothing happens if the email is unknown, and the mail is sent in background,
+// so the caller can not tell whether an email is registered, neither by the response nor by the response time.
+func RequestPasswordReset(email string, s *sessions.Session) error {
+	var u User
+	err := persistence.ActiveGormDB.WithContext(s.Context).Where("email = ? AND islock = 0", email).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the request context is done once responded, only the localizer and the log fields are kept
+	ctx := localize.NewContext(logging.WithFields(context.Background(), s.Logger().Data), localize.FromContext(s.Context))
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, MailSendTimeout)
+		defer cancel()
+		bs := &sessions.Session{Context: ctx}
+		if err := mailPasswordResetLink(&u, bs); err != nil {
+			bs.Logger().Warnf("failed to mail password reset link to user %s: %v", u.ID, err)
+		}
+	}()
+	return nil
+}
+
+func mailPasswordResetLink(u *User, s *sessions.Session) error {
+	token, err := tokens.Issue(TokenPurposePasswordReset, u.ID, passwordFingerprint(u), PasswordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+	return sendUserMail(u, mailPasswordReset, map[string]interface{}{
+		"Name":          displayName(u),
+		"Link":          meta.PublicURL() + PasswordResetPage + "?token=" + url.QueryEscape(token),
+		"ExpireMinutes": int(PasswordResetTokenTTL.Minutes()),
+	}, s)
+}
+
+// ResetPasswordByToken replace the password of the user which the token is issued to, the token is used only once.
+func ResetPasswordByToken(r *PasswordReset, s *sessions.Session) error {
+	claims, err := tokens.Parse(r.Token, TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+	u, err := userOfToken(claims, passwordFingerprint, s)
+	if err != nil {
+		return err
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	hashed, err := hashPassword(r.Password, salt)
+	if err != nil {
+		return err
+	}
+	// the old salt in condition prevents the token from being used concurrently
+	db := persistence.ActiveGormDB.WithContext(s.Context).Model(&User{}).
+		Where("id = ? AND salt = ?", u.ID, u.Salt).
+		Updates(map[string]interface{}{"salt": salt, "password": hashed, "update_time": types.CurrentTimestamp()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return tokens.ErrTokenUsed
+	}
+	// the password may be reset because the account is compromised, the sessions logged in before are ended
+	sessions.RevokeSessions(u.ID)
+	return nil
+}
+
+// RequestEmailVerification mail a verify link to the email of the user in session, nothing happens if it is verified.
+func RequestEmailVerification(s *sessions.Session) error {
+	var u User
+	if err := persistence.ActiveGormDB.WithContext(s.Context).Where("id = ?", s.Identity.ID).First(&u).Error; err != nil {
+		return err
+	}
+	if u.EmailVerified {
+		return nil
+	}
+
+	token, err := tokens.Issue(TokenPurposeEmailVerify, u.ID, emailFingerprint(&u), EmailVerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	return sendUserMail(&u, mailEmailVerify, map[string]interface{}{
+		"Name":        displayName(&u),
+		"Link":        meta.PublicURL() + EmailVerifyPage + "?token=" + url.QueryEscape(token),
+		"ExpireHours": int(EmailVerifyTokenTTL.Hours()),
+	}, s)
+}
+
+// VerifyEmail mark the email of the user which the token is issued to as verified, the token is used only once.
+func VerifyEmail(token string, s *sessions.Session) error {
+	claims, err := tokens.Parse(token, TokenPurposeEmailVerify)
+	if err != nil {
+		return err
+	}
+	u, err := userOfToken(claims, emailFingerprint, s)
+	if err != nil {
+		return err
+	}
+
+	db := persistence.ActiveGormDB.WithContext(s.Context).Model(&User{}).
+		Where("id = ? AND email = ? AND email_verified = 0", u.ID, u.Email).
+		Updates(map[string]interface{}{"email_verified": true, "update_time": types.CurrentTimestamp()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return tokens.ErrTokenUsed
+	}
+	return nil
+}
+
+// userOfToken load the user which the token is issued to, and check that the token is not used yet
+func userOfToken(claims *tokens.Claims, fingerprint func(*User) string, s *sessions.Session) (*User, error) {
+	var u User
+	err := persistence.ActiveGormDB.WithContext(s.Context).Where("id = ? AND islock = 0", claims.Subject).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, tokens.ErrTokenUsed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint(&u) != claims.Fingerprint {
+		return nil, tokens.ErrTokenUsed
+	}
+	return &u, nil
+}
+
+// sendUserMail render the mail in the preferred language of user (or the language of request).
+// The messages are loaded from the active bundle, since the mail may be sent out of requests.
+func sendUserMail(u *User, messageID string, data map[string]interface{}, s *sessions.Session) error {
+	lang := u.Locale
+	if lang == "" {
+		lang = localize.FromContext(s.Context).Language().String()
+	}
+	l := localize.LocalizerOf(lang)
+	subject, err := l.Localize(&i18n.LocalizeConfig{MessageID: messageID + ".subject", TemplateData: data})
+	if err != nil {
+		return err
+	}
+	text, err := l.Localize(&i18n.LocalizeConfig{MessageID: messageID + ".body", TemplateData: data})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Active.Send(s.Context, &mailer.Message{To: []string{u.Email}, Subject: subject, Text: text})
+}
+
+func displayName(u *User) string {
+	if u.RealName != "" {
+		return u.RealName
+	}
+	return u.Username
+}
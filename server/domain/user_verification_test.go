@@ -0,0 +1,242 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/localize"
+	"owlet/server/infra/mailer"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tokens"
+	"owlet/server/testinfra"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+}
+
+func (r *recordingMailer) Send(ctx context.Context, m *mailer.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// sent the messages sent, the mails may be sent in background
+func (r *recordingMailer) sent() []*mailer.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*mailer.Message{}, r.messages...)
+}
+
+func useRecordingMailer() (*recordingMailer, func()) {
+	origin := mailer.Active
+	r := &recordingMailer{}
+	mailer.Active = r
+	return r, func() { mailer.Active = origin }
+}
+
+// tokenOfLink extract the token from the link in mail body
+func tokenOfLink(body string) string {
+	link := regexp.MustCompile(`https?://\S+`).FindString(body)
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("token")
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	RegisterTestingT(t)
+
+	tokens.SetSecret([]byte("test-secret"))
+
+	t.Run("should do nothing if email is unknown", func(t *testing.T) {
+		recorder, restore := useRecordingMailer()
+		defer restore()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE email = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs("nobody@example.com").WillReturnRows(sqlmock.NewRows(userColumns))
+
+		Expect(RequestPasswordReset("nobody@example.com", &sessions.Session{Context: context.TODO()})).To(Succeed())
+		Consistently(recorder.sent, 50*time.Millisecond).Should(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should mail reset link bound to the current password in background", func(t *testing.T) {
+		localize.LocalizeMiddleware("../../i18n")
+		recorder, restore := useRecordingMailer()
+		defer restore()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE email = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs("tom@example.com").
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		Expect(RequestPasswordReset("tom@example.com", &sessions.Session{Context: ctx})).To(Succeed())
+		cancel() // the request is responded
+		Eventually(recorder.sent).Should(HaveLen(1))
+		m := recorder.sent()[0]
+		Expect(m.To).To(Equal([]string{"tom@example.com"}))
+		Expect(m.Subject).To(Equal("Reset your password"))
+		Expect(m.Text).To(HavePrefix("Hi Tom,"))
+		Expect(m.Text).To(ContainSubstring("http://localhost/reset-password?token="))
+		Expect(m.Text).To(ContainSubstring("30 minutes"))
+
+		claims, err := tokens.Parse(tokenOfLink(m.Text), TokenPurposePasswordReset)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Subject).To(Equal(types.ID(100)))
+		Expect(claims.Fingerprint).To(Equal(passwordFingerprint(&User{Salt: "salt", Password: "hashed"})))
+	})
+}
+
+func TestResetPasswordByToken(t *testing.T) {
+	RegisterTestingT(t)
+
+	tokens.SetSecret([]byte("test-secret"))
+	tom := &User{ID: 100, Salt: "salt", Password: "hashed"}
+
+	t.Run("should reset password, invalidate the token and end the sessions of user", func(t *testing.T) {
+		token, _ := tokens.Issue(TokenPurposePasswordReset, 100, passwordFingerprint(tom), time.Minute)
+		sessions.TokenCache.Set("tom-reset-token", &sessions.Session{Token: "tom-reset-token",
+			Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `password`=?,`salt`=?,`update_time`=? WHERE id = ? AND salt = ?")).
+			WithArgs(testinfra.AnyArgument{}, testinfra.AnyArgument{}, testinfra.AnyArgument{}, 100, "salt").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		Expect(ResetPasswordByToken(&PasswordReset{Token: token, Password: "new-secret"},
+			&sessions.Session{Context: context.TODO()})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+		_, found := sessions.TokenCache.Get("tom-reset-token")
+		Expect(found).To(BeFalse())
+	})
+
+	t.Run("should reject used token", func(t *testing.T) {
+		token, _ := tokens.Issue(TokenPurposePasswordReset, 100, passwordFingerprint(tom), time.Minute)
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, "tom", "tom@example.com", "new-salt", "new-hashed", "", "", "", "Tom", "", "", false))
+
+		err := ResetPasswordByToken(&PasswordReset{Token: token, Password: "new-secret"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(err).To(Equal(tokens.ErrTokenUsed))
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject token of other purpose", func(t *testing.T) {
+		token, _ := tokens.Issue(TokenPurposeEmailVerify, 100, passwordFingerprint(tom), time.Minute)
+		err := ResetPasswordByToken(&PasswordReset{Token: token, Password: "new-secret"},
+			&sessions.Session{Context: context.TODO()})
+		Expect(errors.Is(err, fail.ErrInvalidToken)).To(BeTrue())
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	RegisterTestingT(t)
+
+	tokens.SetSecret([]byte("test-secret"))
+	s := &sessions.Session{Context: context.TODO(), Identity: sessions.Identity{ID: 100}}
+	verifiedColumns := append(append([]string{}, userColumns...), "email_verified")
+
+	t.Run("should do nothing if email is verified", func(t *testing.T) {
+		recorder, restore := useRecordingMailer()
+		defer restore()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(verifiedColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "Tom", "", "", false, true))
+
+		Expect(RequestEmailVerification(s)).To(Succeed())
+		Expect(recorder.messages).To(BeEmpty())
+	})
+
+	t.Run("should mail verify link in the preferred language of user, and verify email once", func(t *testing.T) {
+		localize.LocalizeMiddleware("../../i18n")
+		recorder, restore := useRecordingMailer()
+		defer restore()
+
+		_, mock := testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(verifiedColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "", "", "zh", false, false))
+
+		Expect(RequestEmailVerification(s)).To(Succeed())
+		Expect(len(recorder.messages)).To(Equal(1))
+		m := recorder.messages[0]
+		Expect(m.Subject).To(Equal("验证邮箱地址"))
+		Expect(m.Text).To(HavePrefix("tom，你好"))
+		Expect(m.Text).To(ContainSubstring("24 小时"))
+		token := tokenOfLink(m.Text)
+
+		_, mock = testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(verifiedColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "", "", "zh", false, false))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE `user` SET `email_verified`=?,`update_time`=? "+
+			"WHERE id = ? AND email = ? AND email_verified = 0")).
+			WithArgs(true, testinfra.AnyArgument{}, 100, "tom@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		Expect(VerifyEmail(token, &sessions.Session{Context: context.TODO()})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).ShouldNot(HaveOccurred())
+
+		// verified already
+		_, mock = testinfra.SetUpMockSql()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `user` WHERE id = ? AND islock = 0 ORDER BY `user`.`id` LIMIT 1")).
+			WithArgs(100).
+			WillReturnRows(sqlmock.NewRows(verifiedColumns).
+				AddRow(100, "tom", "tom@example.com", "salt", "hashed", "", "", "", "", "", "zh", false, true))
+		Expect(VerifyEmail(token, &sessions.Session{Context: context.TODO()})).To(Equal(tokens.ErrTokenUsed))
+	})
+}
+
+func TestMailTemplatesTranslated(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("all mail templates should be translated", func(t *testing.T) {
+		for _, file := range []string{"../../i18n/en.yaml", "../../i18n/zh.yaml"} {
+			buf, err := ioutil.ReadFile(file)
+			Expect(err).ToNot(HaveOccurred())
+			messages := map[string]interface{}{}
+			Expect(yaml.Unmarshal(buf, &messages)).To(Succeed())
+			for _, mail := range []string{mailPasswordReset, mailEmailVerify} {
+				for _, id := range []string{mail + ".subject", mail + ".body"} {
+					Expect(messages).To(HaveKey(id), file+" lacks "+id)
+					Expect(strings.TrimSpace(messages[id].(string))).ToNot(BeEmpty())
+				}
+			}
+		}
+	})
+}
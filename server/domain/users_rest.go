@@ -0,0 +1,338 @@
+package domain
+
+import (
+	"net/http"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/misc"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	PathUsers      = "/v1/users"
+	PathAdminUsers = "/v1/admin/users"
+)
+
+type authorProfileQuery struct {
+	Page int `form:"page"` // base 1
+}
+
+// RegisterUsersRestAPI the profile of the current user requires login, author profiles are public
+func RegisterUsersRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathUsers, middleWares...)
+	g.GET("me", sessions.SessionFilter(), handleDetailMyProfile)
+	g.PATCH("me", sessions.SessionFilter(), handlePatchMyProfile)
+	g.POST("me/email-verification", sessions.SessionFilter(), handleRequestEmailVerification)
+	g.GET("me/identities", sessions.SessionFilter(), handleQueryMyIdentities)
+	g.GET("me/usage", sessions.SessionFilter(), handleQueryMyFileUsage)
+	g.DELETE("me/identities/:id", sessions.SessionFilter(), handleUnlinkMyIdentity)
+	g.POST("me/mfa", sessions.SessionFilter(), handleBeginMFAEnrollment)
+	g.POST("me/mfa/confirm", sessions.SessionFilter(), handleConfirmMFAEnrollment)
+	g.POST("me/mfa/recovery-codes", sessions.SessionFilter(), handleRegenerateMFARecoveryCodes)
+	g.DELETE("me/mfa", sessions.SessionFilter(), handleDisableMyMFA)
+	g.POST("email-verification/confirm", handleVerifyEmail)
+	g.POST("password-reset", handleRequestPasswordReset)
+	g.POST("password-reset/confirm", handleResetPassword)
+	g.GET(":id", handleDetailAuthorProfile)
+}
+
+// RegisterUsersAdminRestAPI the middleWares should restrict the access to administrators
+func RegisterUsersAdminRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathAdminUsers, middleWares...)
+	g.GET("", handleQueryUsers)
+	g.PUT(":id/lock", handleLockUser)
+	g.DELETE(":id/lock", handleUnlockUser)
+	g.DELETE(":id/mfa", handleResetUserMFA)
+}
+
+// @ID user-me-detail
+// @Success 200 {object} domain.UserProfile
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me [get]
+func handleDetailMyProfile(c *gin.Context) {
+	profile, err := DetailMyProfileFunc(sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// @ID user-me-patch
+// @Accept  json
+// @Param patch body domain.UserPatch true "fields to update"
+// @Success 200 {object} domain.UserProfile
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me [patch]
+func handlePatchMyProfile(c *gin.Context) {
+	patch := UserPatch{}
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+
+	s := sessions.ExtractSessionFromGinContext(c)
+	profile, err := PatchMyProfileFunc(&patch, s)
+	if err != nil {
+		panic(err)
+	}
+	// the language preference takes effect in the following requests of the session
+	sessions.UpdateCachedIdentity(s.Token, func(identity *sessions.Identity) {
+		identity.Locale = profile.Locale
+	})
+	c.JSON(http.StatusOK, profile)
+}
+
+// @ID user-me-identity-list
+// @Success 200 {array} domain.UserIdentity
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/identities [get]
+func handleQueryMyIdentities(c *gin.Context) {
+	identities, err := QueryMyIdentitiesFunc(sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// @ID user-me-usage
+// @Success 200 {object} domain.FileUsageReport
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/usage [get]
+func handleQueryMyFileUsage(c *gin.Context) {
+	usage, err := QueryMyFileUsageFunc(sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// @ID user-me-identity-unlink
+// @Param id path uint64 true "id"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/identities/{id} [delete]
+func handleUnlinkMyIdentity(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	if err := UnlinkMyIdentityFunc(id, sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID user-me-mfa-enroll
+// @Success 200 {object} domain.MFAEnrollment
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/mfa [post]
+func handleBeginMFAEnrollment(c *gin.Context) {
+	enrollment, err := BeginMFAEnrollmentFunc(sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// @ID user-me-mfa-confirm
+// @Accept  json
+// @Param code body domain.MFACode true "the TOTP code of the new secret"
+// @Success 200 {object} domain.MFARecoveryCodes
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/mfa/confirm [post]
+func handleConfirmMFAEnrollment(c *gin.Context) {
+	body := MFACode{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	codes, err := ConfirmMFAEnrollmentFunc(body.Code, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// @ID user-me-mfa-recovery-codes
+// @Accept  json
+// @Param code body domain.MFACode true "a TOTP code"
+// @Success 200 {object} domain.MFARecoveryCodes
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/mfa/recovery-codes [post]
+func handleRegenerateMFARecoveryCodes(c *gin.Context) {
+	body := MFACode{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	codes, err := RegenerateMFARecoveryCodesFunc(body.Code, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// @ID user-me-mfa-disable
+// @Accept  json
+// @Param code body domain.MFACode true "a TOTP code or a recovery code"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/mfa [delete]
+func handleDisableMyMFA(c *gin.Context) {
+	body := MFACode{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	if err := DisableMyMFAFunc(body.Code, sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID user-password-reset-request
+// @Accept  json
+// @Param request body domain.PasswordResetRequest true "the email of account"
+// @Success 202
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/password-reset [post]
+func handleRequestPasswordReset(c *gin.Context) {
+	body := PasswordResetRequest{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	if err := RequestPasswordResetFunc(body.Email, &sessions.Session{Context: c.Request.Context()}); err != nil {
+		panic(err)
+	}
+	// accepted whether the email is registered or not
+	c.Status(http.StatusAccepted)
+}
+
+// @ID user-password-reset-confirm
+// @Accept  json
+// @Param reset body domain.PasswordReset true "token in mail and the new password"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/password-reset/confirm [post]
+func handleResetPassword(c *gin.Context) {
+	body := PasswordReset{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	if err := ResetPasswordByTokenFunc(&body, &sessions.Session{Context: c.Request.Context()}); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID user-me-email-verification-request
+// @Success 202
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/me/email-verification [post]
+func handleRequestEmailVerification(c *gin.Context) {
+	if err := RequestEmailVerificationFunc(sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// @ID user-email-verification-confirm
+// @Accept  json
+// @Param verification body domain.EmailVerification true "token in mail"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/email-verification/confirm [post]
+func handleVerifyEmail(c *gin.Context) {
+	body := EmailVerification{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+	if err := VerifyEmailFunc(body.Token, &sessions.Session{Context: c.Request.Context()}); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID user-author-detail
+// @Param id path uint64 true "id"
+// @Param page query int false "page number of articles based 1"
+// @Success 200 {object} domain.AuthorProfile
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/users/{id} [get]
+func handleDetailAuthorProfile(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	q := authorProfileQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+
+	profile, err := DetailAuthorProfileFunc(id, q.Page, &sessions.Session{Context: c.Request.Context()})
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// @ID admin-user-list
+// @Param kw query string false "keyword of username, email or real name"
+// @Param page query int false "page number based 1"
+// @Success 200 {array} domain.UserSummary
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/users [get]
+func handleQueryUsers(c *gin.Context) {
+	q := UserQuery{}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		panic(&fail.ErrBadParam{Cause: err})
+	}
+
+	users, err := QueryUsersFunc(q, sessions.ExtractSessionFromGinContext(c))
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// @ID admin-user-lock
+// @Param id path uint64 true "id"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/users/{id}/lock [put]
+func handleLockUser(c *gin.Context) {
+	setUserLocked(c, true)
+}
+
+// @ID admin-user-unlock
+// @Param id path uint64 true "id"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/users/{id}/lock [delete]
+func handleUnlockUser(c *gin.Context) {
+	setUserLocked(c, false)
+}
+
+func setUserLocked(c *gin.Context, locked bool) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	if err := LockUserByIDFunc(id, locked, sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @ID admin-user-mfa-reset
+// @Param id path uint64 true "id"
+// @Success 204
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/users/{id}/mfa [delete]
+func handleResetUserMFA(c *gin.Context) {
+	id, err := misc.BindingPathID(c)
+	if err != nil {
+		panic(err)
+	}
+	if err := ResetUserMFAFunc(id, sessions.ExtractSessionFromGinContext(c)); err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,419 @@
+package domain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tokens"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+func TestMyProfileAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should require login", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/me", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	t.Run("should be able to get profile of current user", func(t *testing.T) {
+		var in *sessions.Session
+		DetailMyProfileFunc = func(s *sessions.Session) (*UserProfile, error) {
+			in = s
+			return &UserProfile{ID: 100, Username: "tom", Email: "tom@example.com", Locale: "zh"}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/me", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"id": "100", "username": "tom", "email": "tom@example.com", "email_verified": false, "avatar": "",
+			"theme": "", "theme_editor": "", "real_name": "", "phone_no": "", "locale": "zh",
+			"create_time": null, "modify_time": null}`))
+		Expect(in.Identity.ID).To(Equal(types.ID(100)))
+	})
+
+	t.Run("should be able to patch profile and refresh the language preference of session", func(t *testing.T) {
+		var in *UserPatch
+		PatchMyProfileFunc = func(p *UserPatch, s *sessions.Session) (*UserProfile, error) {
+			in = p
+			return &UserProfile{ID: 100, Username: "tom", Email: "tom@example.com", Locale: "zh-TW"}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, PathUsers+"/me", strings.NewReader(`{"locale": "zh-TW"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(*in.Locale).To(Equal("zh-TW"))
+		Expect(in.Email).To(BeNil())
+
+		cached, _ := sessions.TokenCache.Get("tom-token")
+		Expect(cached.(*sessions.Session).Identity.Locale).To(Equal("zh-TW"))
+	})
+
+	t.Run("should respond conflict when email is used", func(t *testing.T) {
+		PatchMyProfileFunc = func(p *UserPatch, s *sessions.Session) (*UserProfile, error) {
+			return nil, ErrEmailConflict
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, PathUsers+"/me", strings.NewReader(`{"email": "jerry@example.com"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusConflict))
+		Expect(body).To(MatchJSON(`{"code": "common.conflict", "message": "email is already used", "data": null}`))
+	})
+
+	t.Run("should validate patch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, PathUsers+"/me", strings.NewReader(`{"email": "not-email"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring(`"code":"bad_request.validation_failed"`))
+	})
+}
+
+func TestAuthorProfileAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	t.Run("should be able to get public profile without login", func(t *testing.T) {
+		var inID types.ID
+		var inPage int
+		DetailAuthorProfileFunc = func(id types.ID, page int, s *sessions.Session) (*AuthorProfile, error) {
+			inID, inPage = id, page
+			return &AuthorProfile{ID: id, Username: "tom", Articles: []ArticleMetaExt{}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/100?page=2", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"id": "100", "username": "tom", "avatar": "", "real_name": "", "articles": []}`))
+		Expect(inID).To(Equal(types.ID(100)))
+		Expect(inPage).To(Equal(2))
+	})
+
+	t.Run("should respond not found when user is absent", func(t *testing.T) {
+		DetailAuthorProfileFunc = func(id types.ID, page int, s *sessions.Session) (*AuthorProfile, error) {
+			return nil, gorm.ErrRecordNotFound
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/100", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+}
+
+func TestUsersAdminAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersAdminRestAPI(router)
+
+	t.Run("should be able to list users", func(t *testing.T) {
+		var in UserQuery
+		QueryUsersFunc = func(q UserQuery, s *sessions.Session) ([]UserSummary, error) {
+			in = q
+			return []UserSummary{{UserProfile: UserProfile{ID: 100, Username: "tom"}, IsLocked: true}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathAdminUsers+"?kw=to&page=2", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"is_locked":true`))
+		Expect(body).ToNot(ContainSubstring("salt"))
+		Expect(in).To(Equal(UserQuery{KeyWord: "to", Page: 2}))
+	})
+
+	t.Run("should be able to lock and unlock user", func(t *testing.T) {
+		var inID types.ID
+		var inLocked bool
+		LockUserByIDFunc = func(id types.ID, locked bool, s *sessions.Session) error {
+			inID, inLocked = id, locked
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodPut, PathAdminUsers+"/100/lock", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(inID).To(Equal(types.ID(100)))
+		Expect(inLocked).To(BeTrue())
+
+		req = httptest.NewRequest(http.MethodDelete, PathAdminUsers+"/100/lock", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(inLocked).To(BeFalse())
+	})
+
+	t.Run("should respond not found when user is absent", func(t *testing.T) {
+		LockUserByIDFunc = func(id types.ID, locked bool, s *sessions.Session) error {
+			return gorm.ErrRecordNotFound
+		}
+
+		req := httptest.NewRequest(http.MethodPut, PathAdminUsers+"/100/lock", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	t.Run("should be able to reset MFA of user", func(t *testing.T) {
+		var inID types.ID
+		ResetUserMFAFunc = func(id types.ID, s *sessions.Session) error {
+			inID = id
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, PathAdminUsers+"/100/mfa", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(inID).To(Equal(types.ID(100)))
+	})
+}
+
+func TestUserVerificationAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should accept password reset request", func(t *testing.T) {
+		var in string
+		RequestPasswordResetFunc = func(email string, s *sessions.Session) error {
+			in = email
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/password-reset", strings.NewReader(`{"email": "tom@example.com"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(in).To(Equal("tom@example.com"))
+	})
+
+	t.Run("should reset password by token", func(t *testing.T) {
+		var in *PasswordReset
+		ResetPasswordByTokenFunc = func(r *PasswordReset, s *sessions.Session) error {
+			in = r
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/password-reset/confirm",
+			strings.NewReader(`{"token": "t", "password": "new-secret"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(*in).To(Equal(PasswordReset{Token: "t", Password: "new-secret"}))
+	})
+
+	t.Run("should respond bad request when token is invalid", func(t *testing.T) {
+		ResetPasswordByTokenFunc = func(r *PasswordReset, s *sessions.Session) error {
+			return tokens.ErrTokenExpired
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/password-reset/confirm",
+			strings.NewReader(`{"token": "t", "password": "new-secret"}`))
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring(`"code":"security.invalid_token"`))
+	})
+
+	t.Run("should require login to request email verification", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/me/email-verification", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		var in *sessions.Session
+		RequestEmailVerificationFunc = func(s *sessions.Session) error {
+			in = s
+			return nil
+		}
+		req = httptest.NewRequest(http.MethodPost, PathUsers+"/me/email-verification", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(in.Identity.ID).To(Equal(types.ID(100)))
+	})
+
+	t.Run("should verify email by token", func(t *testing.T) {
+		var in string
+		VerifyEmailFunc = func(token string, s *sessions.Session) error {
+			in = token
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/email-verification/confirm", strings.NewReader(`{"token": "t"}`))
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(in).To(Equal("t"))
+	})
+}
+
+func TestMyIdentitiesAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should list identities of current user", func(t *testing.T) {
+		QueryMyIdentitiesFunc = func(s *sessions.Session) ([]UserIdentity, error) {
+			return []UserIdentity{{ID: 1, User: s.Identity.ID, AuthChannel: AuthChannelOIDC, ChannelKey: "corp:u-1"}}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/me/identities", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`[{"id": "1", "user": "100", "auth_channel": 1, "channel_key": "corp:u-1"}]`))
+	})
+
+	t.Run("should unlink identity", func(t *testing.T) {
+		var inID types.ID
+		UnlinkMyIdentityFunc = func(id types.ID, s *sessions.Session) error {
+			inID = id
+			return nil
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, PathUsers+"/me/identities/1", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		Expect(inID).To(Equal(types.ID(1)))
+	})
+
+	t.Run("should respond conflict when unlink the last identity", func(t *testing.T) {
+		UnlinkMyIdentityFunc = func(id types.ID, s *sessions.Session) error {
+			return &ErrLastIdentity{}
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, PathUsers+"/me/identities/1", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusConflict))
+		Expect(body).To(MatchJSON(`{"code": "user.last_identity", "message": "the last identity can not be unlinked", "data": null}`))
+	})
+}
+
+func TestMyMFAAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should require login", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/me/mfa", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	t.Run("should begin and confirm enrollment", func(t *testing.T) {
+		BeginMFAEnrollmentFunc = func(s *sessions.Session) (*MFAEnrollment, error) {
+			return &MFAEnrollment{Secret: "SECRET", URI: "otpauth://totp/owlet:tom?secret=SECRET"}, nil
+		}
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/me/mfa", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"secret": "SECRET", "uri": "otpauth://totp/owlet:tom?secret=SECRET"}`))
+
+		var inCode string
+		ConfirmMFAEnrollmentFunc = func(code string, s *sessions.Session) (*MFARecoveryCodes, error) {
+			inCode = code
+			return &MFARecoveryCodes{RecoveryCodes: []string{"abcd-2345"}}, nil
+		}
+		req = httptest.NewRequest(http.MethodPost, PathUsers+"/me/mfa/confirm", strings.NewReader(`{"code": "123456"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"recovery_codes": ["abcd-2345"]}`))
+		Expect(inCode).To(Equal("123456"))
+	})
+
+	t.Run("should regenerate recovery codes", func(t *testing.T) {
+		RegenerateMFARecoveryCodesFunc = func(code string, s *sessions.Session) (*MFARecoveryCodes, error) {
+			return &MFARecoveryCodes{RecoveryCodes: []string{"efgh-6789"}}, nil
+		}
+		req := httptest.NewRequest(http.MethodPost, PathUsers+"/me/mfa/recovery-codes", strings.NewReader(`{"code": "123456"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"recovery_codes": ["efgh-6789"]}`))
+	})
+
+	t.Run("should disable MFA with a valid code", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, PathUsers+"/me/mfa", strings.NewReader(`{}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		DisableMyMFAFunc = func(code string, s *sessions.Session) error {
+			return &ErrMFAInvalidCode{}
+		}
+		req = httptest.NewRequest(http.MethodDelete, PathUsers+"/me/mfa", strings.NewReader(`{"code": "000000"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring(`"code":"user.mfa_invalid_code"`))
+
+		DisableMyMFAFunc = func(code string, s *sessions.Session) error {
+			return nil
+		}
+		req = httptest.NewRequest(http.MethodDelete, PathUsers+"/me/mfa", strings.NewReader(`{"code": "123456"}`))
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+	})
+}
+
+func TestMyFileUsageAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	RegisterUsersRestAPI(router)
+
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should report file usage of current user", func(t *testing.T) {
+		var in types.ID
+		QueryMyFileUsageFunc = func(s *sessions.Session) (*FileUsageReport, error) {
+			in = s.Identity.ID
+			return &FileUsageReport{Files: 3, Bytes: 2048, Quota: 1 << 30, SpaceBytes: 4096}, nil
+		}
+
+		req := httptest.NewRequest(http.MethodGet, PathUsers+"/me/usage", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		req = httptest.NewRequest(http.MethodGet, PathUsers+"/me/usage", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"files": 3, "bytes": 2048, "quota": 1073741824, "space_bytes": 4096, "space_quota": 0}`))
+		Expect(in).To(Equal(types.ID(100)))
+	})
+}
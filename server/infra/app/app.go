@@ -2,15 +2,23 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"owlet/init/db"
+	"owlet/server/domain"
 	"owlet/server/infra/assemble"
+	"owlet/server/infra/blobstore"
 	"owlet/server/infra/fail"
+	"owlet/server/infra/health"
+	"owlet/server/infra/imaging"
+	"owlet/server/infra/ldapauth"
 	"owlet/server/infra/localize"
+	"owlet/server/infra/logging"
+	"owlet/server/infra/mailer"
+	"owlet/server/infra/metrics"
+	"owlet/server/infra/oidc"
 	"owlet/server/infra/persistence"
+	"owlet/server/infra/sessions"
 	"owlet/server/infra/tracing"
 	"strconv"
 	"syscall"
@@ -18,6 +26,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,10 +36,14 @@ var (
 )
 
 // Bootstrap
-//    database migration (with distribution lock)
-//    database connection pool
-//    http serve start and become healthy
+//
+//	database migration (with distribution lock)
+//	database connection pool
+//	http serve start and become healthy
 func Bootstrap() {
+	if err := logging.Setup(); err != nil {
+		logrus.Fatalf("logging setting: %v\n", err)
+	}
 	logrus.Infoln("bootstrap...")
 
 	// tracer
@@ -44,36 +57,51 @@ func Bootstrap() {
 	logrus.Infoln("tracing setting success")
 
 	// database setting up
-	if os.Getenv(persistence.EnvDatabaseURL) == "" {
-		os.Setenv(persistence.EnvDatabaseURL, "mysql://root:root@(127.0.0.1:3306)/owlet-go?charset=utf8mb4&parseTime=True&loc=Local&timeout=5s")
-	}
 	logrus.Infoln("database setting...")
-	dsn, err := persistence.ParseDatabaseConfigFromEnv()
+	gormDB, err := OpenDatabase()
 	if err != nil {
 		logrus.Fatalf("database setting: %v\n", err)
 	}
-	if err := db.PrepareMysqlDatabase(dsn); err != nil {
-		logrus.Fatalf("database setting: prepare database: %v\n", err)
-	}
-	gormDB, err := persistence.StartGormDB(dsn)
-	if err != nil {
-		logrus.Fatalf("database setting: open db: %v\n", err)
-	}
 	defer persistence.StopGormDB(gormDB)
 
-	if err := gormDB.AutoMigrate(assemble.AutoMigrations...); err != nil {
-		logrus.Fatalf("database setting: auto migration: %v\n", err)
+	if _, err := persistence.MigrateUp(gormDB, assemble.AutoMigrations, assemble.Migrations); err != nil {
+		logrus.Fatalf("database setting: migration: %v\n", err)
 	}
-
-	persistence.ActiveGormDB = gormDB
 	logrus.Infoln("database setting success")
 
+	health.Register(assemble.HealthCheckers...)
+
+	mailer.Active = mailer.FromEnv()
+	oidc.Providers = oidc.FromEnv()
+	ldapauth.Active = ldapauth.FromEnv()
+	if blobstore.Active, err = blobstore.FromEnv(); err != nil {
+		logrus.Fatalf("blob store setting: %v\n", err)
+	}
+	imaging.Active = imaging.OptionsFromEnv()
+	domain.FileLinks = domain.FileLinkOptionsFromEnv()
+	domain.FileLimits = domain.FileLimitsFromEnv()
+
+	// metrics
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		logrus.Fatalf("metrics setting: %v\n", err)
+	}
+	if err := metrics.Register(collectors.NewDBStatsCollector(sqlDB, "owlet")); err != nil {
+		logrus.Fatalf("metrics setting: %v\n", err)
+	}
+	if err := metrics.Register(assemble.MetricCollectors...); err != nil {
+		logrus.Fatalf("metrics setting: %v\n", err)
+	}
+
 	// http server
 	engine := gin.New()
 
 	engine.Use(
-		gin.LoggerWithConfig(gin.LoggerConfig{SkipPaths: []string{"/"}}),
-		localize.LocalizeMiddleware("./i18n"),
+		logging.AccessLog("/", health.PathLiveness, health.PathReadiness, metrics.PathMetrics),
+		metrics.HTTPMetrics(),
+		localize.LocalizeMiddleware("./i18n",
+			localize.WithReload(context.Background(), localize.DefaultReloadInterval),
+			localize.WithPreferenceResolver(sessions.PreferredLanguage)),
 		tracing.TracingRestAPI(),
 		fail.ErrorHandling(),
 		// gin.Recovery(),
@@ -83,6 +111,11 @@ func Bootstrap() {
 		registerEntry.Register(engine, registerEntry.MiddleWares...)
 	}
 
+	// file garbage collection, stopped with the process
+	gcInterval, gcGrace := domain.FileGCConfigFromEnv()
+	domain.FileGCGrace = gcGrace
+	go domain.RunFileGC(context.Background(), gcInterval)
+
 	StartHTTPServer(engine)
 }
 
@@ -94,9 +127,10 @@ func StartHTTPServer(engine *gin.Engine) {
 	}
 
 	// run http server async
+	health.MarkReady()
 	go func() {
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %v\n", err) // exit
+			logrus.Fatalf("listen: %v\n", err) // exit
 		}
 	}()
 
@@ -107,6 +141,8 @@ func StartHTTPServer(engine *gin.Engine) {
 	// kill -9 is syscall.SIGKILL but can't be catch
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	// stop receiving new traffic as soon as possible
+	health.MarkUnready()
 	logrus.Infoln("[SHUTDOWN] shutdown signal has been received, the service will exit in 3 seconds.")
 
 	ctx, cancel := context.WithTimeout(context.Background(), GracefulShutdownTimeout)
@@ -114,7 +150,7 @@ func StartHTTPServer(engine *gin.Engine) {
 
 	// graceful shutdown http.Server
 	if err := httpServer.Shutdown(ctx); err != nil {
-		log.Fatalf("[SHUTDOWN] http server shutdown:%v\n", err)
+		logrus.Fatalf("[SHUTDOWN] http server shutdown:%v\n", err)
 	}
 	logrus.Infoln("[SHUTDOWN] http server is shutdowning gracefully, new request will be rejected.")
 
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"os"
+	"owlet/init/db"
+	"owlet/server/infra/persistence"
+
+	"gorm.io/gorm"
+)
+
+const defaultDatabaseURL = "mysql://root:root@(127.0.0.1:3306)/owlet-go?charset=utf8mb4&parseTime=True&loc=Local&timeout=5s"
+
+// LoadDatabaseConfig resolve the database dsn from environment, a local database is used when absent.
+func LoadDatabaseConfig() (string, error) {
+	if os.Getenv(persistence.EnvDatabaseURL) == "" {
+		os.Setenv(persistence.EnvDatabaseURL, defaultDatabaseURL)
+	}
+	return persistence.ParseDatabaseConfigFromEnv()
+}
+
+// OpenDatabase prepare the database and open the connection pool, which is activated as persistence.ActiveGormDB.
+// The returned gorm.DB should be closed by persistence.StopGormDB.
+func OpenDatabase() (*gorm.DB, error) {
+	dsn, err := LoadDatabaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PrepareMysqlDatabase(dsn); err != nil {
+		return nil, err
+	}
+	gormDB, err := persistence.StartGormDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	persistence.ActiveGormDB = gormDB
+	return gormDB, nil
+}
@@ -2,25 +2,41 @@ package assemble
 
 import (
 	"owlet/server/domain"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/blobstore"
 	"owlet/server/infra/doc"
+	"owlet/server/infra/health"
+	"owlet/server/infra/localize"
+	"owlet/server/infra/logging"
 	"owlet/server/infra/meta"
+	"owlet/server/infra/metrics"
+	"owlet/server/infra/persistence"
+	"owlet/server/infra/ratelimit"
+	"owlet/server/infra/sessions"
+	"owlet/server/infra/tracing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 /*
 * registry endpoint for:
 *
-*   1. database auto migrations
+*   1. database auto migrations and versioned migrations
 *   2. rest api routes
 *   3. error serialize
 *   4. metric collectors
+*   5. health checkers
  */
 
 type RestAPIRegister func(*gin.Engine, ...gin.HandlerFunc)
 
 var AutoMigrations = []interface{}{}
+var Migrations = []persistence.Migration{}
 var RestAPIRegistry = []APIRegistryEntry{}
+var MetricCollectors = []prometheus.Collector{}
+var HealthCheckers = []health.Checker{}
 
 type APIRegistryEntry struct {
 	Register    RestAPIRegister
@@ -28,11 +44,34 @@ type APIRegistryEntry struct {
 }
 
 func init() {
+	// each rate limit middleware has its own buckets (the admin groups share one),
+	// the login endpoints are limited by client address to slow down guessing
+	apiRule := ratelimit.RuleFromEnv(ratelimit.EnvAPIRule, ratelimit.DefaultAPIRule)
+	loginRule := ratelimit.RuleFromEnv(ratelimit.EnvLoginRule, ratelimit.DefaultLoginRule)
+	adminMiddleWares := []gin.HandlerFunc{sessions.SessionFilter(), sessions.RequireRole(authority.RoleAdmin),
+		ratelimit.ByUser(apiRule)}
+
 	AutoMigrations = []interface{}{}
+	Migrations = domain.Migrations
 	RestAPIRegistry = []APIRegistryEntry{
 		{meta.RegisterMetaRestAPI, nil},
+		{health.RegisterHealthRestAPI, nil},
+		{metrics.RegisterMetricsRestAPI, nil},
+		{logging.RegisterLoggingRestAPI, adminMiddleWares},
+		{localize.RegisterLocalizeRestAPI, adminMiddleWares},
 		{doc.RegisterDocsAPI, nil},
-		{domain.RegisterArticlesRestAPI, []gin.HandlerFunc{}},
-		{domain.RegisterTagsRestAPI, nil},
+		{domain.RegisterArticlesRestAPI, []gin.HandlerFunc{ratelimit.ByUser(apiRule)}},
+		{domain.RegisterTagsRestAPI, []gin.HandlerFunc{ratelimit.ByUser(apiRule)}},
+		{domain.RegisterUsersRestAPI, []gin.HandlerFunc{ratelimit.ByUser(apiRule)}},
+		{domain.RegisterFilesRestAPI, []gin.HandlerFunc{ratelimit.ByUser(apiRule)}},
+		{domain.RegisterAuthRestAPI, []gin.HandlerFunc{ratelimit.ByIP(loginRule)}},
+		{domain.RegisterUsersAdminRestAPI, adminMiddleWares},
+		{domain.RegisterFilesAdminRestAPI, adminMiddleWares},
+	}
+	MetricCollectors = domain.MetricCollectors
+	HealthCheckers = []health.Checker{
+		{Name: "mysql", Timeout: 2 * time.Second, Check: persistence.PingDatabase},
+		{Name: "tracer", Check: tracing.CheckTracer},
+		{Name: "blobstore", Timeout: 3 * time.Second, Check: blobstore.Check},
 	}
 }
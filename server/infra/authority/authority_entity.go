@@ -7,6 +7,8 @@ import (
 	"github.com/fundwit/go-commons/types"
 )
 
+const RoleAdmin = "admin"
+
 type Permissions []string
 
 func (c Permissions) HasRole(role string) bool {
@@ -0,0 +1,106 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"owlet/server/infra/fail"
+	"strings"
+	"time"
+)
+
+const (
+	EnvStore    = "BLOB_STORE" // local or oss, local by default
+	EnvLocalDir = "BLOB_LOCAL_DIR"
+
+	EnvOSSEndpoint        = "OSS_ENDPOINT" // e.g. https://oss-cn-hangzhou.aliyuncs.com
+	EnvOSSAccessKeyID     = "OSS_ACCESS_KEY_ID"
+	EnvOSSAccessKeySecret = "OSS_ACCESS_KEY_SECRET"
+	EnvOSSBucket          = "OSS_BUCKET"
+	EnvOSSPrefix          = "OSS_PREFIX" // the prefix of object keys, e.g. 'owlet/'
+
+	StoreLocal = "local"
+	StoreOSS   = "oss"
+
+	DefaultLocalDir = "./data/blobs"
+)
+
+// ErrBlobNotFound the blob is absent in store, it wraps fail.ErrNotFound
+var ErrBlobNotFound = fmt.Errorf("%w: blob", fail.ErrNotFound)
+
+// BlobStore keep the contents of files by keys, the keys are slash separated paths like 'files/123'.
+// Implementations should be safe for concurrent use.
+type BlobStore interface {
+	// Put write the content of key, an existing blob is replaced. The size is -1 if it is unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get open the content of key, ErrBlobNotFound is returned if it is absent
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete remove the blob of key, it is not an error if the blob is absent
+	Delete(ctx context.Context, key string) error
+	// List the keys starting with prefix in lexical order, e.g. prefix 'files/' lists all blobs under 'files'
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Ping check the availability of store
+	Ping(ctx context.Context) error
+}
+
+// URLSigner a store able to issue URLs for reading blobs directly, without passing through this server
+type URLSigner interface {
+	// SignURL a GET URL of key valid for ttl, the response carries the content type and disposition if they are given
+	SignURL(ctx context.Context, key string, ttl time.Duration, contentType, disposition string) (string, error)
+}
+
+// Active the store used by domain services, blobs are kept in DefaultLocalDir until it is replaced (see FromEnv)
+var Active BlobStore = NewLocalStore(DefaultLocalDir)
+
+// FromEnv build the store specified by BLOB_STORE
+func FromEnv() (BlobStore, error) {
+	switch store := strings.ToLower(os.Getenv(EnvStore)); store {
+	case "", StoreLocal:
+		dir := os.Getenv(EnvLocalDir)
+		if dir == "" {
+			dir = DefaultLocalDir
+		}
+		return NewLocalStore(dir), nil
+	case StoreOSS:
+		return NewOSSStore(OSSConfig{
+			Endpoint:        os.Getenv(EnvOSSEndpoint),
+			AccessKeyID:     os.Getenv(EnvOSSAccessKeyID),
+			AccessKeySecret: os.Getenv(EnvOSSAccessKeySecret),
+			Bucket:          os.Getenv(EnvOSSBucket),
+			Prefix:          os.Getenv(EnvOSSPrefix),
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store '%s'", store)
+	}
+}
+
+// Check the health checker of the active store
+func Check(ctx context.Context) error {
+	return Active.Ping(ctx)
+}
+
+// ValidPrefix an empty prefix or a valid key optionally ended by a slash
+func ValidPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if ValidKey(strings.TrimSuffix(prefix, "/")) != nil {
+		return errors.New("invalid blob prefix '" + prefix + "'")
+	}
+	return nil
+}
+
+// ValidKey reject empty keys, absolute paths and the keys escaping the root like 'a/../../b'
+func ValidKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return errors.New("invalid blob key '" + key + "'")
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return errors.New("invalid blob key '" + key + "'")
+		}
+	}
+	return nil
+}
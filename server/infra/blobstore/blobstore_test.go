@@ -0,0 +1,209 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"owlet/server/infra/fail"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestValidKey(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, key := range []string{"a", "files/123", "a/b.c/d"} {
+		Expect(ValidKey(key)).ToNot(HaveOccurred())
+	}
+	for _, key := range []string{"", "/etc/passwd", "a/../../b", "..", "a//b", "a/", "./a", `a\b`} {
+		Expect(ValidKey(key)).To(HaveOccurred(), key)
+	}
+
+	for _, prefix := range []string{"", "a", "a/", "a/b/", "a/b"} {
+		Expect(ValidPrefix(prefix)).ToNot(HaveOccurred(), prefix)
+	}
+	for _, prefix := range []string{"/", "//", "../", "a//", "/a/"} {
+		Expect(ValidPrefix(prefix)).To(HaveOccurred(), prefix)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer os.Unsetenv(EnvStore)
+	defer os.Unsetenv(EnvLocalDir)
+
+	t.Run("should build local store by default", func(t *testing.T) {
+		os.Setenv(EnvLocalDir, "/tmp/owlet-blobs")
+		store, err := FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store).To(Equal(&LocalStore{Dir: "/tmp/owlet-blobs"}))
+	})
+
+	t.Run("should build OSS store", func(t *testing.T) {
+		os.Setenv(EnvStore, "OSS")
+		_, err := FromEnv()
+		Expect(err).To(HaveOccurred())
+
+		os.Setenv(EnvOSSEndpoint, "https://oss-cn-hangzhou.aliyuncs.com")
+		os.Setenv(EnvOSSBucket, "owlet")
+		os.Setenv(EnvOSSPrefix, "blobs/")
+		defer os.Unsetenv(EnvOSSEndpoint)
+		defer os.Unsetenv(EnvOSSBucket)
+		defer os.Unsetenv(EnvOSSPrefix)
+		store, err := FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.(*OSSStore).Config.Prefix).To(Equal("blobs/"))
+	})
+
+	t.Run("should reject unknown store", func(t *testing.T) {
+		os.Setenv(EnvStore, "s4")
+		_, err := FromEnv()
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestLocalStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir, err := ioutil.TempDir("", "blobs")
+	Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	testBlobStore(t, NewLocalStore(dir))
+}
+
+func TestOSSStore(t *testing.T) {
+	RegisterTestingT(t)
+
+	stub := testinfra.StartOSSStub("owlet", "key-id")
+	defer stub.Close()
+	store, err := NewOSSStore(OSSConfig{Endpoint: stub.URL, AccessKeyID: "key-id", AccessKeySecret: "secret",
+		Bucket: "owlet", Prefix: "blobs/"})
+	Expect(err).ToNot(HaveOccurred())
+
+	testBlobStore(t, store)
+
+	t.Run("should put objects with prefix and content type", func(t *testing.T) {
+		Expect(store.Put(context.TODO(), "files/2", strings.NewReader("hi"), -1, "text/plain")).ToNot(HaveOccurred())
+		Expect(stub.Object("blobs/files/2")).To(Equal(&testinfra.OSSStubObject{Content: []byte("hi"), ContentType: "text/plain"}))
+	})
+
+	t.Run("should presign URLs for reading objects", func(t *testing.T) {
+		Expect(store.Put(context.TODO(), "files/3", strings.NewReader("signed"), 6, "text/plain")).ToNot(HaveOccurred())
+
+		signed, err := store.SignURL(context.TODO(), "files/3", time.Minute, "image/png", `inline; filename="a.png"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(signed).To(HavePrefix(stub.URL + "/owlet/"))
+		res, err := http.Get(signed)
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("signed"))
+		Expect(res.Header.Get("Content-Type")).To(Equal("image/png"))
+		Expect(res.Header.Get("Content-Disposition")).To(Equal(`inline; filename="a.png"`))
+
+		res, err = http.Get(stub.URL + "/owlet/blobs/files/3")
+		Expect(err).ToNot(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+
+		_, err = store.SignURL(context.TODO(), "../escape", time.Minute, "", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("should list objects page by page", func(t *testing.T) {
+		for i := 0; i < ossListPageSize+5; i++ {
+			Expect(store.Put(context.TODO(), fmt.Sprintf("pages/%04d", i), strings.NewReader("x"), 1, "")).ToNot(HaveOccurred())
+		}
+		keys, err := store.List(context.TODO(), "pages/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(ossListPageSize + 5))
+		Expect(keys[0]).To(Equal("pages/0000"))
+		Expect(keys[ossListPageSize+4]).To(Equal(fmt.Sprintf("pages/%04d", ossListPageSize+4)))
+	})
+
+	t.Run("should fail with wrong credentials or bucket", func(t *testing.T) {
+		wrong, err := NewOSSStore(OSSConfig{Endpoint: stub.URL, AccessKeyID: "other", AccessKeySecret: "secret", Bucket: "owlet"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(wrong.Ping(context.TODO())).To(HaveOccurred())
+
+		wrong, err = NewOSSStore(OSSConfig{Endpoint: stub.URL, AccessKeyID: "key-id", AccessKeySecret: "secret", Bucket: "other"})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = wrong.Get(context.TODO(), "files/1")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, fail.ErrNotFound)).To(BeFalse())
+	})
+}
+
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.TODO()
+
+	t.Run("should put, get and delete blobs", func(t *testing.T) {
+		content := []byte("hello world")
+		Expect(store.Put(ctx, "files/1", bytes.NewReader(content), int64(len(content)), "text/plain")).ToNot(HaveOccurred())
+
+		r, err := store.Get(ctx, "files/1")
+		Expect(err).ToNot(HaveOccurred())
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(content))
+
+		Expect(store.Put(ctx, "files/1", strings.NewReader("replaced"), -1, "")).ToNot(HaveOccurred())
+		r, err = store.Get(ctx, "files/1")
+		Expect(err).ToNot(HaveOccurred())
+		got, _ = ioutil.ReadAll(r)
+		r.Close()
+		Expect(string(got)).To(Equal("replaced"))
+
+		Expect(store.Delete(ctx, "files/1")).ToNot(HaveOccurred())
+		_, err = store.Get(ctx, "files/1")
+		Expect(err).To(Equal(ErrBlobNotFound))
+		Expect(errors.Is(err, fail.ErrNotFound)).To(BeTrue())
+
+		Expect(store.Delete(ctx, "files/1")).ToNot(HaveOccurred())
+	})
+
+	t.Run("should list blobs by prefix", func(t *testing.T) {
+		for _, key := range []string{"list/b/2", "list/a/1", "list/a/2", "list/ab", "other/1"} {
+			Expect(store.Put(ctx, key, strings.NewReader(key), -1, "")).ToNot(HaveOccurred())
+		}
+
+		keys, err := store.List(ctx, "list/a/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{"list/a/1", "list/a/2"}))
+		keys, err = store.List(ctx, "list/a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{"list/a/1", "list/a/2", "list/ab"}))
+		keys, err = store.List(ctx, "absent/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(BeEmpty())
+		_, err = store.List(ctx, "../")
+		Expect(err).To(HaveOccurred())
+
+		for _, key := range []string{"list/b/2", "list/a/1", "list/a/2", "list/ab", "other/1"} {
+			Expect(store.Delete(ctx, key)).ToNot(HaveOccurred())
+		}
+	})
+
+	t.Run("should reject invalid keys", func(t *testing.T) {
+		Expect(store.Put(ctx, "../escape", strings.NewReader("x"), 1, "")).To(HaveOccurred())
+		_, err := store.Get(ctx, "/etc/passwd")
+		Expect(err).To(HaveOccurred())
+		Expect(store.Delete(ctx, "a/../b")).To(HaveOccurred())
+	})
+
+	t.Run("should be healthy", func(t *testing.T) {
+		Expect(store.Ping(ctx)).ToNot(HaveOccurred())
+	})
+}
@@ -0,0 +1,139 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// ossListPageSize the maximum number of objects listed by one request
+const ossListPageSize = 1000
+
+type OSSConfig struct {
+	Endpoint        string
+	AccessKeyID     string
+	AccessKeySecret string
+	Bucket          string
+	Prefix          string
+}
+
+// OSSStore keep blobs as objects of an Aliyun OSS bucket, the key of object is Prefix + key.
+// The path style is used if the endpoint is an IP address, e.g. a local OSS compatible stub.
+// The SDK does not support context, the requests are bounded by the timeouts of client.
+type OSSStore struct {
+	Config OSSConfig
+	bucket *oss.Bucket
+}
+
+func NewOSSStore(cfg OSSConfig) (*OSSStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("the endpoint and the bucket of OSS are required")
+	}
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyID, cfg.AccessKeySecret, oss.Timeout(5, 60))
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &OSSStore{Config: cfg, bucket: bucket}, nil
+}
+
+func (s *OSSStore) objectKey(key string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	return s.Config.Prefix + key, nil
+}
+
+func (s *OSSStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	options := []oss.Option{}
+	if contentType != "" {
+		options = append(options, oss.ContentType(contentType))
+	}
+	if size >= 0 {
+		options = append(options, oss.ContentLength(size))
+	}
+	return s.bucket.PutObject(objectKey, r, options...)
+}
+
+func (s *OSSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.bucket.GetObject(objectKey)
+	if isOSSNotFound(err) {
+		return nil, ErrBlobNotFound
+	}
+	return body, err
+}
+
+// Delete OSS responds success for absent objects
+func (s *OSSStore) Delete(ctx context.Context, key string) error {
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	return s.bucket.DeleteObject(objectKey)
+}
+
+// List page through the objects, OSS returns them in lexical order
+func (s *OSSStore) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := ValidPrefix(prefix); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	marker := ""
+	for {
+		result, err := s.bucket.ListObjects(oss.Prefix(s.Config.Prefix+prefix), oss.Marker(marker), oss.MaxKeys(ossListPageSize))
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Objects {
+			keys = append(keys, strings.TrimPrefix(object.Key, s.Config.Prefix))
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// SignURL presign a GET URL, the ttl is rounded up to seconds
+func (s *OSSStore) SignURL(ctx context.Context, key string, ttl time.Duration, contentType, disposition string) (string, error) {
+	objectKey, err := s.objectKey(key)
+	if err != nil {
+		return "", err
+	}
+	options := []oss.Option{}
+	if contentType != "" {
+		options = append(options, oss.ResponseContentType(contentType))
+	}
+	if disposition != "" {
+		options = append(options, oss.ResponseContentDisposition(disposition))
+	}
+	return s.bucket.SignURL(objectKey, oss.HTTPGet, int64((ttl+time.Second-1)/time.Second), options...)
+}
+
+// Ping probe an object by HEAD, only the permissions of objects are required
+func (s *OSSStore) Ping(ctx context.Context) error {
+	_, err := s.bucket.IsObjectExist(s.Config.Prefix + ".ping")
+	return err
+}
+
+// isOSSNotFound an absent bucket is not treated as an absent blob
+func isOSSNotFound(err error) bool {
+	var serviceErr oss.ServiceError
+	return errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound && serviceErr.Code == "NoSuchKey"
+}
@@ -0,0 +1,78 @@
+package fail
+
+import (
+	"net/http"
+	"sort"
+)
+
+// ErrorCode is an entry of the error code catalog.
+// MessageID is the i18n message id of the code, and Message is the default (english) message template.
+type ErrorCode struct {
+	Code      string
+	Status    int
+	MessageID string
+	Message   string
+}
+
+const (
+	CodeUnexpected        = "common.internal_server_error"
+	CodeBadParam          = "common.bad_param"
+	CodeRecordNotFound    = "common.record_not_found"
+	CodeConflict          = "common.conflict"
+	CodeTooManyRequests   = "common.too_many_requests"
+	CodeBodyNotFound      = "bad_request.body_not_found"
+	CodeInvalidBodyFormat = "bad_request.invalid_body_format"
+	CodeValidationFailed  = "bad_request.validation_failed"
+	CodeUnauthenticated   = "security.unauthenticated"
+	CodeForbidden         = "security.forbidden"
+	CodeInvalidToken      = "security.invalid_token"
+)
+
+const errorMessageIDPrefix = "error."
+
+var catalog = map[string]ErrorCode{}
+
+func init() {
+	RegisterErrorCodes(
+		ErrorCode{Code: CodeUnexpected, Status: http.StatusInternalServerError, Message: "{{.Cause}}"},
+		ErrorCode{Code: CodeBadParam, Status: http.StatusBadRequest,
+			Message: "{{if .Param}}invalid {{.Param}} '{{.InvalidValue}}'{{else if .Cause}}{{.Cause}}{{else}}bad param{{end}}"},
+		ErrorCode{Code: CodeRecordNotFound, Status: http.StatusNotFound, Message: "record not found"},
+		ErrorCode{Code: CodeConflict, Status: http.StatusConflict, Message: "{{.Field}} is already used"},
+		ErrorCode{Code: CodeTooManyRequests, Status: http.StatusTooManyRequests,
+			Message: "too many requests, please retry after {{.RetryAfter}} seconds"},
+		ErrorCode{Code: CodeBodyNotFound, Status: http.StatusBadRequest, Message: "body not found"},
+		ErrorCode{Code: CodeInvalidBodyFormat, Status: http.StatusBadRequest, Message: "invalid body format"},
+		ErrorCode{Code: CodeValidationFailed, Status: http.StatusBadRequest, Message: "validation failed"},
+		ErrorCode{Code: CodeUnauthenticated, Status: http.StatusUnauthorized, Message: "unauthenticated"},
+		ErrorCode{Code: CodeForbidden, Status: http.StatusForbidden, Message: "access forbidden"},
+		ErrorCode{Code: CodeInvalidToken, Status: http.StatusBadRequest, Message: "the token is invalid or expired"},
+	)
+}
+
+// RegisterErrorCodes add codes into the catalog, the message id is 'error.<code>' if not specified.
+func RegisterErrorCodes(codes ...ErrorCode) {
+	for _, c := range codes {
+		if c.MessageID == "" {
+			c.MessageID = errorMessageIDPrefix + c.Code
+		}
+		catalog[c.Code] = c
+	}
+}
+
+func LookupErrorCode(code string) (ErrorCode, bool) {
+	c, found := catalog[code]
+	return c, found
+}
+
+// ErrorCodes list all registered codes ordered by code
+func ErrorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(catalog))
+	for _, c := range catalog {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes
+}
@@ -0,0 +1,100 @@
+package fail
+
+import (
+	"owlet/server/infra/localize"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+const (
+	validationMessageIDPrefix = "validation."
+	validationDefaultRule     = "default"
+)
+
+// ValidationMessages default (english) message templates of validation rules, keyed by rule tag.
+// The message id of a rule is 'validation.<tag>', the template data has 'Field' and 'Param'.
+var ValidationMessages = map[string]string{
+	validationDefaultRule: "{{.Field}} is invalid",
+	"required":            "{{.Field}} is required",
+	"email":               "{{.Field}} must be a valid email address",
+	"url":                 "{{.Field}} must be a valid URL",
+	"oneof":               "{{.Field}} must be one of [{{.Param}}]",
+	"len":                 "length of {{.Field}} must be {{.Param}}",
+	"min":                 "{{.Field}} must be at least {{.Param}}",
+	"max":                 "{{.Field}} must be at most {{.Param}}",
+	"gte":                 "{{.Field}} must be greater than or equal to {{.Param}}",
+	"lte":                 "{{.Field}} must be less than or equal to {{.Param}}",
+	"gt":                  "{{.Field}} must be greater than {{.Param}}",
+	"lt":                  "{{.Field}} must be less than {{.Param}}",
+}
+
+// FieldViolation is the translated detail of a field which failed in validation
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// report fields by the names in json (or form) instead of the struct field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(fieldName)
+	}
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// localizeMessage render the message of code in the language of request,
+// fallback is used when the code is not registered in catalog.
+func localizeMessage(c *gin.Context, code, fallback string, data map[string]interface{}) string {
+	entry, found := catalog[code]
+	if !found {
+		return fallback
+	}
+	return localizeTemplate(c, entry.MessageID, entry.Message, data)
+}
+
+func localizeTemplate(c *gin.Context, messageID, defaultMessage string, data map[string]interface{}) string {
+	cfg := &i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{ID: messageID, Other: defaultMessage},
+		TemplateData:   data,
+	}
+	message, err := localize.FromGinContext(c).Localize(cfg)
+	if err != nil {
+		return defaultMessage
+	}
+	return message
+}
+
+func fieldViolations(c *gin.Context, errs validator.ValidationErrors) []FieldViolation {
+	violations := make([]FieldViolation, 0, len(errs))
+	for _, fe := range errs {
+		rule := fe.Tag()
+		defaultMessage, found := ValidationMessages[rule]
+		if !found {
+			rule = validationDefaultRule
+			defaultMessage = ValidationMessages[rule]
+		}
+		message := localizeTemplate(c, validationMessageIDPrefix+rule, defaultMessage,
+			map[string]interface{}{"Field": fe.Field(), "Param": fe.Param()})
+		violations = append(violations, FieldViolation{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param(), Message: message})
+	}
+	return violations
+}
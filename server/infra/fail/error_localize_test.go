@@ -0,0 +1,114 @@
+package fail_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/localize"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	. "github.com/onsi/gomega"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v2"
+)
+
+const i18nPath = "../../../i18n"
+
+type demoCreate struct {
+	Name  string `json:"name" binding:"required,lte=5"`
+	Email string `json:"email" binding:"omitempty,email"`
+	Kind  string `json:"kind" binding:"omitempty,oneof=a b"`
+}
+
+func TestLocalizedErrorHandling(t *testing.T) {
+	RegisterTestingT(t)
+
+	r := gin.Default()
+	r.Use(localize.LocalizeMiddleware(i18nPath), fail.ErrorHandling())
+	r.GET("/forbidden", func(c *gin.Context) { panic(fail.ErrForbidden) })
+	r.GET("/bad-param", func(c *gin.Context) { panic(&fail.ErrBadParam{Param: "id", InvalidValue: "aaa"}) })
+	r.POST("/demo", func(c *gin.Context) {
+		body := demoCreate{}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			panic(err)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("message should be rendered in request language", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/forbidden?lang=zh", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(body).To(MatchJSON(`{"code":"security.forbidden", "message":"没有访问权限", "data": null}`))
+
+		req = httptest.NewRequest(http.MethodGet, "/forbidden", nil)
+		req.Header.Set("Accept-Language", "en")
+		status, body, _ = testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(body).To(MatchJSON(`{"code":"security.forbidden", "message":"access forbidden", "data": null}`))
+	})
+
+	t.Run("message template of biz error should be rendered with message data", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/bad-param?lang=zh", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(MatchJSON(`{"code":"common.bad_param", "message":"无效的参数 id 'aaa'", "data": null}`))
+	})
+
+	t.Run("validation errors should be translated per field", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/demo?lang=zh",
+			strings.NewReader(`{"email": "not-an-email", "kind": "c"}`))
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(MatchJSON(`{"code":"bad_request.validation_failed", "message":"参数校验失败", "data": [
+			{"field": "name", "rule": "required", "message": "name 不能为空"},
+			{"field": "email", "rule": "email", "message": "email 必须是有效的邮箱地址"},
+			{"field": "kind", "rule": "oneof", "param": "a b", "message": "kind 必须是 [a b] 之一"}
+		]}`))
+
+		req = httptest.NewRequest(http.MethodPost, "/demo", strings.NewReader(`{"name": "123456"}`))
+		status, body, _ = testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(MatchJSON(`{"code":"bad_request.validation_failed", "message":"validation failed", "data": [
+			{"field": "name", "rule": "lte", "param": "5", "message": "name must be less than or equal to 5"}
+		]}`))
+	})
+}
+
+func TestErrorCatalog(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("registered codes should be found in catalog", func(t *testing.T) {
+		code, found := fail.LookupErrorCode(fail.CodeForbidden)
+		Expect(found).To(BeTrue())
+		Expect(code).To(Equal(fail.ErrorCode{Code: "security.forbidden", Status: http.StatusForbidden,
+			MessageID: "error.security.forbidden", Message: "access forbidden"}))
+
+		_, found = fail.LookupErrorCode("common.demo")
+		Expect(found).To(BeFalse())
+	})
+
+	t.Run("all codes and validation rules should be translated", func(t *testing.T) {
+		for _, lang := range []string{"en", "zh"} {
+			bundle := i18n.NewBundle(language.English)
+			bundle.RegisterUnmarshalFunc("yaml", yaml.Unmarshal)
+			file, err := bundle.LoadMessageFile(i18nPath + "/" + lang + ".yaml")
+			Expect(err).To(BeNil())
+			ids := map[string]bool{}
+			for _, m := range file.Messages {
+				ids[m.ID] = true
+			}
+
+			for _, code := range fail.ErrorCodes() {
+				Expect(ids).To(HaveKey(code.MessageID), lang)
+			}
+			for rule := range fail.ValidationMessages {
+				Expect(ids).To(HaveKey("validation."+rule), lang)
+			}
+		}
+	})
+}
@@ -1,7 +1,11 @@
 package fail
 
+// KeyTraceID the key of trace id in gin context, it is set by tracing middleware
+const KeyTraceID = "traceId"
+
 type ErrorBody struct {
 	Code    string      `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+	TraceID string      `json:"trace_id,omitempty"`
 }
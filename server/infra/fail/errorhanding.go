@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"owlet/server/infra/logging"
 	"runtime/debug"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-sql-driver/mysql"
-	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -37,62 +38,76 @@ func handle(c *gin.Context) {
 }
 
 func HandleError(c *gin.Context, err error) {
-	logrus.Error(err)
-	debug.Stack()
+	status, body := resolveError(c, err)
 
+	logger := logging.FromContext(c.Request.Context()).WithField("status", status)
+	if status >= http.StatusInternalServerError {
+		logger.WithField("stack", string(debug.Stack())).Error(err)
+	} else {
+		logger.Warn(err)
+	}
+
+	var throttled *ErrTooManyRequests
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+	}
+
+	body.TraceID = c.GetString(KeyTraceID)
+	c.JSON(status, body)
+	c.Abort()
+}
+
+func resolveError(c *gin.Context, err error) (int, *ErrorBody) {
 	genericErr := err
 	var ginErr *gin.Error
 	if errors.As(err, &ginErr) {
 		genericErr = ginErr.Err
 	}
 
+	// validation failed, even it is wrapped (e.g. by ErrBadParam)
+	var validationErr validator.ValidationErrors
+	if errors.As(genericErr, &validationErr) {
+		return errorOf(c, CodeValidationFailed, fieldViolations(c, validationErr), nil)
+	}
+
 	if bizErr, ok := genericErr.(BizError); ok {
 		respond := bizErr.Respond()
-		c.JSON(respond.Status, &ErrorBody{Code: respond.Code, Message: respond.Message, Data: respond.Data})
-		c.Abort()
-		return
+		return respond.Status, &ErrorBody{Code: respond.Code,
+			Message: localizeMessage(c, respond.Code, respond.Message, respond.MessageData), Data: respond.Data}
 	}
 
 	// bad request:  io.EOF (no body).
 	if errors.Is(genericErr, io.EOF) {
-		c.JSON(http.StatusBadRequest, &ErrorBody{Code: "bad_request.body_not_found", Message: "body not found"})
-		c.Abort()
-		return
+		return errorOf(c, CodeBodyNotFound, nil, nil)
 	}
 	// bad request: json syntax Error
 	if syntaxErr, ok := genericErr.(*json.SyntaxError); ok {
-		c.JSON(http.StatusBadRequest, &ErrorBody{Code: "bad_request.invalid_body_format", Message: "invalid body format", Data: syntaxErr.Error()})
-		c.Abort()
-		return
-	}
-	// validation failed
-	if validationErr, ok := genericErr.(validator.ValidationErrors); ok {
-		c.JSON(http.StatusBadRequest, &ErrorBody{Code: "bad_request.validation_failed", Message: "validation failed", Data: validationErr.Error()})
-		c.Abort()
-		return
+		return errorOf(c, CodeInvalidBodyFormat, syntaxErr.Error(), nil)
 	}
 
 	if errors.Is(genericErr, ErrUnauthenticated) {
-		c.JSON(http.StatusUnauthorized, &ErrorBody{Code: ErrUnauthenticated.Error(), Message: "unauthenticated"})
-		c.Abort()
-		return
+		return errorOf(c, CodeUnauthenticated, nil, nil)
 	}
 	if errors.Is(genericErr, ErrForbidden) {
-		c.JSON(http.StatusForbidden, &ErrorBody{Code: ErrForbidden.Error(), Message: "access forbidden"})
-		c.Abort()
-		return
+		return errorOf(c, CodeForbidden, nil, nil)
+	}
+	if errors.Is(genericErr, ErrInvalidToken) {
+		return errorOf(c, CodeInvalidToken, nil, nil)
 	}
 	if errors.Is(genericErr, gorm.ErrRecordNotFound) || errors.Is(genericErr, ErrNotFound) {
-		c.JSON(http.StatusNotFound, &ErrorBody{Code: "common.record_not_found", Message: "record not found"})
-		c.Abort()
-		return
+		return errorOf(c, CodeRecordNotFound, nil, nil)
 	}
+
+	cause := map[string]interface{}{"Cause": err.Error()}
 	if errors.Is(genericErr, mysql.ErrInvalidConn) {
-		c.JSON(http.StatusServiceUnavailable, &ErrorBody{Code: ErrUnexpected.Error(), Message: err.Error()})
-		c.Abort()
-		return
+		_, body := errorOf(c, CodeUnexpected, nil, cause)
+		return http.StatusServiceUnavailable, body
 	}
+	return errorOf(c, CodeUnexpected, nil, cause)
+}
 
-	c.JSON(500, &ErrorBody{Code: ErrUnexpected.Error(), Message: err.Error()})
-	c.Abort()
+// errorOf build the response of a code registered in catalog
+func errorOf(c *gin.Context, code string, data interface{}, messageData map[string]interface{}) (int, *ErrorBody) {
+	entry := catalog[code]
+	return entry.Status, &ErrorBody{Code: code, Message: localizeMessage(c, code, entry.Message, messageData), Data: data}
 }
@@ -1,6 +1,7 @@
 package fail_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +11,13 @@ import (
 	"owlet/server/infra/fail"
 	"owlet/server/testinfra"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-sql-driver/mysql"
 	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -130,6 +133,19 @@ func TestCommonErrorHandling(t *testing.T) {
 		Expect(body).To(MatchJSON(`{"code":"security.forbidden", "message":"access forbidden", "data": null}`))
 	})
 
+	t.Run("should handle wrapped ErrInvalidToken", func(t *testing.T) {
+		r := gin.Default()
+		r.Use(fail.ErrorHandling())
+
+		r.GET("/", func(c *gin.Context) {
+			panic(fmt.Errorf("%w: expired", fail.ErrInvalidToken))
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(MatchJSON(`{"code":"security.invalid_token", "message":"the token is invalid or expired", "data": null}`))
+	})
+
 	t.Run("should handle ErrUnauthenticated", func(t *testing.T) {
 		r := gin.Default()
 		r.Use(fail.ErrorHandling())
@@ -142,6 +158,21 @@ func TestCommonErrorHandling(t *testing.T) {
 		Expect(status).To(Equal(http.StatusUnauthorized))
 		Expect(body).To(MatchJSON(`{"code":"security.unauthenticated", "message":"unauthenticated", "data": null}`))
 	})
+
+	t.Run("should handle ErrTooManyRequests with Retry-After header", func(t *testing.T) {
+		r := gin.Default()
+		r.Use(fail.ErrorHandling())
+
+		r.GET("/", func(c *gin.Context) {
+			panic(&fail.ErrTooManyRequests{RetryAfter: 1500 * time.Millisecond})
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		status, body, res := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+		Expect(res.Header.Get("Retry-After")).To(Equal("2"))
+		Expect(body).To(MatchJSON(`{"code":"common.too_many_requests",
+			"message":"too many requests, please retry after 2 seconds", "data": null}`))
+	})
 }
 
 func TestThirdpartErrorHandling(t *testing.T) {
@@ -196,7 +227,7 @@ func TestThirdpartErrorHandling(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
 		status, body, _ := testinfra.ExecuteRequest(req, r)
 		Expect(status).To(Equal(http.StatusBadRequest))
-		Expect(body).To(MatchJSON(`{"code":"bad_request.validation_failed", "message":"validation failed", "data": ""}`))
+		Expect(body).To(MatchJSON(`{"code":"bad_request.validation_failed", "message":"validation failed", "data": []}`))
 	})
 
 	t.Run("should handle mysql.ErrInvalidConn", func(t *testing.T) {
@@ -211,6 +242,65 @@ func TestThirdpartErrorHandling(t *testing.T) {
 		Expect(status).To(Equal(http.StatusServiceUnavailable))
 		Expect(body).To(MatchJSON(`{"code":"common.internal_server_error", "message":"invalid connection", "data": null}`))
 	})
+
+	t.Run("should include trace id when available", func(t *testing.T) {
+		r := gin.Default()
+		r.Use(func(c *gin.Context) { c.Set(fail.KeyTraceID, "1234abcd") }, fail.ErrorHandling())
+
+		r.GET("/", func(c *gin.Context) {
+			c.Error(fail.ErrForbidden)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(body).To(MatchJSON(`{"code":"` + fail.ErrForbidden.Error() + `", "message":"access forbidden", "data": null, "trace_id": "1234abcd"}`))
+	})
+}
+
+func TestErrorLogging(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf := &bytes.Buffer{}
+	out, formatter := logrus.StandardLogger().Out, logrus.StandardLogger().Formatter
+	logrus.SetOutput(buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	defer func() {
+		logrus.SetOutput(out)
+		logrus.SetFormatter(formatter)
+	}()
+
+	r := gin.Default()
+	r.Use(fail.ErrorHandling())
+	r.GET("/500", func(c *gin.Context) { panic(errors.New("some error")) })
+	r.GET("/403", func(c *gin.Context) { panic(fail.ErrForbidden) })
+
+	t.Run("stack should be logged for 5xx", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/500", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusInternalServerError))
+
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(BeNil())
+		Expect(entry["level"]).To(Equal("error"))
+		Expect(entry["msg"]).To(Equal("some error"))
+		Expect(entry["status"]).To(Equal(float64(500)))
+		Expect(entry["stack"]).To(ContainSubstring("errorhanding_test.go"))
+	})
+
+	t.Run("stack should not be logged for 4xx", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/403", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusForbidden))
+
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(BeNil())
+		Expect(entry["level"]).To(Equal("warning"))
+		Expect(entry["msg"]).To(Equal(fail.ErrForbidden.Error()))
+		Expect(entry["status"]).To(Equal(float64(403)))
+		Expect(entry).ToNot(HaveKey("stack"))
+	})
 }
 
 type demoError struct {
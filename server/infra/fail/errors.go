@@ -2,13 +2,16 @@ package fail
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"time"
 )
 
 var ErrUnexpected = errors.New("common.internal_server_error")
 var ErrInvalidArguments = errors.New("invalid arguments")
 var ErrUnauthenticated = errors.New("security.unauthenticated")
 var ErrForbidden = errors.New("security.forbidden")
+var ErrInvalidToken = errors.New("security.invalid_token")
 
 var ErrNotFound = errors.New("not found")
 var ErrNoContent = errors.New("no content")
@@ -21,10 +24,13 @@ type BizError interface {
 	Respond() *BizErrorDetail
 }
 
+// BizErrorDetail Message is used as it is if Code is not registered in catalog,
+// otherwise the message is rendered from the catalog in request language, with MessageData as template data.
 type BizErrorDetail struct {
-	Status  int
-	Code    string
-	Message string
+	Status      int
+	Code        string
+	Message     string
+	MessageData map[string]interface{}
 
 	Data interface{}
 }
@@ -52,9 +58,65 @@ func (e *ErrBadParam) Error() string {
 
 func (e *ErrBadParam) Respond() *BizErrorDetail {
 	return &BizErrorDetail{
-		Status:  http.StatusBadRequest,
-		Code:    "common.bad_param",
-		Message: e.Error(),
-		Data:    nil,
+		Status:      http.StatusBadRequest,
+		Code:        CodeBadParam,
+		Message:     e.Error(),
+		MessageData: e.messageData(),
+		Data:        nil,
+	}
+}
+
+func (e *ErrBadParam) messageData() map[string]interface{} {
+	data := map[string]interface{}{"Param": e.Param, "InvalidValue": e.InvalidValue}
+	if e.Cause != nil {
+		data["Cause"] = e.Cause.Error()
+	}
+	return data
+}
+
+// ErrConflict the value of an unique field is already used by another record
+type ErrConflict struct {
+	Field string
+}
+
+func (e *ErrConflict) Error() string {
+	return e.Field + " conflict"
+}
+
+func (e *ErrConflict) Respond() *BizErrorDetail {
+	return &BizErrorDetail{
+		Status:      http.StatusConflict,
+		Code:        CodeConflict,
+		Message:     e.Error(),
+		MessageData: map[string]interface{}{"Field": e.Field},
+		Data:        nil,
+	}
+}
+
+// ErrTooManyRequests the client is throttled, the response carries the Retry-After header
+type ErrTooManyRequests struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrTooManyRequests) Error() string {
+	return "too many requests"
+}
+
+// RetryAfterSeconds the delay rounded up to whole seconds, at least 1
+func (e *ErrTooManyRequests) RetryAfterSeconds() int {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func (e *ErrTooManyRequests) Respond() *BizErrorDetail {
+	return &BizErrorDetail{
+		Status:      http.StatusTooManyRequests,
+		Code:        CodeTooManyRequests,
+		Message:     e.Error(),
+		MessageData: map[string]interface{}{"RetryAfter": e.RetryAfterSeconds()},
+		Data:        nil,
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"owlet/server/infra/fail"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 )
@@ -33,10 +34,11 @@ func TestErrBadParam_Respond(t *testing.T) {
 	t.Run("should return response data as expected", func(t *testing.T) {
 		err := fail.ErrBadParam{Param: "id", InvalidValue: "aaa", Cause: fail.ErrForbidden}
 		Expect(*err.Respond()).To(Equal(fail.BizErrorDetail{
-			Status:  http.StatusBadRequest,
-			Code:    "common.bad_param",
-			Message: "invalid id 'aaa'",
-			Data:    nil,
+			Status:      http.StatusBadRequest,
+			Code:        "common.bad_param",
+			Message:     "invalid id 'aaa'",
+			MessageData: map[string]interface{}{"Param": "id", "InvalidValue": "aaa", "Cause": "security.forbidden"},
+			Data:        nil,
 		}))
 	})
 }
@@ -52,3 +54,41 @@ func TestErrBadParam_Unwrap(t *testing.T) {
 		Expect(err.Unwrap()).To(BeNil())
 	})
 }
+
+func TestErrConflict(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should return response data as expected", func(t *testing.T) {
+		err := fail.ErrConflict{Field: "email"}
+		Expect(err.Error()).To(Equal("email conflict"))
+		Expect(*err.Respond()).To(Equal(fail.BizErrorDetail{
+			Status:      http.StatusConflict,
+			Code:        "common.conflict",
+			Message:     "email conflict",
+			MessageData: map[string]interface{}{"Field": "email"},
+			Data:        nil,
+		}))
+	})
+}
+
+func TestErrTooManyRequests(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should round retry after up to whole seconds", func(t *testing.T) {
+		Expect((&fail.ErrTooManyRequests{RetryAfter: 0}).RetryAfterSeconds()).To(Equal(1))
+		Expect((&fail.ErrTooManyRequests{RetryAfter: time.Second}).RetryAfterSeconds()).To(Equal(1))
+		Expect((&fail.ErrTooManyRequests{RetryAfter: 61 * time.Second / 2}).RetryAfterSeconds()).To(Equal(31))
+	})
+
+	t.Run("should return response data as expected", func(t *testing.T) {
+		err := fail.ErrTooManyRequests{RetryAfter: 3 * time.Second}
+		Expect(err.Error()).To(Equal("too many requests"))
+		Expect(*err.Respond()).To(Equal(fail.BizErrorDetail{
+			Status:      http.StatusTooManyRequests,
+			Code:        "common.too_many_requests",
+			Message:     "too many requests",
+			MessageData: map[string]interface{}{"RetryAfter": 3},
+			Data:        nil,
+		}))
+	})
+}
@@ -0,0 +1,170 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+var (
+	DefaultTimeout  = 3 * time.Second
+	DefaultCacheTTL = 5 * time.Second
+
+	ErrCheckTimeout = errors.New("check timeout")
+)
+
+// Checker is a named dependency check, the result is cached for CacheTTL.
+type Checker struct {
+	Name     string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	Check    func(ctx context.Context) error
+}
+
+type Result struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckTime time.Time `json:"check_time"`
+	Duration  int64     `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type entry struct {
+	checker Checker
+
+	mu     sync.Mutex
+	result *Result
+}
+
+// Registry holds the checkers and the readiness flag of service.
+type Registry struct {
+	mu      sync.RWMutex
+	entries []*entry
+	ready   int32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(checkers ...Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range checkers {
+		if c.Timeout <= 0 {
+			c.Timeout = DefaultTimeout
+		}
+		if c.CacheTTL <= 0 {
+			c.CacheTTL = DefaultCacheTTL
+		}
+		r.entries = append(r.entries, &entry{checker: c})
+	}
+}
+
+func (r *Registry) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&r.ready, 1)
+	} else {
+		atomic.StoreInt32(&r.ready, 0)
+	}
+}
+
+func (r *Registry) IsReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// Readiness run all checkers concurrently, the service is ready only if it is marked as ready and all checks passed.
+func (r *Registry) Readiness(ctx context.Context) Report {
+	r.mu.RLock()
+	entries := make([]*entry, len(r.entries))
+	copy(entries, r.entries)
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(entries))}
+	if !r.IsReady() {
+		report.Status = StatusDown
+	}
+
+	wg := sync.WaitGroup{}
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			report.Checks[i] = e.check(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (e *entry) check(ctx context.Context) Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.result != nil && time.Since(e.result.CheckTime) < e.checker.CacheTTL {
+		return *e.result
+	}
+
+	start := time.Now()
+	err := runWithTimeout(ctx, e.checker.Timeout, e.checker.Check)
+	result := Result{Name: e.checker.Name, Status: StatusUp, CheckTime: start,
+		Duration: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	e.result = &result
+	return result
+}
+
+func runWithTimeout(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrCheckTimeout
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register add checkers into the default registry
+func Register(checkers ...Checker) {
+	defaultRegistry.Register(checkers...)
+}
+
+// MarkReady mark the service as ready, it should be called after migrations finished.
+func MarkReady() {
+	defaultRegistry.SetReady(true)
+}
+
+// MarkUnready mark the service as unready, e.g. on shutting down.
+func MarkUnready() {
+	defaultRegistry.SetReady(false)
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PathLiveness  = "/healthz"
+	PathReadiness = "/readyz"
+)
+
+func RegisterHealthRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group("/", middleWares...)
+	g.GET(PathLiveness, handleLiveness)
+	g.GET(PathReadiness, handleReadiness(defaultRegistry))
+}
+
+// @ID health-liveness
+// @Success 200 {object} health.Report
+// @Router /healthz [get]
+func handleLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, &Report{Status: StatusUp, Checks: []Result{}})
+}
+
+// @ID health-readiness
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /readyz [get]
+func handleReadiness(registry *Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := registry.Readiness(c.Request.Context())
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, &report)
+	}
+}
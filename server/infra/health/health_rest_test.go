@@ -0,0 +1,50 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/health"
+	"owlet/server/testinfra"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func TestHealthRestAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	health.RegisterHealthRestAPI(router)
+
+	t.Run("liveness should always be up", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, health.PathLiveness, nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"status": "UP", "checks": []}`))
+	})
+
+	t.Run("readiness should follow the ready flag and checkers", func(t *testing.T) {
+		health.MarkUnready()
+		req := httptest.NewRequest(http.MethodGet, health.PathReadiness, nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(MatchJSON(`{"status": "DOWN", "checks": []}`))
+
+		health.MarkReady()
+		req = httptest.NewRequest(http.MethodGet, health.PathReadiness, nil)
+		status, body, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"status": "UP", "checks": []}`))
+
+		health.Register(health.Checker{Name: "broken", Check: func(ctx context.Context) error {
+			return errors.New("some error")
+		}})
+		req = httptest.NewRequest(http.MethodGet, health.PathReadiness, nil)
+		status, body, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(ContainSubstring(`"name":"broken","status":"DOWN","error":"some error"`))
+	})
+}
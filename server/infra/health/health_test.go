@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRegistry(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be down until marked as ready", func(t *testing.T) {
+		r := NewRegistry()
+		Expect(r.Readiness(context.TODO()).Status).To(Equal(StatusDown))
+
+		r.SetReady(true)
+		report := r.Readiness(context.TODO())
+		Expect(report.Status).To(Equal(StatusUp))
+		Expect(report.Checks).To(BeEmpty())
+
+		r.SetReady(false)
+		Expect(r.Readiness(context.TODO()).Status).To(Equal(StatusDown))
+	})
+
+	t.Run("should be down when any check failed", func(t *testing.T) {
+		r := NewRegistry()
+		r.SetReady(true)
+		r.Register(
+			Checker{Name: "ok", Check: func(ctx context.Context) error { return nil }},
+			Checker{Name: "broken", Check: func(ctx context.Context) error { return errors.New("some error") }},
+		)
+
+		report := r.Readiness(context.TODO())
+		Expect(report.Status).To(Equal(StatusDown))
+		Expect(len(report.Checks)).To(Equal(2))
+		Expect(report.Checks[0].Name).To(Equal("ok"))
+		Expect(report.Checks[0].Status).To(Equal(StatusUp))
+		Expect(report.Checks[0].Error).To(BeEmpty())
+		Expect(report.Checks[1].Name).To(Equal("broken"))
+		Expect(report.Checks[1].Status).To(Equal(StatusDown))
+		Expect(report.Checks[1].Error).To(Equal("some error"))
+	})
+
+	t.Run("check should fail when timeout", func(t *testing.T) {
+		r := NewRegistry()
+		r.SetReady(true)
+		r.Register(Checker{Name: "slow", Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}})
+
+		start := time.Now()
+		report := r.Readiness(context.TODO())
+		Expect(time.Since(start) < 500*time.Millisecond).To(BeTrue())
+		Expect(report.Status).To(Equal(StatusDown))
+		Expect(report.Checks[0].Error).To(Equal(ErrCheckTimeout.Error()))
+	})
+
+	t.Run("check result should be cached", func(t *testing.T) {
+		r := NewRegistry()
+		r.SetReady(true)
+		count := 0
+		r.Register(Checker{Name: "counter", CacheTTL: 50 * time.Millisecond, Check: func(ctx context.Context) error {
+			count++
+			return nil
+		}})
+
+		r.Readiness(context.TODO())
+		r.Readiness(context.TODO())
+		Expect(count).To(Equal(1))
+
+		time.Sleep(60 * time.Millisecond)
+		r.Readiness(context.TODO())
+		Expect(count).To(Equal(2))
+	})
+}
@@ -0,0 +1,258 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the decoder of webp
+)
+
+const (
+	EnvSizes     = "IMAGE_SIZES"      // comma separated pixels, e.g. '160,320,640,1280'
+	EnvMaxPixels = "IMAGE_MAX_PIXELS" // the limit of width * height
+
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+
+	jpegQuality = 85
+)
+
+var (
+	// ErrInvalid the content is not a decodable image
+	ErrInvalid = errors.New("invalid image")
+	// ErrTooLarge the dimensions exceed Options.MaxPixels
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Options the thumbnails of each size (as the width) are generated when uploading,
+// and the requested variants are rounded up to one of the sizes, so the number of variants is bounded.
+type Options struct {
+	Sizes     []int
+	MaxPixels int
+}
+
+var DefaultOptions = Options{Sizes: []int{160, 320, 640, 1280}, MaxPixels: 50_000_000}
+
+// Active the options used by domain services, see OptionsFromEnv
+var Active = DefaultOptions
+
+// OptionsFromEnv the default options are used if the env is absent or invalid
+func OptionsFromEnv() Options {
+	opts := DefaultOptions
+	if value := os.Getenv(EnvSizes); value != "" {
+		sizes, err := ParseSizes(value)
+		if err != nil {
+			logrus.Warnf("%s: %v, the default sizes are used", EnvSizes, err)
+		} else {
+			opts.Sizes = sizes
+		}
+	}
+	if value := os.Getenv(EnvMaxPixels); value != "" {
+		pixels, err := strconv.Atoi(value)
+		if err != nil || pixels <= 0 {
+			logrus.Warnf("%s: invalid value '%s', the default %d is used", EnvMaxPixels, value, opts.MaxPixels)
+		} else {
+			opts.MaxPixels = pixels
+		}
+	}
+	return opts
+}
+
+// ParseSizes parse '160,320,640', the sizes are sorted and deduplicated
+func ParseSizes(s string) ([]int, error) {
+	seen := map[int]bool{}
+	sizes := []int{}
+	for _, part := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || size <= 0 || size > 10000 {
+			return nil, fmt.Errorf("invalid size '%s'", part)
+		}
+		if !seen[size] {
+			seen[size] = true
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// Snap round the requested size up to one of Sizes, or down to the largest one. 0 means unbounded.
+func (o Options) Snap(size int) int {
+	if size <= 0 || len(o.Sizes) == 0 {
+		return 0
+	}
+	for _, s := range o.Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return o.Sizes[len(o.Sizes)-1]
+}
+
+// Supported the MIME types processed, other images (e.g. SVG) are kept as they are
+func Supported(mimeType string) bool {
+	_, found := FormatOf(mimeType)
+	return found
+}
+
+// FormatOf the format of the supported MIME types, e.g. 'image/jpeg' => 'jpeg'
+func FormatOf(mimeType string) (string, bool) {
+	switch mimeType {
+	case "image/jpeg":
+		return FormatJPEG, true
+	case "image/png":
+		return FormatPNG, true
+	case "image/gif":
+		return FormatGIF, true
+	case "image/webp":
+		return FormatWebP, true
+	}
+	return "", false
+}
+
+// Info the format and the dimensions of an image
+type Info struct {
+	Format string
+	Width  int
+	Height int
+}
+
+// Inspect read the header of image, the image is rejected if it is not decodable or too large
+func (o Options) Inspect(data []byte) (*Info, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalid
+	}
+	if o.MaxPixels > 0 && cfg.Width*cfg.Height > o.MaxPixels {
+		return nil, ErrTooLarge
+	}
+	return &Info{Format: format, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// Decode the whole image is decoded, truncated images are rejected here
+func (o Options) Decode(data []byte) (image.Image, *Info, error) {
+	info, err := o.Inspect(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return img, info, nil
+}
+
+// Fit the dimensions scaled down to fit in the box of width * height (0 means unbounded) with the aspect ratio,
+// images are never scaled up
+func Fit(width, height, boxWidth, boxHeight int) (int, int) {
+	scale := 1.0
+	if boxWidth > 0 && width > boxWidth {
+		scale = float64(boxWidth) / float64(width)
+	}
+	if boxHeight > 0 && float64(height)*scale > float64(boxHeight) {
+		scale = float64(boxHeight) / float64(height)
+	}
+	w, h := int(float64(width)*scale+0.5), int(float64(height)*scale+0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// Resize scale img to fit in the box, it returns false if the image fits already
+func Resize(img image.Image, boxWidth, boxHeight int) (image.Image, bool) {
+	b := img.Bounds()
+	w, h := Fit(b.Dx(), b.Dy(), boxWidth, boxHeight)
+	if w == b.Dx() && h == b.Dy() {
+		return img, false
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst, true
+}
+
+// VariantType the MIME type of the variants of a format, the variants of lossless formats are PNG
+// for the transparency, others are JPEG
+func VariantType(format string) string {
+	if format == FormatPNG || format == FormatGIF {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// EncodeVariant encode the variant of an image in format, in the type of VariantType
+func EncodeVariant(img image.Image, format string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var err error
+	if VariantType(format) == "image/png" {
+		err = png.Encode(buf, img)
+	} else {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sanitize remove the metadata (EXIF, XMP, comments, text chunks) which may carry GPS locations or
+// device information. The pixels are kept untouched, except that a JPEG rotated by the EXIF orientation
+// is re-encoded upright since the orientation is removed. GIF has no such metadata and is kept as it is.
+func (o Options) Sanitize(data []byte) ([]byte, *Info, error) {
+	info, err := o.Inspect(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sanitized []byte
+	switch info.Format {
+	case FormatJPEG:
+		if orientation := jpegOrientation(data); orientation > 1 && orientation <= 8 {
+			img, _, err := o.Decode(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			img = orient(img, orientation)
+			buf := &bytes.Buffer{}
+			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+				return nil, nil, err
+			}
+			b := img.Bounds()
+			return buf.Bytes(), &Info{Format: FormatJPEG, Width: b.Dx(), Height: b.Dy()}, nil
+		}
+		sanitized, err = stripJPEG(data)
+	case FormatPNG:
+		sanitized, err = stripPNG(data)
+	case FormatWebP:
+		sanitized, err = stripWebP(data)
+	case FormatGIF:
+		if _, err := gif.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		sanitized = data
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported format %s", ErrInvalid, info.Format)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return sanitized, info, nil
+}
@@ -0,0 +1,220 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// withEXIF insert an APP1 segment with the orientation and a fake GPS tag after SOI
+func withEXIF(jpegData []byte, orientation uint16) []byte {
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, uint32(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPSLatitude 31.2304")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, jpegData[2:]...)
+}
+
+func encodeJPEG(img image.Image) []byte {
+	buf := &bytes.Buffer{}
+	jpeg.Encode(buf, img, nil)
+	return buf.Bytes()
+}
+
+func TestParseSizes(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(ParseSizes("640, 160,320,160")).To(Equal([]int{160, 320, 640}))
+	for _, s := range []string{"", "a", "0", "-1", "100000"} {
+		_, err := ParseSizes(s)
+		Expect(err).To(HaveOccurred())
+	}
+
+	defer os.Unsetenv(EnvSizes)
+	defer os.Unsetenv(EnvMaxPixels)
+	os.Setenv(EnvSizes, "100,200")
+	os.Setenv(EnvMaxPixels, "x")
+	Expect(OptionsFromEnv()).To(Equal(Options{Sizes: []int{100, 200}, MaxPixels: DefaultOptions.MaxPixels}))
+}
+
+func TestSnapAndFit(t *testing.T) {
+	RegisterTestingT(t)
+
+	o := Options{Sizes: []int{160, 320, 640}}
+	Expect(o.Snap(0)).To(Equal(0))
+	Expect(o.Snap(100)).To(Equal(160))
+	Expect(o.Snap(320)).To(Equal(320))
+	Expect(o.Snap(321)).To(Equal(640))
+	Expect(o.Snap(5000)).To(Equal(640))
+
+	w, h := Fit(1000, 500, 160, 0)
+	Expect([]int{w, h}).To(Equal([]int{160, 80}))
+	w, h = Fit(1000, 500, 0, 100)
+	Expect([]int{w, h}).To(Equal([]int{200, 100}))
+	w, h = Fit(1000, 500, 320, 100)
+	Expect([]int{w, h}).To(Equal([]int{200, 100}))
+	w, h = Fit(100, 50, 320, 320)
+	Expect([]int{w, h}).To(Equal([]int{100, 50}))
+}
+
+func TestSanitize(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should strip EXIF of JPEG losslessly", func(t *testing.T) {
+		original := encodeJPEG(testImage(40, 20))
+		data := withEXIF(original, 1)
+		Expect(jpegOrientation(data)).To(Equal(1))
+
+		sanitized, info, err := DefaultOptions.Sanitize(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*info).To(Equal(Info{Format: FormatJPEG, Width: 40, Height: 20}))
+		Expect(sanitized).To(Equal(original))
+		Expect(bytes.Contains(sanitized, []byte("GPSLatitude"))).To(BeFalse())
+	})
+
+	t.Run("should rotate JPEG upright by EXIF orientation", func(t *testing.T) {
+		data := withEXIF(encodeJPEG(testImage(40, 20)), 6)
+		Expect(jpegOrientation(data)).To(Equal(6))
+
+		sanitized, info, err := DefaultOptions.Sanitize(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*info).To(Equal(Info{Format: FormatJPEG, Width: 20, Height: 40}))
+		Expect(bytes.Contains(sanitized, []byte("Exif"))).To(BeFalse())
+		Expect(jpegOrientation(sanitized)).To(Equal(0))
+	})
+
+	t.Run("should strip text chunks of PNG", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		Expect(png.Encode(buf, testImage(8, 8))).ToNot(HaveOccurred())
+		original := buf.Bytes()
+		// a tEXt chunk before IDAT, the CRC is not verified by the stripper
+		text := []byte{0, 0, 0, 8, 't', 'E', 'X', 't', 'G', 'P', 'S', 0, '3', '1', '.', '2', 0, 0, 0, 0}
+		data := append(append(append([]byte{}, original[:33]...), text...), original[33:]...)
+
+		sanitized, info, err := DefaultOptions.Sanitize(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Format).To(Equal(FormatPNG))
+		Expect(sanitized).To(Equal(original))
+	})
+
+	t.Run("should strip EXIF chunk of WebP", func(t *testing.T) {
+		original, err := ioutil.ReadFile("testdata/gopher.webp")
+		Expect(err).ToNot(HaveOccurred())
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(original))
+		Expect(err).ToNot(HaveOccurred())
+
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = 0x08
+		putUint24(vp8x[12:], cfg.Width-1)
+		putUint24(vp8x[15:], cfg.Height-1)
+		exif := append([]byte("EXIF\x0c\x00\x00\x00"), []byte("GPSLatitude ")...)
+		data := append(append(append(append([]byte{}, original[:12]...), vp8x...), original[12:]...), exif...)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+		sanitized, info, err := DefaultOptions.Sanitize(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Format).To(Equal(FormatWebP))
+		Expect(bytes.Contains(sanitized, []byte("GPSLatitude"))).To(BeFalse())
+		Expect(sanitized[20] & 0x08).To(BeZero())
+		_, _, err = image.Decode(bytes.NewReader(sanitized))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("should reject undecodable or too large images", func(t *testing.T) {
+		_, _, err := DefaultOptions.Sanitize([]byte("\xff\xd8\xff\xe0 not a jpeg"))
+		Expect(errors.Is(err, ErrInvalid)).To(BeTrue())
+
+		_, _, err = Options{MaxPixels: 100}.Sanitize(encodeJPEG(testImage(20, 10)))
+		Expect(err).To(Equal(ErrTooLarge))
+
+		truncated := encodeJPEG(testImage(20, 10))
+		_, _, err = DefaultOptions.Decode(truncated[:len(truncated)/2])
+		Expect(errors.Is(err, ErrInvalid)).To(BeTrue())
+	})
+}
+
+func TestVariant(t *testing.T) {
+	RegisterTestingT(t)
+
+	img, _, err := DefaultOptions.Decode(encodeJPEG(testImage(400, 200)))
+	Expect(err).ToNot(HaveOccurred())
+
+	resized, changed := Resize(img, 160, 0)
+	Expect(changed).To(BeTrue())
+	Expect(resized.Bounds().Dx()).To(Equal(160))
+	Expect(resized.Bounds().Dy()).To(Equal(80))
+
+	_, changed = Resize(img, 640, 640)
+	Expect(changed).To(BeFalse())
+
+	data, err := EncodeVariant(resized, FormatJPEG)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(VariantType(FormatJPEG)).To(Equal("image/jpeg"))
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(format).To(Equal("jpeg"))
+	Expect(cfg.Width).To(Equal(160))
+
+	data, err = EncodeVariant(resized, FormatGIF)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(VariantType(FormatGIF)).To(Equal("image/png"))
+	_, format, _ = image.DecodeConfig(bytes.NewReader(data))
+	Expect(format).To(Equal("png"))
+}
+
+func TestOrient(t *testing.T) {
+	RegisterTestingT(t)
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	// rotated 90 clockwise: the left pixel goes to the top
+	dst := orient(src, 6)
+	Expect(dst.Bounds().Size()).To(Equal(image.Pt(1, 2)))
+	Expect(dst.At(0, 0)).To(Equal(red))
+	Expect(dst.At(0, 1)).To(Equal(blue))
+
+	dst = orient(src, 8)
+	Expect(dst.At(0, 0)).To(Equal(blue))
+	dst = orient(src, 2)
+	Expect(dst.At(0, 0)).To(Equal(blue))
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
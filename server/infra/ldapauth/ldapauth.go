@@ -0,0 +1,321 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	EnvURL          = "LDAP_URL" // ldap://host:389 or ldaps://host:636
+	EnvStartTLS     = "LDAP_START_TLS"
+	EnvBindDN       = "LDAP_BIND_DN" // the service account to search users, search anonymously if absent
+	EnvBindPassword = "LDAP_BIND_PASSWORD"
+	EnvBaseDN       = "LDAP_BASE_DN"
+	EnvUserFilter   = "LDAP_USER_FILTER"   // %s is replaced by the escaped username
+	EnvGroupBaseDN  = "LDAP_GROUP_BASE_DN" // the same as LDAP_BASE_DN if absent
+	EnvGroupFilter  = "LDAP_GROUP_FILTER"  // %s is replaced by the escaped user DN
+	// EnvGroupRoles the semicolon separated mappings '<group DN or CN>:<role>', e.g. 'cn=admins,ou=groups,dc=example,dc=com:admin'
+	EnvGroupRoles   = "LDAP_GROUP_ROLES"
+	EnvAttrUsername = "LDAP_ATTR_USERNAME"
+	EnvAttrEmail    = "LDAP_ATTR_EMAIL"
+	EnvAttrName     = "LDAP_ATTR_NAME"
+
+	DefaultUserFilter   = "(uid=%s)"
+	DefaultGroupFilter  = "(member=%s)"
+	DefaultAttrUsername = "uid"
+	DefaultAttrEmail    = "mail"
+	DefaultAttrName     = "cn"
+	DefaultTimeout      = 5 * time.Second
+)
+
+// ErrInvalidCredentials the user is absent in directory or the password is wrong, it wraps fail.ErrUnauthenticated
+var ErrInvalidCredentials = fmt.Errorf("%w: invalid credentials", fail.ErrUnauthenticated)
+
+type Config struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+	GroupBaseDN  string
+	GroupFilter  string
+	// GroupRoles maps the DN or CN of groups (case insensitive) to roles
+	GroupRoles map[string]string
+
+	AttrUsername string
+	AttrEmail    string
+	AttrName     string
+	Timeout      time.Duration
+}
+
+// Entry the authenticated user in directory
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+	Roles    authority.Permissions
+}
+
+// Directory authenticate users by binding against a LDAP server
+type Directory struct {
+	Config Config
+}
+
+// Active the directory used for login, LDAP login is disabled if it is nil (see FromEnv)
+var Active *Directory
+
+func NewDirectory(cfg Config) *Directory {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = DefaultUserFilter
+	}
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	if cfg.GroupFilter == "" {
+		cfg.GroupFilter = DefaultGroupFilter
+	}
+	if cfg.AttrUsername == "" {
+		cfg.AttrUsername = DefaultAttrUsername
+	}
+	if cfg.AttrEmail == "" {
+		cfg.AttrEmail = DefaultAttrEmail
+	}
+	if cfg.AttrName == "" {
+		cfg.AttrName = DefaultAttrName
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	roles := map[string]string{}
+	for group, role := range cfg.GroupRoles {
+		roles[normalizeGroup(group)] = role
+	}
+	cfg.GroupRoles = roles
+	return &Directory{Config: cfg}
+}
+
+// FromEnv build the directory if LDAP_URL is specified, otherwise nil is returned
+func FromEnv() *Directory {
+	u := os.Getenv(EnvURL)
+	if u == "" {
+		return nil
+	}
+	return NewDirectory(Config{
+		URL:          u,
+		StartTLS:     strings.EqualFold(os.Getenv(EnvStartTLS), "true"),
+		BindDN:       os.Getenv(EnvBindDN),
+		BindPassword: os.Getenv(EnvBindPassword),
+		BaseDN:       os.Getenv(EnvBaseDN),
+		UserFilter:   os.Getenv(EnvUserFilter),
+		GroupBaseDN:  os.Getenv(EnvGroupBaseDN),
+		GroupFilter:  os.Getenv(EnvGroupFilter),
+		GroupRoles:   ParseGroupRoles(os.Getenv(EnvGroupRoles)),
+		AttrUsername: os.Getenv(EnvAttrUsername),
+		AttrEmail:    os.Getenv(EnvAttrEmail),
+		AttrName:     os.Getenv(EnvAttrName),
+	})
+}
+
+// ParseGroupRoles parse mappings like 'cn=admins,ou=groups,dc=example,dc=com:admin;writers:writer'
+func ParseGroupRoles(s string) map[string]string {
+	roles := map[string]string{}
+	for _, pair := range strings.Split(s, ";") {
+		idx := strings.LastIndex(pair, ":")
+		if idx <= 0 {
+			continue
+		}
+		group, role := strings.TrimSpace(pair[:idx]), strings.TrimSpace(pair[idx+1:])
+		if group != "" && role != "" {
+			roles[group] = role
+		}
+	}
+	return roles
+}
+
+// Authenticate find the user by username, then verify the password by binding as the user.
+// ErrInvalidCredentials is returned if the user is absent, ambiguous or the password is wrong.
+func (d *Directory) Authenticate(username, password string) (*Entry, error) {
+	if username == "" || password == "" {
+		// an empty password means unauthenticated bind, which always succeeds
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+	user, err := d.searchUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	// groups are searched by the service account, users may not be able to read groups
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+	groups, err := d.searchGroups(conn, user.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		DN:       user.DN,
+		Username: user.GetAttributeValue(d.Config.AttrUsername),
+		Email:    user.GetAttributeValue(d.Config.AttrEmail),
+		Name:     user.GetAttributeValue(d.Config.AttrName),
+		Groups:   groups,
+		Roles:    d.rolesOf(groups),
+	}
+	if entry.Username == "" {
+		entry.Username = username
+	}
+	return entry, nil
+}
+
+func (d *Directory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.Config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.Config.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(d.Config.Timeout)
+	if d.Config.StartTLS {
+		host, err := serverName(d.Config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// serverName the host name in url to verify the certificate of server, the port is optional in url
+func serverName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("host is absent in %s", rawURL)
+	}
+	return u.Hostname(), nil
+}
+
+func (d *Directory) bindService(conn *ldap.Conn) error {
+	if d.Config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(d.Config.BindDN, d.Config.BindPassword)
+}
+
+func (d *Directory) searchUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(d.Config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.Config.Timeout.Seconds()), false,
+		strings.ReplaceAll(d.Config.UserFilter, "%s", ldap.EscapeFilter(username)),
+		[]string{d.Config.AttrUsername, d.Config.AttrEmail, d.Config.AttrName}, nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// searchGroups the DNs of groups which the user is member of, ordered by DN
+func (d *Directory) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(d.Config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(d.Config.Timeout.Seconds()), false,
+		strings.ReplaceAll(d.Config.GroupFilter, "%s", ldap.EscapeFilter(userDN)), []string{"cn"}, nil))
+	if err != nil {
+		var ldapErr *ldap.Error
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		groups = append(groups, e.DN)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// rolesOf map groups to roles by DN or CN, ordered by role
+func (d *Directory) rolesOf(groups []string) authority.Permissions {
+	unique := map[string]bool{}
+	for _, group := range groups {
+		if role, found := d.Config.GroupRoles[normalizeGroup(group)]; found {
+			unique[role] = true
+		} else if role, found := d.Config.GroupRoles[normalizeGroup(commonName(group))]; found {
+			unique[role] = true
+		}
+	}
+	roles := authority.Permissions{}
+	for role := range unique {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// commonName the value of the first RDN if it is a cn
+func commonName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// normalizeGroup DNs are compared case insensitively and without spaces around separators
+func normalizeGroup(group string) string {
+	if parsed, err := ldap.ParseDN(group); err == nil && len(parsed.RDNs) > 1 {
+		parts := make([]string, 0, len(parsed.RDNs))
+		for _, rdn := range parsed.RDNs {
+			for _, attr := range rdn.Attributes {
+				parts = append(parts, strings.ToLower(attr.Type)+"="+strings.ToLower(attr.Value))
+			}
+		}
+		return strings.Join(parts, ",")
+	}
+	return strings.ToLower(strings.TrimSpace(group))
+}
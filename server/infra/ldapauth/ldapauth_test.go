@@ -0,0 +1,128 @@
+package ldapauth
+
+import (
+	"errors"
+	"os"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/fail"
+	"owlet/server/testinfra"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testServiceDN = "cn=owlet,ou=services,dc=example,dc=com"
+	testTomDN     = "uid=tom,ou=people,dc=example,dc=com"
+)
+
+func startDirectory() (*testinfra.LDAPStub, error) {
+	return testinfra.StartLDAPStub(
+		testinfra.LDAPStubEntry{DN: "dc=example,dc=com"},
+		testinfra.LDAPStubEntry{DN: testServiceDN, Password: "service-secret"},
+		testinfra.LDAPStubEntry{DN: testTomDN, Password: "tom-secret", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"tom"}, "mail": {"tom@example.com"}, "cn": {"Tom Cat"}}},
+		testinfra.LDAPStubEntry{DN: "uid=jerry,ou=people,dc=example,dc=com", Password: "jerry-secret",
+			Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"jerry"}}},
+		testinfra.LDAPStubEntry{DN: "cn=admins,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"admins"}, "member": {testTomDN}}},
+		testinfra.LDAPStubEntry{DN: "cn=writers,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"writers"}, "member": {testTomDN, "uid=jerry,ou=people,dc=example,dc=com"}}},
+		testinfra.LDAPStubEntry{DN: "cn=readers,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"readers"}, "member": {testTomDN}}},
+	)
+}
+
+func TestAuthenticate(t *testing.T) {
+	RegisterTestingT(t)
+
+	stub, err := startDirectory()
+	Expect(err).ToNot(HaveOccurred())
+	defer stub.Close()
+
+	d := NewDirectory(Config{URL: stub.URL, BindDN: testServiceDN, BindPassword: "service-secret",
+		BaseDN: "dc=example,dc=com", UserFilter: "(&(objectClass=person)(uid=%s))",
+		GroupRoles: map[string]string{"CN=Admins, OU=Groups, DC=example, DC=com": authority.RoleAdmin, "writers": "writer"}})
+
+	t.Run("should authenticate user and map groups to roles", func(t *testing.T) {
+		entry, err := d.Authenticate("tom", "tom-secret")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*entry).To(Equal(Entry{DN: testTomDN, Username: "tom", Email: "tom@example.com", Name: "Tom Cat",
+			Groups: []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=readers,ou=groups,dc=example,dc=com",
+				"cn=writers,ou=groups,dc=example,dc=com"},
+			Roles: authority.Permissions{"admin", "writer"}}))
+		Expect(stub.Binds()).To(ContainElement(testTomDN))
+	})
+
+	t.Run("should reject wrong password, unknown user and empty password", func(t *testing.T) {
+		for _, c := range [][2]string{{"tom", "wrong"}, {"nobody", "secret"}, {"tom", ""}, {"*", "tom-secret"}} {
+			_, err := d.Authenticate(c[0], c[1])
+			Expect(err).To(Equal(ErrInvalidCredentials))
+			Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+		}
+	})
+
+	t.Run("should fail when service account is rejected", func(t *testing.T) {
+		bad := NewDirectory(Config{URL: stub.URL, BindDN: testServiceDN, BindPassword: "wrong", BaseDN: "dc=example,dc=com"})
+		_, err := bad.Authenticate("tom", "tom-secret")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeFalse())
+	})
+
+	t.Run("should have no roles if groups are absent", func(t *testing.T) {
+		d := NewDirectory(Config{URL: stub.URL, BaseDN: "dc=example,dc=com", GroupBaseDN: "ou=absent,dc=example,dc=com"})
+		entry, err := d.Authenticate("jerry", "jerry-secret")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entry.Username).To(Equal("jerry"))
+		Expect(entry.Groups).To(BeEmpty())
+		Expect(entry.Roles).To(BeEmpty())
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be disabled if url is absent", func(t *testing.T) {
+		Expect(FromEnv()).To(BeNil())
+	})
+
+	t.Run("should build directory from env", func(t *testing.T) {
+		defer os.Unsetenv(EnvURL)
+		defer os.Unsetenv(EnvBaseDN)
+		defer os.Unsetenv(EnvGroupRoles)
+		os.Setenv(EnvURL, "ldap://127.0.0.1:389")
+		os.Setenv(EnvBaseDN, "dc=example,dc=com")
+		os.Setenv(EnvGroupRoles, "cn=admins,ou=groups,dc=example,dc=com:admin; writers : writer;invalid")
+
+		d := FromEnv()
+		Expect(d.Config.URL).To(Equal("ldap://127.0.0.1:389"))
+		Expect(d.Config.GroupBaseDN).To(Equal("dc=example,dc=com"))
+		Expect(d.Config.UserFilter).To(Equal(DefaultUserFilter))
+		Expect(d.Config.GroupRoles).To(Equal(map[string]string{
+			"cn=admins,ou=groups,dc=example,dc=com": "admin", "writers": "writer"}))
+	})
+}
+
+func TestServerName(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be the host name with or without port", func(t *testing.T) {
+		for rawURL, host := range map[string]string{
+			"ldap://ldap.example.com":      "ldap.example.com",
+			"ldap://ldap.example.com:389":  "ldap.example.com",
+			"ldaps://ldap.example.com:636": "ldap.example.com",
+			"ldap://[::1]:389":             "::1",
+		} {
+			name, err := serverName(rawURL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).To(Equal(host), rawURL)
+		}
+	})
+
+	t.Run("should fail if host is absent", func(t *testing.T) {
+		_, err := serverName("ldap:///dc=example")
+		Expect(err).To(HaveOccurred())
+		_, err = serverName("ldap://%zz")
+		Expect(err).To(HaveOccurred())
+	})
+}
@@ -1,45 +1,103 @@
 package localize
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"golang.org/x/text/language"
 )
 
+const (
+	// ParamLang the query parameter to specify language
+	ParamLang = "lang"
+	// CookieLang the cookie to specify language
+	CookieLang = "lang"
+	// HeaderContentLanguage the response header carries the negotiated language
+	HeaderContentLanguage = "Content-Language"
+)
+
 type (
-	// LangResolver ...
+	// LangResolver resolve the requested languages from request, in the format of Accept-Language header
+	// (a single language tag is also valid), defaultLang is returned if absent
 	LangResolver = func(context *gin.Context, defaultLang string) string
 
-	// Option ...
+	// PreferenceResolver resolve the preferred language of the current user, empty if unknown
+	PreferenceResolver = func(context *gin.Context) string
+
+	// Option customize the localize middleware
 	Option func(*GinI18n)
 )
 
+// BundleCfg the languages are discovered from the message files in RootPath
 type BundleCfg struct {
 	DefaultLanguage language.Tag
-	AcceptLanguage  []language.Tag
 	RootPath        string
 }
 
-// WithBundle ...
+// active the most recently created instance, it is used by the missing translation report
+var active atomic.Value // *GinI18n
+
+var ErrNotInitialized = errors.New("localize is not initialized")
+
+// MissingTranslations report the message ids absent in each language, compared with all languages
+func MissingTranslations() (map[string][]string, error) {
+	ins, ok := active.Load().(*GinI18n)
+	if !ok {
+		return nil, ErrNotInitialized
+	}
+	return ins.currentState().messages.missingTranslations(), nil
+}
+
+// LocalizerOf the localizer of the best matched language, it is useful out of requests (e.g. rendering mails
+// in the preferred language of user). A default localizer (english without messages) is returned if not initialized.
+func LocalizerOf(lang string) *Localizer {
+	ins, ok := active.Load().(*GinI18n)
+	if !ok {
+		return defaultLocalizer
+	}
+	return ins.getLocalizerByLang(lang)
+}
+
+// WithBundle set the bundle configuration
 func WithBundle(config *BundleCfg) Option {
 	return func(g *GinI18n) {
 		g.setBundleConfig(config)
 	}
 }
 
-// WithCustomLangResolver ...
+// WithCustomLangResolver replace the default language negotiation (query, cookie, user preference and then header)
 func WithCustomLangResolver(f LangResolver) Option {
 	return func(g *GinI18n) {
 		g.setLangResolver(f)
 	}
 }
 
-var atI18n *GinI18n
+// WithPreferenceResolver resolve the user preferred language, which is checked after query and cookie
+func WithPreferenceResolver(f PreferenceResolver) Option {
+	return func(g *GinI18n) {
+		g.preferenceResolver = f
+	}
+}
+
+// DefaultReloadInterval the interval of checking message files for changes
+const DefaultReloadInterval = 5 * time.Second
 
-// newI18n ...
-func newI18n(opts ...Option) {
+// WithReload reload message files once they are changed on disk, the files are checked in every interval.
+// Reloading stops when ctx is done.
+func WithReload(ctx context.Context, interval time.Duration) Option {
+	return func(g *GinI18n) {
+		g.reloadCtx = ctx
+		g.reloadInterval = interval
+	}
+}
+
+// newI18n build the bundle and localizers, they are read only after built (until reloaded)
+func newI18n(opts ...Option) (*GinI18n, error) {
 	// init default value
 	ins := &GinI18n{
-		langResolver: defaultLangResolver,
 		bundleConfig: defaultBundleConfig,
 	}
 
@@ -48,45 +106,29 @@ func newI18n(opts ...Option) {
 		opt(ins)
 	}
 
-	ins.setBundle(ins.bundleConfig)
-
-	atI18n = ins
+	if err := ins.setBundle(ins.bundleConfig); err != nil {
+		return nil, err
+	}
+	if ins.reloadCtx != nil && ins.reloadInterval > 0 {
+		go ins.watch(ins.reloadCtx, ins.reloadInterval)
+	}
+	return ins, nil
 }
 
-// Localize ...
+// Localize resolve the localizer of each request, then save it in the gin context and the request context.
+// Use FromGinContext or FromContext to get the localizer.
+// It panics if message files are malformed.
 func Localize(opts ...Option) gin.HandlerFunc {
-	newI18n(opts...)
+	ins, err := newI18n(opts...)
+	if err != nil {
+		panic(err)
+	}
+	active.Store(ins)
 	return func(c *gin.Context) {
-		atI18n.setCurrentContext(c)
+		l := ins.resolveLocalizer(c)
+		c.Header(HeaderContentLanguage, l.Language().String())
+		c.Set(KeyLocalizer, l)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), l))
+		c.Next()
 	}
 }
-
-/*GetMessage get the i18n message
- param is one of these type: messageID, *i18n.LocalizeConfig
- Example:
-	GetMessage("hello") // messageID is hello
-	GetMessage(&i18n.LocalizeConfig{
-			MessageID: "welcomeWithName",
-			TemplateData: map[string]string{
-				"name": context.Param("name"),
-			},
-	})
-*/
-func GetMessage(param interface{}) (string, error) {
-	return atI18n.getMessage(param)
-}
-
-/*MustGetMessage get the i18n message without error handling
-  param is one of these type: messageID, *i18n.LocalizeConfig
-  Example:
-	MustGetMessage("hello") // messageID is hello
-	MustGetMessage(&i18n.LocalizeConfig{
-			MessageID: "welcomeWithName",
-			TemplateData: map[string]string{
-				"name": context.Param("name"),
-			},
-	})
-*/
-func MustGetMessage(param interface{}) string {
-	return atI18n.mustGetMessage(param)
-}
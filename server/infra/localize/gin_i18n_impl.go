@@ -2,12 +2,14 @@ package localize
 
 import (
 	"context"
-	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/text/language"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -15,154 +17,217 @@ const (
 )
 
 var (
-	defaultLanguage       = language.English
-	defaultAcceptLanguage = []language.Tag{
-		defaultLanguage,
-		language.Chinese,
-	}
+	defaultLanguage = language.English
 
 	defaultBundleConfig = &BundleCfg{
 		RootPath:        defaultRootPath,
-		AcceptLanguage:  defaultAcceptLanguage,
 		DefaultLanguage: defaultLanguage,
 	}
 )
 
-// defaultLangResolver ...
-func defaultLangResolver(context *gin.Context, defaultLang string) string {
-	lang := context.Query("lang")
-	if lang != "" {
-		return lang
+// resolveLocalizer negotiate the language of request, the sources are checked in order:
+// query parameter, cookie, user preference and then the Accept-Language header.
+// The first source matching a supported language wins, the default language is used if none matched.
+func (i *GinI18n) resolveLocalizer(c *gin.Context) *Localizer {
+	if i.langResolver != nil {
+		return i.getLocalizerByLang(i.langResolver(c, i.defaultLanguage.String()))
 	}
 
-	lang = context.GetHeader("Accept-Language")
-	if lang != "" {
-		return lang
+	sources := []func(*gin.Context) string{
+		func(c *gin.Context) string { return c.Query(ParamLang) },
+		func(c *gin.Context) string { lang, _ := c.Cookie(CookieLang); return lang },
+		i.preferenceResolver,
+		func(c *gin.Context) string { return c.GetHeader("Accept-Language") },
 	}
-
-	return defaultLang
+	for _, source := range sources {
+		if source == nil {
+			continue
+		}
+		if localizer := i.matchLocalizer(source(c)); localizer != nil {
+			return localizer
+		}
+	}
+	return i.currentState().localizerByLang[i.defaultLanguage.String()]
 }
 
 type GinI18n struct {
 	bundleConfig *BundleCfg
 
-	bundle          *i18n.Bundle
-	currentContext  *gin.Context
-	localizerByLang map[string]*i18n.Localizer
-	defaultLanguage language.Tag
-	langResolver    LangResolver
+	defaultLanguage    language.Tag
+	langResolver       LangResolver
+	preferenceResolver PreferenceResolver
+
+	reloadCtx      context.Context
+	reloadInterval time.Duration
+	failedFiles    map[string]fileStamp // files failed to reload, they are not retried until changed again
+
+	state atomic.Value // *i18nState
+}
+
+// i18nState the loaded messages and the localizers built on them, it is replaced as a whole on reload
+type i18nState struct {
+	messages        *messageBundle
+	localizerByLang map[string]*Localizer
+	supported       []language.Tag // the default language is the first one
+	matcher         language.Matcher
 }
 
 func (i *GinI18n) setBundleConfig(cfg *BundleCfg) {
 	i.bundleConfig = cfg
 }
 
-// getMessage get localize message by lang and messageID
-func (i *GinI18n) getMessage(messageID interface{}) (string, error) {
-	lang := i.langResolver(i.currentContext, i.defaultLanguage.String())
-	localizer := i.getLocalizerByLang(lang)
+func (i *GinI18n) setBundle(cfg *BundleCfg) error {
+	i.bundleConfig = cfg
+	i.defaultLanguage = cfg.DefaultLanguage
+	return i.loadMessages()
+}
 
-	localizeItem, err := i.getLocalizeItem(messageID)
+// loadMessages load message files and replace the state, it is safe for concurrent use with requests
+func (i *GinI18n) loadMessages() error {
+	messages, err := loadMessageBundle(i.bundleConfig.RootPath, i.defaultLanguage)
 	if err != nil {
-		return fmt.Sprint(messageID), err
+		return err
 	}
-
-	message, err := localizer.Localize(localizeItem)
-	if err != nil {
-		return fmt.Sprint(messageID), err
+	supported := []language.Tag{i.defaultLanguage}
+	for _, tag := range messages.bundle.LanguageTags() {
+		if tag != i.defaultLanguage {
+			supported = append(supported, tag)
+		}
 	}
-
-	return message, nil
+	i.state.Store(&i18nState{
+		messages:        messages,
+		localizerByLang: i.buildLocalizers(messages),
+		supported:       supported,
+		matcher:         language.NewMatcher(supported),
+	})
+	return nil
 }
 
-// mustGetMessage ...
-func (i *GinI18n) mustGetMessage(param interface{}) string {
-	message, _ := i.getMessage(param)
-	return message
-}
-
-func (i *GinI18n) setCurrentContext(ctx context.Context) {
-	i.currentContext = ctx.(*gin.Context)
+func (i *GinI18n) setLangResolver(handler LangResolver) {
+	i.langResolver = handler
 }
 
-func (i *GinI18n) setBundle(cfg *BundleCfg) {
-	bundle := i18n.NewBundle(cfg.DefaultLanguage)
-	bundle.RegisterUnmarshalFunc("yaml", yaml.Unmarshal)
-
-	i.bundle = bundle
-	i.defaultLanguage = cfg.DefaultLanguage
-
-	i.loadMessageFiles(cfg)
-	i.setLocalizerByLang(cfg.AcceptLanguage)
+func (i *GinI18n) currentState() *i18nState {
+	return i.state.Load().(*i18nState)
 }
 
-func (i *GinI18n) setLangResolver(handler LangResolver) {
-	i.langResolver = handler
+// watch reload the message files once they are changed, until ctx is done
+func (i *GinI18n) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := i.reloadIfChanged(); err != nil {
+				logrus.Warnf("failed to reload i18n message files, previous messages are kept: %v", err)
+			}
+		}
+	}
 }
 
-// loadMessageFiles load all file localize to bundle
-func (i *GinI18n) loadMessageFiles(config *BundleCfg) {
-	for _, lang := range config.AcceptLanguage {
-		path := config.RootPath + "/" + lang.String() + ".yaml"
-		i.bundle.MustLoadMessageFile(path)
+func (i *GinI18n) reloadIfChanged() error {
+	files, err := scanMessageFiles(i.bundleConfig.RootPath)
+	if err != nil {
+		return err
+	}
+	if !i.currentState().messages.changed(files) || sameFiles(i.failedFiles, files) {
+		return nil
+	}
+	if err := i.loadMessages(); err != nil {
+		i.failedFiles = files
+		return err
 	}
+	i.failedFiles = nil
+	logrus.Infof("i18n message files reloaded from %s", i.bundleConfig.RootPath)
+	return nil
 }
 
-// setLocalizerByLang set localizer by language
-func (i *GinI18n) setLocalizerByLang(acceptLanguage []language.Tag) {
-	i.localizerByLang = map[string]*i18n.Localizer{}
-	for _, lang := range acceptLanguage {
-		langStr := lang.String()
-		i.localizerByLang[langStr] = i.newLocalizer(langStr)
+// buildLocalizers build localizer for each language found in message files
+func (i *GinI18n) buildLocalizers(messages *messageBundle) map[string]*Localizer {
+	localizerByLang := map[string]*Localizer{}
+	for _, tag := range messages.bundle.LanguageTags() {
+		localizerByLang[tag.String()] = i.newLocalizer(messages, tag)
 	}
 
 	// set defaultLanguage if it isn't exist
-	defaultLang := i.defaultLanguage.String()
-	if _, hasDefaultLang := i.localizerByLang[defaultLang]; !hasDefaultLang {
-		i.localizerByLang[defaultLang] = i.newLocalizer(defaultLang)
+	if _, hasDefaultLang := localizerByLang[i.defaultLanguage.String()]; !hasDefaultLang {
+		localizerByLang[i.defaultLanguage.String()] = i.newLocalizer(messages, i.defaultLanguage)
 	}
+	return localizerByLang
 }
 
-// newLocalizer create a localizer by language
-func (i *GinI18n) newLocalizer(lang string) *i18n.Localizer {
-	langDefault := i.defaultLanguage.String()
-	langs := []string{
-		lang,
+// newLocalizer create a localizer by language, it falls back to the parent languages (e.g. zh-TW -> zh-Hant),
+// the base language (e.g. zh) and then the default language
+func (i *GinI18n) newLocalizer(messages *messageBundle, lang language.Tag) *Localizer {
+	candidates := []string{lang.String()}
+	for parent := lang.Parent(); !parent.IsRoot(); parent = parent.Parent() {
+		candidates = append(candidates, parent.String())
 	}
-
-	if lang != langDefault {
-		langs = append(langs, langDefault)
+	if base, _ := lang.Base(); base.String() != lang.String() {
+		candidates = append(candidates, base.String())
 	}
 
-	localizer := i18n.NewLocalizer(
-		i.bundle,
-		langs...,
-	)
-	return localizer
+	langs := []string{}
+	for _, candidate := range candidates {
+		if _, found := messages.messageIDs[candidate]; found && candidate != i.defaultLanguage.String() {
+			langs = append(langs, candidate)
+		}
+	}
+	langs = append(langs, i.defaultLanguage.String())
+	return newLocalizer(messages.bundle, lang, langs...)
 }
 
-// getLocalizerByLang get localizer by language
-func (i *GinI18n) getLocalizerByLang(lang string) *i18n.Localizer {
-	acceptLangs := ParseAcceptLanguage(lang)
+// getLocalizerByLang get localizer by the languages in the format of Accept-Language header,
+// localizer of the default language is returned if none of them is supported
+func (i *GinI18n) getLocalizerByLang(lang string) *Localizer {
+	if localizer := i.matchLocalizer(lang); localizer != nil {
+		return localizer
+	}
+	return i.currentState().localizerByLang[i.defaultLanguage.String()]
+}
 
-	for _, al := range acceptLangs {
-		localizer, hasValue := i.localizerByLang[al.Lang]
-		if hasValue {
-			return localizer
-		}
+// matchLocalizer find the localizer of the best matched supported language, nil is returned if nothing matched
+func (i *GinI18n) matchLocalizer(lang string) *Localizer {
+	if lang == "" {
+		return nil
+	}
+	desired := parseAcceptLanguage(lang)
+	if len(desired) == 0 {
+		return nil
 	}
 
-	return i.localizerByLang[i.defaultLanguage.String()]
+	state := i.currentState()
+	_, index, confidence := state.matcher.Match(desired...)
+	if confidence == language.No {
+		return nil
+	}
+	return state.localizerByLang[state.supported[index].String()]
 }
 
-func (i *GinI18n) getLocalizeItem(param interface{}) (*i18n.LocalizeConfig, error) {
-	switch paramValue := param.(type) {
-	case *i18n.LocalizeConfig:
-		return paramValue, nil
-	default:
-		entry := &i18n.LocalizeConfig{
-			MessageID: fmt.Sprintf("%s", param),
+// parseAcceptLanguage parse languages in the format of Accept-Language header and sort them by weight,
+// malformed or unknown entries are skipped instead of failing the whole header.
+func parseAcceptLanguage(lang string) []language.Tag {
+	type weighted struct {
+		tag    language.Tag
+		weight float32
+	}
+	var entries []weighted
+	for _, entry := range strings.Split(lang, ",") {
+		tags, weights, err := language.ParseAcceptLanguage(entry)
+		if err != nil {
+			continue
+		}
+		for idx, tag := range tags {
+			entries = append(entries, weighted{tag: tag, weight: weights[idx]})
 		}
-		return entry, nil
 	}
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].weight > entries[b].weight })
+
+	tags := make([]language.Tag, 0, len(entries))
+	for _, e := range entries {
+		tags = append(tags, e.tag)
+	}
+	return tags
 }
@@ -0,0 +1,137 @@
+package localize
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v2"
+)
+
+// unmarshalFuncs supported formats of message file, keyed by file extension
+var unmarshalFuncs = map[string]i18n.UnmarshalFunc{
+	"yaml": yaml.Unmarshal,
+	"yml":  yaml.Unmarshal,
+	"json": json.Unmarshal,
+	"toml": toml.Unmarshal,
+}
+
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// messageBundle is an immutable snapshot of all message files in the i18n directory
+type messageBundle struct {
+	bundle     *i18n.Bundle
+	files      map[string]fileStamp
+	messageIDs map[string]map[string]struct{} // message ids keyed by language
+}
+
+// scanMessageFiles list the message files in root directory, an absent directory contains no files.
+func scanMessageFiles(root string) (map[string]fileStamp, error) {
+	files := map[string]fileStamp{}
+	entries, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		ext := strings.TrimPrefix(filepath.Ext(entry.Name()), ".")
+		if entry.IsDir() || unmarshalFuncs[ext] == nil {
+			continue
+		}
+		files[filepath.Join(root, entry.Name())] = fileStamp{ModTime: entry.ModTime(), Size: entry.Size()}
+	}
+	return files, nil
+}
+
+// loadMessageBundle load all message files in root directory,
+// the language is resolved from file name, e.g. zh-TW.yaml, en.json or active.zh.toml
+func loadMessageBundle(root string, defaultLanguage language.Tag) (*messageBundle, error) {
+	files, err := scanMessageFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := i18n.NewBundle(defaultLanguage)
+	for format, fn := range unmarshalFuncs {
+		bundle.RegisterUnmarshalFunc(format, fn)
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	messageIDs := map[string]map[string]struct{}{}
+	for _, p := range paths {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		file, err := bundle.ParseMessageFileBytes(buf, p)
+		if err != nil {
+			return nil, err
+		}
+		lang := file.Tag.String()
+		if messageIDs[lang] == nil {
+			messageIDs[lang] = map[string]struct{}{}
+		}
+		for _, m := range file.Messages {
+			messageIDs[lang][m.ID] = struct{}{}
+		}
+	}
+
+	return &messageBundle{bundle: bundle, files: files, messageIDs: messageIDs}, nil
+}
+
+// changed whether files differ from the files the bundle loaded from
+func (b *messageBundle) changed(files map[string]fileStamp) bool {
+	return !sameFiles(b.files, files)
+}
+
+func sameFiles(a, b map[string]fileStamp) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return a == nil && b == nil
+	}
+	for p, stamp := range b {
+		if old, found := a[p]; !found || !old.ModTime.Equal(stamp.ModTime) || old.Size != stamp.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// missingTranslations report the message ids which are defined in any language but absent in each language
+func (b *messageBundle) missingTranslations() map[string][]string {
+	all := map[string]struct{}{}
+	for _, ids := range b.messageIDs {
+		for id := range ids {
+			all[id] = struct{}{}
+		}
+	}
+
+	report := map[string][]string{}
+	for lang, ids := range b.messageIDs {
+		missing := []string{}
+		for id := range all {
+			if _, found := ids[id]; !found {
+				missing = append(missing, id)
+			}
+		}
+		sort.Strings(missing)
+		report[lang] = missing
+	}
+	return report
+}
@@ -0,0 +1,139 @@
+package localize_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"owlet/server/infra/localize"
+	"owlet/server/testinfra"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func writeMessageFile(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func messageOf(r *gin.Engine, id, lang string) string {
+	req := httptest.NewRequest(http.MethodGet, "/?id="+id+"&lang="+lang, nil)
+	_, body, _ := testinfra.ExecuteRequest(req, r)
+	return body
+}
+
+func newMessageEngine(i18nPath string, opts ...localize.Option) *gin.Engine {
+	r := gin.New()
+	r.Use(localize.LocalizeMiddleware(i18nPath, opts...))
+	r.GET("/", func(c *gin.Context) {
+		l := localize.FromGinContext(c)
+		if count := c.Query("count"); count != "" {
+			c.String(http.StatusOK, l.Plural(c.Query("id"), count, nil))
+			return
+		}
+		c.String(http.StatusOK, l.Message(c.Query("id"), nil))
+	})
+	return r
+}
+
+func TestMessageFileDiscovery(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should discover languages from yaml, json and toml files", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.yaml", "greeting: hello\ncolor: color")
+		writeMessageFile(t, dir, "zh.json", `{"greeting": "你好", "color": "颜色"}`)
+		writeMessageFile(t, dir, "zh-TW.toml", `greeting = "妳好"`)
+		r := newMessageEngine(dir)
+
+		Expect(messageOf(r, "greeting", "en")).To(Equal("hello"))
+		Expect(messageOf(r, "greeting", "zh")).To(Equal("你好"))
+		Expect(messageOf(r, "greeting", "zh-TW")).To(Equal("妳好"))
+		// region variant falls back to its parent language
+		Expect(messageOf(r, "color", "zh-TW")).To(Equal("颜色"))
+		// unknown language falls back to the default language
+		Expect(messageOf(r, "greeting", "fr")).To(Equal("hello"))
+	})
+
+	t.Run("should render plural messages", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.yaml", "articles:\n  one: \"{{.Count}} article\"\n  other: \"{{.Count}} articles\"")
+		writeMessageFile(t, dir, "zh.yaml", "articles:\n  other: \"{{.Count}} 篇文章\"")
+		r := newMessageEngine(dir)
+
+		req := httptest.NewRequest(http.MethodGet, "/?id=articles&count=1&lang=en", nil)
+		_, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(body).To(Equal("1 article"))
+
+		req = httptest.NewRequest(http.MethodGet, "/?id=articles&count=3&lang=en", nil)
+		_, body, _ = testinfra.ExecuteRequest(req, r)
+		Expect(body).To(Equal("3 articles"))
+
+		req = httptest.NewRequest(http.MethodGet, "/?id=articles&count=1&lang=zh", nil)
+		_, body, _ = testinfra.ExecuteRequest(req, r)
+		Expect(body).To(Equal("1 篇文章"))
+	})
+
+	t.Run("should panic if message file is malformed", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.json", `{"greeting": `)
+		Expect(func() { newMessageEngine(dir) }).To(Panic())
+	})
+}
+
+func TestMessageFileReload(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should reload messages after message files changed", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.yaml", "greeting: hello")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newMessageEngine(dir, localize.WithReload(ctx, 10*time.Millisecond))
+		Expect(messageOf(r, "greeting", "zh")).To(Equal("hello"))
+
+		writeMessageFile(t, dir, "zh.yaml", "greeting: 你好")
+		Eventually(func() string { return messageOf(r, "greeting", "zh") }).Should(Equal("你好"))
+
+		writeMessageFile(t, dir, "en.yaml", "greeting: hello, world")
+		Eventually(func() string { return messageOf(r, "greeting", "en") }).Should(Equal("hello, world"))
+	})
+
+	t.Run("should keep previous messages if changed files are malformed", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.yaml", "greeting: hello")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := newMessageEngine(dir, localize.WithReload(ctx, 10*time.Millisecond))
+
+		writeMessageFile(t, dir, "en.json", `{"greeting": `)
+		Consistently(func() string { return messageOf(r, "greeting", "en") }, 100*time.Millisecond).Should(Equal("hello"))
+	})
+}
+
+func TestMissingTranslations(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should report message ids absent in each language", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMessageFile(t, dir, "en.yaml", "greeting: hello\ncolor: color")
+		writeMessageFile(t, dir, "zh.yaml", "greeting: 你好\nfarewell: 再见")
+		newMessageEngine(dir)
+
+		missing, err := localize.MissingTranslations()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(Equal(map[string][]string{"en": {"farewell"}, "zh": {"color"}}))
+
+		r := gin.New()
+		localize.RegisterLocalizeRestAPI(r)
+		req := httptest.NewRequest(http.MethodGet, localize.PathMissingTranslations, nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"en": ["farewell"], "zh": ["color"]}`))
+	})
+}
@@ -0,0 +1,90 @@
+package localize_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/localize"
+	"owlet/server/testinfra"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func TestLanguageNegotiation(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := t.TempDir()
+	writeMessageFile(t, dir, "en.yaml", "greeting: hello")
+	writeMessageFile(t, dir, "zh.yaml", "greeting: 你好")
+	writeMessageFile(t, dir, "zh-TW.yaml", "greeting: 妳好")
+	writeMessageFile(t, dir, "fr.yaml", "greeting: bonjour")
+
+	negotiate := func(r *gin.Engine, query, cookie, header string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/?id=greeting"+query, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: localize.CookieLang, Value: cookie})
+		}
+		if header != "" {
+			req.Header.Set("Accept-Language", header)
+		}
+		_, body, resp := testinfra.ExecuteRequest(req, r)
+		return body, resp.Header.Get(localize.HeaderContentLanguage)
+	}
+
+	t.Run("should match the closest supported language", func(t *testing.T) {
+		r := newMessageEngine(dir)
+
+		body, contentLang := negotiate(r, "", "", "en-GB")
+		Expect(body).To(Equal("hello"))
+		Expect(contentLang).To(Equal("en"))
+
+		body, contentLang = negotiate(r, "", "", "zh-Hant-TW")
+		Expect(body).To(Equal("妳好"))
+		Expect(contentLang).To(Equal("zh-TW"))
+
+		body, contentLang = negotiate(r, "", "", "zh-Hans-CN")
+		Expect(body).To(Equal("你好"))
+		Expect(contentLang).To(Equal("zh"))
+
+		body, contentLang = negotiate(r, "", "", "ja")
+		Expect(body).To(Equal("hello"))
+		Expect(contentLang).To(Equal("en"))
+	})
+
+	t.Run("should respect weights and tolerate malformed entries in header", func(t *testing.T) {
+		r := newMessageEngine(dir)
+
+		body, _ := negotiate(r, "", "", " fr ; q=0.3 , zh-CN ;q=0.9, en;q=0.5")
+		Expect(body).To(Equal("你好"))
+
+		body, _ = negotiate(r, "", "", "xx-invalid-, zh;q=abc, fr;q=0.2")
+		Expect(body).To(Equal("bonjour"))
+
+		body, contentLang := negotiate(r, "", "", ";;;")
+		Expect(body).To(Equal("hello"))
+		Expect(contentLang).To(Equal("en"))
+	})
+
+	t.Run("should resolve language from query, cookie, preference and header in order", func(t *testing.T) {
+		preference := ""
+		r := newMessageEngine(dir, localize.WithPreferenceResolver(func(c *gin.Context) string { return preference }))
+
+		body, _ := negotiate(r, "&lang=fr", "zh", "zh-TW")
+		Expect(body).To(Equal("bonjour"))
+
+		body, _ = negotiate(r, "", "zh", "zh-TW")
+		Expect(body).To(Equal("你好"))
+
+		body, _ = negotiate(r, "", "", "zh-TW")
+		Expect(body).To(Equal("妳好"))
+
+		preference = "fr-CA"
+		body, _ = negotiate(r, "", "", "zh-TW")
+		Expect(body).To(Equal("bonjour"))
+
+		// unsupported languages are skipped
+		body, _ = negotiate(r, "&lang=ja", "ko", "zh-TW")
+		Expect(body).To(Equal("bonjour"))
+	})
+}
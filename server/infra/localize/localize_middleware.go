@@ -5,14 +5,17 @@ import (
 	"golang.org/x/text/language"
 )
 
-func LocalizeMiddleware(i18nPath string) gin.HandlerFunc {
-	return LocalizeMiddlewareWithCustomLangResolver(i18nPath, nil)
+func LocalizeMiddleware(i18nPath string, opts ...Option) gin.HandlerFunc {
+	bundleCfg := &BundleCfg{
+		RootPath:        i18nPath,
+		DefaultLanguage: language.English,
+	}
+	return Localize(append([]Option{WithBundle(bundleCfg)}, opts...)...)
 }
 
 func LocalizeMiddlewareWithCustomLangResolver(i18nPath string, h LangResolver) gin.HandlerFunc {
 	bundleCfg := &BundleCfg{
 		RootPath:        i18nPath,
-		AcceptLanguage:  []language.Tag{language.Chinese, language.English},
 		DefaultLanguage: language.English,
 	}
 
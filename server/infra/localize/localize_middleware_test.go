@@ -1,15 +1,21 @@
-package localize
+package localize_test
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"owlet/server/infra/localize"
 	"owlet/server/testinfra"
 	"path"
+	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
 	. "github.com/onsi/gomega"
+	"golang.org/x/text/language"
 )
 
 func TestLocalize(t *testing.T) {
@@ -21,10 +27,10 @@ func TestLocalize(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		r.GET("/", func(c *gin.Context) {
-			msg := MustGetMessage("running")
+			msg := localize.FromGinContext(c).Message("running", nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -39,10 +45,10 @@ func TestLocalize(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		r.GET("/", func(c *gin.Context) {
-			msg := MustGetMessage("running")
+			msg := localize.FromGinContext(c).Message("running", nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/?lang=xxx", nil)
@@ -57,10 +63,10 @@ func TestLocalize(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		r.GET("/", func(c *gin.Context) {
-			msg := MustGetMessage("status-running")
+			msg := localize.FromGinContext(c).Message("status-running", nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/?lang=xxx", nil)
@@ -75,10 +81,10 @@ func TestLocalize(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		r.GET("/", func(c *gin.Context) {
-			msg := MustGetMessage("status-running")
+			msg := localize.FromGinContext(c).Message("status-running", nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -98,10 +104,10 @@ func TestLocalizeSpecifiedLanguage(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		r.GET("/", func(c *gin.Context) {
-			msg := MustGetMessage("status-running")
+			msg := localize.FromGinContext(c).Message("status-running", nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/?lang=en", nil)
@@ -116,11 +122,11 @@ func TestLocalizeSpecifiedLanguage(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		key := "status-running"
 		r.GET("/", func(c *gin.Context) {
-			msg, _ := GetMessage(key)
+			msg := localize.FromGinContext(c).Message(key, nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/?lang=en", nil)
@@ -148,11 +154,11 @@ func TestLocalizeSpecifiedLanguage(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddleware(path))
+		r.Use(localize.LocalizeMiddleware(path))
 
 		key := "status-running"
 		r.GET("/", func(c *gin.Context) {
-			msg, _ := GetMessage(key)
+			msg := localize.FromGinContext(c).Message(key, nil)
 			c.String(http.StatusOK, msg)
 		})
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -184,7 +190,7 @@ func TestLocalizeSpecifiedLanguageWithCustomGetLangHandle(t *testing.T) {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		r := gin.Default()
-		r.Use(LocalizeMiddlewareWithCustomLangResolver(path, func(context *gin.Context, defaultLang string) string {
+		r.Use(localize.LocalizeMiddlewareWithCustomLangResolver(path, func(context *gin.Context, defaultLang string) string {
 			if context == nil || context.Request == nil {
 				return defaultLang
 			}
@@ -197,7 +203,7 @@ func TestLocalizeSpecifiedLanguageWithCustomGetLangHandle(t *testing.T) {
 
 		key := "status-running"
 		r.GET("/", func(c *gin.Context) {
-			msg, _ := GetMessage(key)
+			msg := localize.FromGinContext(c).Message(key, nil)
 			c.String(http.StatusOK, msg)
 		})
 
@@ -213,6 +219,94 @@ func TestLocalizeSpecifiedLanguageWithCustomGetLangHandle(t *testing.T) {
 	})
 }
 
+func TestLocalizerInContext(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("localizer should be saved in request context", func(t *testing.T) {
+		path, en, zh, err := setI18nFile()
+		defer cleanI18nFile(en, zh)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		r := gin.Default()
+		r.Use(localize.LocalizeMiddleware(path))
+		r.GET("/", func(c *gin.Context) {
+			l := localize.FromContext(c.Request.Context())
+			Expect(l).To(BeIdenticalTo(localize.FromGinContext(c)))
+			c.String(http.StatusOK, l.Language().String()+":"+l.Message("status-running", nil))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/?lang=zh", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("zh:运行中"))
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		status, body, _ = testinfra.ExecuteRequest(req, r)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("en:running"))
+	})
+
+	t.Run("default localizer should be returned when absent", func(t *testing.T) {
+		l := localize.FromContext(context.Background())
+		Expect(l.Language()).To(Equal(language.English))
+		Expect(l.Message("status-running", nil)).To(Equal("status-running"))
+		Expect(localize.FromContext(nil)).To(BeIdenticalTo(l))
+		Expect(localize.FromGinContext(&gin.Context{})).To(BeIdenticalTo(l))
+
+		message, err := l.Localize(&i18n.LocalizeConfig{
+			DefaultMessage: &i18n.Message{ID: "welcome", Other: "hello {{.Name}}"},
+			TemplateData:   map[string]string{"Name": "tom"},
+		})
+		Expect(err).To(BeNil())
+		Expect(message).To(Equal("hello tom"))
+	})
+}
+
+// run with -race to detect data race between concurrent requests
+func TestLocalizeConcurrentRequests(t *testing.T) {
+	RegisterTestingT(t)
+
+	path, en, zh, err := setI18nFile()
+	defer cleanI18nFile(en, zh)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.DebugMode)
+	r := gin.New()
+	r.Use(localize.LocalizeMiddleware(path))
+	r.GET("/", func(c *gin.Context) {
+		runtime.Gosched() // let other requests interleave
+		c.String(http.StatusOK, localize.FromContext(c.Request.Context()).Message("status-running", nil))
+	})
+
+	expected := map[string]string{"en": "running", "zh": "运行中"}
+	wg := sync.WaitGroup{}
+	results := make(chan string, 200)
+	for i := 0; i < 200; i++ {
+		lang := "en"
+		if i%2 == 0 {
+			lang = "zh"
+		}
+		wg.Add(1)
+		go func(lang string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/?lang="+lang, nil)
+			_, body, _ := testinfra.ExecuteRequest(req, r)
+			if body != expected[lang] {
+				results <- lang + " => " + body
+			}
+		}(lang)
+	}
+	wg.Wait()
+	close(results)
+
+	mismatches := []string{}
+	for m := range results {
+		mismatches = append(mismatches, m)
+	}
+	Expect(mismatches).To(BeEmpty())
+}
+
 func setI18nFile() (string, *testinfra.TempFile, *testinfra.TempFile, error) {
 	p := "i18n-test"
 	en, err := testinfra.NewFileWithContent(p+"/en.yaml", "status-running: running")
@@ -0,0 +1,26 @@
+package localize
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const PathMissingTranslations = "/v1/admin/i18n/missing-translations"
+
+func RegisterLocalizeRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathMissingTranslations, middleWares...)
+	g.GET("", handleMissingTranslations)
+}
+
+// @ID i18n-missing-translations
+// @Success 200 {object} map[string][]string "message ids absent in each language"
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/i18n/missing-translations [get]
+func handleMissingTranslations(c *gin.Context) {
+	missing, err := MissingTranslations()
+	if err != nil {
+		panic(err)
+	}
+	c.JSON(http.StatusOK, missing)
+}
@@ -0,0 +1,112 @@
+package localize
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+)
+
+// KeyLocalizer the key of localizer in gin context
+const KeyLocalizer = "localizer"
+
+type localizerKey struct{}
+
+// Localizer localize messages in a resolved language, it is safe for concurrent use.
+type Localizer struct {
+	tag        language.Tag
+	localizers []*i18n.Localizer // one per language in fallback order
+}
+
+// defaultLocalizer is used when the localizer is absent in context,
+// it has no messages, so only the default messages in LocalizeConfig can be rendered.
+var defaultLocalizer = newLocalizer(i18n.NewBundle(defaultLanguage), defaultLanguage, defaultLanguage.String())
+
+// newLocalizer the messages are looked up in langs one by one, the last one should be the default language of bundle
+func newLocalizer(bundle *i18n.Bundle, tag language.Tag, langs ...string) *Localizer {
+	localizers := make([]*i18n.Localizer, 0, len(langs))
+	for _, lang := range langs {
+		localizers = append(localizers, i18n.NewLocalizer(bundle, lang))
+	}
+	return &Localizer{tag: tag, localizers: localizers}
+}
+
+// NewContext return a copy of ctx which carries the localizer
+func NewContext(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, localizerKey{}, l)
+}
+
+// FromContext return the localizer in ctx, a default localizer (english without messages) is returned if absent
+func FromContext(ctx context.Context) *Localizer {
+	if ctx != nil {
+		if l, ok := ctx.Value(localizerKey{}).(*Localizer); ok {
+			return l
+		}
+	}
+	return defaultLocalizer
+}
+
+// FromGinContext return the localizer of request, a default localizer (english without messages) is returned if absent
+func FromGinContext(c *gin.Context) *Localizer {
+	if value, found := c.Get(KeyLocalizer); found {
+		if l, ok := value.(*Localizer); ok {
+			return l
+		}
+	}
+	if c.Request != nil {
+		return FromContext(c.Request.Context())
+	}
+	return defaultLocalizer
+}
+
+// Language the resolved language of localizer
+func (l *Localizer) Language() language.Tag {
+	return l.tag
+}
+
+// Localize localize the message in the first language which has it
+func (l *Localizer) Localize(cfg *i18n.LocalizeConfig) (string, error) {
+	var message string
+	var err error
+	for _, localizer := range l.localizers {
+		if message, err = localizer.Localize(cfg); err == nil {
+			return message, nil
+		}
+	}
+	return message, err
+}
+
+/*
+Message get the i18n message with template data, the message id is returned if message not found.
+Example:
+
+	Message("hello", nil)
+	Message("welcomeWithName", map[string]string{"name": "tom"})
+*/
+func (l *Localizer) Message(id string, data interface{}) string {
+	message, err := l.Localize(&i18n.LocalizeConfig{MessageID: id, TemplateData: data})
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return message
+}
+
+/*
+Plural get the i18n message in the plural form selected by count, count is also available as
+template data "Count" if data is nil. The message id is returned if message not found.
+Example:
+
+	Plural("articles", 3, nil)
+*/
+func (l *Localizer) Plural(id string, count interface{}, data interface{}) string {
+	if data == nil {
+		data = map[string]interface{}{"Count": count}
+	}
+	message, err := l.Localize(&i18n.LocalizeConfig{MessageID: id, PluralCount: count, TemplateData: data})
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return message
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"github.com/uber/jaeger-client-go"
+)
+
+const (
+	EnvLogLevel     = "LOG_LEVEL"
+	DefaultLogLevel = "info"
+
+	FieldRequestID = "request_id"
+	FieldTraceID   = "trace_id"
+	FieldSpanID    = "span_id"
+	FieldUserID    = "user_id"
+	FieldRoute     = "route"
+)
+
+type loggerKey struct{}
+
+// Setup switch the standard logger to JSON format, the level is read from LOG_LEVEL (default info).
+func Setup() error {
+	logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	level := os.Getenv(EnvLogLevel)
+	if level == "" {
+		level = DefaultLogLevel
+	}
+	return SetLevel(level)
+}
+
+// SetLevel change the level of standard logger, it takes effect immediately on all loggers derived from it.
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(l)
+	return nil
+}
+
+func GetLevel() string {
+	return logrus.GetLevel().String()
+}
+
+// WithFields return a copy of ctx in which the logger carries additional fields.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entryOf(ctx).WithFields(fields))
+}
+
+// FromContext return the logger of request scope,
+// entries carry request id, user id, route and the trace/span id of current span.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := entryOf(ctx)
+	if ctx == nil {
+		return entry
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok && sc.IsValid() {
+			entry = entry.WithFields(logrus.Fields{FieldTraceID: sc.TraceID().String(), FieldSpanID: sc.SpanID().String()})
+		}
+	}
+	return entry
+}
+
+func entryOf(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+			return entry
+		}
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderRequestID = "X-Request-Id"
+
+	maxRequestIDLength = 128
+)
+
+// AccessLog assign a request id (reuse the X-Request-Id header if present) and the request scope logger,
+// then write an access log for each request except the skipped paths.
+func AccessLog(skipPaths ...string) gin.HandlerFunc {
+	skip := make(map[string]struct{}, len(skipPaths))
+	for _, p := range skipPaths {
+		skip[p] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(HeaderRequestID)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+		c.Header(HeaderRequestID, requestID)
+		c.Request = c.Request.WithContext(WithFields(c.Request.Context(),
+			logrus.Fields{FieldRequestID: requestID, FieldRoute: c.FullPath()}))
+
+		c.Next()
+
+		if _, found := skip[c.Request.URL.Path]; found {
+			return
+		}
+
+		status := c.Writer.Status()
+		entry := FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"status":     status,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  c.ClientIP(),
+			"size":       c.Writer.Size(),
+		})
+		if status >= 500 {
+			entry.Error("access")
+		} else {
+			entry.Info("access")
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package logging_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/logging"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func TestAccessLog(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf, restore := captureLogs()
+	defer restore()
+
+	router := gin.New()
+	router.Use(logging.AccessLog("/skipped"))
+	router.GET("/test/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("in handler")
+		c.Status(http.StatusOK)
+	})
+	router.GET("/error", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+	router.GET("/skipped", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("access log and app log should carry request id and route", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/test/123", nil)
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		requestID := resp.Header.Get(logging.HeaderRequestID)
+		Expect(requestID).ToNot(BeEmpty())
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(len(lines)).To(Equal(2))
+		Expect(lines[0]).To(ContainSubstring(`"msg":"in handler"`))
+		Expect(lines[0]).To(ContainSubstring(`"request_id":"` + requestID + `"`))
+		Expect(lines[0]).To(ContainSubstring(`"route":"/test/:id"`))
+
+		entry := lastEntry(buf)
+		Expect(entry["msg"]).To(Equal("access"))
+		Expect(entry["level"]).To(Equal("info"))
+		Expect(entry["request_id"]).To(Equal(requestID))
+		Expect(entry["route"]).To(Equal("/test/:id"))
+		Expect(entry["path"]).To(Equal("/test/123"))
+		Expect(entry["method"]).To(Equal("GET"))
+		Expect(entry["status"]).To(Equal(float64(200)))
+		Expect(entry).To(HaveKey("latency_ms"))
+	})
+
+	t.Run("request id should be reused from request header", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/error", nil)
+		req.Header.Set(logging.HeaderRequestID, "abc")
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusInternalServerError))
+		Expect(resp.Header.Get(logging.HeaderRequestID)).To(Equal("abc"))
+
+		entry := lastEntry(buf)
+		Expect(entry["request_id"]).To(Equal("abc"))
+		Expect(entry["level"]).To(Equal("error"))
+	})
+
+	t.Run("skipped path should not be logged", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/skipped", nil)
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(logging.HeaderRequestID)).ToNot(BeEmpty())
+		Expect(buf.String()).To(BeEmpty())
+	})
+}
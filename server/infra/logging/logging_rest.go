@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const PathLogLevel = "/v1/admin/log-level"
+
+type LogLevel struct {
+	Level string `json:"level" binding:"required,oneof=panic fatal error warn warning info debug trace"`
+}
+
+func RegisterLoggingRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathLogLevel, middleWares...)
+	g.GET("", handleGetLogLevel)
+	g.PUT("", handleSetLogLevel)
+}
+
+// @ID log-level-get
+// @Success 200 {object} logging.LogLevel
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/log-level [get]
+func handleGetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, &LogLevel{Level: GetLevel()})
+}
+
+// @ID log-level-set
+// @Accept  json
+// @Param level body logging.LogLevel true "log level"
+// @Success 200 {object} logging.LogLevel
+// @Failure default {object} fail.ErrorBody "error"
+// @Router /v1/admin/log-level [put]
+func handleSetLogLevel(c *gin.Context) {
+	body := LogLevel{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		panic(err)
+	}
+	if err := SetLevel(body.Level); err != nil {
+		panic(err)
+	}
+	FromContext(c.Request.Context()).Warnf("log level changed to %s", GetLevel())
+	c.JSON(http.StatusOK, &LogLevel{Level: GetLevel()})
+}
@@ -0,0 +1,47 @@
+package logging_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/logging"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+func TestLoggingRestAPI(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, restore := captureLogs()
+	defer restore()
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	logging.RegisterLoggingRestAPI(router)
+
+	t.Run("should be able to get and set log level", func(t *testing.T) {
+		Expect(logging.SetLevel("info")).To(BeNil())
+		req := httptest.NewRequest(http.MethodGet, logging.PathLogLevel, nil)
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"level": "info"}`))
+
+		req = httptest.NewRequest(http.MethodPut, logging.PathLogLevel, strings.NewReader(`{"level": "debug"}`))
+		status, body, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"level": "debug"}`))
+		Expect(logging.GetLevel()).To(Equal("debug"))
+	})
+
+	t.Run("invalid log level should be rejected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, logging.PathLogLevel, strings.NewReader(`{"level": "verbose"}`))
+		status, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring(`"code":"bad_request.validation_failed"`))
+		Expect(logging.GetLevel()).To(Equal("debug"))
+	})
+}
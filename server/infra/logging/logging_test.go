@@ -0,0 +1,94 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"owlet/server/infra/logging"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"github.com/uber/jaeger-client-go"
+)
+
+func captureLogs() (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	logger := logrus.StandardLogger()
+	out, formatter, level := logger.Out, logger.Formatter, logger.GetLevel()
+	logrus.SetOutput(buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	return buf, func() {
+		logrus.SetOutput(out)
+		logrus.SetFormatter(formatter)
+		logrus.SetLevel(level)
+	}
+}
+
+func lastEntry(buf *bytes.Buffer) map[string]interface{} {
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	entry := map[string]interface{}{}
+	Expect(json.Unmarshal(lines[len(lines)-1], &entry)).To(BeNil())
+	return entry
+}
+
+func TestSetup(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, restore := captureLogs()
+	defer restore()
+
+	t.Run("level should be info by default", func(t *testing.T) {
+		Expect(logging.Setup()).To(BeNil())
+		Expect(logging.GetLevel()).To(Equal("info"))
+		_, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter)
+		Expect(ok).To(BeTrue())
+	})
+
+	t.Run("level should be read from environment", func(t *testing.T) {
+		os.Setenv(logging.EnvLogLevel, "debug")
+		defer os.Unsetenv(logging.EnvLogLevel)
+		Expect(logging.Setup()).To(BeNil())
+		Expect(logging.GetLevel()).To(Equal("debug"))
+	})
+
+	t.Run("invalid level should be rejected", func(t *testing.T) {
+		Expect(logging.SetLevel("verbose")).ToNot(BeNil())
+		Expect(logging.SetLevel("warn")).To(BeNil())
+		Expect(logging.GetLevel()).To(Equal("warning"))
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	RegisterTestingT(t)
+
+	buf, restore := captureLogs()
+	defer restore()
+
+	t.Run("logger without fields should be returned when absent in context", func(t *testing.T) {
+		Expect(logging.FromContext(nil).Data).To(BeEmpty())
+		Expect(logging.FromContext(context.Background()).Data).To(BeEmpty())
+	})
+
+	t.Run("fields and trace should be carried by logger in context", func(t *testing.T) {
+		tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+		defer closer.Close()
+		span := tracer.StartSpan("test")
+		defer span.Finish()
+		sc := span.Context().(jaeger.SpanContext)
+
+		ctx := logging.WithFields(context.Background(), logrus.Fields{logging.FieldRequestID: "r1"})
+		ctx = logging.WithFields(ctx, logrus.Fields{logging.FieldUserID: 100})
+		ctx = opentracing.ContextWithSpan(ctx, span)
+
+		logging.FromContext(ctx).Info("hello")
+		entry := lastEntry(buf)
+		Expect(entry["msg"]).To(Equal("hello"))
+		Expect(entry[logging.FieldRequestID]).To(Equal("r1"))
+		Expect(entry[logging.FieldUserID]).To(Equal(float64(100)))
+		Expect(entry[logging.FieldTraceID]).To(Equal(sc.TraceID().String()))
+		Expect(entry[logging.FieldSpanID]).To(Equal(sc.SpanID().String()))
+	})
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"time"
+)
+
+const (
+	EnvSMTPAddr     = "MAIL_SMTP_ADDR"
+	EnvSMTPUsername = "MAIL_SMTP_USERNAME"
+	EnvSMTPPassword = "MAIL_SMTP_PASSWORD"
+	EnvFrom         = "MAIL_FROM"
+	EnvDir          = "MAIL_DIR"
+
+	DefaultFrom = "owlet <noreply@localhost>"
+)
+
+// Message a mail in plain text, HTML is optional
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer deliver messages, implementations should be safe for concurrent use
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Active the mailer used by domain services, messages are only logged until it is replaced (see FromEnv)
+var Active Mailer = &LogMailer{From: DefaultFrom}
+
+// FromEnv build a SMTP mailer if MAIL_SMTP_ADDR is specified,
+// otherwise messages are written into MAIL_DIR (or logged if MAIL_DIR is absent too).
+func FromEnv() Mailer {
+	from := os.Getenv(EnvFrom)
+	if from == "" {
+		from = DefaultFrom
+	}
+	if addr := os.Getenv(EnvSMTPAddr); addr != "" {
+		return &SMTPMailer{Addr: addr, From: from,
+			Username: os.Getenv(EnvSMTPUsername), Password: os.Getenv(EnvSMTPPassword)}
+	}
+	return &LogMailer{From: from, Dir: os.Getenv(EnvDir)}
+}
+
+// Compose render the message in RFC 5322 format, it is a multipart/alternative message if HTML is present
+func Compose(from string, m *Message, now time.Time) ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("mail %q has no recipients", m.Subject)
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, err
+	}
+	if _, err := mail.ParseAddressList(joinAddresses(m.To)); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", joinAddresses(m.To))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, content string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
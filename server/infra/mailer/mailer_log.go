@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogMailer is for local development, messages are written into Dir as .eml files, or logged if Dir is empty.
+type LogMailer struct {
+	From string
+	Dir  string
+}
+
+func (l *LogMailer) Send(ctx context.Context, m *Message) error {
+	now := time.Now()
+	msg, err := Compose(l.From, m, now)
+	if err != nil {
+		return err
+	}
+	entry := logrus.WithFields(logrus.Fields{"to": joinAddresses(m.To), "subject": m.Subject})
+	if l.Dir == "" {
+		entry.Infof("mail is not delivered (no mailer configured):\n%s", msg)
+		return nil
+	}
+
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(l.Dir, fmt.Sprintf("%d.eml", now.UnixNano()))
+	if err := ioutil.WriteFile(file, msg, 0644); err != nil {
+		return err
+	}
+	entry.WithField("file", file).Info("mail is written into file")
+	return nil
+}
+
+func joinAddresses(addresses []string) string {
+	return strings.Join(addresses, ", ")
+}
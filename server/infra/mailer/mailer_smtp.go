@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer deliver messages through a SMTP server, STARTTLS is used if the server supports it.
+// The PLAIN authentication is used if Username is specified, which requires TLS except for localhost.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m *Message) error {
+	msg, err := Compose(s.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+	sender, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	recipients, err := mail.ParseAddressList(joinAddresses(m.To))
+	if err != nil {
+		return err
+	}
+	to := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		to = append(to, r.Address)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, sender.Address, to, msg)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
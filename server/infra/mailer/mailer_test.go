@@ -0,0 +1,131 @@
+package mailer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"owlet/server/infra/mailer"
+	"owlet/server/testinfra"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCompose(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("should compose plain text message", func(t *testing.T) {
+		msg, err := mailer.Compose("owlet <noreply@example.com>",
+			&mailer.Message{To: []string{"tom@example.com"}, Subject: "重置密码", Text: "hello"}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(msg)).To(Equal("From: owlet <noreply@example.com>\r\n" +
+			"To: tom@example.com\r\n" +
+			"Subject: =?utf-8?q?=E9=87=8D=E7=BD=AE=E5=AF=86=E7=A0=81?=\r\n" +
+			"Date: Sun, 02 Jan 2022 03:04:05 +0000\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n\r\n" +
+			"hello"))
+	})
+
+	t.Run("should compose multipart message if html is present", func(t *testing.T) {
+		msg, err := mailer.Compose("noreply@example.com",
+			&mailer.Message{To: []string{"tom@example.com"}, Subject: "hi", Text: "hello", HTML: "<b>hello</b>"}, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(msg)).To(ContainSubstring("Content-Type: multipart/alternative; boundary="))
+		Expect(string(msg)).To(ContainSubstring("Content-Type: text/plain; charset=utf-8"))
+		Expect(string(msg)).To(ContainSubstring("Content-Type: text/html; charset=utf-8"))
+		Expect(string(msg)).To(ContainSubstring("<b>hello</b>"))
+	})
+
+	t.Run("should reject invalid addresses", func(t *testing.T) {
+		_, err := mailer.Compose("noreply@example.com", &mailer.Message{Subject: "hi"}, now)
+		Expect(err).To(HaveOccurred())
+
+		_, err = mailer.Compose("noreply@example.com", &mailer.Message{To: []string{"not an address"}}, now)
+		Expect(err).To(HaveOccurred())
+
+		_, err = mailer.Compose("", &mailer.Message{To: []string{"tom@example.com"}}, now)
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestSMTPMailer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should deliver message to smtp server", func(t *testing.T) {
+		stub, err := testinfra.StartSMTPStub()
+		Expect(err).ToNot(HaveOccurred())
+		defer stub.Close()
+
+		m := &mailer.SMTPMailer{Addr: stub.Addr, From: "owlet <noreply@example.com>", Username: "u", Password: "p"}
+		err = m.Send(context.Background(), &mailer.Message{To: []string{"Tom <tom@example.com>"}, Subject: "hi", Text: "hello"})
+		Expect(err).ToNot(HaveOccurred())
+
+		mails := stub.Mails()
+		Expect(len(mails)).To(Equal(1))
+		Expect(mails[0].From).To(Equal("noreply@example.com"))
+		Expect(mails[0].To).To(Equal([]string{"tom@example.com"}))
+		Expect(mails[0].Data).To(ContainSubstring("Subject: hi\r\n"))
+		Expect(mails[0].Data).To(HaveSuffix("hello\r\n"))
+	})
+
+	t.Run("should fail when smtp server is unreachable", func(t *testing.T) {
+		stub, err := testinfra.StartSMTPStub()
+		Expect(err).ToNot(HaveOccurred())
+		stub.Close()
+
+		m := &mailer.SMTPMailer{Addr: stub.Addr, From: "noreply@example.com"}
+		err = m.Send(context.Background(), &mailer.Message{To: []string{"tom@example.com"}, Subject: "hi", Text: "hello"})
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestLogMailer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should write message into directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "mails")
+		m := &mailer.LogMailer{From: "noreply@example.com", Dir: dir}
+		Expect(m.Send(context.Background(), &mailer.Message{To: []string{"tom@example.com"}, Subject: "hi", Text: "hello"})).
+			To(Succeed())
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0].Name()).To(HaveSuffix(".eml"))
+		content, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.HasPrefix(string(content), "From: noreply@example.com\r\nTo: tom@example.com\r\n")).To(BeTrue())
+	})
+
+	t.Run("should log message if directory is absent", func(t *testing.T) {
+		m := &mailer.LogMailer{From: "noreply@example.com"}
+		Expect(m.Send(context.Background(), &mailer.Message{To: []string{"tom@example.com"}, Subject: "hi", Text: "hello"})).
+			To(Succeed())
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should build mailer from environment", func(t *testing.T) {
+		defer os.Unsetenv(mailer.EnvSMTPAddr)
+		defer os.Unsetenv(mailer.EnvDir)
+		defer os.Unsetenv(mailer.EnvFrom)
+
+		Expect(mailer.FromEnv()).To(Equal(&mailer.LogMailer{From: mailer.DefaultFrom}))
+
+		os.Setenv(mailer.EnvDir, "/tmp/mails")
+		os.Setenv(mailer.EnvFrom, "noreply@example.com")
+		Expect(mailer.FromEnv()).To(Equal(&mailer.LogMailer{From: "noreply@example.com", Dir: "/tmp/mails"}))
+
+		os.Setenv(mailer.EnvSMTPAddr, "smtp.example.com:25")
+		Expect(mailer.FromEnv()).To(Equal(&mailer.SMTPMailer{Addr: "smtp.example.com:25", From: "noreply@example.com"}))
+	})
+}
@@ -0,0 +1,130 @@
+package markdown
+
+import (
+	"math"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	// wordsPerMinute the reading speed of the words in alphabetic scripts
+	wordsPerMinute = 200
+	// cjkCharsPerMinute the reading speed of CJK text, each character is counted as a word
+	cjkCharsPerMinute = 300
+	// secondsPerImage the time spent on each image
+	secondsPerImage = 10
+)
+
+// Heading an entry of the table of contents, Anchor is the id of the rendered heading (see Render)
+type Heading struct {
+	Level    int       `json:"level"`
+	Title    string    `json:"title"`
+	Anchor   string    `json:"anchor"`
+	Children []Heading `json:"children,omitempty"`
+}
+
+// Stats the statistics of a Markdown document. The text of code blocks and the alternative text of images
+// are not counted as words.
+type Stats struct {
+	TOC []Heading
+	// Words each CJK character is counted as a word, as well as each run of letters and digits of other scripts
+	Words int
+	// Chars the characters except the white spaces
+	Chars          int
+	CodeBlocks     int
+	Images         int
+	ReadingMinutes int
+}
+
+// Analyze extract the heading tree and count the words of the Markdown source
+func Analyze(source string) *Stats {
+	src := []byte(source)
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{used: map[string]bool{}}))
+	doc := converter.Parser().Parse(text.NewReader(src), parser.WithContext(ctx))
+
+	stats := &Stats{}
+	headings := []Heading{}
+	var cjkChars, otherWords int
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Heading:
+			anchor, _ := node.AttributeString("id")
+			id, _ := anchor.([]byte)
+			headings = append(headings, Heading{Level: node.Level, Title: string(node.Text(src)), Anchor: string(id)})
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			stats.CodeBlocks++
+			return ast.WalkSkipChildren, nil
+		case *ast.Image:
+			stats.Images++
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			cjk, words, chars := countWords(node.Segment.Value(src))
+			cjkChars, otherWords, stats.Chars = cjkChars+cjk, otherWords+words, stats.Chars+chars
+		case *ast.AutoLink:
+			cjk, words, chars := countWords(node.Label(src))
+			cjkChars, otherWords, stats.Chars = cjkChars+cjk, otherWords+words, stats.Chars+chars
+		}
+		return ast.WalkContinue, nil
+	})
+
+	stats.TOC = nestHeadings(headings)
+	stats.Words = cjkChars + otherWords
+	seconds := float64(otherWords)*60/wordsPerMinute + float64(cjkChars)*60/cjkCharsPerMinute +
+		float64(stats.Images*secondsPerImage)
+	stats.ReadingMinutes = int(math.Ceil(seconds / 60))
+	return stats
+}
+
+// nestHeadings the headings are nested under the nearest preceding heading of a higher level
+func nestHeadings(headings []Heading) []Heading {
+	roots := []Heading{}
+	for len(headings) > 0 {
+		head := headings[0]
+		end := 1
+		for end < len(headings) && headings[end].Level > head.Level {
+			end++
+		}
+		head.Children = nestHeadings(headings[1:end])
+		if len(head.Children) == 0 {
+			head.Children = nil
+		}
+		roots = append(roots, head)
+		headings = headings[end:]
+	}
+	return roots
+}
+
+// countWords the CJK characters, the words of other scripts and the characters except white spaces
+func countWords(b []byte) (cjk, words, chars int) {
+	inWord := false
+	for _, r := range string(b) {
+		if !unicode.IsSpace(r) {
+			chars++
+		}
+		switch {
+		case isCJK(r):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if !inWord {
+				words++
+			}
+			inWord = true
+		case r == '\'' || r == '’' || r == '-':
+			// e.g. "don't" and "well-known" are single words
+		default:
+			inWord = false
+		}
+	}
+	return
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
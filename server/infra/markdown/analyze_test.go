@@ -0,0 +1,62 @@
+package markdown
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestAnalyze(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should extract heading tree with anchors", func(t *testing.T) {
+		stats := Analyze("# Intro\n\n## 背景 *A*\n\n### Detail\n\n## Intro\n\n#### Deep\n\n# End\n")
+		Expect(stats.TOC).To(Equal([]Heading{
+			{Level: 1, Title: "Intro", Anchor: "intro", Children: []Heading{
+				{Level: 2, Title: "背景 A", Anchor: "背景-a", Children: []Heading{
+					{Level: 3, Title: "Detail", Anchor: "detail"},
+				}},
+				{Level: 2, Title: "Intro", Anchor: "intro-1", Children: []Heading{
+					{Level: 4, Title: "Deep", Anchor: "deep"},
+				}},
+			}},
+			{Level: 1, Title: "End", Anchor: "end"},
+		}))
+
+		html, _ := Render("# Intro\n\n## 背景 *A*\n\n## Intro\n")
+		Expect(html).To(ContainSubstring(`<h2 id="背景-a">`))
+		Expect(html).To(ContainSubstring(`<h2 id="intro-1">`))
+	})
+
+	t.Run("should count words of CJK and other scripts", func(t *testing.T) {
+		stats := Analyze("Hello, **world**! It's a well-known 示例文本 in 2022.\n")
+		Expect(stats.Words).To(Equal(11))
+		Expect(stats.Chars).To(Equal(38))
+		Expect(stats.ReadingMinutes).To(Equal(1))
+	})
+
+	t.Run("should count code blocks and images without their text", func(t *testing.T) {
+		stats := Analyze("text\n\n```go\nfunc main() {}\n```\n\n    indented code\n\n![alt text](a.png) ![b](b.png) `inline code`\n")
+		Expect(stats.CodeBlocks).To(Equal(2))
+		Expect(stats.Images).To(Equal(2))
+		Expect(stats.Words).To(Equal(3))
+	})
+
+	t.Run("should estimate reading time", func(t *testing.T) {
+		Expect(Analyze("").ReadingMinutes).To(BeZero())
+		Expect(Analyze("").TOC).To(BeEmpty())
+
+		long := ""
+		for i := 0; i < 500; i++ {
+			long += "word "
+		}
+		// 500 words at 200 per minute
+		Expect(Analyze(long).ReadingMinutes).To(Equal(3))
+		cjk := ""
+		for i := 0; i < 600; i++ {
+			cjk += "字"
+		}
+		// 600 characters at 300 per minute, and 6 images at 10 seconds each
+		Expect(Analyze(cjk + "\n\n![a](a.png) ![a](a.png) ![a](a.png) ![a](a.png) ![a](a.png) ![a](a.png)").ReadingMinutes).To(Equal(3))
+	})
+}
@@ -0,0 +1,51 @@
+package markdown
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRender(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should render GFM tables and task lists", func(t *testing.T) {
+		html, err := Render("| a | b |\n|:--|--:|\n| 1 | 2 |\n\n- [x] done\n- [ ] todo\n\n~~old~~ https://example.com\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(html).To(ContainSubstring(`<th align="left">a</th>`))
+		Expect(html).To(ContainSubstring(`<td align="right">2</td>`))
+		Expect(html).To(ContainSubstring(`<li><input checked="" disabled="" type="checkbox"> done</li>`))
+		Expect(html).To(ContainSubstring(`<li><input disabled="" type="checkbox"> todo</li>`))
+		Expect(html).To(ContainSubstring(`<del>old</del>`))
+		Expect(html).To(ContainSubstring(`<a href="https://example.com" rel="nofollow">https://example.com</a>`))
+	})
+
+	t.Run("should keep classes of code blocks for highlighters", func(t *testing.T) {
+		html, err := Render("```go\nfmt.Println(1)\n```\n\n<code class=\"evil\">x</code>\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(html).To(ContainSubstring(`<pre><code class="language-go">fmt.Println(1)`))
+		Expect(html).To(ContainSubstring(`<code>x</code>`))
+	})
+
+	t.Run("should generate unique anchors of headings", func(t *testing.T) {
+		html, err := Render("# Hello World\n\n## 你好 World\n\n## Hello World\n\n## ?\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(html).To(Equal(`<h1 id="hello-world">Hello World</h1>` + "\n" +
+			`<h2 id="你好-world">你好 World</h2>` + "\n" +
+			`<h2 id="hello-world-1">Hello World</h2>` + "\n" +
+			`<h2 id="heading">?</h2>` + "\n"))
+	})
+
+	t.Run("should sanitize scripts and dangerous attributes", func(t *testing.T) {
+		html, err := Render("<script>alert(1)</script>\n\n<a href=\"javascript:alert(1)\" onclick=\"x()\">a</a>\n<img src=\"x.png\" onerror=\"x()\">" +
+			"\n\n[b](javascript:alert(1)) <input type=\"text\" value=\"x\"> <sup>2</sup>\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(html).ToNot(ContainSubstring("script"))
+		Expect(html).ToNot(ContainSubstring("javascript"))
+		Expect(html).ToNot(ContainSubstring("onclick"))
+		Expect(html).ToNot(ContainSubstring("onerror"))
+		Expect(html).ToNot(ContainSubstring("input"))
+		Expect(html).To(ContainSubstring(`<img src="x.png">`))
+		Expect(html).To(ContainSubstring(`<sup>2</sup>`))
+	})
+}
@@ -0,0 +1,106 @@
+package markdown
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// ellipsis appended to the summary which is cut off in the middle of a sentence
+const ellipsis = "…"
+
+// Summarize extract the plain text of the paragraphs of the Markdown source as the summary, which is at most
+// maxRunes characters. The headings, code blocks, inline code, tables, images and raw HTML are dropped,
+// the wiki links are replaced with their texts.
+// The text is cut at the last end of sentence (Chinese or English) within the limit, a long sentence
+// is cut at the last white space and followed by an ellipsis.
+func Summarize(source string, maxRunes int) string {
+	src := []byte(ReplaceWikiLinks(source, WikiLink.Text))
+	doc := converter.Parser().Parse(text.NewReader(src))
+
+	b := strings.Builder{}
+	newBlock := false
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		if newBlock && b.Len() > 0 {
+			last, _ := utf8.DecodeLastRuneInString(b.String())
+			first, _ := utf8.DecodeRuneInString(s)
+			// the lines of Chinese are joined without space
+			if !(isCJK(last) || isCJKPunct(last)) || !isCJK(first) {
+				b.WriteByte(' ')
+			}
+		}
+		newBlock = false
+		b.WriteString(s)
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Heading, *ast.FencedCodeBlock, *ast.CodeBlock, *ast.CodeSpan, *ast.HTMLBlock, *ast.Image,
+			*ast.RawHTML, *east.Table:
+			return ast.WalkSkipChildren, nil
+		case *ast.Paragraph, *ast.TextBlock:
+			newBlock = true
+		case *ast.Text:
+			write(string(node.Segment.Value(src)))
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				newBlock = true
+			}
+		case *ast.AutoLink:
+			write(string(node.Label(src)))
+		}
+		return ast.WalkContinue, nil
+	})
+	return truncate(strings.Join(strings.Fields(b.String()), " "), maxRunes)
+}
+
+// truncate cut the text at the last end of sentence within maxRunes characters
+func truncate(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+
+	for i := maxRunes - 1; i > 0; i-- {
+		if isSentenceEnd(runes, i) {
+			return string(runes[:i+1])
+		}
+	}
+
+	limit := maxRunes - utf8.RuneCountInString(ellipsis)
+	if limit <= 0 {
+		return string(runes[:maxRunes])
+	}
+	cut := limit
+	for i := limit; i > 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace) + ellipsis
+}
+
+// isSentenceEnd the Chinese terminators end the sentence anywhere, while the English terminators must
+// be followed by a white space, e.g. the dot in '3.14' is not the end of sentence
+func isSentenceEnd(runes []rune, i int) bool {
+	switch runes[i] {
+	case '。', '！', '？', '…':
+		return true
+	case '.', '!', '?':
+		return i+1 < len(runes) && unicode.IsSpace(runes[i+1])
+	}
+	return false
+}
+
+func isCJKPunct(r rune) bool {
+	return strings.ContainsRune("。，、；：！？…）】」』”", r)
+}
@@ -0,0 +1,42 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSummarize(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should strip markdown syntax and code", func(t *testing.T) {
+		source := "# Title\n\nSome *emphasis* and `code` with [link](http://a.com).\n\n" +
+			"```go\nfmt.Println()\n```\n\n![image](a.png)\n\n<div>raw</div>\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n" +
+			"- item one\n- item two\n\n第一行\n第二行。\n"
+		Expect(Summarize(source, 1000)).To(Equal("Some emphasis and with link. item one item two 第一行第二行。"))
+		Expect(Summarize("", 1000)).To(Equal(""))
+		Expect(Summarize("```\ncode only\n```", 1000)).To(Equal(""))
+	})
+
+	t.Run("should drop inline code", func(t *testing.T) {
+		Expect(Summarize("Call `fmt.Println(\"secret\")` to print. Use ``a `b` c`` too.", 1000)).
+			To(Equal("Call to print. Use too."))
+		Expect(Summarize("`only code`", 1000)).To(Equal(""))
+	})
+
+	t.Run("should cut at the last end of sentence", func(t *testing.T) {
+		Expect(Summarize("First one. Second one. Third one.", 25)).To(Equal("First one. Second one."))
+		Expect(Summarize("Pi is 3.14 exactly. Next", 15)).To(Equal("Pi is 3.14…"))
+		Expect(Summarize("第一句话。第二句话！第三句话？", 12)).To(Equal("第一句话。第二句话！"))
+		Expect(Summarize("这是一个非常长的没有标点的句子", 8)).To(Equal("这是一个非常长…"))
+	})
+
+	t.Run("should stay within the limit", func(t *testing.T) {
+		source := strings.Repeat("word ", 500) + "\n\n" + strings.Repeat("中文句子。", 500)
+		for _, limit := range []int{1, 10, 100, 1000} {
+			Expect(utf8.RuneCountInString(Summarize(source, limit))).To(BeNumerically("<=", limit))
+		}
+	})
+}
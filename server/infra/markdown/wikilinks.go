@@ -0,0 +1,118 @@
+package markdown
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// wikiLinkPattern matches '[[target]]' and '[[target|label]]'
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// WikiLink a link to another article in the form of '[[target]]' or '[[target|label]]',
+// the target is the title or the id of the article
+type WikiLink struct {
+	Target string
+	Label  string
+}
+
+// WikiLinks the distinct targets linked by the Markdown source in order of appearance.
+// The links in code and raw HTML are not links.
+func WikiLinks(source string) []WikiLink {
+	links := []WikiLink{}
+	seen := map[string]bool{}
+	ReplaceWikiLinks(source, func(link WikiLink) string {
+		if !seen[link.Target] {
+			seen[link.Target] = true
+			links = append(links, link)
+		}
+		return ""
+	})
+	return links
+}
+
+// ReplaceWikiLinks replace each wiki link of the Markdown source with the text returned by fn,
+// the links in code and raw HTML are kept as they are
+func ReplaceWikiLinks(source string, fn func(link WikiLink) string) string {
+	src := []byte(source)
+	excluded := codeRanges(src)
+
+	b := strings.Builder{}
+	last := 0
+	for _, m := range wikiLinkPattern.FindAllStringSubmatchIndex(source, -1) {
+		if inRanges(excluded, m[0]) {
+			continue
+		}
+		link := WikiLink{Target: strings.TrimSpace(source[m[2]:m[3]])}
+		if m[4] >= 0 {
+			link.Label = strings.TrimSpace(source[m[4]:m[5]])
+		}
+		if link.Target == "" {
+			continue
+		}
+		b.WriteString(source[last:m[0]])
+		b.WriteString(fn(link))
+		last = m[1]
+	}
+	b.WriteString(source[last:])
+	return b.String()
+}
+
+// String the link in the syntax of source
+func (l WikiLink) String() string {
+	if l.Label == "" {
+		return "[[" + l.Target + "]]"
+	}
+	return "[[" + l.Target + "|" + l.Label + "]]"
+}
+
+// Text the text displayed for the link, the target is displayed if the label is absent
+func (l WikiLink) Text() string {
+	if l.Label == "" {
+		return l.Target
+	}
+	return l.Label
+}
+
+// codeRanges the sorted byte ranges of code blocks, code spans and raw HTML in the source
+func codeRanges(src []byte) [][2]int {
+	doc := converter.Parser().Parse(text.NewReader(src))
+	ranges := [][2]int{}
+	addLines := func(lines *text.Segments) {
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			ranges = append(ranges, [2]int{seg.Start, seg.Stop})
+		}
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock:
+			addLines(node.Lines())
+			return ast.WalkSkipChildren, nil
+		case *ast.RawHTML:
+			addLines(node.Segments)
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					ranges = append(ranges, [2]int{t.Segment.Start, t.Segment.Stop})
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	return ranges
+}
+
+func inRanges(ranges [][2]int, pos int) bool {
+	idx := sort.Search(len(ranges), func(i int) bool { return ranges[i][0] > pos })
+	return idx > 0 && pos < ranges[idx-1][1]
+}
@@ -0,0 +1,39 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestWikiLinks(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should extract distinct wiki links out of code", func(t *testing.T) {
+		source := "See [[Getting Started]] and [[ 123 | the guide ]], again [[Getting Started|here]].\n\n" +
+			"`[[inline code]]` <a href=\"[[attr]]\">\n\n<div>\n[[html]]\n</div>\n\n```\n[[fenced code]]\n```\n\n    [[indented code]]\n\n" +
+			"[[]] [[a\nb]] [normal](link)"
+		Expect(WikiLinks(source)).To(Equal([]WikiLink{
+			{Target: "Getting Started"}, {Target: "123", Label: "the guide"},
+		}))
+		Expect(WikiLinks("")).To(BeEmpty())
+	})
+
+	t.Run("should replace wiki links out of code", func(t *testing.T) {
+		source := "[[Old]] [[Old|label]] [[Other]] `[[Old]]`"
+		replaced := ReplaceWikiLinks(source, func(link WikiLink) string {
+			if link.Target == "Old" {
+				link.Target = "New"
+			}
+			return link.String()
+		})
+		Expect(replaced).To(Equal("[[New]] [[New|label]] [[Other]] `[[Old]]`"))
+		Expect(ReplaceWikiLinks(source, WikiLink.Text)).To(Equal("Old label Other `[[Old]]`"))
+	})
+
+	t.Run("should summarize texts of wiki links", func(t *testing.T) {
+		Expect(Summarize("See [[Intro]] and [[1|this]].", 100)).To(Equal("See Intro and this."))
+		Expect(strings.Contains(Summarize("```\n[[a]]\n```", 100), "a")).To(BeFalse())
+	})
+}
@@ -2,8 +2,10 @@ package meta
 
 import (
 	"fmt"
+	"os"
 	"owlet/server/infra/idgen"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/sony/sonyflake"
@@ -41,3 +43,17 @@ func GetServiceMeta() ServiceInfo {
 		NumMaxProcs:  runtime.GOMAXPROCS(0),
 	}
 }
+
+// EnvPublicURL the external base url of service, it is used to build links (e.g. in mails)
+const EnvPublicURL = "PUBLIC_URL"
+
+const DefaultPublicURL = "http://localhost"
+
+// PublicURL the external base url without the trailing slash
+func PublicURL() string {
+	u := strings.TrimRight(os.Getenv(EnvPublicURL), "/")
+	if u == "" {
+		return DefaultPublicURL
+	}
+	return u
+}
@@ -15,7 +15,7 @@ func RegisterMetaRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
 // @Success 200 {object} meta.ServiceInfo
 // @Router / [get]
 func metaInfo(c *gin.Context) {
-	// localize.MustGetMessage("status-running")
+	// localize.FromGinContext(c).Message("status-running", nil)
 	m := GetServiceMeta()
 	c.JSON(http.StatusOK, &m)
 }
@@ -0,0 +1,14 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const PathMetrics = "/metrics"
+
+// RegisterMetricsRestAPI expose the metrics in prometheus text format
+func RegisterMetricsRestAPI(r *gin.Engine, middleWares ...gin.HandlerFunc) {
+	g := r.Group(PathMetrics, middleWares...)
+	g.GET("", gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})))
+}
@@ -0,0 +1,62 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/metrics"
+	"owlet/server/testinfra"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+)
+
+type MetricsResource struct {
+	ID   int
+	Name string
+}
+
+func TestMetrics(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.New()
+	router.Use(metrics.HTTPMetrics())
+	metrics.RegisterMetricsRestAPI(router)
+	router.GET("/resources/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	t.Run("http requests should be counted by route path and status", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/resources/123", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+		req = httptest.NewRequest(http.MethodGet, "/not-exist", nil)
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNotFound))
+
+		req = httptest.NewRequest(http.MethodGet, metrics.PathMetrics, nil)
+		status, body, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(body).To(ContainSubstring(`owlet_http_requests_total{method="GET",path="/resources/:id",status="204"} 1`))
+		Expect(body).To(ContainSubstring(`owlet_http_requests_total{method="GET",path="<unmatched>",status="404"} 1`))
+		Expect(body).To(ContainSubstring(`owlet_http_request_duration_seconds_count{method="GET",path="/resources/:id",status="204"} 1`))
+		Expect(body).To(ContainSubstring("go_goroutines"))
+	})
+
+	t.Run("gorm operations should be observed by operation and table", func(t *testing.T) {
+		gormDB, mock := testinfra.SetUpMockSql()
+		Expect(gormDB.Use(&metrics.GormPlugin{})).To(BeNil())
+
+		mock.ExpectQuery("SELECT \\* FROM `metrics_resources`").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "demo"))
+		records := []MetricsResource{}
+		Expect(gormDB.Find(&records).Error).To(BeNil())
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+
+		req := httptest.NewRequest(http.MethodGet, metrics.PathMetrics, nil)
+		_, body, _ := testinfra.ExecuteRequest(req, router)
+		Expect(body).To(ContainSubstring(`owlet_gorm_query_duration_seconds_count{operation="query",table="metrics_resources"} 1`))
+	})
+}
@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"owlet/server/infra/fail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvProviders the comma separated names of providers, the provider 'corp' is configured by
+// OIDC_CORP_ISSUER, OIDC_CORP_CLIENT_ID, OIDC_CORP_CLIENT_SECRET and OIDC_CORP_SCOPES (optional, comma separated).
+const EnvProviders = "OIDC_PROVIDERS"
+
+var DefaultScopes = []string{"openid", "profile", "email"}
+
+// ErrAuthentication the provider rejected the authorization, or the id token is not acceptable.
+// It wraps fail.ErrUnauthenticated.
+var ErrAuthentication = fmt.Errorf("%w: oidc", fail.ErrUnauthenticated)
+
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// Provider an OpenID provider which supports the authorization code flow with PKCE.
+// The discovery document and the signing keys are fetched lazily and cached.
+type Provider struct {
+	Config ProviderConfig
+	Client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]interface{}
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Providers the configured providers by name, it is empty until replaced (see FromEnv)
+var Providers = map[string]*Provider{}
+
+func NewProvider(cfg ProviderConfig) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &Provider{Config: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// FromEnv build the providers listed in OIDC_PROVIDERS, providers without issuer or client id are skipped
+func FromEnv() map[string]*Provider {
+	providers := map[string]*Provider{}
+	for _, name := range splitList(os.Getenv(EnvProviders)) {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       splitList(os.Getenv(prefix + "SCOPES")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			continue
+		}
+		providers[name] = NewProvider(cfg)
+	}
+	return providers
+}
+
+// Lookup the provider with name, fail.ErrNotFound is returned if it is not configured
+func Lookup(name string) (*Provider, error) {
+	p, found := Providers[name]
+	if !found {
+		return nil, fail.ErrNotFound
+	}
+	return p, nil
+}
+
+// AuthRequest the parameters of an authorization request, they are kept by the client until the callback
+type AuthRequest struct {
+	State       string
+	Nonce       string
+	Verifier    string // PKCE code verifier, only the S256 challenge of it is sent to the provider
+	RedirectURL string
+}
+
+// NewAuthRequest generate random state, nonce and code verifier
+func NewAuthRequest(redirectURL string) (*AuthRequest, error) {
+	values := make([]string, 3)
+	for i := range values {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	return &AuthRequest{State: values[0], Nonce: values[1], Verifier: values[2], RedirectURL: redirectURL}, nil
+}
+
+// CodeChallenge the S256 challenge of PKCE code verifier
+func CodeChallenge(verifier string) string {
+	digest := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// AuthCodeURL the url of provider which the user agent should be redirected to
+func (p *Provider) AuthCodeURL(ctx context.Context, r *AuthRequest) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {r.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {r.State},
+		"nonce":                 {r.Nonce},
+		"code_challenge":        {CodeChallenge(r.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeem the authorization code, then verify the id token against the request
+func (p *Provider) Exchange(ctx context.Context, code string, r *AuthRequest) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {r.RedirectURL},
+		"code_verifier": {r.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint responds %d: %s", ErrAuthentication, res.StatusCode, body)
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("%w: malformed token response: %v", ErrAuthentication, err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: id_token is absent", ErrAuthentication)
+	}
+	return p.verify(ctx, token.IDToken, r.Nonce, time.Now())
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	m := metadata{}
+	if err := p.getJSON(ctx, p.Config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(m.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("issuer of provider %s mismatches: %q", p.Config.Name, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of provider %s is incomplete", p.Config.Name)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: status %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
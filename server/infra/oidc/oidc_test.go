@@ -0,0 +1,179 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"owlet/server/infra/fail"
+	"owlet/server/testinfra"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+const testRedirectURL = "http://localhost/v1/auth/oidc/stub/callback"
+
+// authorize follow the authorization url, the code and state are extracted from the redirection
+func authorize(authURL string) (code string, state string, err error) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	RegisterTestingT(t)
+
+	stub, err := testinfra.StartOIDCStub("owlet", "secret")
+	Expect(err).ToNot(HaveOccurred())
+	defer stub.Close()
+	stub.SetClaims(map[string]interface{}{"sub": "u-1", "email": "tom@example.com", "email_verified": true,
+		"name": "Tom", "preferred_username": "tom"})
+
+	p := NewProvider(ProviderConfig{Name: "stub", Issuer: stub.URL, ClientID: "owlet", ClientSecret: "secret"})
+
+	t.Run("should authenticate user with PKCE", func(t *testing.T) {
+		r, err := NewAuthRequest(testRedirectURL)
+		Expect(err).ToNot(HaveOccurred())
+		authURL, err := p.AuthCodeURL(context.TODO(), r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authURL).To(HavePrefix(stub.URL + "/authorize?"))
+		Expect(authURL).To(ContainSubstring("code_challenge=" + CodeChallenge(r.Verifier)))
+		Expect(authURL).To(ContainSubstring("scope=openid+profile+email"))
+		Expect(authURL).ToNot(ContainSubstring(r.Verifier))
+
+		code, state, err := authorize(authURL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(r.State))
+
+		claims, err := p.Exchange(context.TODO(), code, r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Subject).To(Equal("u-1"))
+		Expect(claims.Email).To(Equal("tom@example.com"))
+		Expect(claims.EmailVerified).To(BeTrue())
+		Expect(claims.Name).To(Equal("Tom"))
+		Expect(claims.PreferredUsername).To(Equal("tom"))
+	})
+
+	t.Run("should reject code exchange without the right verifier", func(t *testing.T) {
+		r, _ := NewAuthRequest(testRedirectURL)
+		authURL, _ := p.AuthCodeURL(context.TODO(), r)
+		code, _, err := authorize(authURL)
+		Expect(err).ToNot(HaveOccurred())
+
+		forged := *r
+		forged.Verifier = "forged"
+		_, err = p.Exchange(context.TODO(), code, &forged)
+		Expect(errors.Is(err, fail.ErrUnauthenticated)).To(BeTrue())
+	})
+
+	t.Run("should reject id token with other nonce", func(t *testing.T) {
+		r, _ := NewAuthRequest(testRedirectURL)
+		authURL, _ := p.AuthCodeURL(context.TODO(), r)
+		code, _, err := authorize(authURL)
+		Expect(err).ToNot(HaveOccurred())
+
+		replayed := *r
+		replayed.Nonce = "other"
+		_, err = p.Exchange(context.TODO(), code, &replayed)
+		Expect(errors.Is(err, ErrAuthentication)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("nonce mismatch"))
+	})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	RegisterTestingT(t)
+
+	stub, err := testinfra.StartOIDCStub("owlet", "secret")
+	Expect(err).ToNot(HaveOccurred())
+	defer stub.Close()
+	p := NewProvider(ProviderConfig{Name: "stub", Issuer: stub.URL, ClientID: "owlet", ClientSecret: "secret"})
+
+	now := time.Now()
+	claimsOf := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"iss": stub.URL, "aud": []string{"other", "owlet"}, "sub": "u-1",
+			"nonce": "n", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	t.Run("should accept audience in array", func(t *testing.T) {
+		token, _ := stub.SignIDToken(claimsOf(nil))
+		claims, err := p.verify(context.TODO(), token, "n", now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Subject).To(Equal("u-1"))
+	})
+
+	cases := []struct {
+		name     string
+		claims   map[string]interface{}
+		expected string
+	}{
+		{"other issuer", claimsOf(map[string]interface{}{"iss": "http://evil"}), "issuer mismatch"},
+		{"other audience", claimsOf(map[string]interface{}{"aud": "other"}), "audience mismatch"},
+		{"expired", claimsOf(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), "id token expired"},
+		{"issued in future", claimsOf(map[string]interface{}{"iat": now.Add(time.Hour).Unix()}), "issued in future"},
+		{"without subject", claimsOf(map[string]interface{}{"sub": ""}), "subject is absent"},
+	}
+	for _, c := range cases {
+		t.Run("should reject id token "+c.name, func(t *testing.T) {
+			token, _ := stub.SignIDToken(c.claims)
+			_, err := p.verify(context.TODO(), token, "n", now)
+			Expect(errors.Is(err, ErrAuthentication)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(c.expected))
+		})
+	}
+
+	t.Run("should reject tampered id token", func(t *testing.T) {
+		token, _ := stub.SignIDToken(claimsOf(nil))
+		other, _ := stub.SignIDToken(claimsOf(map[string]interface{}{"sub": "u-2"}))
+		parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+		_, err := p.verify(context.TODO(), parts[0]+"."+otherParts[1]+"."+parts[2], "n", now)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("bad id token signature"))
+
+		_, err = p.verify(context.TODO(), "not-a-token", "n", now)
+		Expect(errors.Is(err, ErrAuthentication)).To(BeTrue())
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should build providers with complete config", func(t *testing.T) {
+		defer os.Unsetenv(EnvProviders)
+		defer os.Unsetenv("OIDC_CORP_SSO_ISSUER")
+		defer os.Unsetenv("OIDC_CORP_SSO_CLIENT_ID")
+		defer os.Unsetenv("OIDC_CORP_SSO_SCOPES")
+
+		os.Setenv(EnvProviders, "corp-sso, absent")
+		os.Setenv("OIDC_CORP_SSO_ISSUER", "https://sso.example.com/")
+		os.Setenv("OIDC_CORP_SSO_CLIENT_ID", "owlet")
+		os.Setenv("OIDC_CORP_SSO_SCOPES", "openid,email")
+
+		providers := FromEnv()
+		Expect(len(providers)).To(Equal(1))
+		Expect(providers["corp-sso"].Config).To(Equal(ProviderConfig{Name: "corp-sso",
+			Issuer: "https://sso.example.com", ClientID: "owlet", Scopes: []string{"openid", "email"}}))
+	})
+
+	t.Run("should fail to lookup absent provider", func(t *testing.T) {
+		_, err := Lookup("absent")
+		Expect(err).To(Equal(fail.ErrNotFound))
+	})
+}
@@ -1,8 +1,13 @@
 package persistence
 
 import (
+	"context"
+	"errors"
 	"os"
+	"owlet/server/infra/metrics"
+	"strings"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -11,6 +16,8 @@ import (
 
 var ActiveGormDB *gorm.DB
 
+var ErrDatabaseNotActive = errors.New("database is not active")
+
 func StartGormDB(dsn string) (*gorm.DB, error) {
 	_, args := SplitName(dsn)
 	gormDB, err := gorm.Open(mysql.Open(args), &gorm.Config{})
@@ -18,7 +25,12 @@ func StartGormDB(dsn string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	gormDB.Use(gormopentracing.New(gormopentracing.WithLogResult(false)))
+	if err := gormDB.Use(gormopentracing.New(gormopentracing.WithLogResult(false))); err != nil {
+		return nil, err
+	}
+	if err := gormDB.Use(&metrics.GormPlugin{}); err != nil {
+		return nil, err
+	}
 
 	if os.Getenv("GIN_MODE") == "debug" {
 		gormDB = gormDB.Debug()
@@ -36,3 +48,28 @@ func StopGormDB(gormDB *gorm.DB) {
 		}
 	}
 }
+
+// PingDatabase check the connectivity of ActiveGormDB
+func PingDatabase(ctx context.Context) error {
+	if ActiveGormDB == nil {
+		return ErrDatabaseNotActive
+	}
+	sqlDB, err := ActiveGormDB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// mysqlErrDupEntry the error number of MySQL when a unique index is violated
+const mysqlErrDupEntry = 1062
+
+// IsDuplicateKeyError whether the error is caused by violating the unique index named key
+func IsDuplicateKeyError(err error, key string) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlErrDupEntry {
+		return false
+	}
+	// the key is qualified by the table name since MySQL 8.0, e.g. "for key 'user.uk_user_email'"
+	return strings.HasSuffix(mysqlErr.Message, "'"+key+"'") || strings.HasSuffix(mysqlErr.Message, "."+key+"'")
+}
@@ -2,9 +2,13 @@ package persistence_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"owlet/server/infra/persistence"
 	"owlet/server/testinfra"
 	"testing"
 
+	"github.com/go-sql-driver/mysql"
 	. "github.com/onsi/gomega"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
@@ -75,3 +79,38 @@ func TestGormTracing(t *testing.T) {
 		Expect(s1.SpanContext.Sampled).To(BeTrue())
 	})
 }
+
+func TestPingDatabase(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should fail when database is not active", func(t *testing.T) {
+		persistence.ActiveGormDB = nil
+		Expect(persistence.PingDatabase(context.TODO())).To(Equal(persistence.ErrDatabaseNotActive))
+	})
+
+	t.Run("should ping active database", func(t *testing.T) {
+		testinfra.SetUpMockSql()
+		Expect(persistence.PingDatabase(context.TODO())).To(BeNil())
+	})
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should match the violated unique index", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1062,
+			Message: "Duplicate entry 'tom@example.com' for key 'user.uk_user_email'"})
+		Expect(persistence.IsDuplicateKeyError(err, "uk_user_email")).To(BeTrue())
+		Expect(persistence.IsDuplicateKeyError(err, "email")).To(BeFalse())
+
+		err = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'tom@example.com' for key 'uk_user_email'"}
+		Expect(persistence.IsDuplicateKeyError(err, "uk_user_email")).To(BeTrue())
+	})
+
+	t.Run("should not match other errors", func(t *testing.T) {
+		Expect(persistence.IsDuplicateKeyError(nil, "uk_user_email")).To(BeFalse())
+		Expect(persistence.IsDuplicateKeyError(errors.New("for key 'uk_user_email'"), "uk_user_email")).To(BeFalse())
+		err := &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}
+		Expect(persistence.IsDuplicateKeyError(err, "uk_user_email")).To(BeFalse())
+	})
+}
@@ -0,0 +1,147 @@
+package persistence
+
+import (
+	"sort"
+
+	"github.com/fundwit/go-commons/types"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned schema change which can be applied and reverted.
+// Versions are applied in ascending order.
+type Migration struct {
+	Version     int
+	Description string
+
+	Up   func(*gorm.DB) error
+	Down func(*gorm.DB) error
+}
+
+// MigrationRecord is the history of applied migrations.
+type MigrationRecord struct {
+	Version     int             `json:"version" gorm:"primary_key;type:INT NOT NULL"`
+	Description string          `json:"description" gorm:"type:VARCHAR(255) NOT NULL"`
+	AppliedTime types.Timestamp `json:"applied_time" gorm:"type:DATETIME NOT NULL"`
+}
+
+func (r *MigrationRecord) TableName() string {
+	return "schema_migration"
+}
+
+type MigrationStatus struct {
+	Version     int              `json:"version"`
+	Description string           `json:"description"`
+	Applied     bool             `json:"applied"`
+	AppliedTime *types.Timestamp `json:"applied_time"`
+}
+
+// MigrateUp runs the auto migrations first, then applies all pending versioned migrations.
+// The versions of newly applied migrations are returned.
+func MigrateUp(db *gorm.DB, autoMigrations []interface{}, migrations []Migration) ([]int, error) {
+	if len(autoMigrations) > 0 {
+		if err := db.AutoMigrate(autoMigrations...); err != nil {
+			return nil, err
+		}
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []int{}
+	for _, m := range sortedMigrations(migrations) {
+		if _, found := applied[m.Version]; found {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.Up != nil {
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&MigrationRecord{Version: m.Version, Description: m.Description,
+				AppliedTime: types.CurrentTimestamp()}).Error
+		})
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+// MigrateDown reverts the latest applied migrations, at most 'steps' migrations will be reverted.
+// The versions of reverted migrations are returned.
+func MigrateDown(db *gorm.DB, migrations []Migration, steps int) ([]int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := sortedMigrations(migrations)
+	versions := []int{}
+	for i := len(sorted) - 1; i >= 0 && len(versions) < steps; i-- {
+		m := sorted[i]
+		if _, found := applied[m.Version]; !found {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.Down != nil {
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&MigrationRecord{}, "version = ?", m.Version).Error
+		})
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+// MigrationStatuses reports the applied status of each migration.
+func MigrationStatuses(db *gorm.DB, migrations []Migration) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range sortedMigrations(migrations) {
+		status := MigrationStatus{Version: m.Version, Description: m.Description}
+		if r, found := applied[m.Version]; found {
+			appliedTime := r.AppliedTime
+			status.Applied = true
+			status.AppliedTime = &appliedTime
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]MigrationRecord, error) {
+	if err := db.AutoMigrate(&MigrationRecord{}); err != nil {
+		return nil, err
+	}
+	records := []MigrationRecord{}
+	if err := db.Model(&MigrationRecord{}).Scan(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]MigrationRecord, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
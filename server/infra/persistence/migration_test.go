@@ -0,0 +1,81 @@
+package persistence_test
+
+import (
+	"errors"
+	"owlet/server/infra/persistence"
+	"owlet/server/testinfra"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+)
+
+type MigrationResource struct {
+	ID   int
+	Name string
+}
+
+func TestMigrations(t *testing.T) {
+	RegisterTestingT(t)
+
+	var testDatabase *testinfra.TestDatabase
+	upCalls, downCalls := []int{}, []int{}
+	migrations := []persistence.Migration{
+		{Version: 2, Description: "second",
+			Up:   func(db *gorm.DB) error { upCalls = append(upCalls, 2); return nil },
+			Down: func(db *gorm.DB) error { downCalls = append(downCalls, 2); return nil }},
+		{Version: 1, Description: "first",
+			Up:   func(db *gorm.DB) error { upCalls = append(upCalls, 1); return nil },
+			Down: func(db *gorm.DB) error { downCalls = append(downCalls, 1); return nil }},
+	}
+
+	t.Run("migrations should be applied and reverted in order", func(t *testing.T) {
+		defer testinfra.GormIntegrateTestTeardown(t, testDatabase)
+		testinfra.GormIntegrateTestSetup(t, &testDatabase)
+
+		versions, err := persistence.MigrateUp(testDatabase.GormDB, []interface{}{&MigrationResource{}}, migrations)
+		Expect(err).To(BeNil())
+		Expect(versions).To(Equal([]int{1, 2}))
+		Expect(upCalls).To(Equal([]int{1, 2}))
+		Expect(testDatabase.GormDB.Migrator().HasTable(&MigrationResource{})).To(BeTrue())
+
+		// idempotent
+		versions, err = persistence.MigrateUp(testDatabase.GormDB, nil, migrations)
+		Expect(err).To(BeNil())
+		Expect(versions).To(BeEmpty())
+
+		statuses, err := persistence.MigrationStatuses(testDatabase.GormDB, migrations)
+		Expect(err).To(BeNil())
+		Expect(len(statuses)).To(Equal(2))
+		Expect(statuses[0].Version).To(Equal(1))
+		Expect(statuses[0].Applied).To(BeTrue())
+		Expect(statuses[1].Applied).To(BeTrue())
+
+		versions, err = persistence.MigrateDown(testDatabase.GormDB, migrations, 1)
+		Expect(err).To(BeNil())
+		Expect(versions).To(Equal([]int{2}))
+		Expect(downCalls).To(Equal([]int{2}))
+
+		statuses, err = persistence.MigrationStatuses(testDatabase.GormDB, migrations)
+		Expect(err).To(BeNil())
+		Expect(statuses[0].Applied).To(BeTrue())
+		Expect(statuses[1].Applied).To(BeFalse())
+		Expect(statuses[1].AppliedTime).To(BeNil())
+	})
+
+	t.Run("failed migration should not be recorded", func(t *testing.T) {
+		defer testinfra.GormIntegrateTestTeardown(t, testDatabase)
+		testinfra.GormIntegrateTestSetup(t, &testDatabase)
+
+		failed := []persistence.Migration{
+			{Version: 1, Description: "broken", Up: func(db *gorm.DB) error { return errors.New("some error") }},
+		}
+		versions, err := persistence.MigrateUp(testDatabase.GormDB, nil, failed)
+		Expect(err).To(Equal(errors.New("some error")))
+		Expect(versions).To(BeEmpty())
+
+		statuses, err := persistence.MigrationStatuses(testDatabase.GormDB, failed)
+		Expect(err).To(BeNil())
+		Expect(statuses[0].Applied).To(BeFalse())
+	})
+}
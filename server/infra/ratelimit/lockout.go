@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"owlet/server/infra/fail"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Lockout lock a key (e.g. an account) temporarily after too many failures, e.g. wrong passwords.
+// The state is kept in memory of each instance.
+type Lockout struct {
+	maxFailures int
+	duration    time.Duration
+
+	mu      sync.Mutex
+	entries *cache.Cache
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewLockout the key is locked for duration after maxFailures failures,
+// the failures are forgotten if there is no failure within duration.
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	return &Lockout{maxFailures: maxFailures, duration: duration, entries: cache.New(duration, time.Minute)}
+}
+
+// Check return fail.ErrTooManyRequests if the key is locked
+func (l *Lockout) Check(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	value, found := l.entries.Get(key)
+	if !found {
+		return nil
+	}
+	if remaining := time.Until(value.(*lockoutEntry).lockedUntil); remaining > 0 {
+		return &fail.ErrTooManyRequests{RetryAfter: remaining}
+	}
+	return nil
+}
+
+// Fail record a failure of key, it returns true if the key becomes locked
+func (l *Lockout) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := &lockoutEntry{}
+	if value, found := l.entries.Get(key); found {
+		entry = value.(*lockoutEntry)
+	}
+	entry.failures++
+	locked := entry.failures >= l.maxFailures
+	if locked {
+		entry.failures = 0
+		entry.lockedUntil = time.Now().Add(l.duration)
+	}
+	l.entries.Set(key, entry, l.duration)
+	return locked
+}
+
+// Reset forget the failures of key, e.g. after a success
+func (l *Lockout) Reset(key string) {
+	l.entries.Delete(key)
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// EnvAPIRule the rule of the general api groups, see ParseRule for the format
+	EnvAPIRule = "RATE_LIMIT_API"
+	// EnvLoginRule the rule of the login endpoints, see ParseRule for the format
+	EnvLoginRule = "RATE_LIMIT_LOGIN"
+
+	// bucketIdleTTL the buckets of idle clients are evicted
+	bucketIdleTTL = 10 * time.Minute
+)
+
+var (
+	DefaultAPIRule   = Rule{Limit: 20, Burst: 40}
+	DefaultLoginRule = Rule{Limit: rate.Every(6 * time.Second), Burst: 10}
+)
+
+// Rule a token bucket refilled at Limit tokens per second with the capacity of Burst.
+// A zero Burst disables the limit.
+type Rule struct {
+	Limit rate.Limit
+	Burst int
+}
+
+func (r Rule) Disabled() bool {
+	return r.Burst <= 0
+}
+
+// ParseRule parse '<events>/<unit>[:<burst>]', the unit is one of s, m and h, e.g. '20/s:40', '10/m'.
+// The burst is the same as events if absent. 'off' disables the limit.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "off") {
+		return Rule{}, nil
+	}
+	spec, burstSpec := s, ""
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		spec, burstSpec = s[:idx], s[idx+1:]
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return Rule{}, fmt.Errorf("invalid rate limit rule '%s'", s)
+	}
+	events, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || events <= 0 {
+		return Rule{}, fmt.Errorf("invalid events of rate limit rule '%s'", s)
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	unit, found := units[strings.TrimSpace(parts[1])]
+	if !found {
+		return Rule{}, fmt.Errorf("invalid unit of rate limit rule '%s'", s)
+	}
+	burst := events
+	if burstSpec != "" {
+		if burst, err = strconv.Atoi(strings.TrimSpace(burstSpec)); err != nil || burst <= 0 {
+			return Rule{}, fmt.Errorf("invalid burst of rate limit rule '%s'", s)
+		}
+	}
+	return Rule{Limit: rate.Limit(float64(events) / unit.Seconds()), Burst: burst}, nil
+}
+
+// RuleFromEnv the rule in env, the default rule is used if it is absent or invalid
+func RuleFromEnv(env string, def Rule) Rule {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	rule, err := ParseRule(value)
+	if err != nil {
+		logrus.Warnf("%s: %v, the default rule is used", env, err)
+		return def
+	}
+	return rule
+}
+
+// Limiter the token buckets of clients with the same rule
+type Limiter struct {
+	rule    Rule
+	mu      sync.Mutex
+	buckets *cache.Cache
+}
+
+func NewLimiter(rule Rule) *Limiter {
+	return &Limiter{rule: rule, buckets: cache.New(bucketIdleTTL, time.Minute)}
+}
+
+// Allow take a token from the bucket of key, the delay until a token is available is returned if it is empty
+func (l *Limiter) Allow(key string) (time.Duration, bool) {
+	if l.rule.Disabled() {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	var bucket *rate.Limiter
+	if value, found := l.buckets.Get(key); found {
+		bucket = value.(*rate.Limiter)
+	} else {
+		bucket = rate.NewLimiter(l.rule.Limit, l.rule.Burst)
+	}
+	l.buckets.SetDefault(key, bucket) // the idle time is counted from the last request
+	l.mu.Unlock()
+
+	now := time.Now()
+	r := bucket.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Duration(math.MaxInt64), false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// KeyFunc the client identity which the bucket is keyed by
+type KeyFunc func(*gin.Context) string
+
+// ClientIP key by the client address, see gin.Context.ClientIP for the trusted proxies
+func ClientIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// UserOrIP key by the logged in user, or by the client address for anonymous requests
+func UserOrIP(c *gin.Context) string {
+	if s, found := sessions.LookupSession(c); found && s.Identity.ID != 0 {
+		return "user:" + s.Identity.ID.String()
+	}
+	return ClientIP(c)
+}
+
+// Middleware reject the requests exceeding the rule with fail.ErrTooManyRequests,
+// each middleware has its own buckets, so the groups using different middlewares are limited separately.
+func Middleware(rule Rule, key KeyFunc) gin.HandlerFunc {
+	limiter := NewLimiter(rule)
+	return func(c *gin.Context) {
+		if delay, ok := limiter.Allow(key(c)); !ok {
+			panic(&fail.ErrTooManyRequests{RetryAfter: delay})
+		}
+		c.Next()
+	}
+}
+
+// ByIP limit each client address
+func ByIP(rule Rule) gin.HandlerFunc {
+	return Middleware(rule, ClientIP)
+}
+
+// ByUser limit each logged in user, anonymous requests are limited by client address
+func ByUser(rule Rule) gin.HandlerFunc {
+	return Middleware(rule, UserOrIP)
+}
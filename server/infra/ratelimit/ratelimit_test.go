@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/sessions"
+	"owlet/server/testinfra"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+)
+
+func TestParseRule(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should parse rules", func(t *testing.T) {
+		cases := map[string]Rule{
+			"20/s:40": {Limit: 20, Burst: 40},
+			"10/m":    {Limit: rate.Limit(10.0 / 60), Burst: 10},
+			" 1/h:5 ": {Limit: rate.Limit(1.0 / 3600), Burst: 5},
+			"off":     {},
+		}
+		for spec, expected := range cases {
+			rule, err := ParseRule(spec)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule).To(Equal(expected))
+		}
+		Expect(Rule{}.Disabled()).To(BeTrue())
+	})
+
+	t.Run("should reject invalid rules", func(t *testing.T) {
+		for _, spec := range []string{"", "20", "0/s", "x/s", "20/d", "20/s:0", "20/s:x"} {
+			_, err := ParseRule(spec)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	t.Run("should read rule from env", func(t *testing.T) {
+		defer os.Unsetenv(EnvAPIRule)
+		Expect(RuleFromEnv(EnvAPIRule, DefaultAPIRule)).To(Equal(DefaultAPIRule))
+		os.Setenv(EnvAPIRule, "5/s:5")
+		Expect(RuleFromEnv(EnvAPIRule, DefaultAPIRule)).To(Equal(Rule{Limit: 5, Burst: 5}))
+		os.Setenv(EnvAPIRule, "invalid")
+		Expect(RuleFromEnv(EnvAPIRule, DefaultAPIRule)).To(Equal(DefaultAPIRule))
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should allow burst then reject until refilled", func(t *testing.T) {
+		l := NewLimiter(Rule{Limit: rate.Every(time.Minute), Burst: 2})
+		for i := 0; i < 2; i++ {
+			_, ok := l.Allow("a")
+			Expect(ok).To(BeTrue())
+		}
+		delay, ok := l.Allow("a")
+		Expect(ok).To(BeFalse())
+		Expect(delay).To(BeNumerically(">", 59*time.Second))
+		Expect(delay).To(BeNumerically("<=", time.Minute))
+
+		// rejected requests do not consume tokens
+		delay2, _ := l.Allow("a")
+		Expect(delay2).To(BeNumerically("<=", delay))
+
+		_, ok = l.Allow("b")
+		Expect(ok).To(BeTrue())
+	})
+
+	t.Run("should allow everything if disabled", func(t *testing.T) {
+		l := NewLimiter(Rule{})
+		for i := 0; i < 100; i++ {
+			_, ok := l.Allow("a")
+			Expect(ok).To(BeTrue())
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	RegisterTestingT(t)
+
+	router := gin.Default()
+	router.Use(fail.ErrorHandling())
+	router.GET("/ip", ByIP(Rule{Limit: rate.Every(time.Minute), Burst: 1}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/user", ByUser(Rule{Limit: rate.Every(time.Minute), Burst: 1}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	sessions.TokenCache.Add("tom-token", &sessions.Session{Token: "tom-token", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+	t.Run("should limit by client address", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+
+		req = httptest.NewRequest(http.MethodGet, "/ip", nil)
+		status, body, res := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+		Expect(body).To(ContainSubstring(`"code":"common.too_many_requests"`))
+		Expect(res.Header.Get("Retry-After")).To(Equal("60"))
+
+		req = httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = "192.0.2.2:1234"
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+	})
+
+	t.Run("should limit logged in user separately from address", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		status, _, _ := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+
+		req = httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusNoContent))
+
+		req = httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Add("cookie", "sec_token=tom-token")
+		req.RemoteAddr = "192.0.2.2:1234"
+		status, _, _ = testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+	})
+}
+
+func TestLockout(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should lock key after too many failures", func(t *testing.T) {
+		l := NewLockout(3, time.Minute)
+		Expect(l.Fail("tom")).To(BeFalse())
+		Expect(l.Fail("tom")).To(BeFalse())
+		Expect(l.Check("tom")).ToNot(HaveOccurred())
+		Expect(l.Fail("tom")).To(BeTrue())
+
+		err := l.Check("tom")
+		Expect(err).To(BeAssignableToTypeOf(&fail.ErrTooManyRequests{}))
+		Expect(err.(*fail.ErrTooManyRequests).RetryAfter).To(BeNumerically(">", 59*time.Second))
+		Expect(l.Check("jerry")).ToNot(HaveOccurred())
+	})
+
+	t.Run("should forget failures after reset or the lock expired", func(t *testing.T) {
+		l := NewLockout(2, time.Minute)
+		l.Fail("tom")
+		l.Reset("tom")
+		Expect(l.Fail("tom")).To(BeFalse())
+
+		l = NewLockout(1, 10*time.Millisecond)
+		Expect(l.Fail("tom")).To(BeTrue())
+		Expect(l.Check("tom")).To(HaveOccurred())
+		time.Sleep(20 * time.Millisecond)
+		Expect(l.Check("tom")).ToNot(HaveOccurred())
+	})
+}
@@ -3,10 +3,12 @@ package sessions
 import (
 	"context"
 	"owlet/server/infra/authority"
+	"owlet/server/infra/logging"
 	"strings"
 	"time"
 
 	"github.com/fundwit/go-commons/types"
+	"github.com/sirupsen/logrus"
 )
 
 type Session struct {
@@ -24,6 +26,7 @@ type Identity struct {
 	ID       types.ID `json:"id"`
 	Name     string   `json:"name"`
 	Nickname string   `json:"nickname"`
+	Locale   string   `json:"locale"`
 }
 
 func (c *Session) Clone() Session {
@@ -37,6 +40,11 @@ func (c *Session) Clone() Session {
 	}
 }
 
+// Logger return the request scope logger carried by Context
+func (c *Session) Logger() *logrus.Entry {
+	return logging.FromContext(c.Context)
+}
+
 // VisibleProjects  parse visible project ids from Context.Perms
 func (c *Session) VisibleProjects() []types.ID {
 	var projectIds []types.ID
@@ -1,10 +1,17 @@
 package sessions
 
 import (
+	"net/http"
+	"owlet/server/infra/authority"
+	"owlet/server/infra/logging"
+	"strings"
 	"time"
 
+	"github.com/fundwit/go-commons/types"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 )
 
 const TokenExpiration = 24 * time.Hour
@@ -12,8 +19,8 @@ const TokenExpiration = 24 * time.Hour
 var TokenCache = cache.New(TokenExpiration, 1*time.Minute)
 
 type LoginRequest struct {
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	Name     string `json:"name" binding:"required,lte=255"`
+	Password string `json:"password" binding:"required,lte=255"`
 }
 
 const KeySecCtx = "SecCtx"
@@ -36,5 +43,62 @@ func ExtractSessionFromGinContext(ctx *gin.Context) *Session {
 func InjectSessionIntoGinContext(ctx *gin.Context, s *Session) {
 	if s != nil && s.Token != "" {
 		ctx.Set(KeySecCtx, s)
+		if ctx.Request != nil {
+			ctx.Request = ctx.Request.WithContext(logging.WithFields(ctx.Request.Context(),
+				logrus.Fields{logging.FieldUserID: s.Identity.ID}))
+		}
 	}
 }
+
+// UpdateCachedIdentity apply update to the identity of the cached session with token, the expiration is kept.
+// The cached session is replaced instead of modified, since it may be read by concurrent requests.
+func UpdateCachedIdentity(token string, update func(*Identity)) {
+	value, expiration, found := TokenCache.GetWithExpiration(token)
+	if !found {
+		return
+	}
+	s, ok := value.(*Session)
+	if !ok {
+		return
+	}
+	updated := s.Clone()
+	update(&updated.Identity)
+
+	ttl := cache.NoExpiration
+	if !expiration.IsZero() {
+		if ttl = time.Until(expiration); ttl <= 0 {
+			return
+		}
+	}
+	TokenCache.Set(token, &updated, ttl)
+}
+
+// RevokeSessions log the user out everywhere by removing the cached sessions of the user,
+// the number of removed sessions is returned.
+func RevokeSessions(userID types.ID) int {
+	revoked := 0
+	for token, item := range TokenCache.Items() {
+		if s, ok := item.Object.(*Session); ok && s.Identity.ID == userID {
+			TokenCache.Delete(token)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// Establish start a new session for the authenticated identity, the token is cached and sent by cookie.
+func Establish(ctx *gin.Context, identity Identity, perms authority.Permissions) *Session {
+	s := &Session{
+		Token:       strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Identity:    identity,
+		Perms:       perms,
+		SigningTime: time.Now(),
+	}
+	TokenCache.Set(s.Token, s, TokenExpiration)
+
+	secure := ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https"
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(KeySecToken, s.Token, int(TokenExpiration.Seconds()), "/", "", secure, true)
+	InjectSessionIntoGinContext(ctx, s)
+	return s
+}
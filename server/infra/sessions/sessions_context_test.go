@@ -2,8 +2,11 @@ package sessions_test
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"owlet/server/infra/authority"
 	session "owlet/server/infra/sessions"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/onsi/gomega"
@@ -48,3 +51,49 @@ func TestSaveSecurityContext(t *testing.T) {
 		Expect(*secCtx).To(Equal(session.Session{Token: "a token"}))
 	})
 }
+
+func TestEstablish(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should cache session and send token by cookie", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(w)
+		ginCtx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+		s := session.Establish(ginCtx, session.Identity{ID: 100, Name: "tom"}, authority.Permissions{"admin"})
+		Expect(s.Token).ToNot(BeEmpty())
+		Expect(s.SigningTime.IsZero()).To(BeFalse())
+		cached, found := session.TokenCache.Get(s.Token)
+		Expect(found).To(BeTrue())
+		Expect(cached.(*session.Session).Identity).To(Equal(session.Identity{ID: 100, Name: "tom"}))
+		Expect(cached.(*session.Session).Perms).To(Equal(authority.Permissions{"admin"}))
+		Expect(session.ExtractSessionFromGinContext(ginCtx).Token).To(Equal(s.Token))
+
+		cookie := w.Result().Cookies()[0]
+		Expect(cookie.Name).To(Equal(session.KeySecToken))
+		Expect(cookie.Value).To(Equal(s.Token))
+		Expect(cookie.HttpOnly).To(BeTrue())
+		Expect(cookie.Secure).To(BeFalse())
+		Expect(cookie.SameSite).To(Equal(http.SameSiteLaxMode))
+	})
+}
+
+func TestRevokeSessions(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should remove the sessions of the user only", func(t *testing.T) {
+		session.TokenCache.Set("revoke-1", &session.Session{Token: "revoke-1", Identity: session.Identity{ID: 501}}, time.Minute)
+		session.TokenCache.Set("revoke-2", &session.Session{Token: "revoke-2", Identity: session.Identity{ID: 501}}, time.Minute)
+		session.TokenCache.Set("revoke-3", &session.Session{Token: "revoke-3", Identity: session.Identity{ID: 502}}, time.Minute)
+
+		Expect(session.RevokeSessions(501)).To(Equal(2))
+		_, found := session.TokenCache.Get("revoke-1")
+		Expect(found).To(BeFalse())
+		_, found = session.TokenCache.Get("revoke-2")
+		Expect(found).To(BeFalse())
+		_, found = session.TokenCache.Get("revoke-3")
+		Expect(found).To(BeTrue())
+
+		Expect(session.RevokeSessions(501)).To(BeZero())
+	})
+}
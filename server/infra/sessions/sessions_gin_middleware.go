@@ -26,3 +26,51 @@ func SessionFilter() gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// OptionalSessionFilter inject the session if the request is logged in, anonymous requests are passed through,
+// for the resources whose representation depends on the viewer.
+func OptionalSessionFilter() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if s, found := LookupSession(ctx); found {
+			InjectSessionIntoGinContext(ctx, s)
+		}
+		ctx.Next()
+	}
+}
+
+// RequireRole reject the request if the session does not have the specified role.
+// It should be placed after SessionFilter.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		s := ExtractSessionFromGinContext(ctx)
+		if !s.Perms.HasRole(role) {
+			panic(fail.ErrForbidden)
+		}
+		ctx.Next()
+	}
+}
+
+// PreferredLanguage the preferred language of the logged in user, empty if not logged in or not specified.
+// It does not require SessionFilter, so it can be used by the global localize middleware.
+func PreferredLanguage(ctx *gin.Context) string {
+	s, found := LookupSession(ctx)
+	if !found {
+		return ""
+	}
+	return s.Identity.Locale
+}
+
+// LookupSession find the cached session by the token in cookie, it does not reject anonymous requests
+// like SessionFilter does.
+func LookupSession(ctx *gin.Context) (*Session, bool) {
+	token, err := ctx.Cookie(KeySecToken)
+	if err != nil {
+		return nil, false
+	}
+	value, found := TokenCache.Get(token)
+	if !found {
+		return nil, false
+	}
+	s, ok := value.(*Session)
+	return s, ok
+}
@@ -1,9 +1,12 @@
 package sessions_test
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"owlet/server/infra/authority"
 	"owlet/server/infra/fail"
+	"owlet/server/infra/logging"
 	"owlet/server/infra/sessions"
 	"owlet/server/testinfra"
 	"testing"
@@ -58,3 +61,98 @@ func TestSessionFilter(t *testing.T) {
 		Expect(body).To(Equal("b"))
 	})
 }
+
+func TestOptionalSessionFilter(t *testing.T) {
+	RegisterTestingT(t)
+
+	engine := gin.Default()
+	engine.Use(fail.ErrorHandling(), sessions.OptionalSessionFilter())
+	engine.GET("/", func(c *gin.Context) {
+		s := sessions.ExtractSessionFromGinContext(c)
+		c.String(http.StatusOK, fmt.Sprint(s.Identity.ID))
+	})
+
+	t.Run("anonymous session when not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		status, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("0"))
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=absent")
+		status, body, _ = testinfra.ExecuteRequest(req, engine)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("0"))
+	})
+
+	t.Run("session of the logged in user", func(t *testing.T) {
+		sessions.TokenCache.Add("optional-user", &sessions.Session{Token: "optional-user", Identity: sessions.Identity{ID: 100}}, time.Minute)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=optional-user")
+		status, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("100"))
+	})
+}
+
+func TestRequireRole(t *testing.T) {
+	RegisterTestingT(t)
+
+	engine := gin.Default()
+	engine.Use(fail.ErrorHandling(), sessions.SessionFilter(), sessions.RequireRole(authority.RoleAdmin))
+	engine.GET("/", func(c *gin.Context) {
+		s := sessions.ExtractSessionFromGinContext(c)
+		c.String(http.StatusOK, fmt.Sprint(s.Logger().Data[logging.FieldUserID]))
+	})
+
+	t.Run("forbidden response when role is absent", func(t *testing.T) {
+		sessions.TokenCache.Add("normal", &sessions.Session{Token: "normal", Perms: authority.Permissions{"user"}}, time.Minute)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=normal")
+		status, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(body).To(MatchJSON(`{"code":"security.forbidden", "message": "access forbidden", "data": null}`))
+	})
+
+	t.Run("access is granted when role is present, and user id is carried by logger", func(t *testing.T) {
+		sessions.TokenCache.Add("admin", &sessions.Session{Token: "admin", Identity: sessions.Identity{ID: 100},
+			Perms: authority.Permissions{"admin"}}, time.Minute)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=admin")
+		status, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("100"))
+	})
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	RegisterTestingT(t)
+
+	engine := gin.Default()
+	engine.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, sessions.PreferredLanguage(c))
+	})
+
+	t.Run("empty when not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(body).To(BeEmpty())
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=absent")
+		_, body, _ = testinfra.ExecuteRequest(req, engine)
+		Expect(body).To(BeEmpty())
+	})
+
+	t.Run("locale of the logged in user", func(t *testing.T) {
+		sessions.TokenCache.Add("zh-user", &sessions.Session{Token: "zh-user", Identity: sessions.Identity{ID: 100, Locale: "zh-TW"}}, time.Minute)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("cookie", "sec_token=zh-user")
+		_, body, _ := testinfra.ExecuteRequest(req, engine)
+		Expect(body).To(Equal("zh-TW"))
+	})
+}
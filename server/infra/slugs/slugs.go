@@ -0,0 +1,71 @@
+package slugs
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength the max length of slugs, the words beyond it are dropped
+const MaxLength = 100
+
+// pinyinArgs the pinyin without tones, e.g. '中' => 'zhong'
+var pinyinArgs = pinyin.NewArgs()
+
+// pattern a valid slug consists of lower case letters and digits separated by single hyphens
+var pattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Make generate the slug of text, e.g. 'Hello, World' => 'hello-world' and '你好 Go' => 'ni-hao-go'.
+// The Chinese characters are transliterated to pinyin and the diacritics of Latin letters are removed,
+// other characters are separators. The result is empty if no letter or digit is kept.
+func Make(text string) string {
+	words := []string{}
+	word := strings.Builder{}
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// the diacritics decomposed from the letters, e.g. 'é' => 'e' + U+0301
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			word.WriteRune(unicode.ToLower(r))
+		case unicode.Is(unicode.Han, r):
+			flush()
+			if p := pinyin.SinglePinyin(r, pinyinArgs); len(p) > 0 {
+				words = append(words, p[0])
+			}
+		case r == '\'' || r == '’':
+			// e.g. "don't" => 'dont'
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	slug := ""
+	for _, w := range words {
+		if len(slug)+len(w)+1 > MaxLength {
+			break
+		}
+		if slug != "" {
+			slug += "-"
+		}
+		slug += w
+	}
+	if slug == "" && len(words) > 0 {
+		slug = words[0][:MaxLength]
+	}
+	return slug
+}
+
+// Valid the slug is well-formed, which is the same as the one made of itself
+func Valid(slug string) bool {
+	return len(slug) <= MaxLength && pattern.MatchString(slug)
+}
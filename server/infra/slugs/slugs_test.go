@@ -0,0 +1,48 @@
+package slugs
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMake(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should make slugs of titles", func(t *testing.T) {
+		Expect(Make("Hello, World!")).To(Equal("hello-world"))
+		Expect(Make("  Go 1.18: Generics  ")).To(Equal("go-1-18-generics"))
+		Expect(Make("Don't panic")).To(Equal("dont-panic"))
+		Expect(Make("Café à la crème")).To(Equal("cafe-a-la-creme"))
+		Expect(Make("")).To(Equal(""))
+		Expect(Make("!!! ???")).To(Equal(""))
+		Expect(Make("こんにちは")).To(Equal(""))
+	})
+
+	t.Run("should transliterate Chinese to pinyin", func(t *testing.T) {
+		Expect(Make("你好世界")).To(Equal("ni-hao-shi-jie"))
+		Expect(Make("Go语言入门，第1章")).To(Equal("go-yu-yan-ru-men-di-1-zhang"))
+	})
+
+	t.Run("should be within max length at word boundary", func(t *testing.T) {
+		slug := Make(strings.Repeat("word ", 50))
+		Expect(len(slug)).To(BeNumerically("<=", MaxLength))
+		Expect(slug).To(HaveSuffix("word"))
+
+		slug = Make(strings.Repeat("a", 200))
+		Expect(slug).To(Equal(strings.Repeat("a", MaxLength)))
+	})
+}
+
+func TestValid(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, slug := range []string{"hello", "hello-world", "go-1-18", Make("你好世界")} {
+		Expect(Valid(slug)).To(BeTrue(), slug)
+	}
+	for _, slug := range []string{"", "Hello", "hello--world", "-hello", "hello-", "hello_world", "你好",
+		strings.Repeat("a", MaxLength+1)} {
+		Expect(Valid(slug)).To(BeFalse(), slug)
+	}
+}
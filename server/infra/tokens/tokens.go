@@ -0,0 +1,123 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"owlet/server/infra/fail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	"github.com/sirupsen/logrus"
+)
+
+// EnvTokenSecret the HMAC key for signing tokens, it should be shared by all instances
+const EnvTokenSecret = "TOKEN_SECRET"
+
+var (
+	ErrTokenMalformed = fmt.Errorf("%w: malformed", fail.ErrInvalidToken)
+	ErrTokenSignature = fmt.Errorf("%w: bad signature", fail.ErrInvalidToken)
+	ErrTokenPurpose   = fmt.Errorf("%w: purpose mismatch", fail.ErrInvalidToken)
+	ErrTokenExpired   = fmt.Errorf("%w: expired", fail.ErrInvalidToken)
+	// ErrTokenUsed the fingerprint mismatches the current state, it is returned by the token consumers
+	ErrTokenUsed = fmt.Errorf("%w: used", fail.ErrInvalidToken)
+)
+
+// Claims the payload of token. The Fingerprint is a digest of the state which the token acts on,
+// the token is used only once if the state changes after it is used (e.g. the password is reset).
+type Claims struct {
+	Purpose     string   `json:"p"`
+	Subject     types.ID `json:"s"`
+	Fingerprint string   `json:"f,omitempty"`
+	ExpiresAt   int64    `json:"e"`
+}
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+)
+
+// SetSecret replace the signing key, tokens signed by the previous key become invalid
+func SetSecret(key []byte) {
+	secretOnce.Do(func() {})
+	secret = key
+}
+
+func signingSecret() []byte {
+	secretOnce.Do(func() {
+		if key := os.Getenv(EnvTokenSecret); key != "" {
+			secret = []byte(key)
+			return
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+		logrus.Warnf("%s is absent, a random secret is used, tokens become invalid after restart", EnvTokenSecret)
+	})
+	return secret
+}
+
+// Issue sign a token for purpose, it expires after ttl
+func Issue(purpose string, subject types.ID, fingerprint string, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(&Claims{Purpose: purpose, Subject: subject, Fingerprint: fingerprint,
+		ExpiresAt: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(encoded)), nil
+}
+
+// Parse verify the signature, purpose and expiration of token.
+// The errors wrap fail.ErrInvalidToken.
+func Parse(token, purpose string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !hmac.Equal(signature, sign(parts[0])) {
+		return nil, ErrTokenSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := Claims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrTokenPurpose
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// Fingerprint digest the state values, it is short but enough to detect state changes
+func Fingerprint(values ...string) string {
+	h := sha256.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12])
+}
+
+func sign(payload string) []byte {
+	mac := hmac.New(sha256.New, signingSecret())
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
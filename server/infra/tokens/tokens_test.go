@@ -0,0 +1,75 @@
+package tokens_test
+
+import (
+	"errors"
+	"owlet/server/infra/fail"
+	"owlet/server/infra/tokens"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fundwit/go-commons/types"
+	. "github.com/onsi/gomega"
+)
+
+func TestIssueAndParse(t *testing.T) {
+	RegisterTestingT(t)
+
+	tokens.SetSecret([]byte("test-secret"))
+
+	t.Run("should parse issued token", func(t *testing.T) {
+		token, err := tokens.Issue("reset", 100, "fp", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		claims, err := tokens.Parse(token, "reset")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Purpose).To(Equal("reset"))
+		Expect(claims.Subject).To(Equal(types.ID(100)))
+		Expect(claims.Fingerprint).To(Equal("fp"))
+	})
+
+	t.Run("should reject token of other purpose", func(t *testing.T) {
+		token, _ := tokens.Issue("reset", 100, "fp", time.Minute)
+		_, err := tokens.Parse(token, "verify")
+		Expect(err).To(Equal(tokens.ErrTokenPurpose))
+		Expect(errors.Is(err, fail.ErrInvalidToken)).To(BeTrue())
+	})
+
+	t.Run("should reject expired token", func(t *testing.T) {
+		token, _ := tokens.Issue("reset", 100, "fp", -time.Second)
+		_, err := tokens.Parse(token, "reset")
+		Expect(err).To(Equal(tokens.ErrTokenExpired))
+	})
+
+	t.Run("should reject tampered or malformed token", func(t *testing.T) {
+		token, _ := tokens.Issue("reset", 100, "fp", time.Minute)
+		other, _ := tokens.Issue("reset", 200, "fp", time.Minute)
+		tampered := strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1]
+		_, err := tokens.Parse(tampered, "reset")
+		Expect(err).To(Equal(tokens.ErrTokenSignature))
+
+		_, err = tokens.Parse("abc", "reset")
+		Expect(err).To(Equal(tokens.ErrTokenMalformed))
+		_, err = tokens.Parse("abc.!!!", "reset")
+		Expect(err).To(Equal(tokens.ErrTokenMalformed))
+	})
+
+	t.Run("should reject token signed by other secret", func(t *testing.T) {
+		token, _ := tokens.Issue("reset", 100, "fp", time.Minute)
+		tokens.SetSecret([]byte("other-secret"))
+		defer tokens.SetSecret([]byte("test-secret"))
+
+		_, err := tokens.Parse(token, "reset")
+		Expect(err).To(Equal(tokens.ErrTokenSignature))
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should be stable and sensitive to values", func(t *testing.T) {
+		Expect(tokens.Fingerprint("a", "b")).To(Equal(tokens.Fingerprint("a", "b")))
+		Expect(tokens.Fingerprint("a", "b")).ToNot(Equal(tokens.Fingerprint("ab", "")))
+		Expect(tokens.Fingerprint("a")).ToNot(Equal(tokens.Fingerprint("b")))
+	})
+}
@@ -0,0 +1,84 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// the secret of test vectors in RFC 6238 appendix B (SHA1)
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should match the test vectors of RFC 6238", func(t *testing.T) {
+		// the 8 digits vectors truncated to 6 digits
+		vectors := map[int64]string{59: "287082", 1111111109: "081804", 1111111111: "050471",
+			1234567890: "005924", 2000000000: "279037", 20000000000: "353130"}
+		for unix, expected := range vectors {
+			code, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(code).To(Equal(expected))
+		}
+	})
+
+	t.Run("should reject malformed secret", func(t *testing.T) {
+		_, err := Code("not base32!", 1)
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestValidate(t *testing.T) {
+	RegisterTestingT(t)
+
+	now := time.Unix(1111111109, 0)
+	current := Step(now)
+
+	t.Run("should accept codes of adjacent steps", func(t *testing.T) {
+		for _, step := range []int64{current - 1, current, current + 1} {
+			code, _ := Code(rfcSecret, step)
+			matched, ok := Validate(rfcSecret, code, now, 0)
+			Expect(ok).To(BeTrue())
+			Expect(matched).To(Equal(step))
+		}
+	})
+
+	t.Run("should reject codes out of window, used steps and malformed codes", func(t *testing.T) {
+		code, _ := Code(rfcSecret, current-2)
+		_, ok := Validate(rfcSecret, code, now, 0)
+		Expect(ok).To(BeFalse())
+
+		code, _ = Code(rfcSecret, current)
+		_, ok = Validate(rfcSecret, code, now, current)
+		Expect(ok).To(BeFalse())
+
+		for _, code := range []string{"", "12345", "1234567"} {
+			_, ok = Validate(rfcSecret, code, now, 0)
+			Expect(ok).To(BeFalse())
+		}
+	})
+}
+
+func TestSecretAndURI(t *testing.T) {
+	RegisterTestingT(t)
+
+	secret, err := GenerateSecret()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(len(secret)).To(Equal(32))
+	another, _ := GenerateSecret()
+	Expect(another).ToNot(Equal(secret))
+
+	u, err := url.Parse(URI("owlet", "tom@example.com", secret))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(u.Scheme).To(Equal("otpauth"))
+	Expect(u.Host).To(Equal("totp"))
+	Expect(u.Path).To(Equal("/owlet:tom@example.com"))
+	Expect(u.Query().Get("secret")).To(Equal(secret))
+	Expect(u.Query().Get("issuer")).To(Equal("owlet"))
+	Expect(u.Query().Get("digits")).To(Equal("6"))
+	Expect(u.Query().Get("period")).To(Equal("30"))
+}
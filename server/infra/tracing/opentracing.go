@@ -3,36 +3,88 @@ package tracing
 import (
 	"io"
 	"os"
+	"strings"
 
 	"owlet/server/infra/meta"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
 	"github.com/uber/jaeger-client-go"
 	jaegerconfig "github.com/uber/jaeger-client-go/config"
-	jaegerlog "github.com/uber/jaeger-client-go/log"
 	jaegermetrics "github.com/uber/jaeger-lib/metrics"
 )
 
-func NewTracer() (opentracing.Tracer, io.Closer, error) {
-	// Sample configuration for testing. Use constant sampling to sample every
-	// trace and enable LogSpan to log every span via configured Logger.
+const (
+	// EnvPropagation comma separated propagation formats, e.g. "jaeger,b3,w3c"
+	EnvPropagation = "TRACING_PROPAGATION"
+
+	DefaultSamplerType  = jaeger.SamplerTypeProbabilistic
+	DefaultSamplerParam = 0.1
+)
+
+// LoadConfig build the tracer configuration from the standard jaeger environment variables:
+//
+//	JAEGER_DISABLED            true to use a no-op tracer
+//	JAEGER_SAMPLER_TYPE        const, probabilistic, ratelimiting or remote, default probabilistic
+//	JAEGER_SAMPLER_PARAM       default 0.1
+//	JAEGER_AGENT_HOST          report spans to agent over UDP, with JAEGER_AGENT_PORT
+//	JAEGER_ENDPOINT            report spans to collector over HTTP, take precedence over agent
+//	JAEGER_REPORTER_LOG_SPANS  log every reported span, default false
+func LoadConfig(serviceName string) (*jaegerconfig.Configuration, error) {
 	cfg := &jaegerconfig.Configuration{
-		ServiceName: meta.GetServiceMeta().Name,
+		ServiceName: serviceName,
 		Sampler: &jaegerconfig.SamplerConfig{
-			Type:  jaeger.SamplerTypeConst,
-			Param: 1,
-		},
-		Reporter: &jaegerconfig.ReporterConfig{
-			LogSpans:          true,
-			CollectorEndpoint: os.Getenv("JAEGER_ENDPOINT"),
+			Type:  DefaultSamplerType,
+			Param: DefaultSamplerParam,
 		},
+		Reporter: &jaegerconfig.ReporterConfig{},
+	}
+	return cfg.FromEnv()
+}
+
+// LoadPropagations read propagation formats from TRACING_PROPAGATION, all supported formats are enabled by default.
+func LoadPropagations() []string {
+	value := strings.TrimSpace(os.Getenv(EnvPropagation))
+	if value == "" {
+		return DefaultPropagations
+	}
+	formats := []string{}
+	for _, f := range strings.Split(value, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+func NewTracer() (opentracing.Tracer, io.Closer, error) {
+	cfg, err := LoadConfig(meta.GetServiceMeta().Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	propagator, err := NewPropagator(LoadPropagations()...)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Initialize tracer with a logger and a metrics factory
 	// the returned closer func can be used to flush buffers before shutdown
-	tracer, closer, err := cfg.NewTracer(
-		jaegerconfig.Logger(jaegerlog.StdLogger),
+	// a no-op tracer will be returned if tracing is disabled
+	return cfg.NewTracer(
+		jaegerconfig.Logger(jaegerLogger{}),
 		jaegerconfig.Metrics(jaegermetrics.NullFactory),
+		jaegerconfig.Injector(opentracing.HTTPHeaders, propagator),
+		jaegerconfig.Extractor(opentracing.HTTPHeaders, propagator),
 	)
-	return tracer, closer, err
+}
+
+// jaegerLogger route the logs of jaeger client to logrus
+type jaegerLogger struct{}
+
+func (jaegerLogger) Error(msg string) {
+	logrus.Error(msg)
+}
+
+func (jaegerLogger) Infof(msg string, args ...interface{}) {
+	logrus.Infof(msg, args...)
 }
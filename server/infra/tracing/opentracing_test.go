@@ -1,10 +1,12 @@
 package tracing_test
 
 import (
+	"os"
 	"owlet/server/infra/tracing"
 	"testing"
 
 	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
 	jaeger "github.com/uber/jaeger-client-go"
 )
 
@@ -23,7 +25,91 @@ func TestNewTracer(t *testing.T) {
 		tags := tracer.Tags()
 		Expect(len(tags)).To(Equal(3)) // jaeger.version, hostname, ip
 
-		// TODO assert effective configuration:
-		//      serviceName, sampler config, reporter config, tracer config
 	})
+
+	t.Run("no-op tracer should be used when tracing disabled", func(t *testing.T) {
+		os.Setenv("JAEGER_DISABLED", "true")
+		defer os.Unsetenv("JAEGER_DISABLED")
+
+		tr, c, err := tracing.NewTracer()
+		defer c.Close()
+		Expect(err).To(BeNil())
+		Expect(tr).To(Equal(&opentracing.NoopTracer{}))
+	})
+
+	t.Run("invalid propagation should be rejected", func(t *testing.T) {
+		os.Setenv(tracing.EnvPropagation, "jaeger,unknown")
+		defer os.Unsetenv(tracing.EnvPropagation)
+
+		tr, c, err := tracing.NewTracer()
+		Expect(err).To(MatchError("unsupported propagation format 'unknown'"))
+		Expect(tr).To(BeNil())
+		Expect(c).To(BeNil())
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should use defaults when environment variables are absent", func(t *testing.T) {
+		cfg, err := tracing.LoadConfig("demo")
+		Expect(err).To(BeNil())
+		Expect(cfg.ServiceName).To(Equal("demo"))
+		Expect(cfg.Disabled).To(BeFalse())
+		Expect(cfg.Sampler.Type).To(Equal(jaeger.SamplerTypeProbabilistic))
+		Expect(cfg.Sampler.Param).To(Equal(0.1))
+		Expect(cfg.Reporter.LogSpans).To(BeFalse())
+		Expect(cfg.Reporter.CollectorEndpoint).To(BeEmpty())
+	})
+
+	t.Run("should be overridden by environment variables", func(t *testing.T) {
+		envs := map[string]string{
+			"JAEGER_SAMPLER_TYPE":       "ratelimiting",
+			"JAEGER_SAMPLER_PARAM":      "5",
+			"JAEGER_ENDPOINT":           "http://collector:14268/api/traces",
+			"JAEGER_REPORTER_LOG_SPANS": "true",
+		}
+		for k, v := range envs {
+			os.Setenv(k, v)
+			defer os.Unsetenv(k)
+		}
+
+		cfg, err := tracing.LoadConfig("demo")
+		Expect(err).To(BeNil())
+		Expect(cfg.Sampler.Type).To(Equal(jaeger.SamplerTypeRateLimiting))
+		Expect(cfg.Sampler.Param).To(Equal(float64(5)))
+		Expect(cfg.Reporter.CollectorEndpoint).To(Equal("http://collector:14268/api/traces"))
+		Expect(cfg.Reporter.LogSpans).To(BeTrue())
+	})
+
+	t.Run("agent endpoint should be used when collector endpoint absent", func(t *testing.T) {
+		os.Setenv("JAEGER_AGENT_HOST", "agent")
+		defer os.Unsetenv("JAEGER_AGENT_HOST")
+		os.Setenv("JAEGER_AGENT_PORT", "6832")
+		defer os.Unsetenv("JAEGER_AGENT_PORT")
+
+		cfg, err := tracing.LoadConfig("demo")
+		Expect(err).To(BeNil())
+		Expect(cfg.Reporter.CollectorEndpoint).To(BeEmpty())
+		Expect(cfg.Reporter.LocalAgentHostPort).To(Equal("agent:6832"))
+	})
+
+	t.Run("invalid sampler param should be rejected", func(t *testing.T) {
+		os.Setenv("JAEGER_SAMPLER_PARAM", "abc")
+		defer os.Unsetenv("JAEGER_SAMPLER_PARAM")
+
+		cfg, err := tracing.LoadConfig("demo")
+		Expect(cfg).To(BeNil())
+		Expect(err).ToNot(BeNil())
+	})
+}
+
+func TestLoadPropagations(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(tracing.LoadPropagations()).To(Equal(tracing.DefaultPropagations))
+
+	os.Setenv(tracing.EnvPropagation, " W3C, b3 ,")
+	defer os.Unsetenv(tracing.EnvPropagation)
+	Expect(tracing.LoadPropagations()).To(Equal([]string{"w3c", "b3"}))
 }
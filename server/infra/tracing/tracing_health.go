@@ -0,0 +1,18 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var ErrTracerNotRegistered = errors.New("global tracer is not registered")
+
+// CheckTracer check the global tracer has been set up
+func CheckTracer(ctx context.Context) error {
+	if !opentracing.IsGlobalTracerRegistered() {
+		return ErrTracerNotRegistered
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestCheckTracer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("should pass only when global tracer is registered", func(t *testing.T) {
+		if !opentracing.IsGlobalTracerRegistered() {
+			Expect(CheckTracer(context.TODO())).To(Equal(ErrTracerNotRegistered))
+		}
+		opentracing.SetGlobalTracer(mocktracer.New())
+		Expect(CheckTracer(context.TODO())).To(BeNil())
+	})
+}
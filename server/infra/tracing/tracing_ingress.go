@@ -1,11 +1,16 @@
 package tracing
 
 import (
+	"owlet/server/infra/fail"
+
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 )
 
+const HeaderTraceID = "X-Trace-Id"
+
+// TracingRestAPI start a server span for each request, the trace id is returned in the X-Trace-Id header
 func TracingRestAPI() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		tracer := opentracing.GlobalTracer()
@@ -14,6 +19,11 @@ func TracingRestAPI() gin.HandlerFunc {
 		ext.HTTPMethod.Set(serverSpan, ctx.Request.Method)
 		defer serverSpan.Finish()
 
+		if traceID := TraceID(serverSpan.Context()); traceID != "" {
+			ctx.Header(HeaderTraceID, traceID)
+			ctx.Set(fail.KeyTraceID, traceID)
+		}
+
 		ctx.Request = ctx.Request.WithContext(opentracing.ContextWithSpan(ctx.Request.Context(), serverSpan))
 		ctx.Next()
 
@@ -3,6 +3,7 @@ package tracing
 import (
 	"net/http"
 	"net/http/httptest"
+	"owlet/server/infra/fail"
 	"owlet/server/testinfra"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
 )
 
 func TestTracingRestAPI(t *testing.T) {
@@ -122,3 +124,60 @@ func TestTracingRestAPI(t *testing.T) {
 		}))
 	})
 }
+
+func TestTracingRestAPI_TraceID(t *testing.T) {
+	RegisterTestingT(t)
+
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+
+	var traceIDInContext string
+	router := gin.Default()
+	router.Use(TracingRestAPI())
+	router.GET("/test/:id", func(c *gin.Context) {
+		traceIDInContext = c.GetString(fail.KeyTraceID)
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("trace id should be returned in response header", func(t *testing.T) {
+		reporter := jaeger.NewInMemoryReporter()
+		tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+		defer closer.Close()
+		opentracing.SetGlobalTracer(tracer)
+
+		req := httptest.NewRequest(http.MethodGet, "/test/123", nil)
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+
+		traceID := TraceID(reporter.GetSpans()[0].Context())
+		Expect(traceID).ToNot(BeEmpty())
+		Expect(resp.Header.Get("X-Trace-Id")).To(Equal(traceID))
+		Expect(traceIDInContext).To(Equal(traceID))
+	})
+
+	t.Run("trace id should be inherited from w3c traceparent", func(t *testing.T) {
+		p, err := NewPropagator(PropagationW3C)
+		Expect(err).To(BeNil())
+		tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+			jaeger.TracerOptions.Extractor(opentracing.HTTPHeaders, p))
+		defer closer.Close()
+		opentracing.SetGlobalTracer(tracer)
+
+		req := httptest.NewRequest(http.MethodGet, "/test/123", nil)
+		req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(HeaderTraceID)).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+		Expect(traceIDInContext).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+	})
+
+	t.Run("trace id should be absent when tracing disabled", func(t *testing.T) {
+		opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		traceIDInContext = ""
+
+		req := httptest.NewRequest(http.MethodGet, "/test/123", nil)
+		status, _, resp := testinfra.ExecuteRequest(req, router)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(HeaderTraceID)).To(BeEmpty())
+		Expect(traceIDInContext).To(BeEmpty())
+	})
+}
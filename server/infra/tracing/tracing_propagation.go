@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/zipkin"
+)
+
+const (
+	PropagationJaeger = "jaeger"
+	PropagationB3     = "b3"
+	PropagationW3C    = "w3c"
+
+	HeaderTraceParent = "traceparent"
+)
+
+var DefaultPropagations = []string{PropagationJaeger, PropagationB3, PropagationW3C}
+
+type propagator interface {
+	jaeger.Injector
+	jaeger.Extractor
+}
+
+// Propagator inject span context in all configured formats,
+// and extract span context from the first format found in carrier.
+type Propagator struct {
+	propagators []propagator
+}
+
+func NewPropagator(formats ...string) (*Propagator, error) {
+	p := &Propagator{}
+	for _, format := range formats {
+		switch format {
+		case PropagationJaeger:
+			headers := &jaeger.HeadersConfig{}
+			headers.ApplyDefaults()
+			p.propagators = append(p.propagators, jaeger.NewHTTPHeaderPropagator(headers, *jaeger.NewNullMetrics()))
+		case PropagationB3:
+			p.propagators = append(p.propagators, zipkin.NewZipkinB3HTTPHeaderPropagator())
+		case PropagationW3C:
+			p.propagators = append(p.propagators, W3CPropagator{})
+		default:
+			return nil, fmt.Errorf("unsupported propagation format '%s'", format)
+		}
+	}
+	return p, nil
+}
+
+func (p *Propagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	for _, i := range p.propagators {
+		if err := i.Inject(sc, carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Propagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	err := opentracing.ErrSpanContextNotFound
+	for _, e := range p.propagators {
+		sc, extractErr := e.Extract(carrier)
+		if extractErr == nil && sc.IsValid() {
+			return sc, nil
+		}
+		if extractErr != nil && !errors.Is(extractErr, opentracing.ErrSpanContextNotFound) {
+			err = extractErr
+		}
+	}
+	return jaeger.SpanContext{}, err
+}
+
+// W3CPropagator propagate span context through the 'traceparent' header of W3C Trace Context:
+// {version}-{trace-id}-{parent-id}-{trace-flags}, e.g. 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01
+type W3CPropagator struct{}
+
+func (W3CPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+	traceID := sc.TraceID()
+	writer.Set(HeaderTraceParent, fmt.Sprintf("00-%016x%016x-%016x-%s", traceID.High, traceID.Low, uint64(sc.SpanID()), flags))
+	return nil
+}
+
+func (W3CPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var traceParent string
+	if err := reader.ForeachKey(func(key, val string) error {
+		if strings.ToLower(key) == HeaderTraceParent {
+			traceParent = val
+		}
+		return nil
+	}); err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	if traceParent == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	traceID, err := jaeger.TraceIDFromString(parts[1])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(parts[2])
+	if err != nil || spanID == 0 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	var flags byte
+	if _, err := fmt.Sscanf(parts[3], "%02x", &flags); err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	return jaeger.NewSpanContext(traceID, spanID, 0, flags&0x01 == 0x01, nil), nil
+}
+
+// TraceID return the trace id of span context in hex, empty string if not available (e.g. no-op tracer)
+func TraceID(sc opentracing.SpanContext) string {
+	if jaegerCtx, ok := sc.(jaeger.SpanContext); ok && jaegerCtx.IsValid() {
+		return jaegerCtx.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+)
+
+func TestPropagator(t *testing.T) {
+	RegisterTestingT(t)
+
+	sc := jaeger.NewSpanContext(jaeger.TraceID{High: 0x0af7651916cd43dd, Low: 0x8448eb211c80319c},
+		jaeger.SpanID(0xb7ad6b7169203331), 0, true, nil)
+
+	t.Run("unsupported format should be rejected", func(t *testing.T) {
+		p, err := NewPropagator("jaeger", "unknown")
+		Expect(p).To(BeNil())
+		Expect(err).To(MatchError("unsupported propagation format 'unknown'"))
+	})
+
+	t.Run("span context should be injected in all formats", func(t *testing.T) {
+		p, err := NewPropagator(DefaultPropagations...)
+		Expect(err).To(BeNil())
+
+		header := http.Header{}
+		Expect(p.Inject(sc, opentracing.HTTPHeadersCarrier(header))).To(BeNil())
+		Expect(header.Get("uber-trace-id")).To(Equal("0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0000000000000000:1"))
+		Expect(header.Get("x-b3-traceid")).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+		Expect(header.Get("x-b3-spanid")).To(Equal("b7ad6b7169203331"))
+		Expect(header.Get("x-b3-sampled")).To(Equal("1"))
+		Expect(header.Get("traceparent")).To(Equal("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	})
+
+	t.Run("span context should be extracted from any enabled format", func(t *testing.T) {
+		p, err := NewPropagator(DefaultPropagations...)
+		Expect(err).To(BeNil())
+
+		for _, header := range []http.Header{
+			{"Uber-Trace-Id": {"0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1"}},
+			{"X-B3-Traceid": {"0af7651916cd43dd8448eb211c80319c"}, "X-B3-Spanid": {"b7ad6b7169203331"}, "X-B3-Sampled": {"1"}},
+			{"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}},
+		} {
+			extracted, err := p.Extract(opentracing.HTTPHeadersCarrier(header))
+			Expect(err).To(BeNil())
+			Expect(extracted.TraceID()).To(Equal(sc.TraceID()))
+			Expect(extracted.SpanID()).To(Equal(sc.SpanID()))
+			Expect(extracted.IsSampled()).To(BeTrue())
+		}
+
+		_, err = p.Extract(opentracing.HTTPHeadersCarrier(http.Header{}))
+		Expect(err).To(Equal(opentracing.ErrSpanContextNotFound))
+	})
+
+	t.Run("disabled format should not be extracted", func(t *testing.T) {
+		p, err := NewPropagator(PropagationJaeger)
+		Expect(err).To(BeNil())
+		header := http.Header{"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}
+		_, err = p.Extract(opentracing.HTTPHeadersCarrier(header))
+		Expect(err).To(Equal(opentracing.ErrSpanContextNotFound))
+	})
+
+	t.Run("corrupted traceparent should be rejected", func(t *testing.T) {
+		for _, value := range []string{
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+			"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+			"00-0af7651916cd43dd8448eb211c8031xx-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-zz",
+		} {
+			header := http.Header{"Traceparent": {value}}
+			_, err := W3CPropagator{}.Extract(opentracing.HTTPHeadersCarrier(header))
+			Expect(err).To(Equal(opentracing.ErrSpanContextCorrupted), value)
+		}
+	})
+
+	t.Run("unsampled flag should be kept", func(t *testing.T) {
+		header := http.Header{"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"}}
+		extracted, err := W3CPropagator{}.Extract(opentracing.HTTPHeadersCarrier(header))
+		Expect(err).To(BeNil())
+		Expect(extracted.IsSampled()).To(BeFalse())
+	})
+}
@@ -0,0 +1,252 @@
+package testinfra
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAPStubEntry an entry of LDAPStub, the entry can bind with Password if it is not empty
+type LDAPStubEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// LDAPStub a minimal LDAP v3 server supports simple bind and search (with and, or, not, equality and presence filters),
+// it is for testing directory authentication locally.
+type LDAPStub struct {
+	URL string
+
+	listener net.Listener
+	mu       sync.Mutex
+	entries  []LDAPStubEntry
+	binds    []string
+}
+
+const (
+	ldapAppBindRequest      = 0
+	ldapAppBindResponse     = 1
+	ldapAppUnbindRequest    = 2
+	ldapAppSearchRequest    = 3
+	ldapAppSearchEntry      = 4
+	ldapAppSearchDone       = 5
+	ldapResultSuccess       = 0
+	ldapResultNoSuchObject  = 32
+	ldapResultInvalidCreds  = 49
+	ldapResultUnwilling     = 53
+	ldapScopeBaseObject     = 0
+	ldapScopeSingleLevel    = 1
+	ldapFilterAnd           = 0
+	ldapFilterOr            = 1
+	ldapFilterNot           = 2
+	ldapFilterEqualityMatch = 3
+	ldapFilterPresent       = 7
+)
+
+// StartLDAPStub listen on a random local port, Close it after use
+func StartLDAPStub(entries ...LDAPStubEntry) (*LDAPStub, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &LDAPStub{URL: "ldap://" + l.Addr().String(), listener: l, entries: entries}
+	go s.serve()
+	return s, nil
+}
+
+func (s *LDAPStub) Close() error {
+	return s.listener.Close()
+}
+
+// Binds the DNs bound successfully, anonymous binds are recorded as empty DN
+func (s *LDAPStub) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func (s *LDAPStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *LDAPStub) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		packet, err := ber.ReadPacket(r)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldapAppBindRequest:
+			s.bind(conn, messageID, op)
+		case ldapAppSearchRequest:
+			s.search(conn, messageID, op)
+		case ldapAppUnbindRequest:
+			return
+		default:
+			_, _ = conn.Write(ldapResult(messageID, op.Tag+1, ldapResultUnwilling, "unsupported operation").Bytes())
+		}
+	}
+}
+
+func (s *LDAPStub) bind(conn net.Conn, messageID int64, op *ber.Packet) {
+	if len(op.Children) < 3 {
+		_, _ = conn.Write(ldapResult(messageID, ldapAppBindResponse, ldapResultUnwilling, "malformed bind").Bytes())
+		return
+	}
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+
+	code := ldapResultInvalidCreds
+	if dn == "" && password == "" {
+		code = ldapResultSuccess
+	} else if e := s.lookup(dn); e != nil && e.Password != "" && e.Password == password {
+		code = ldapResultSuccess
+	}
+	if code == ldapResultSuccess {
+		s.mu.Lock()
+		s.binds = append(s.binds, dn)
+		s.mu.Unlock()
+	}
+	_, _ = conn.Write(ldapResult(messageID, ldapAppBindResponse, code, "").Bytes())
+}
+
+func (s *LDAPStub) search(conn net.Conn, messageID int64, op *ber.Packet) {
+	if len(op.Children) < 8 {
+		_, _ = conn.Write(ldapResult(messageID, ldapAppSearchDone, ldapResultUnwilling, "malformed search").Bytes())
+		return
+	}
+	base, _ := op.Children[0].Value.(string)
+	scope, _ := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+
+	baseFound := base == ""
+	for idx := range s.entries {
+		e := &s.entries[idx]
+		if strings.EqualFold(e.DN, base) {
+			baseFound = true
+		}
+		if !inScope(e.DN, base, scope) || !matchFilter(e, filter) {
+			continue
+		}
+		_, _ = conn.Write(ldapEntry(messageID, e).Bytes())
+	}
+	if !baseFound {
+		_, _ = conn.Write(ldapResult(messageID, ldapAppSearchDone, ldapResultNoSuchObject, "no such object").Bytes())
+		return
+	}
+	_, _ = conn.Write(ldapResult(messageID, ldapAppSearchDone, ldapResultSuccess, "").Bytes())
+}
+
+func (s *LDAPStub) lookup(dn string) *LDAPStubEntry {
+	for idx := range s.entries {
+		if strings.EqualFold(s.entries[idx].DN, dn) {
+			return &s.entries[idx]
+		}
+	}
+	return nil
+}
+
+func inScope(dn, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	switch scope {
+	case ldapScopeBaseObject:
+		return dn == base
+	case ldapScopeSingleLevel:
+		idx := strings.Index(dn, ",")
+		return idx > 0 && dn[idx+1:] == base
+	default:
+		return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+func matchFilter(e *LDAPStubEntry, f *ber.Packet) bool {
+	switch f.Tag {
+	case ldapFilterAnd:
+		for _, child := range f.Children {
+			if !matchFilter(e, child) {
+				return false
+			}
+		}
+		return true
+	case ldapFilterOr:
+		for _, child := range f.Children {
+			if matchFilter(e, child) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterNot:
+		return len(f.Children) == 1 && !matchFilter(e, f.Children[0])
+	case ldapFilterEqualityMatch:
+		if len(f.Children) != 2 {
+			return false
+		}
+		value := f.Children[1].Data.String()
+		for _, v := range attributeValues(e, f.Children[0].Data.String()) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterPresent:
+		return len(attributeValues(e, f.Data.String())) > 0
+	}
+	return false
+}
+
+func attributeValues(e *LDAPStubEntry, name string) []string {
+	for k, values := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func ldapEnvelope(messageID int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResult(messageID int64, tag ber.Tag, code int, message string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return ldapEnvelope(messageID, op)
+}
+
+func ldapEntry(messageID int64, e *LDAPStubEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapAppSearchEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(vals)
+		attributes.AppendChild(attr)
+	}
+	op.AppendChild(attributes)
+	return ldapEnvelope(messageID, op)
+}
@@ -0,0 +1,243 @@
+package testinfra
+
+import (
+	"encoding/xml"
+	"hash/crc64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OSSStubObject an object kept by OSSStub
+type OSSStubObject struct {
+	Content     []byte
+	ContentType string
+}
+
+// OSSStub a minimal OSS compatible server supports PUT, GET, HEAD and DELETE of objects in path style
+// (http://127.0.0.1:port/<bucket>/<key>) and listing objects, the requests must be signed by AccessKeyID,
+// or be presigned GET URLs of it.
+// It is for testing the OSS blob store locally.
+type OSSStub struct {
+	URL         string
+	Bucket      string
+	AccessKeyID string
+
+	server  *httptest.Server
+	mu      sync.Mutex
+	objects map[string]OSSStubObject
+}
+
+type ossStubListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	Marker      string   `xml:"Marker"`
+	MaxKeys     int      `xml:"MaxKeys"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	NextMarker  string   `xml:"NextMarker,omitempty"`
+	Contents    []ossStubListObject
+}
+
+type ossStubListObject struct {
+	XMLName xml.Name `xml:"Contents"`
+	Key     string   `xml:"Key"`
+	Size    int      `xml:"Size"`
+}
+
+type ossStubError struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// StartOSSStub serve the bucket on a random local port, Close it after use
+func StartOSSStub(bucket, accessKeyID string) *OSSStub {
+	s := &OSSStub{Bucket: bucket, AccessKeyID: accessKeyID, objects: map[string]OSSStubObject{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *OSSStub) Close() {
+	s.server.Close()
+}
+
+// Object the object of key, it is nil if absent
+func (s *OSSStub) Object(key string) *OSSStubObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, found := s.objects[key]; found {
+		return &o
+	}
+	return nil
+}
+
+// Keys the keys of all objects
+func (s *OSSStub) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *OSSStub) handle(w http.ResponseWriter, r *http.Request) {
+	if code := s.authorize(r); code != "" {
+		s.fail(w, r, http.StatusForbidden, code)
+		return
+	}
+	path, err := url.PathUnescape(r.URL.EscapedPath())
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if parts[0] != s.Bucket {
+		s.fail(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if len(parts) < 2 || parts[1] == "" {
+		if r.Method != http.MethodGet {
+			s.fail(w, r, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		s.list(w, r)
+		return
+	}
+	key := parts[1]
+
+	switch r.Method {
+	case http.MethodPut:
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.mu.Lock()
+		s.objects[key] = OSSStubObject{Content: content, ContentType: r.Header.Get("Content-Type")}
+		s.mu.Unlock()
+		w.Header().Set("x-oss-hash-crc64ecma", crc64ECMA(content))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		o := s.Object(key)
+		if o == nil {
+			s.fail(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", o.ContentType)
+		if v := r.URL.Query().Get("response-content-type"); v != "" {
+			w.Header().Set("Content-Type", v)
+		}
+		if v := r.URL.Query().Get("response-content-disposition"); v != "" {
+			w.Header().Set("Content-Disposition", v)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.Content)))
+		w.Header().Set("x-oss-hash-crc64ecma", crc64ECMA(o.Content))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(o.Content)
+		}
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// authorize the error code if the request is neither signed nor presigned by AccessKeyID, the signatures are not verified
+func (s *OSSStub) authorize(r *http.Request) string {
+	if r.Header.Get("Authorization") != "" {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "OSS "+s.AccessKeyID+":") {
+			return "InvalidAccessKeyId"
+		}
+		return ""
+	}
+	query := r.URL.Query()
+	if query.Get("OSSAccessKeyId") != s.AccessKeyID || query.Get("Signature") == "" || r.Method != http.MethodGet {
+		return "AccessDenied"
+	}
+	expires, err := strconv.ParseInt(query.Get("Expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "AccessDenied"
+	}
+	return ""
+}
+
+// list respond the objects after marker with prefix in lexical order, the keys are URL encoded if
+// encoding-type is 'url' like OSS does
+func (s *OSSStub) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, marker := query.Get("prefix"), query.Get("marker")
+	maxKeys := 100
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.fail(w, r, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		maxKeys = n
+	}
+	encode := func(v string) string { return v }
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+
+	s.mu.Lock()
+	sizes := map[string]int{}
+	for key, o := range s.objects {
+		sizes[key] = len(o.Content)
+	}
+	s.mu.Unlock()
+	keys := []string{}
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := ossStubListResult{Name: s.Bucket, Prefix: encode(prefix), Marker: encode(marker), MaxKeys: maxKeys}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		if len(result.Contents) == maxKeys {
+			result.IsTruncated = true
+			result.NextMarker = encode(result.Contents[maxKeys-1].Key)
+			break
+		}
+		result.Contents = append(result.Contents, ossStubListObject{Key: key, Size: sizes[key]})
+	}
+	for idx := range result.Contents {
+		result.Contents[idx].Key = encode(result.Contents[idx].Key)
+	}
+	body, _ := xml.Marshal(result)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append([]byte(xml.Header), body...))
+}
+
+// fail respond the error in XML, the body of HEAD responses is omitted like OSS does
+func (s *OSSStub) fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		body, _ := xml.Marshal(ossStubError{Code: code, Message: http.StatusText(status), RequestID: "stub"})
+		w.Write(append([]byte(xml.Header), body...))
+	}
+}
+
+func crc64ECMA(content []byte) string {
+	return strconv.FormatUint(crc64.Checksum(content, crc64.MakeTable(crc64.ECMA)), 10)
+}
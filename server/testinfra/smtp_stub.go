@@ -0,0 +1,116 @@
+package testinfra
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// SMTPStubMail a mail received by SMTPStub
+type SMTPStubMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// SMTPStub a minimal SMTP server accepts any mail, it is for testing mail delivery locally.
+type SMTPStub struct {
+	Addr string
+
+	listener net.Listener
+	mu       sync.Mutex
+	mails    []SMTPStubMail
+}
+
+// StartSMTPStub listen on a random local port, Close it after use
+func StartSMTPStub() (*SMTPStub, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTPStub{Addr: l.Addr().String(), listener: l}
+	go s.serve()
+	return s, nil
+}
+
+func (s *SMTPStub) Close() error {
+	return s.listener.Close()
+}
+
+// Mails the received mails
+func (s *SMTPStub) Mails() []SMTPStubMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMTPStubMail{}, s.mails...)
+}
+
+func (s *SMTPStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SMTPStub) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost stub")
+	mail := SMTPStubMail{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			reply("235 authenticated")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			mail = SMTPStubMail{From: addressOf(line)}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			mail.To = append(mail.To, addressOf(line))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data := strings.Builder{}
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			mail.Data = data.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func addressOf(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}